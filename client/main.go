@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
 	"time"
 
 	"github.com/pion/webrtc/v4"
@@ -15,11 +18,123 @@ import (
 )
 
 var (
-	serverAddr = "127.0.0.1:8080"
-	roomID     = "room123"
+	serverAddr     = "127.0.0.1:8080"
+	roomID         = flag.String("room", "room123", "room to join; with -clients > 1 clients are spread over rooms <room>-<n>")
+	audioFile      = flag.String("file", "debug_audio.ogg", "OGG/Opus file to send, or - to read from stdin")
+	pageDuration   = flag.Duration("page-duration", 0, "fixed duration of each OGG page (0 = derive from granule positions)")
+	clients        = flag.Int("clients", 1, "number of concurrent clients to run")
+	clientsPerRoom = flag.Int("clients-per-room", 2, "clients joined to each room when -clients > 1")
+	stagger        = flag.Duration("stagger", 100*time.Millisecond, "delay between starting consecutive clients")
+	connectTimeout = flag.Duration("connect-timeout", 30*time.Second, "time a client may take to connect before it counts as failed")
 )
 
+const (
+	// opusGranuleRate is the rate of Opus granule positions, which is 48 kHz
+	// regardless of the input sample rate (RFC 7845).
+	opusGranuleRate = 48000
+	// noGranulePosition marks a page on which no packet ends.
+	noGranulePosition = ^uint64(0)
+)
+
+// clientResult is the outcome of one client, see runClient.
+type clientResult struct {
+	connectTime time.Duration
+	err         error
+}
+
 func main() {
+	flag.Parse()
+
+	if *clients < 1 || *clientsPerRoom < 1 {
+		fmt.Println("Error: -clients and -clients-per-room must be at least 1")
+		os.Exit(2)
+	}
+	if *clients > 1 && *audioFile == "-" {
+		fmt.Println("Error: stdin can only feed a single client")
+		os.Exit(2)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	results := make(chan clientResult, *clients)
+	var wg sync.WaitGroup
+	go func() {
+		for i := 0; i < *clients; i++ {
+			if i > 0 {
+				time.Sleep(*stagger)
+			}
+
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				runClient(id, clientRoom(id), results)
+			}(i)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	var collected []clientResult
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				printReport(collected, false)
+				return
+			}
+			collected = append(collected, result)
+		case <-interrupt:
+			printReport(collected, true)
+			return
+		}
+	}
+}
+
+// clientRoom returns the room of client id: -room itself for a single
+// client, otherwise -clients-per-room clients share each <room>-<n>.
+func clientRoom(id int) string {
+	if *clients == 1 {
+		return *roomID
+	}
+	return fmt.Sprintf("%s-%d", *roomID, id / *clientsPerRoom)
+}
+
+// printReport prints the summary of the finished clients; clients still
+// running when interrupted are counted as pending.
+func printReport(results []clientResult, interrupted bool) {
+	var successes, failures int
+	var totalConnectTime time.Duration
+	for _, result := range results {
+		if result.err != nil {
+			failures++
+			continue
+		}
+		successes++
+		totalConnectTime += result.connectTime
+	}
+
+	fmt.Println()
+	if interrupted {
+		fmt.Println("Interrupted")
+	}
+	fmt.Printf("Clients: %d\n", *clients)
+	fmt.Printf("Successes: %d\n", successes)
+	fmt.Printf("Failures: %d\n", failures)
+	if pending := *clients - len(results); pending > 0 {
+		fmt.Printf("Pending: %d\n", pending)
+	}
+	if successes > 0 {
+		fmt.Printf("Average time to connect: %s\n", totalConnectTime/time.Duration(successes))
+	}
+}
+
+// runClient joins room, sends the audio file once connected and reports to
+// results once it has been sent. A client that fails to negotiate or connect
+// within -connect-timeout reports the error. A single client then stays
+// connected, like any WHIP publisher, until the connection fails or the
+// client is interrupted.
+func runClient(id int, room string, results chan<- clientResult) {
 	config := webrtc.Configuration{
 		ICEServers: []webrtc.ICEServer{
 			{
@@ -31,6 +146,7 @@ func main() {
 	peerConnection, err := webrtc.NewPeerConnection(config)
 	if err != nil {
 		fmt.Printf("Error creating peer connection: %v\n", err)
+		results <- clientResult{err: err}
 		return
 	}
 	defer peerConnection.Close()
@@ -44,12 +160,14 @@ func main() {
 	)
 	if err != nil {
 		fmt.Printf("Error creating audio track: %v\n", err)
+		results <- clientResult{err: err}
 		return
 	}
 
 	rtpSender, audioTrackErr := peerConnection.AddTrack(audioTrack)
 	if audioTrackErr != nil {
 		fmt.Printf("Error adding track: %v\n", audioTrackErr)
+		results <- clientResult{err: audioTrackErr}
 		return
 	}
 
@@ -62,92 +180,143 @@ func main() {
 		}
 	}()
 
+	connected := make(chan struct{})
+	failed := make(chan struct{})
+	var connectedOnce, failedOnce sync.Once
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		fmt.Printf("Peer Connection State: %s\n", state.String())
-		if state == webrtc.PeerConnectionStateConnected {
-			go func() {
-				file, oggErr := os.Open("debug_audio.ogg")
-				if oggErr != nil {
-					panic(oggErr)
-				}
-
-				ogg, _, err := oggreader.NewWith(file)
-				if err != nil {
-					fmt.Printf("Error NewWith: %v\n", err)
-					return
-				}
-
-				var lastGranule uint64
-				var oggPageDuration = time.Millisecond * 20
-
-				ticker := time.NewTicker(oggPageDuration)
-				for ; true; <-ticker.C {
-					pageData, pageHeader, oggErr := ogg.ParseNextPage()
-					if errors.Is(oggErr, io.EOF) {
-						fmt.Printf("All audio pages parsed and sent")
-						break
-					}
-					if oggErr != nil {
-						fmt.Printf("Error ParseNextPage: %v\n", oggErr)
-						break
-					}
-					sampleCount := float64(pageHeader.GranulePosition - lastGranule)
-					lastGranule = pageHeader.GranulePosition
-					sampleDuration := time.Duration((sampleCount/48000)*1000) * time.Millisecond
-
-					if err = audioTrack.WriteSample(media.Sample{Data: pageData, Duration: sampleDuration}); err != nil {
-						fmt.Printf("Error WriteSample: %v\n", err)
-						break
-					}
-				}
-			}()
+		fmt.Printf("Client %d (room %s) Peer Connection State: %s\n", id, room, state.String())
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectedOnce.Do(func() { close(connected) })
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			failedOnce.Do(func() { close(failed) })
 		}
 	})
 
+	start := time.Now()
+	if err = negotiate(peerConnection, room); err != nil {
+		fmt.Printf("Error negotiating client %d: %v\n", id, err)
+		results <- clientResult{err: err}
+		return
+	}
+
+	select {
+	case <-connected:
+	case <-failed:
+		results <- clientResult{err: errors.New("connection failed")}
+		return
+	case <-time.After(*connectTimeout):
+		fmt.Printf("Client %d: timed out connecting\n", id)
+		results <- clientResult{err: errors.New("timed out connecting")}
+		return
+	}
+	connectTime := time.Since(start)
+
+	if err = sendAudio(audioTrack); err != nil {
+		fmt.Printf("Error sending audio of client %d: %v\n", id, err)
+	}
+	results <- clientResult{connectTime: connectTime}
+
+	if *clients == 1 {
+		<-failed
+	}
+}
+
+// negotiate runs the WHIP POST for room and applies the answer.
+func negotiate(peerConnection *webrtc.PeerConnection, room string) error {
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
-		fmt.Printf("Error creating offer: %v\n", err)
-		return
+		return fmt.Errorf("creating offer: %w", err)
 	}
 
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
 	if err = peerConnection.SetLocalDescription(offer); err != nil {
-		fmt.Printf("Error setting local description: %v\n", err)
-		return
+		return fmt.Errorf("setting local description: %w", err)
 	}
 	<-gatherComplete
 
-	whipURL := fmt.Sprintf("http://%s/whip?room=%s", serverAddr, roomID)
+	whipURL := fmt.Sprintf("http://%s/whip?room=%s", serverAddr, room)
 	httpReq, err := http.NewRequest("POST", whipURL, bytes.NewBuffer([]byte(offer.SDP)))
 	if err != nil {
-		fmt.Printf("Error creating WHIP request: %v\n", err)
-		return
+		return fmt.Errorf("creating WHIP request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/sdp")
 
 	client := &http.Client{}
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		fmt.Printf("Error sending WHIP request: %v\n", err)
-		return
+		return fmt.Errorf("sending WHIP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("Error reading WHIP response: %v\n", err)
-		return
+		return fmt.Errorf("reading WHIP response: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("WHIP endpoint answered %s: %s", resp.Status, bytes.TrimSpace(body))
 	}
-	gatherComplete = webrtc.GatheringCompletePromise(peerConnection)
+
 	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
 		SDP:  string(body),
 	}); err != nil {
-		<-gatherComplete
-		fmt.Printf("Error setting remote description: %v\n", err)
-		return
+		return fmt.Errorf("setting remote description: %w", err)
 	}
-	<-gatherComplete
+	return nil
+}
 
-	select {}
+// sendAudio sends the audio file to audioTrack once, in real time.
+func sendAudio(audioTrack *webrtc.TrackLocalStaticSample) error {
+	file, err := openAudio(*audioFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ogg, _, err := oggreader.NewWith(file)
+	if err != nil {
+		return fmt.Errorf("reading OGG header: %w", err)
+	}
+
+	var lastGranule uint64
+
+	// Pages are paced by their own duration rather than a fixed tick, so
+	// files with variable page timing play at the right speed.
+	start := time.Now()
+	var elapsed time.Duration
+	for {
+		pageData, pageHeader, oggErr := ogg.ParseNextPage()
+		if errors.Is(oggErr, io.EOF) {
+			fmt.Println("All audio pages parsed and sent")
+			return nil
+		}
+		if oggErr != nil {
+			return fmt.Errorf("parsing OGG page: %w", oggErr)
+		}
+
+		sampleDuration := *pageDuration
+		if sampleDuration == 0 && pageHeader.GranulePosition != noGranulePosition {
+			sampleCount := pageHeader.GranulePosition - lastGranule
+			lastGranule = pageHeader.GranulePosition
+			sampleDuration = time.Duration(sampleCount) * time.Second / opusGranuleRate
+		}
+
+		if err = audioTrack.WriteSample(media.Sample{Data: pageData, Duration: sampleDuration}); err != nil {
+			return fmt.Errorf("writing sample: %w", err)
+		}
+
+		elapsed += sampleDuration
+		time.Sleep(time.Until(start.Add(elapsed)))
+	}
+}
+
+// openAudio opens the OGG source. "-" reads a live stream from stdin, e.g.
+// `ffmpeg -re -i input -c:a libopus -f ogg - | client -file=-`; stdin can't
+// be seeked, so the stream is sent once until the pipe closes.
+func openAudio(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
 }
@@ -0,0 +1,8 @@
+// Command single-whip runs the single-whip server, see package server.
+package main
+
+import "github.com/aleksousa/single-whip/server"
+
+func main() {
+	server.Main()
+}
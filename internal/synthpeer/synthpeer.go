@@ -0,0 +1,209 @@
+// Package synthpeer joins synthetic peers to a single-whip server, for the
+// selftest command and the integration tests of package singlewhiptest.
+//
+// Each peer sends a stream of one-byte Opus packets carrying a tag of its
+// own, which lets the peers receiving them tell who they heard.
+package synthpeer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// packetInterval is the pace of the packets peers send.
+const packetInterval = 20 * time.Millisecond
+
+// Client joins peers to a WHIP endpoint, tagging each apart.
+type Client struct {
+	// Endpoint is the URL peers are POSTed to, e.g.
+	// http://127.0.0.1:34567/whip.
+	Endpoint string
+
+	nextTag byte
+	mutex   sync.Mutex
+}
+
+// NewClient returns a Client joining peers to endpoint.
+func NewClient(endpoint string) *Client {
+	return &Client{Endpoint: endpoint, nextTag: 'A'}
+}
+
+// StatusError is the error of Join when the server rejects the offer.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("WHIP POST answered %d: %s", e.StatusCode, e.Body)
+}
+
+// Join joins a peer with a WHIP POST carrying query, returning a
+// *StatusError unless the server answers with 201. The peer sends its
+// tagged packets until it leaves, which it does with a DELETE of its
+// resource on Close.
+func (c *Client) Join(query url.Values) (*Peer, error) {
+	c.mutex.Lock()
+	tag := c.nextTag
+	c.nextTag++
+	c.mutex.Unlock()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+	p := &Peer{PeerConnection: peerConnection, tag: tag, heard: map[byte]int{}, done: make(chan struct{})}
+	if err = p.negotiate(c.Endpoint + "?" + query.Encode()); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(packetInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = p.track.WriteSample(media.Sample{Data: []byte{tag}, Duration: packetInterval})
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// Peer is a peer joined to a server.
+type Peer struct {
+	// ID is the peer's ID on the server, the last segment of Location.
+	ID string
+	// Location is the URL of the peer's WHIP resource.
+	Location string
+	// PeerConnection is the peer's connection to the server.
+	PeerConnection *webrtc.PeerConnection
+
+	track     *webrtc.TrackLocalStaticSample
+	tag       byte
+	heard     map[byte]int
+	done      chan struct{}
+	closeOnce sync.Once
+	mutex     sync.Mutex
+}
+
+// negotiate adds the peer's track and runs the client side of a WHIP POST
+// to endpoint.
+func (p *Peer) negotiate(endpoint string) error {
+	var err error
+	p.track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "synthpeer")
+	if err != nil {
+		return err
+	}
+	if _, err = p.PeerConnection.AddTrack(p.track); err != nil {
+		return err
+	}
+	p.PeerConnection.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, err := remote.ReadRTP()
+			if err != nil {
+				return
+			}
+			if len(pkt.Payload) > 0 {
+				p.mutex.Lock()
+				p.heard[pkt.Payload[0]]++
+				p.mutex.Unlock()
+			}
+		}
+	})
+
+	offer, err := p.PeerConnection.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(p.PeerConnection)
+	if err = p.PeerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-gathered
+
+	res, err := http.Post(endpoint, "application/sdp", strings.NewReader(p.PeerConnection.LocalDescription().SDP))
+	if err != nil {
+		return fmt.Errorf("WHIP POST: %w", err)
+	}
+	answer, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading the answer: %w", err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		return &StatusError{StatusCode: res.StatusCode, Body: string(bytes.TrimSpace(answer))}
+	}
+
+	location, err := res.Location()
+	if err != nil {
+		return err
+	}
+	p.Location = location.String()
+	p.ID = location.Path[strings.LastIndex(location.Path, "/")+1:]
+
+	return p.PeerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)})
+}
+
+// Heard returns the number of packets the peer received from source.
+func (p *Peer) Heard(source *Peer) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.heard[source.tag]
+}
+
+// Close leaves the server, stopping the peer's packets, deleting its
+// resource and closing its connection. It may be called more than once.
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		if p.Location != "" {
+			req, err := http.NewRequest(http.MethodDelete, p.Location, nil)
+			if err == nil {
+				var res *http.Response
+				if res, err = http.DefaultClient.Do(req); err == nil {
+					_ = res.Body.Close()
+				}
+			}
+		}
+		_ = p.PeerConnection.Close()
+	})
+}
+
+// AwaitRelayed waits up to timeout for destination to receive a packet from
+// source, returning an error if it doesn't.
+func AwaitRelayed(source, destination *Peer, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for destination.Heard(source) == 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("peer %s heard nothing from peer %s within %s", destination.ID, source.ID, timeout)
+		}
+		time.Sleep(packetInterval)
+	}
+	return nil
+}
+
+// AwaitNotRelayed watches destination for wait, returning an error once it
+// receives a packet from source.
+func AwaitNotRelayed(source, destination *Peer, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		if heard := destination.Heard(source); heard > 0 {
+			return fmt.Errorf("peer %s heard %d packets from peer %s", destination.ID, heard, source.ID)
+		}
+		time.Sleep(packetInterval)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// accessLog logs one line per request handled by next.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: res}
+
+		next.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			clientIP = req.RemoteAddr
+		}
+
+		fmt.Printf("access method=%s path=%q status=%d duration=%s client=%s room=%q\n",
+			req.Method, req.URL.Path, recorder.status, time.Since(start).Round(time.Microsecond),
+			clientIP, requestRoom(req))
+	})
+}
+
+// requestRoom returns the room the request names the way whipHandler reads
+// it, from the query or a "<base>/room/<id>" path, or "" if it names none.
+func requestRoom(req *http.Request) string {
+	roomID, err := roomIDFromRequest(req, whipBasePath(req.URL.Path))
+	if err != nil {
+		return ""
+	}
+	return roomID
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"explicit status", func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, "gone", http.StatusNotFound)
+		}, http.StatusNotFound},
+		{"implicit 200 on write", func(res http.ResponseWriter, req *http.Request) {
+			_, _ = res.Write([]byte("ok"))
+		}, http.StatusOK},
+		{"nothing written", func(res http.ResponseWriter, req *http.Request) {}, 0},
+	}
+	for _, test := range tests {
+		recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+		test.handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		if recorder.status != test.want {
+			t.Errorf("%s: recorded %d, want %d", test.name, recorder.status, test.want)
+		}
+	}
+}
+
+func TestAccessLogPassesThrough(t *testing.T) {
+	handler := accessLog(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+		if err := http.NewResponseController(res).Flush(); err != nil {
+			t.Errorf("flushing through the access log: %s", err.Error())
+		}
+	}))
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/whip?room=logged", nil))
+	if res.Code != http.StatusTeapot || !res.Flushed {
+		t.Errorf("response code %d, flushed %t", res.Code, res.Flushed)
+	}
+}
+
+func TestRequestRoom(t *testing.T) {
+	startServer(t)
+	for target, want := range map[string]string{
+		"/whip?room=query": "query",
+		"/whip/room/path":  "path",
+		"/stats":           "",
+	} {
+		if room := requestRoom(httptest.NewRequest(http.MethodPost, target, nil)); room != want {
+			t.Errorf("%s logged room %q, want %q", target, room, want)
+		}
+	}
+}
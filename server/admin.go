@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// authorizeAdmin reports whether req carries the -admin-token bearer token,
+// writing the error response when it does not. The admin API is disabled
+// while no token is configured.
+func authorizeAdmin(res http.ResponseWriter, req *http.Request) bool {
+	if config.AdminToken == "" {
+		writeError(res, req, "admin API is disabled", http.StatusForbidden)
+		return false
+	}
+
+	token, ok := bearerToken(req)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		res.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(res, req, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// recordingRequest is the JSON body of POST /rooms/<id>/record.
+type recordingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// recordingStatus reports a room's recording state and the files being
+// written, or just finalized when recording stopped.
+type recordingStatus struct {
+	Enabled bool     `json:"enabled"`
+	Files   []string `json:"files"`
+}
+
+func roomRecordHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	if config.RecordDir == "" {
+		writeError(res, req, "recording is not configured, set -record-dir", http.StatusConflict)
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	var recording recordingRequest
+	if err := json.NewDecoder(req.Body).Decode(&recording); err != nil {
+		writeError(res, req, "invalid recording request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files, err := room.setRecording(recording.Enabled)
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Room %s recording=%t\n", room.ID, recording.Enabled)
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(recordingStatus{Enabled: recording.Enabled, Files: files}); err != nil {
+		fmt.Printf("Error writing recording status: %s\n", err.Error())
+	}
+}
+
+// roomPeerHandler serves DELETE /rooms/<id>/peers/<peerID>, removing the
+// peer from the room and closing its connection.
+func roomPeerHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodDelete)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodDelete {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	peerID := req.PathValue("peerID")
+	for _, peer := range room.otherPeers(nil) {
+		if peer.ID == peerID {
+			room.logEvent(eventKick, peer.ID, "removed via the admin API")
+			fmt.Printf("Kicking peer %s from room %s\n", peer.ID, room.ID)
+			teardownPeer(peer)
+			res.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	writeError(res, req, "peer not found", http.StatusNotFound)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// statusWithToken sends a request bearing token and returns the response's
+// status.
+func statusWithToken(t *testing.T, method, url, token string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	return res.StatusCode
+}
+
+func TestKickPeer(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	kicked := server.Join(t, "kick")
+	staying := server.Join(t, "kick")
+	peer := roomManager.findPeer(kicked.ID)
+	kickURL := server.URL + "/rooms/kick/peers/" + kicked.ID
+
+	for _, token := range []string{"", "wrong"} {
+		if status := statusWithToken(t, http.MethodDelete, kickURL, token); status != http.StatusUnauthorized {
+			t.Fatalf("kick with token %q answered %d, want 401", token, status)
+		}
+	}
+	if roomManager.findPeer(kicked.ID) == nil {
+		t.Fatal("peer kicked without the admin token")
+	}
+
+	if status, body := request(t, http.MethodDelete, kickURL, nil); status != http.StatusOK {
+		t.Fatalf("kick answered %d: %s", status, body)
+	}
+	if roomManager.findPeer(kicked.ID) != nil {
+		t.Fatal("kicked peer still in its room")
+	}
+	if peers := roomManager.findRoom("kick").otherPeers(nil); len(peers) != 1 || peers[0].ID != staying.ID {
+		t.Fatalf("room has %d peers after the kick, want the other one only", len(peers))
+	}
+	if state := peer.PeerConnection.ConnectionState(); state != webrtc.PeerConnectionStateClosed {
+		t.Fatalf("kicked peer's connection is %s, want closed", state)
+	}
+
+	if status, _ := request(t, http.MethodDelete, kickURL, nil); status != http.StatusNotFound {
+		t.Fatalf("second kick answered %d, want 404", status)
+	}
+}
+
+func TestAdminAPIDisabledWithoutToken(t *testing.T) {
+	server := startServer(t)
+	peer := server.Join(t, "unguarded")
+	if status := statusWithToken(t, http.MethodDelete, server.URL+"/rooms/unguarded/peers/"+peer.ID, "anything"); status != http.StatusForbidden {
+		t.Fatalf("kick without -admin-token answered %d, want 403", status)
+	}
+}
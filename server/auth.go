@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+var (
+	// errUnauthenticated means the request carried no valid credentials
+	// and is answered with 401.
+	errUnauthenticated = errors.New("unauthenticated")
+	// errForbidden means the credentials are valid but don't grant the
+	// request and is answered with 403.
+	errForbidden = errors.New("forbidden")
+)
+
+// Roles an Identity may be limited to.
+const (
+	// rolePublish may send audio into the room.
+	rolePublish = "publish"
+	// roleSubscribe may receive the room's audio.
+	roleSubscribe = "subscribe"
+)
+
+// Identity is who an Authenticator recognized.
+type Identity struct {
+	// Subject names the client, e.g. a JWT's sub claim; empty when the
+	// credentials carry no name.
+	Subject string
+	// Roles limits the identity to publishing and/or subscribing; nil
+	// allows both.
+	Roles []string
+}
+
+// may reports whether the identity holds role.
+func (i Identity) may(role string) bool {
+	return i.Roles == nil || slices.Contains(i.Roles, role)
+}
+
+// authorizeOffer checks the offer's audio directions against the identity's
+// roles: sending needs publish and receiving needs subscribe, so a sendrecv
+// offer needs both. The error wraps errForbidden.
+func (i Identity) authorizeOffer(offer *sdp.SessionDescription) error {
+	sends, receives := audioDirections(offer)
+	if sends && !i.may(rolePublish) {
+		return fmt.Errorf("%w: not allowed to publish; offer recvonly audio", errForbidden)
+	}
+	if receives && !i.may(roleSubscribe) {
+		return fmt.Errorf("%w: not allowed to subscribe; offer sendonly audio", errForbidden)
+	}
+	return nil
+}
+
+// audioDirections reports whether any audio section of the offer sends or
+// receives, per its direction attribute or the session's (default
+// sendrecv).
+func audioDirections(offer *sdp.SessionDescription) (sends, receives bool) {
+	sessionDirection := sdpDirection(offer.Attributes, "sendrecv")
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Media != "audio" || media.MediaName.Port.Value == 0 {
+			continue
+		}
+
+		switch sdpDirection(media.Attributes, sessionDirection) {
+		case "sendrecv":
+			sends, receives = true, true
+		case "sendonly":
+			sends = true
+		case "recvonly":
+			receives = true
+		}
+	}
+	return sends, receives
+}
+
+func sdpDirection(attributes []sdp.Attribute, fallback string) string {
+	for _, attribute := range attributes {
+		switch attribute.Key {
+		case "sendrecv", "sendonly", "recvonly", "inactive":
+			return attribute.Key
+		}
+	}
+	return fallback
+}
+
+// Authenticator decides whether a WHIP POST may join roomID. Errors wrap
+// errUnauthenticated or errForbidden.
+type Authenticator interface {
+	Authenticate(req *http.Request, roomID string) (Identity, error)
+}
+
+// authenticator authenticates WHIP POSTs; nil admits everyone.
+var authenticator Authenticator
+
+// newAuthenticator builds the Authenticator selected by the -auth-token and
+// -jwt-* flags, or nil when none is set.
+func newAuthenticator() (Authenticator, error) {
+	useJWT := config.JWTSecret != "" || config.JWTJWKSURL != ""
+	switch {
+	case useJWT && len(config.AuthTokens) > 0:
+		return nil, errors.New("choose either -auth-token or -jwt-secret/-jwt-jwks-url")
+	case useJWT:
+		return newJWTAuthenticator(), nil
+	case len(config.AuthTokens) > 0:
+		return staticTokenAuthenticator(config.AuthTokens), nil
+	default:
+		return nil, nil
+	}
+}
+
+// authenticate runs the configured Authenticator for a WHIP POST, writing
+// the error response when it rejects the request.
+func authenticate(res http.ResponseWriter, req *http.Request, roomID string) (Identity, bool) {
+	if authenticator == nil {
+		return Identity{}, true
+	}
+
+	identity, err := authenticator.Authenticate(req, roomID)
+	switch {
+	case err == nil:
+		return identity, true
+	case errors.Is(err, errForbidden):
+		writeError(res, req, err.Error(), http.StatusForbidden)
+	default:
+		res.Header().Set("WWW-Authenticate", `Bearer realm="whip"`)
+		writeError(res, req, err.Error(), http.StatusUnauthorized)
+	}
+	fmt.Printf("Rejected client for room %s: %s\n", roomID, err.Error())
+	return Identity{}, false
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// staticTokenAuthenticator admits requests bearing any of its tokens, to
+// every room. Each token is its own identity, named by a hash of it that is
+// stable across restarts and doesn't reveal the token in logs.
+type staticTokenAuthenticator []string
+
+func (tokens staticTokenAuthenticator) Authenticate(req *http.Request, roomID string) (Identity, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: missing bearer token", errUnauthenticated)
+	}
+
+	for _, valid := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+			digest := sha256.Sum256([]byte(valid))
+			return Identity{Subject: "token-" + hex.EncodeToString(digest[:4])}, nil
+		}
+	}
+	return Identity{}, fmt.Errorf("%w: invalid bearer token", errUnauthenticated)
+}
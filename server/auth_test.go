@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// offerWithDirections returns an offer with the session attribute session,
+// when not empty, and an audio section per direction, "" for none and
+// "rejected" for one with port 0.
+func offerWithDirections(t *testing.T, session string, directions ...string) *sdp.SessionDescription {
+	t.Helper()
+
+	var b strings.Builder
+	b.WriteString("v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n")
+	if session != "" {
+		b.WriteString("a=" + session + "\r\n")
+	}
+	for _, direction := range directions {
+		port := "9"
+		if direction == "rejected" {
+			port, direction = "0", "sendrecv"
+		}
+		b.WriteString("m=audio " + port + " UDP/TLS/RTP/SAVPF 111\r\nc=IN IP4 0.0.0.0\r\na=rtpmap:111 opus/48000/2\r\n")
+		if direction != "" {
+			b.WriteString("a=" + direction + "\r\n")
+		}
+	}
+
+	var offer sdp.SessionDescription
+	if err := offer.Unmarshal([]byte(b.String())); err != nil {
+		t.Fatal(err)
+	}
+	return &offer
+}
+
+func TestAudioDirections(t *testing.T) {
+	tests := []struct {
+		name       string
+		session    string
+		directions []string
+		sends      bool
+		receives   bool
+	}{
+		{"default sendrecv", "", []string{""}, true, true},
+		{"sendonly", "", []string{"sendonly"}, true, false},
+		{"recvonly", "", []string{"recvonly"}, false, true},
+		{"inactive", "", []string{"inactive"}, false, false},
+		{"session recvonly", "recvonly", []string{""}, false, true},
+		{"media overrides the session", "recvonly", []string{"sendonly"}, true, false},
+		{"session inactive", "inactive", []string{""}, false, false},
+		{"rejected section ignored", "", []string{"rejected", "recvonly"}, false, true},
+		{"sections combined", "", []string{"sendonly", "recvonly"}, true, true},
+	}
+	for _, test := range tests {
+		sends, receives := audioDirections(offerWithDirections(t, test.session, test.directions...))
+		if sends != test.sends || receives != test.receives {
+			t.Errorf("%s: sends %t, receives %t, want %t, %t", test.name, sends, receives, test.sends, test.receives)
+		}
+	}
+}
+
+func TestEnforceDirections(t *testing.T) {
+	tests := []struct {
+		path      string
+		direction webrtc.RTPTransceiverDirection
+		status    int
+		answered  string
+	}{
+		{"/whep", webrtc.RTPTransceiverDirectionRecvonly, http.StatusCreated, "a=sendonly"},
+		{"/whep", webrtc.RTPTransceiverDirectionSendonly, http.StatusUnprocessableEntity, ""},
+		{"/whep", webrtc.RTPTransceiverDirectionSendrecv, http.StatusUnprocessableEntity, ""},
+		{"/whip", webrtc.RTPTransceiverDirectionSendonly, http.StatusCreated, "a=recvonly"},
+		{"/whip", webrtc.RTPTransceiverDirectionSendrecv, http.StatusCreated, "a=sendrecv"},
+		{"/whip", webrtc.RTPTransceiverDirectionRecvonly, http.StatusUnprocessableEntity, ""},
+	}
+	server := startServer(t, "-enforce-directions")
+	for i, test := range tests {
+		_, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+			_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: test.direction})
+			return err
+		})
+		res, body := postOffer(t, server.URL+test.path+"?room=directions"+strconv.Itoa(i), offer, nil)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s offer answered %d, want %d: %s", test.path, test.direction, res.StatusCode, test.status, body)
+			continue
+		}
+		if test.answered != "" && !strings.Contains(body, "\r\n"+test.answered+"\r\n") {
+			t.Errorf("%s %s offer answered without %s:\n%s", test.path, test.direction, test.answered, body)
+		}
+	}
+
+	// Without -enforce-directions both paths take any direction.
+	server = startServer(t)
+	_, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+		return err
+	})
+	if res, body := postOffer(t, server.URL+"/whep?room=directions", offer, nil); res.StatusCode != http.StatusCreated {
+		t.Errorf("unenforced /whep sendonly offer answered %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestAuthorizeOffer(t *testing.T) {
+	publisher := Identity{Roles: []string{rolePublish}}
+	subscriber := Identity{Roles: []string{roleSubscribe}}
+	tests := []struct {
+		name      string
+		identity  Identity
+		direction string
+		allowed   bool
+	}{
+		{"unlimited sendrecv", Identity{}, "sendrecv", true},
+		{"publisher sendonly", publisher, "sendonly", true},
+		{"publisher sendrecv", publisher, "sendrecv", false},
+		{"publisher recvonly", publisher, "recvonly", false},
+		{"subscriber recvonly", subscriber, "recvonly", true},
+		{"subscriber sendonly", subscriber, "sendonly", false},
+		{"subscriber inactive", subscriber, "inactive", true},
+		{"no roles", Identity{Roles: []string{}}, "inactive", true},
+	}
+	for _, test := range tests {
+		err := test.identity.authorizeOffer(offerWithDirections(t, "", test.direction))
+		if test.allowed != (err == nil) {
+			t.Errorf("%s: error %v, want allowed %t", test.name, err, test.allowed)
+		}
+		if err != nil && !errors.Is(err, errForbidden) {
+			t.Errorf("%s: error %v doesn't wrap errForbidden", test.name, err)
+		}
+	}
+}
+
+func TestScopedTokens(t *testing.T) {
+	authenticator := &jwtAuthenticator{secret: testJWTSecret}
+	token := func(room, role string) string {
+		claims := map[string]any{"exp": time.Now().Add(time.Hour).Unix(), "room": room, "role": role}
+		return signJWT(t, map[string]any{"alg": "HS256"}, claims, hs256(testJWTSecret))
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		room      string
+		direction string
+		want      error
+	}{
+		{"publisher publishing", token("stage", rolePublish), "stage", "sendonly", nil},
+		{"publisher listening", token("stage", rolePublish), "stage", "sendrecv", errForbidden},
+		{"subscriber listening", token("stage", roleSubscribe), "stage", "recvonly", nil},
+		{"subscriber publishing", token("stage", roleSubscribe), "stage", "sendonly", errForbidden},
+		{"other room", token("stage", rolePublish), "backstage", "sendonly", errForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/whip", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		identity, err := authenticator.Authenticate(req, test.room)
+		if err == nil {
+			err = identity.authorizeOffer(offerWithDirections(t, "", test.direction))
+		}
+		if !errors.Is(err, test.want) || (test.want == nil) != (err == nil) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	authenticator := staticTokenAuthenticator{"first", "second"}
+	identities := map[string]string{}
+	for _, token := range []string{"first", "second"} {
+		req := httptest.NewRequest(http.MethodPost, "/whip", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		identity, err := authenticator.Authenticate(req, "room")
+		if err != nil {
+			t.Fatalf("token %s: %s", token, err.Error())
+		}
+		identities[identity.Subject] = token
+	}
+	if len(identities) != 2 {
+		t.Errorf("tokens share a subject: %v", identities)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/whip", nil)
+	req.Header.Set("Authorization", "Bearer third")
+	if _, err := authenticator.Authenticate(req, "room"); !errors.Is(err, errUnauthenticated) {
+		t.Errorf("unknown token: error %v, want errUnauthenticated", err)
+	}
+}
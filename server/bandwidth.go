@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxAnswerBitrate is the highest -answer-bitrate in kbps: the top Opus
+// bitrate, above which b=AS caps nothing an audio relay sends.
+const maxAnswerBitrate = 510
+
+// validateAnswerBitrate checks a b=AS bandwidth in kbps, zero meaning none.
+func validateAnswerBitrate(kbps uint64) error {
+	if kbps > maxAnswerBitrate {
+		return fmt.Errorf("answer bitrate %d kbps is above the %d kbps Opus tops out at", kbps, maxAnswerBitrate)
+	}
+	return nil
+}
+
+// withAnswerBitrate returns the answer with a b=AS:<kbps> line in each
+// audio media section, after its c= line as RFC 8866 orders them, asking
+// clients honoring it to send at most kbps. Unlike REMB, which only
+// receivers sending feedback use, b=AS is read once from the SDP, so about
+// every client understands it, but it can't change during the session.
+// Sections already carrying a b=AS line keep it.
+func withAnswerBitrate(answer string, kbps uint64) string {
+	if kbps == 0 {
+		return answer
+	}
+
+	sections := strings.Split(answer, "\r\nm=")
+	for i := 1; i < len(sections); i++ {
+		if !strings.HasPrefix(sections[i], "audio ") || strings.Contains(sections[i], "\r\nb=AS:") {
+			continue
+		}
+		lines := strings.Split(sections[i], "\r\n")
+		at := 1
+		for j, line := range lines {
+			if strings.HasPrefix(line, "c=") {
+				at = j + 1
+				break
+			}
+		}
+		lines = append(lines[:at], append([]string{fmt.Sprintf("b=AS:%d", kbps)}, lines[at:]...)...)
+		sections[i] = strings.Join(lines, "\r\n")
+	}
+	return strings.Join(sections, "\r\nm=")
+}
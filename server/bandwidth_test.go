@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAnswerBitrate(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		query string
+		want  string
+	}{
+		{"none", nil, "", ""},
+		{"configured", []string{"-answer-bitrate", "64"}, "", "b=AS:64"},
+		{"room", []string{"-answer-bitrate", "64"}, "&bandwidth=32", "b=AS:32"},
+	}
+	for _, test := range tests {
+		server := startServer(t, test.args...)
+		_, offer := createOffer(t, addAudio)
+		res, answer := postOffer(t, server.URL+"/whip?room="+test.name+test.query, offer, nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: join answered %d: %s", test.name, res.StatusCode, answer)
+		}
+
+		_, media, _ := strings.Cut(answer, "\r\nm=audio ")
+		if test.want == "" {
+			if strings.Contains(answer, "b=AS:") {
+				t.Errorf("%s: answer has a bandwidth line:\n%s", test.name, answer)
+			}
+			continue
+		}
+		// b= lines follow the section's c= line.
+		if !strings.Contains(media, "\r\nc=IN IP4 0.0.0.0\r\n"+test.want+"\r\n") {
+			t.Errorf("%s: answer's audio section lacks %s after its c= line:\n%s", test.name, test.want, answer)
+		}
+	}
+
+	server := startServer(t)
+	_, offer := createOffer(t, addAudio)
+	for _, bandwidth := range []string{"511", "-1", "fast"} {
+		if res, body := postOffer(t, server.URL+"/whip?room=invalid&bandwidth="+bandwidth, offer, nil); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("bandwidth=%s answered %d, want 400: %s", bandwidth, res.StatusCode, body)
+		}
+	}
+}
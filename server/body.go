@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// maxOfferSize bounds an offer after decompression, so a small compressed
+// body can't expand without limit.
+const maxOfferSize = 1 << 20
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+	errInvalidEncoding     = errors.New("invalid compressed body")
+	errOfferTooLarge       = fmt.Errorf("offer exceeds %d bytes", maxOfferSize)
+	errNoSDPPart           = errors.New("multipart body has no SDP part")
+)
+
+// readOffer reads the request body, decompressing it per Content-Encoding:
+// gzip or deflate (zlib, as HTTP defines it). A multipart/form-data body,
+// as form-based tools send, yields its SDP part, see sdpPart.
+func readOffer(req *http.Request) ([]byte, error) {
+	var body io.Reader = req.Body
+
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidEncoding, err.Error())
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		body = reader
+	case "deflate":
+		reader, err := zlib.NewReader(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidEncoding, err.Error())
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		body = reader
+	default:
+		return nil, fmt.Errorf("%w %q, use gzip or deflate", errUnsupportedEncoding, encoding)
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(body, maxOfferSize+1))
+	if err != nil {
+		if body != req.Body {
+			return nil, fmt.Errorf("%w: %s", errInvalidEncoding, err.Error())
+		}
+		return nil, err
+	}
+	if len(offer) > maxOfferSize {
+		return nil, errOfferTooLarge
+	}
+	if mediaType(req) == "multipart/form-data" {
+		return sdpPart(req, offer)
+	}
+	return offer, nil
+}
+
+// sdpPart returns the SDP part of a multipart/form-data body: the first part
+// of type application/sdp, else the field named sdp or offer.
+func sdpPart(req *http.Request, body []byte) ([]byte, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, fmt.Errorf("%w: missing multipart boundary", errNoSDPPart)
+	}
+
+	var field []byte
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errNoSDPPart, err.Error())
+		}
+
+		contentType, _, _ := strings.Cut(part.Header.Get("Content-Type"), ";")
+		isSDP := strings.EqualFold(strings.TrimSpace(contentType), "application/sdp")
+		isField := field == nil && (part.FormName() == "sdp" || part.FormName() == "offer")
+		if !isSDP && !isField {
+			continue
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errNoSDPPart, err.Error())
+		}
+		if isSDP {
+			return content, nil
+		}
+		field = content
+	}
+	if field == nil {
+		return nil, errNoSDPPart
+	}
+	return field, nil
+}
+
+// offerErrorStatus returns the response status for a readOffer error: 415
+// and 413 for bodies the server won't read, and 400 for the rest, bodies
+// that don't decode and failed reads, e.g. a client sending less than its
+// Content-Length or resetting the connection, which the client, if still
+// there, is to blame for.
+func offerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errOfferTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
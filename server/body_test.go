@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+const testOffer = "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n"
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	if encoding == "gzip" {
+		writer = gzip.NewWriter(&buffer)
+	} else {
+		writer = zlib.NewWriter(&buffer)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func offerRequest(body io.Reader, encoding string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/whip", body)
+	req.Header.Set("Content-Type", "application/sdp")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	return req
+}
+
+func TestReadOfferCompressed(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		offer, err := readOffer(offerRequest(bytes.NewReader(compress(t, encoding, []byte(testOffer))), encoding))
+		if err != nil {
+			t.Fatalf("%s: %s", encoding, err.Error())
+		}
+		if string(offer) != testOffer {
+			t.Errorf("%s: read %q", encoding, offer)
+		}
+	}
+}
+
+// failingReader fails with err after its data.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReadOfferErrors(t *testing.T) {
+	bomb := compress(t, "gzip", make([]byte, maxOfferSize+1))
+	tests := []struct {
+		name     string
+		body     io.Reader
+		encoding string
+		status   int
+	}{
+		{"decompressed beyond the limit", bytes.NewReader(bomb), "gzip", http.StatusRequestEntityTooLarge},
+		{"too large", bytes.NewReader(make([]byte, maxOfferSize+1)), "", http.StatusRequestEntityTooLarge},
+		{"unknown encoding", strings.NewReader(testOffer), "br", http.StatusUnsupportedMediaType},
+		{"not gzip", strings.NewReader(testOffer), "gzip", http.StatusBadRequest},
+		{"truncated gzip", bytes.NewReader(compress(t, "gzip", []byte(testOffer))[:20]), "gzip", http.StatusBadRequest},
+		{"truncated body", &failingReader{data: []byte("v=0\r\n"), err: io.ErrUnexpectedEOF}, "", http.StatusBadRequest},
+		{"connection reset", &failingReader{err: io.ErrClosedPipe}, "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		_, err := readOffer(offerRequest(test.body, test.encoding))
+		if err == nil {
+			t.Errorf("%s: read", test.name)
+			continue
+		}
+		if status := offerErrorStatus(err); status != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, status, test.status)
+		}
+	}
+}
+
+func TestWHIPTruncatedBodyAnswered400(t *testing.T) {
+	startServer(t)
+
+	res := httptest.NewRecorder()
+	whipHandler(res, offerRequest(&failingReader{data: []byte("v=0\r\n"), err: io.ErrUnexpectedEOF}, ""))
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", res.Code)
+	}
+}
+
+// multipartOffer is a request whose multipart/form-data body has a part
+// per name and content, the name "file" making it an application/sdp file.
+func multipartOffer(t *testing.T, url string, parts ...[2]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var w io.Writer
+		var err error
+		if part[0] == "file" {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="file"; filename="offer.sdp"`)
+			header.Set("Content-Type", "application/sdp")
+			w, err = writer.CreatePart(header)
+		} else {
+			w, err = writer.CreateFormField(part[0])
+		}
+		if err == nil {
+			_, err = io.WriteString(w, part[1])
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestReadOfferMultipart(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][2]string
+	}{
+		{"sdp field", [][2]string{{"room", "form"}, {"sdp", testOffer}}},
+		{"offer field", [][2]string{{"offer", testOffer}}},
+		{"sdp file", [][2]string{{"room", "form"}, {"file", testOffer}}},
+		{"file over an earlier field", [][2]string{{"sdp", "v=0\r\n"}, {"file", testOffer}}},
+	}
+	for _, test := range tests {
+		offer, err := readOffer(multipartOffer(t, "/whip", test.parts...))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if string(offer) != testOffer {
+			t.Errorf("%s: read %q", test.name, offer)
+		}
+	}
+
+	_, err := readOffer(multipartOffer(t, "/whip", [2]string{"room", "form"}))
+	if !errors.Is(err, errNoSDPPart) || offerErrorStatus(err) != http.StatusBadRequest {
+		t.Errorf("body without an SDP part read with %v", err)
+	}
+	req := offerRequest(strings.NewReader(testOffer), "")
+	req.Header.Set("Content-Type", "multipart/form-data")
+	if _, err = readOffer(req); !errors.Is(err, errNoSDPPart) {
+		t.Errorf("body without a boundary read with %v", err)
+	}
+}
+
+func TestWHIPMultipartOffer(t *testing.T) {
+	startServer(t)
+	_, offer := createOffer(t, addAudio)
+
+	res := httptest.NewRecorder()
+	whipHandler(res, multipartOffer(t, "/whip?room=form", [2]string{"sdp", offer}))
+	if res.Code != http.StatusCreated {
+		t.Fatalf("multipart offer answered %d: %s", res.Code, res.Body.String())
+	}
+	if !strings.Contains(res.Body.String(), "m=audio ") {
+		t.Errorf("multipart offer answered without audio:\n%s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	whipHandler(res, multipartOffer(t, "/whip?room=form", [2]string{"room", "form"}))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("multipart body without an offer answered %d, want 400", res.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// byeReason is the reason sent in relayed BYEs.
+const byeReason = "source ended"
+
+// isGoodbye reports whether packet is a BYE for ssrc.
+func isGoodbye(packet rtcp.Packet, ssrc uint32) bool {
+	bye, ok := packet.(*rtcp.Goodbye)
+	return ok && slices.Contains(bye.Sources, ssrc)
+}
+
+// relayGoodbye handles, with -relay-bye, a source's BYE for the stream of
+// receiver, which ends the stream: it stops the receiver, so the relay of the
+// track exits as if the track had ended, and sends a BYE for their relay
+// track to the destinations it was the only source of, which would otherwise
+// keep waiting on it until the connection times out. Destinations still
+// hearing another source, in mesh rooms or from another track of the
+// source, keep their track running, as does a pair's partner the next peer
+// joining will be relayed to.
+func relayGoodbye(source *Peer, receiver *webrtc.RTPReceiver) {
+	track := receiver.Track()
+	fmt.Printf("Peer %s sent BYE for SSRC %d, ending its relay\n", source.ID, track.SSRC())
+	for _, room := range source.rooms {
+		room.logEvent(eventBye, source.ID, "source sent BYE")
+	}
+
+	source.mutex.Lock()
+	lastTrack := len(source.audioTracks) == 1 && source.audioTracks[0].track == track
+	source.mutex.Unlock()
+
+	if lastTrack {
+		for _, destination := range source.destinations() {
+			if destination.AudioSender == nil || hasOtherSources(destination, source) {
+				continue
+			}
+			target := source.relayTarget(track, destination)
+			if target == nil {
+				continue
+			}
+			ssrc, ok := senderSSRC(target.AudioSender)
+			if !ok {
+				continue
+			}
+			if err := destination.PeerConnection.WriteRTCP([]rtcp.Packet{&rtcp.Goodbye{
+				Sources: []uint32{ssrc},
+				Reason:  byeReason,
+			}}); err != nil {
+				fmt.Printf("Error sending BYE to peer %s: %s\n", destination.ID, err.Error())
+			}
+		}
+	}
+
+	if err := receiver.Stop(); err != nil {
+		fmt.Printf("Error stopping the receiver of peer %s: %s\n", source.ID, err.Error())
+	}
+}
+
+// hasOtherSources reports whether peer hears any peer besides source.
+func hasOtherSources(peer, source *Peer) bool {
+	for _, room := range peer.rooms {
+		for _, other := range room.otherPeers(peer) {
+			if other != source && slices.Contains(room.destinations(other), peer) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+	"github.com/pion/rtcp"
+)
+
+// sendGoodbye has the peer send a BYE for its audio stream.
+func sendGoodbye(t *testing.T, peer *synthpeer.Peer) {
+	t.Helper()
+
+	ssrc := uint32(peer.PeerConnection.GetSenders()[0].GetParameters().Encodings[0].SSRC)
+	if err := peer.PeerConnection.WriteRTCP([]rtcp.Packet{&rtcp.Goodbye{Sources: []uint32{ssrc}}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayBye(t *testing.T) {
+	server := startServer(t, "-relay-bye")
+	publisher := server.Join(t, "bye")
+	subscriber := server.Join(t, "bye")
+	assertRelayed(t, publisher, subscriber, relayTimeout)
+
+	goodbyes := make(chan *rtcp.Goodbye, 1)
+	receiver := subscriber.PeerConnection.GetReceivers()[0]
+	go func() {
+		for {
+			packets, _, err := receiver.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				if bye, ok := packet.(*rtcp.Goodbye); ok {
+					select {
+					case goodbyes <- bye:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	sendGoodbye(t, publisher)
+	select {
+	case bye := <-goodbyes:
+		if ssrc := uint32(receiver.Track().SSRC()); len(bye.Sources) != 1 || bye.Sources[0] != ssrc {
+			t.Errorf("BYE for %v, want the relay track's SSRC %d", bye.Sources, ssrc)
+		}
+		if bye.Reason != byeReason {
+			t.Errorf("BYE gives the reason %q, want %q", bye.Reason, byeReason)
+		}
+	case <-time.After(relayTimeout):
+		t.Fatal("subscriber got no BYE after the publisher's")
+	}
+
+	eventually(t, "the publisher's relay stopping", func() bool {
+		return relayStats.ActiveRelays.Load() == 1
+	})
+	// The publisher keeps sending, but its stream ended, while the other
+	// direction carries on.
+	heard, heardBack := subscriber.Heard(publisher), publisher.Heard(subscriber)
+	time.Sleep(silenceWait)
+	if subscriber.Heard(publisher) != heard {
+		t.Error("subscriber still hears the publisher after its BYE")
+	}
+	if publisher.Heard(subscriber) == heardBack {
+		t.Error("publisher stopped hearing the subscriber after its own BYE")
+	}
+}
+
+func TestByeNotRelayed(t *testing.T) {
+	server := startServer(t)
+	publisher := server.Join(t, "bye")
+	subscriber := server.Join(t, "bye")
+	assertRelayed(t, publisher, subscriber, relayTimeout)
+
+	sendGoodbye(t, publisher)
+	heard := subscriber.Heard(publisher)
+	time.Sleep(silenceWait)
+	if subscriber.Heard(publisher) == heard {
+		t.Error("subscriber stopped hearing the publisher after a BYE without -relay-bye")
+	}
+	if relays := relayStats.ActiveRelays.Load(); relays != 2 {
+		t.Errorf("%d relays running after a BYE without -relay-bye, want 2", relays)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// eventStreamContentType is the media type of server-sent events.
+const eventStreamContentType = "text/event-stream"
+
+// wantsCandidateStream reports whether the WHIP POST asked, with -stream-candidates
+// enabled, for its answer as an event stream, see candidateStream.
+func wantsCandidateStream(req *http.Request) bool {
+	return config.StreamCandidates && strings.Contains(req.Header.Get("Accept"), eventStreamContentType)
+}
+
+// candidateStream answers a WHIP POST without waiting for ICE gathering: the
+// 201 carries a server-sent event stream whose "answer" event holds the
+// answer, as an RTCSessionDescriptionInit, followed by a "candidate" event,
+// an RTCIceCandidateInit, per candidate gathered and an
+// "end-of-candidates" event. This isn't part of WHIP, whose answers are
+// complete, but lets custom clients start connectivity checks against host
+// candidates while server reflexive and relay ones are still gathering.
+type candidateStream struct {
+	candidates chan *webrtc.ICECandidate
+	// done stops relaying candidates once the stream ended.
+	done chan struct{}
+}
+
+// newCandidateStream collects the peer connection's candidates. pion reads
+// the handler when gathering starts, so call it before SetLocalDescription.
+func newCandidateStream(peerConnection *webrtc.PeerConnection) *candidateStream {
+	stream := &candidateStream{
+		candidates: make(chan *webrtc.ICECandidate),
+		done:       make(chan struct{}),
+	}
+	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		select {
+		case stream.candidates <- candidate:
+		case <-stream.done:
+		}
+	})
+	return stream
+}
+
+// serve writes the answer and then the candidates as they are gathered,
+// for at most -ice-gather-timeout like awaitGathering. Once the 201 is out,
+// failures can't be reported to the client and are only logged.
+func (s *candidateStream) serve(ctx context.Context, res http.ResponseWriter, peer *Peer, gatherComplete <-chan struct{}, location string) {
+	defer close(s.done)
+
+	res.Header().Add("Location", location)
+	addICEServerLinks(res)
+	res.Header().Set("Content-Type", eventStreamContentType)
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusCreated)
+
+	controller := http.NewResponseController(res)
+	write := func(event string, data any) bool {
+		encoded, err := json.Marshal(data)
+		if err == nil {
+			_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, encoded)
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			fmt.Printf("Error streaming candidates to peer %s: %s\n", peer.ID, err.Error())
+			return false
+		}
+		return true
+	}
+
+	if !write("answer", webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: localAnswer(peer)}) {
+		return
+	}
+
+	timeout := time.NewTimer(config.ICEGatherTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case candidate := <-s.candidates:
+			if candidate == nil {
+				write("end-of-candidates", struct{}{})
+				return
+			}
+			if !write("candidate", candidate.ToJSON()) {
+				return
+			}
+		case <-timeout.C:
+			fmt.Printf("ICE gathering still running after %s, ending the candidate stream\n", config.ICEGatherTimeout)
+			if config.ICEGatherWatchdog > 0 {
+				peer.goLoop(func() { watchGathering(peer, gatherComplete) })
+			}
+			write("end-of-candidates", struct{}{})
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// streamEvent is a server-sent event.
+type streamEvent struct {
+	name string
+	data string
+}
+
+func TestCandidateStream(t *testing.T) {
+	server := startServer(t, "-stream-candidates")
+	peerConnection, offer := createOffer(t, addAudio)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/whip?room=streamed", strings.NewReader(offer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	req.Header.Set("Accept", eventStreamContentType+", application/sdp")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("streamed join answered %d", res.StatusCode)
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != eventStreamContentType {
+		t.Fatalf("streamed join answered with %s", contentType)
+	}
+	if res.Header.Get("Location") == "" {
+		t.Error("streamed join answered without a Location")
+	}
+
+	var events []streamEvent
+	var event streamEvent
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event.name = name
+		} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+			event.data = data
+		} else if line == "" && event.name != "" {
+			events = append(events, event)
+			event = streamEvent{}
+		}
+	}
+	if len(events) < 3 {
+		t.Fatalf("stream has %d events, want an answer, candidates and end-of-candidates: %+v", len(events), events)
+	}
+
+	if events[0].name != "answer" {
+		t.Fatalf("stream starts with a %s event, want answer", events[0].name)
+	}
+	var answer webrtc.SessionDescription
+	if err = json.Unmarshal([]byte(events[0].data), &answer); err != nil {
+		t.Fatalf("decoding the answer event: %s", err.Error())
+	}
+	if err = peerConnection.SetRemoteDescription(answer); err != nil {
+		t.Fatalf("applying the streamed answer: %s", err.Error())
+	}
+
+	last := events[len(events)-1]
+	if last.name != "end-of-candidates" {
+		t.Errorf("stream ends with a %s event, want end-of-candidates", last.name)
+	}
+	for _, event := range events[1 : len(events)-1] {
+		if event.name != "candidate" {
+			t.Errorf("%s event among the candidates", event.name)
+			continue
+		}
+		var candidate webrtc.ICECandidateInit
+		if err = json.Unmarshal([]byte(event.data), &candidate); err != nil {
+			t.Fatalf("decoding a candidate event: %s", err.Error())
+		}
+		if err = peerConnection.AddICECandidate(candidate); err != nil {
+			t.Errorf("adding streamed candidate %q: %s", candidate.Candidate, err.Error())
+		}
+	}
+
+	eventually(t, "connecting with the streamed candidates", func() bool {
+		return peerConnection.ConnectionState() == webrtc.PeerConnectionStateConnected
+	})
+}
+
+func TestCandidateStreamNotAccepted(t *testing.T) {
+	server := startServer(t, "-stream-candidates")
+	_, offer := createOffer(t, addAudio)
+
+	res, answer := postOffer(t, server.URL+"/whip?room=complete", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType == eventStreamContentType {
+		t.Errorf("join not accepting event streams answered with %s", contentType)
+	}
+	if !strings.Contains(answer, "a=candidate:") {
+		t.Error("complete answer has no candidates")
+	}
+}
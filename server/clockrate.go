@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// g722ClockRate is the RTP clock rate of G.722, which samples at 16 kHz but
+// keeps the 8000 of RFC 1890's mistake for compatibility (RFC 3551 4.5.2).
+const g722ClockRate = 8000
+
+// rtpClockRates are the RTP clock rates the payload formats of the codecs
+// the server negotiates fix, whatever a peer's SDP says.
+var rtpClockRates = map[string]uint32{
+	mimeTypeOpus:                         opusClockRate,
+	mimeTypeRED:                          opusClockRate,
+	strings.ToLower(webrtc.MimeTypeG722): g722ClockRate,
+	mimeTypePCMU:                         g711ClockRate,
+	mimeTypePCMA:                         g711ClockRate,
+}
+
+// codecClockRate returns the RTP clock rate of the codec with mimeType
+// negotiated at negotiated, which is only trusted for codecs not in
+// rtpClockRates; false when neither is known.
+func codecClockRate(mimeType string, negotiated uint32) (uint32, bool) {
+	if clockRate, ok := rtpClockRates[strings.ToLower(mimeType)]; ok {
+		return clockRate, true
+	}
+	return negotiated, negotiated > 0
+}
+
+// trackClockRate returns the RTP clock rate of a source track.
+func trackClockRate(track *webrtc.TrackRemote) uint32 {
+	codec := track.Codec()
+	clockRate, _ := codecClockRate(codec.MimeType, codec.ClockRate)
+	return clockRate
+}
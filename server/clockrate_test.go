@@ -0,0 +1,32 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestCodecClockRate(t *testing.T) {
+	tests := []struct {
+		mimeType   string
+		negotiated uint32
+		want       uint32
+		known      bool
+	}{
+		{webrtc.MimeTypeOpus, 48000, 48000, true},
+		// Opus and G.722 fix their rates whatever the SDP says.
+		{"AUDIO/OPUS", 16000, 48000, true},
+		{webrtc.MimeTypeG722, 16000, 8000, true},
+		{webrtc.MimeTypePCMU, 8000, 8000, true},
+		{webrtc.MimeTypePCMA, 0, 8000, true},
+		// Other codecs are timed at their negotiated rate.
+		{"audio/L16", 44100, 44100, true},
+		{"audio/L16", 0, 0, false},
+	}
+	for _, test := range tests {
+		clockRate, known := codecClockRate(test.mimeType, test.negotiated)
+		if clockRate != test.want || known != test.known {
+			t.Errorf("codecClockRate(%s, %d) = %d, %t, want %d, %t", test.mimeType, test.negotiated, clockRate, known, test.want, test.known)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// reportInterval follows the RFC 3550 minimum RTCP interval.
+const reportInterval = 5 * time.Second
+
+// sendRTCPReports periodically sends the compound RTCP of the peer's relay
+// track, a Sender Report from the peer's relayClock followed by an SDES
+// binding config.CNAME to the track's SSRC, until the peer closes.
+//
+// pion advertises the track's stream ID as the SDP cname but sends no SDES
+// itself. Without it, receivers that correlate RTCP CNAMEs see the SSRC only
+// in the SDP, which confuses lip-sync grouping on some of them.
+func sendRTCPReports(peer *Peer) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-peer.done:
+			return
+		case now := <-ticker.C:
+			ssrc, ok := senderSSRC(peer.AudioSender)
+			if !ok {
+				continue
+			}
+
+			var packets []rtcp.Packet
+			if clockRate, ok := relayClockRate(peer); ok {
+				peer.mutex.Lock()
+				if sr, ok := peer.clock.report(ssrc, clockRate, now); ok {
+					packets = append(packets, sr)
+				}
+				peer.mutex.Unlock()
+			}
+
+			packets = append(packets, &rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{
+				Source: ssrc,
+				Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: config.CNAME}},
+			}}})
+			if err := peer.PeerConnection.WriteRTCP(packets); err != nil {
+				fmt.Printf("Error sending RTCP to peer %s: %s\n", peer.ID, err.Error())
+				return
+			}
+		}
+	}
+}
+
+func senderSSRC(sender *webrtc.RTPSender) (uint32, bool) {
+	encodings := sender.GetParameters().Encodings
+	if len(encodings) == 0 {
+		return 0, false
+	}
+	return uint32(encodings[0].SSRC), true
+}
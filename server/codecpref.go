@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// defaultCodecPreference is the answer's codec order without
+// -codec-preference: the order audioCodecs are registered in.
+var defaultCodecPreference = stringList{"opus", "G722", "PCMU", "PCMA"}
+
+// checkCodecPreference validates -codec-preference against the registered
+// codecs, which it names by encoding as in SDP rtpmap lines.
+func checkCodecPreference() error {
+	for _, encoding := range config.CodecPreference {
+		if !slices.Contains(knownCodecs(), strings.ToLower(encoding)) {
+			return fmt.Errorf("unknown codec %q in -codec-preference", encoding)
+		}
+	}
+	return nil
+}
+
+// codecRank returns the position of the codec in -codec-preference; codecs
+// not listed rank after all listed ones.
+func codecRank(mimeType string) int {
+	_, encoding, _ := strings.Cut(mimeType, "/")
+	for i, preferred := range config.CodecPreference {
+		if strings.EqualFold(preferred, encoding) {
+			return i
+		}
+	}
+	return len(config.CodecPreference)
+}
+
+// preferCodecs orders the codecs of the peer connection's audio
+// transceivers by -codec-preference, so that the answer lists the preferred
+// codec both sides support first and clients send it. pion otherwise keeps
+// the offer's order. Call it between SetRemoteDescription and CreateAnswer:
+// the codecs are the negotiated ones, with the offer's payload types and
+// feedback; codecs not listed keep the offer's order among themselves.
+// Codecs not among allowed, see allowedCodecs, are left out.
+func preferCodecs(peerConnection *webrtc.PeerConnection, allowed []string) error {
+	for _, transceiver := range peerConnection.GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeAudio || transceiver.Receiver() == nil {
+			continue
+		}
+
+		codecs := slices.DeleteFunc(slices.Clone(transceiver.Receiver().GetParameters().Codecs), func(codec webrtc.RTPCodecParameters) bool {
+			_, encoding, _ := strings.Cut(codec.MimeType, "/")
+			return !codecAllowed(allowed, encoding)
+		})
+		if len(codecs) == 0 {
+			continue
+		}
+		slices.SortStableFunc(codecs, func(a, b webrtc.RTPCodecParameters) int {
+			return codecRank(a.MimeType) - codecRank(b.MimeType)
+		})
+		if err := transceiver.SetCodecPreferences(codecs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
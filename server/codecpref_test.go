@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// preferredCodec returns the encoding of the first codec the answer lists
+// for its first media section.
+func preferredCodec(t *testing.T, answer string) string {
+	t.Helper()
+
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(answer)); err != nil {
+		t.Fatalf("parsing the answer: %s", err.Error())
+	}
+	media := parsed.MediaDescriptions[0]
+	for _, attribute := range media.Attributes {
+		if attribute.Key != "rtpmap" {
+			continue
+		}
+		payloadType, rtpmap, _ := strings.Cut(attribute.Value, " ")
+		if payloadType == media.MediaName.Formats[0] {
+			encoding, _, _ := strings.Cut(rtpmap, "/")
+			return encoding
+		}
+	}
+	t.Fatalf("answer has no rtpmap for payload type %s", media.MediaName.Formats[0])
+	return ""
+}
+
+func TestCodecPreference(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default", nil, "opus"},
+		{"configured", []string{"-codec-preference", "G722,PCMU"}, "G722"},
+		{"not offered", []string{"-codec-preference", "PCMA,PCMU"}, "PCMU"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startServer(t, test.args...)
+
+			// The client offers PCMU, G722 and Opus in that order, and not
+			// PCMA.
+			mediaEngine := &webrtc.MediaEngine{}
+			for _, codec := range []webrtc.RTPCodecParameters{audioCodecs[2], audioCodecs[1], audioCodecs[0]} {
+				if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+					t.Fatal(err)
+				}
+			}
+			peerConnection, offer := createOfferWith(t, webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)), addAudio)
+			answer := answerOffer(t, server.URL, "codecs", peerConnection, offer)
+
+			if preferred := preferredCodec(t, answer); !strings.EqualFold(preferred, test.want) {
+				t.Errorf("answer prefers %s, want %s", preferred, test.want)
+			}
+			sending := peerConnection.GetTransceivers()[0].Sender().GetParameters().Codecs[0].MimeType
+			if !strings.EqualFold(sending, "audio/"+test.want) {
+				t.Errorf("client sends %s, want %s", sending, test.want)
+			}
+		})
+	}
+}
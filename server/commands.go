@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Subcommands of the server binary. Flags follow the subcommand, e.g.
+// "single-whip selftest -topology mesh"; without one the server serves.
+const (
+	commandServe    = "serve"
+	commandVersion  = "version"
+	commandSelfTest = "selftest"
+	commandValidate = "validate"
+)
+
+var commands = []struct {
+	name        string
+	description string
+}{
+	{commandServe, "run the WHIP server (default)"},
+	{commandVersion, "print version information and exit"},
+	{commandSelfTest, "relay audio between two in-process clients, print PASS or FAIL and exit"},
+	{commandValidate, "report how an SDP offer read from a file, or stdin, would be answered"},
+}
+
+// parseCommand splits the subcommand off the command-line arguments.
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return commandServe, args, nil
+	}
+	for _, command := range commands {
+		if command.name == args[0] {
+			return args[0], args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+func usage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage: %s [command] [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, command := range commands {
+		fmt.Fprintf(output, "  %-10s %s\n", command.name, command.description)
+	}
+	fmt.Fprintf(output, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runValidate validates the offer in the file named by args, or stdin, and
+// prints the report, returning the exit code: 0 when the offer is valid.
+func runValidate(args []string) int {
+	input := io.Reader(os.Stdin)
+	if len(args) > 0 && args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Printf("Error reading offer: %s\n", err.Error())
+			return 2
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		input = file
+	}
+
+	offer, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Printf("Error reading offer: %s\n", err.Error())
+		return 2
+	}
+
+	report := validateOffer(string(offer))
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		fmt.Printf("Error writing validation report: %s\n", err.Error())
+		return 2
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	for _, test := range []struct {
+		args    []string
+		command string
+		rest    []string
+	}{
+		{nil, commandServe, nil},
+		{[]string{"-topology", "mesh"}, commandServe, []string{"-topology", "mesh"}},
+		{[]string{"selftest", "-topology", "mesh"}, commandSelfTest, []string{"-topology", "mesh"}},
+		{[]string{"validate", "offer.sdp"}, commandValidate, []string{"offer.sdp"}},
+		{[]string{"version"}, commandVersion, []string{}},
+	} {
+		command, rest, err := parseCommand(test.args)
+		if err != nil {
+			t.Errorf("%v: %s", test.args, err.Error())
+			continue
+		}
+		if command != test.command || strings.Join(rest, " ") != strings.Join(test.rest, " ") {
+			t.Errorf("%v parsed as %s %v, want %s %v", test.args, command, rest, test.command, test.rest)
+		}
+	}
+
+	if _, _, err := parseCommand([]string{"server"}); err == nil {
+		t.Error("unknown command accepted")
+	}
+}
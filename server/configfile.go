@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile applies the settings of a -config file: a JSON object, or
+// YAML mapping, keyed by flag name, e.g. {"max-rooms": 10, "auth-token":
+// ["a", "b"]}. Lists set repeatable flags once per element. Flags given on
+// the command line override the file. Unknown keys and values a flag
+// rejects are errors.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&settings)
+	} else {
+		err = yaml.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range settings {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		values, isList := value.([]any)
+		if !isList {
+			values = []any{value}
+		}
+		for _, value := range values {
+			text, err := configValue(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if err = flag.Set(name, text); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", text, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValue formats a scalar of the config file as its flag would be given
+// on the command line.
+func configValue(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return strconv.Itoa(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
+	case nil:
+		return "", errors.New("missing value")
+	default:
+		return "", fmt.Errorf("unsupported value %v, want a string, number, boolean or list of them", value)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// parseWithConfigFile parses args like main does, then applies a config file
+// named name holding contents.
+func parseWithConfigFile(t *testing.T, name, contents string, args ...string) error {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{}
+	flag.CommandLine = flag.NewFlagSet("single-whip", flag.ContinueOnError)
+	registerFlags(flag.CommandLine)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfigFile(path)
+}
+
+func TestConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+			"max-rooms": 10,
+			"topology": "mesh",
+			"auth-token": ["first", "second"],
+			"retry-after": "2s",
+			"transcode": true,
+			"simulate-loss": 2.5
+		}`,
+		"config.yaml": `
+max-rooms: 10
+topology: mesh
+auth-token: [first, second]
+retry-after: 2s
+transcode: true
+simulate-loss: 2.5
+`,
+	}
+	for name, contents := range files {
+		if err := parseWithConfigFile(t, name, contents, "-max-rooms", "3"); err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if config.MaxRooms != 3 {
+			t.Errorf("%s: max rooms %d, want the command line's 3", name, config.MaxRooms)
+		}
+		if config.Topology != topologyMesh || !config.Transcode || config.SimulateLoss != 2.5 || config.RetryAfter != 2*time.Second {
+			t.Errorf("%s: loaded %+v", name, config)
+		}
+		if !slices.Equal(config.AuthTokens, stringList{"first", "second"}) {
+			t.Errorf("%s: auth tokens %v, want first and second", name, config.AuthTokens)
+		}
+	}
+}
+
+func TestInvalidConfigFile(t *testing.T) {
+	tests := map[string]string{
+		"malformed.json": `{"max-rooms": 10`,
+		"malformed.yaml": "max-rooms: [10",
+		"unknown.json":   `{"max-room": 10}`,
+		"invalid.yaml":   "max-rooms: ten",
+		"nested.json":    `{"max-rooms": {"value": 10}}`,
+		"config.yaml":    "config: other.yaml",
+	}
+	for name, contents := range tests {
+		if err := parseWithConfigFile(t, name, contents); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
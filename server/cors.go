@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// addCORSHeaders lets browsers on the -cors-origin origins call the handler,
+// advertising the methods it supports, limited to -cors-method when set, and
+// OPTIONS. Requests from other origins get no CORS headers, so browsers
+// block them.
+func addCORSHeaders(res http.ResponseWriter, req *http.Request, methods ...string) {
+	origin := req.Header.Get("Origin")
+	switch {
+	case slices.Contains(config.CORSOrigins, "*"):
+		res.Header().Set("Access-Control-Allow-Origin", "*")
+	case origin != "" && slices.Contains(config.CORSOrigins, origin):
+		res.Header().Set("Access-Control-Allow-Origin", origin)
+		res.Header().Add("Vary", "Origin")
+	default:
+		return
+	}
+
+	allowed := make([]string, 0, len(methods)+1)
+	for _, method := range methods {
+		if len(config.CORSMethods) == 0 || slices.Contains(config.CORSMethods, method) {
+			allowed = append(allowed, method)
+		}
+	}
+	allowed = append(allowed, http.MethodOptions)
+	res.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+
+	if len(config.CORSHeaders) > 0 {
+		res.Header().Set("Access-Control-Allow-Headers", strings.Join(config.CORSHeaders, ", "))
+	} else {
+		// The wildcard doesn't cover Authorization.
+		res.Header().Set("Access-Control-Allow-Headers", "*, Authorization")
+	}
+	res.Header().Set("Access-Control-Expose-Headers", strings.Join([]string{"Location", "Link", resumptionTokenHeader, "Retry-After"}, ", "))
+	if config.CORSMaxAge > 0 {
+		res.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+// preflight sends a CORS preflight for method from origin to url and returns
+// the response headers.
+func preflight(t *testing.T, url, origin, method string) http.Header {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodOptions, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("preflight of %s answered %d", url, res.StatusCode)
+	}
+	return res.Header
+}
+
+func TestCORSPreflight(t *testing.T) {
+	server := startServer(t)
+	peer := server.Join(t, "cors")
+
+	tests := []struct {
+		url     string
+		methods string
+	}{
+		{server.URL + "/whip", "POST, OPTIONS"},
+		{peer.Location, "PATCH, DELETE, OPTIONS"},
+		{server.URL + "/rooms/cors/speaker", "GET, PUT, DELETE, OPTIONS"},
+	}
+	for _, test := range tests {
+		header := preflight(t, test.url, "https://app.example", http.MethodPost)
+		if methods := header.Get("Access-Control-Allow-Methods"); methods != test.methods {
+			t.Errorf("%s allows methods %q, want %q", test.url, methods, test.methods)
+		}
+		if origin := header.Get("Access-Control-Allow-Origin"); origin != "*" {
+			t.Errorf("%s allows origin %q, want *", test.url, origin)
+		}
+		if headers := header.Get("Access-Control-Allow-Headers"); headers != "*, Authorization" {
+			t.Errorf("%s allows headers %q, want *, Authorization", test.url, headers)
+		}
+	}
+}
+
+func TestCORSConfigured(t *testing.T) {
+	server := startServer(t,
+		"-cors-origin", "https://app.example",
+		"-cors-method", "post,delete",
+		"-cors-header", "Authorization,Content-Type",
+		"-cors-max-age", "10m",
+	)
+	peer := server.Join(t, "cors")
+
+	header := preflight(t, peer.Location, "https://app.example", http.MethodDelete)
+	if methods := header.Get("Access-Control-Allow-Methods"); methods != "DELETE, OPTIONS" {
+		t.Errorf("resource allows methods %q, want DELETE, OPTIONS", methods)
+	}
+	if origin := header.Get("Access-Control-Allow-Origin"); origin != "https://app.example" {
+		t.Errorf("allowed origin %q, want https://app.example", origin)
+	}
+	if vary := header.Get("Vary"); vary != "Origin" {
+		t.Errorf("Vary is %q, want Origin", vary)
+	}
+	if headers := header.Get("Access-Control-Allow-Headers"); headers != "Authorization, Content-Type" {
+		t.Errorf("allowed headers %q, want Authorization, Content-Type", headers)
+	}
+	if maxAge := header.Get("Access-Control-Max-Age"); maxAge != "600" {
+		t.Errorf("max age %q, want 600", maxAge)
+	}
+
+	header = preflight(t, peer.Location, "https://other.example", http.MethodDelete)
+	if origin := header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("unlisted origin was allowed as %q", origin)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// connectDataChannels relays messages on each of the source's data channels
+// to the channel with the same label on the peers that receive its audio.
+func connectDataChannels(room *Room, source *Peer) {
+	source.PeerConnection.OnDataChannel(func(dataChannel *webrtc.DataChannel) {
+		label := dataChannel.Label()
+
+		dataChannel.OnOpen(func() {
+			source.mutex.Lock()
+			source.dataChannels[label] = dataChannel
+			source.mutex.Unlock()
+			fmt.Printf("Data channel %q opened by peer %s (Room: %s)\n", label, source.ID, room.ID)
+		})
+
+		dataChannel.OnClose(func() {
+			source.mutex.Lock()
+			if source.dataChannels[label] == dataChannel {
+				delete(source.dataChannels, label)
+			}
+			source.mutex.Unlock()
+		})
+
+		dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+			for _, destination := range source.destinations() {
+				destinationChannel := destination.dataChannel(label)
+				if destinationChannel == nil {
+					continue
+				}
+
+				var err error
+				if msg.IsString {
+					err = destinationChannel.SendText(string(msg.Data))
+				} else {
+					err = destinationChannel.Send(msg.Data)
+				}
+				if err != nil {
+					fmt.Printf("Error relaying data channel %q to peer %s: %s\n", label, destination.ID, err.Error())
+				}
+			}
+		})
+	})
+}
+
+func (p *Peer) dataChannel(label string) *webrtc.DataChannel {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.dataChannels[label]
+}
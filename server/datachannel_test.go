@@ -0,0 +1,61 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// joinWithDataChannel joins a peer offering only a data channel labelled
+// label to room, returning the channel and the messages it receives.
+func joinWithDataChannel(t *testing.T, serverURL, room, label string) (*webrtc.DataChannel, <-chan string) {
+	t.Helper()
+
+	var dataChannel *webrtc.DataChannel
+	peerConnection, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) (err error) {
+		dataChannel, err = peerConnection.CreateDataChannel(label, nil)
+		return err
+	})
+	messages := make(chan string, 100)
+	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		select {
+		case messages <- string(msg.Data):
+		default:
+		}
+	})
+
+	answer := answerOffer(t, serverURL, room, peerConnection, offer)
+	if !strings.Contains(answer, "\r\nm=application ") || strings.Contains(answer, "\r\nm=audio ") {
+		t.Fatalf("answer to a data-channel-only offer has the wrong sections:\n%s", answer)
+	}
+	return dataChannel, messages
+}
+
+func TestDataChannelOnlyOffer(t *testing.T) {
+	server := startServer(t)
+	sender, _ := joinWithDataChannel(t, server.URL, "chat", "chat")
+	_, received := joinWithDataChannel(t, server.URL, "chat", "chat")
+
+	// The server relays to a channel once it is open on its side, so keep
+	// sending until a message gets through.
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(relayTimeout)
+	for {
+		select {
+		case <-ticker.C:
+			if sender.ReadyState() == webrtc.DataChannelStateOpen {
+				_ = sender.SendText("hello")
+			}
+		case msg := <-received:
+			if msg != "hello" {
+				t.Fatalf("relayed %q, want hello", msg)
+			}
+			return
+		case <-timeout:
+			t.Fatal("data channel message was not relayed")
+		}
+	}
+}
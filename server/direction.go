@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/pion/sdp/v3"
+)
+
+// isWHEPPath reports whether basePath, as returned by whipBasePath, is one of
+// the -whep-path egress endpoints rather than a WHIP ingest one.
+func isWHEPPath(basePath string) bool {
+	return slices.Contains(config.WHEPPaths, basePath)
+}
+
+// checkDirection enforces, with -enforce-directions, that WHIP offers send
+// audio (sendonly or sendrecv) and WHEP offers only receive it (recvonly), so
+// a client can't hold a slot on an endpoint it doesn't use. Offers without
+// audio, e.g. data channel only, pass.
+func checkDirection(offer *sdp.SessionDescription, whep bool) error {
+	if !config.EnforceDirections || !offerHasMedia(offer, "audio") {
+		return nil
+	}
+
+	sends, _ := audioDirections(offer)
+	switch {
+	case whep && sends:
+		return errors.New("WHEP offers must be recvonly; publish over WHIP")
+	case !whep && !sends:
+		return errors.New("WHIP offers must send audio (sendonly or sendrecv); subscribe over WHEP")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// drainRequest is the optional JSON body of POST /rooms/<id>/drain. Timeout,
+// a duration such as "30s", removes the peers still in the room once it
+// passes; without it the room closes when its last peer leaves.
+type drainRequest struct {
+	Timeout string `json:"timeout"`
+}
+
+// drainStatus reports how many peers remain in a draining room.
+type drainStatus struct {
+	Draining bool `json:"draining"`
+	Peers    int  `json:"peers"`
+}
+
+func roomDrainHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	var drain drainRequest
+	if err := json.NewDecoder(req.Body).Decode(&drain); err != nil && !errors.Is(err, io.EOF) {
+		writeError(res, req, "invalid drain request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var timeout time.Duration
+	if drain.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(drain.Timeout); err != nil || timeout <= 0 {
+			writeError(res, req, fmt.Sprintf("invalid drain timeout %q", drain.Timeout), http.StatusBadRequest)
+			return
+		}
+	}
+
+	peers := room.drain(timeout)
+	roomManager.removeRoomIfEmpty(room)
+	fmt.Printf("Room %s draining, %d peers remain\n", room.ID, peers)
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(drainStatus{Draining: true, Peers: peers}); err != nil {
+		fmt.Printf("Error writing drain status: %s\n", err.Error())
+	}
+}
+
+// drain stops the room admitting peers and, when timeout is positive,
+// removes those still in it after timeout. It returns the number of peers
+// left; the room closes once the last one leaves.
+func (r *Room) drain(timeout time.Duration) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.draining {
+		r.draining = true
+		r.events.add(eventDrain, "", "")
+	}
+	if timeout > 0 && r.drainTimer == nil {
+		r.drainTimer = time.AfterFunc(timeout, func() {
+			for _, peer := range r.otherPeers(nil) {
+				fmt.Printf("Drain of room %s timed out, removing peer %s\n", r.ID, peer.ID)
+				teardownPeer(peer)
+			}
+		})
+	}
+	return len(r.Peers)
+}
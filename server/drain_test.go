@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestDrainRoom(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	alice := server.Join(t, "draining")
+	bob := server.Join(t, "draining")
+	assertRelayed(t, alice, bob, relayTimeout)
+
+	status, body := request(t, http.MethodPost, server.URL+"/rooms/draining/drain", nil)
+	if status != http.StatusOK {
+		t.Fatalf("drain answered %d: %s", status, body)
+	}
+	var drained drainStatus
+	if err := json.Unmarshal([]byte(body), &drained); err != nil {
+		t.Fatal(err)
+	}
+	if !drained.Draining || drained.Peers != 2 {
+		t.Fatalf("drain status %+v, want draining with 2 peers", drained)
+	}
+
+	assertRoomFull(t, server, url.Values{"room": {"draining"}})
+
+	heard := bob.Heard(alice)
+	eventually(t, "the peers still talking while draining", func() bool {
+		return bob.Heard(alice) > heard
+	})
+	assertRelayed(t, bob, alice, relayTimeout)
+}
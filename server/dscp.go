@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dscpClasses maps the DiffServ class names accepted by -dscp to their code
+// points.
+var dscpClasses = map[string]int{
+	"CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46,
+}
+
+// parseDSCP accepts a class name such as "EF" or a numeric code point 0-63.
+func parseDSCP(value string) (int, error) {
+	if codePoint, ok := dscpClasses[strings.ToUpper(value)]; ok {
+		return codePoint, nil
+	}
+
+	codePoint, err := strconv.Atoi(value)
+	if err != nil || codePoint < 0 || codePoint > 63 {
+		return 0, fmt.Errorf("invalid DSCP value %q: want a class name like EF or a code point 0-63", value)
+	}
+	return codePoint, nil
+}
+
+// configureUDPMux serves every connection's ICE traffic from a single UDP
+// socket on config.UDPMuxPort, when set, e.g. for a firewall opening one
+// port, and marks it with the -dscp code point, if any.
+//
+// pion offers no per-packet marking, so DSCP marking needs the single socket
+// and marks the socket itself. With BUNDLE every media section of a
+// connection shares that socket, so marking can't differ per media type:
+// data channel traffic carries the same class as the audio, which is what
+// the server relays and what EF is meant for.
+//
+// Platform limitations: Linux and macOS honor the socket option for
+// unprivileged processes, though some kernels require CAP_NET_ADMIN for the
+// high-precedence classes. Windows ignores it unless a local QoS policy
+// allows the application to mark traffic. Routers outside your network
+// commonly rewrite or clear the marking.
+func configureUDPMux(settingEngine *webrtc.SettingEngine) error {
+	codePoint, err := checkUDPMux()
+	if err != nil || config.UDPMuxPort == 0 {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.UDPMuxPort})
+	if err != nil {
+		return err
+	}
+
+	if codePoint >= 0 {
+		// The socket is dual-stack, so mark both families; it is enough for
+		// one of them to succeed.
+		tos := codePoint << 2
+		errV4 := ipv4.NewConn(conn).SetTOS(tos)
+		errV6 := ipv6.NewConn(conn).SetTrafficClass(tos)
+		if errV4 != nil && errV6 != nil {
+			_ = conn.Close()
+			return fmt.Errorf("setting DSCP %d: %w", codePoint, errors.Join(errV4, errV6))
+		}
+		fmt.Printf("Marking WebRTC traffic on UDP port %d with DSCP %d\n", config.UDPMuxPort, codePoint)
+	}
+
+	settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, conn))
+	fmt.Printf("Serving ICE on UDP port %d\n", config.UDPMuxPort)
+	return nil
+}
+
+// checkUDPMux checks -udp-mux-port and -dscp without binding the socket and
+// returns the code point to mark it with, -1 for none.
+func checkUDPMux() (int, error) {
+	codePoint := -1
+	if config.DSCP != "" {
+		var err error
+		if codePoint, err = parseDSCP(config.DSCP); err != nil {
+			return 0, err
+		}
+		if config.UDPMuxPort == 0 {
+			return 0, errors.New("DSCP marking requires -udp-mux-port")
+		}
+	}
+	if config.UDPMuxPort < 0 || config.UDPMuxPort > 65535 {
+		return 0, fmt.Errorf("invalid UDP mux port %d", config.UDPMuxPort)
+	}
+	return codePoint, nil
+}
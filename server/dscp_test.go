@@ -0,0 +1,82 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestParseDSCP(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		valid bool
+	}{
+		{"EF", 46, true},
+		{"af41", 34, true},
+		{"0", 0, true},
+		{"63", 63, true},
+		{"64", 0, false},
+		{"-1", 0, false},
+		{"XX", 0, false},
+	}
+	for _, test := range tests {
+		codePoint, err := parseDSCP(test.value)
+		if test.valid != (err == nil) || codePoint != test.want {
+			t.Errorf("parseDSCP(%q) = %d, %v, want %d, valid %t", test.value, codePoint, err, test.want, test.valid)
+		}
+	}
+}
+
+// freeUDPPort returns a UDP port nothing listens on.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPMuxPortWithoutDSCP(t *testing.T) {
+	port := freeUDPPort(t)
+	server := startServer(t, "-udp-mux-port", strconv.Itoa(port))
+	alice := server.Join(t, "mux")
+	bob := server.Join(t, "mux")
+
+	answer := alice.PeerConnection.RemoteDescription().SDP
+	if !strings.Contains(answer, fmt.Sprintf(" %d typ host", port)) {
+		t.Fatalf("answer has no host candidate on port %d:\n%s", port, answer)
+	}
+	assertRelayed(t, alice, bob, relayTimeout)
+}
+
+func TestValidateDoesNotBindUDPMux(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	config = Config{}
+	peerConnectionConfiguration = webrtc.Configuration{}
+	flag.CommandLine = flag.NewFlagSet("single-whip", flag.ContinueOnError)
+	registerFlags(flag.CommandLine)
+	if err = flag.CommandLine.Parse([]string{"-udp-mux-port", strconv.Itoa(port), "-dscp", "EF"}); err != nil {
+		t.Fatal(err)
+	}
+	configure(false)
+
+	config.UDPMuxPort = 0
+	if _, err = checkUDPMux(); err == nil {
+		t.Error("-dscp accepted without -udp-mux-port")
+	}
+}
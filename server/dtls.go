@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// DTLS setup attributes accepted by -dtls-setup for the answer.
+const (
+	dtlsSetupActive  = "active"
+	dtlsSetupPassive = "passive"
+	dtlsSetupActPass = "actpass"
+)
+
+// configureDTLSSetup sets the a=setup attribute the server answers with.
+// Empty keeps pion's default, active. An answer can't be actpass (RFC 5763
+// section 5): only the offerer may leave the role open, so it is rejected
+// rather than silently answered as one of the others.
+func configureDTLSSetup(settingEngine *webrtc.SettingEngine) error {
+	switch config.DTLSSetup {
+	case "":
+		return nil
+	case dtlsSetupActive:
+		return settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleClient)
+	case dtlsSetupPassive:
+		return settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleServer)
+	case dtlsSetupActPass:
+		return fmt.Errorf("DTLS setup %q is only valid in offers; answer as %s or %s", config.DTLSSetup, dtlsSetupActive, dtlsSetupPassive)
+	default:
+		return fmt.Errorf("unknown DTLS setup %q: want %s or %s", config.DTLSSetup, dtlsSetupActive, dtlsSetupPassive)
+	}
+}
+
+// dtlsCertificateValidity is how long a certificate generated for
+// -dtls-certificate stays valid. pion's own last a month, too short to pin.
+const dtlsCertificateValidity = 365 * 24 * time.Hour
+
+// loadDTLSCertificate loads the PEM certificate and private key at path,
+// generating and saving an ECDSA P-256 pair first when the file doesn't
+// exist, so the fingerprint stays the same across restarts and can be shared
+// ahead of signaling. An expired certificate is an error rather than
+// replaced, as that would change the fingerprint.
+func loadDTLSCertificate(path string) (*webrtc.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generateDTLSCertificate(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := webrtc.CertificateFromPEM(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("DTLS certificate %s: %w", path, err)
+	}
+	if time.Now().After(certificate.Expires()) {
+		return nil, fmt.Errorf("DTLS certificate %s expired on %s; delete it to generate a new one", path, certificate.Expires().Format(time.DateOnly))
+	}
+	return certificate, nil
+}
+
+func generateDTLSCertificate(path string) (*webrtc.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	certificate, err := webrtc.NewCertificate(key, x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: "single-whip"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(dtlsCertificateValidity),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := certificate.PEM()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(path, []byte(data), 0o600); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Generated DTLS certificate %s\n", path)
+	return certificate, nil
+}
+
+// configureDTLSCertificate makes every peer connection use the certificate of
+// -dtls-certificate, when set, instead of the one pion generates per process.
+func configureDTLSCertificate(configuration *webrtc.Configuration) error {
+	if config.DTLSCertificate == "" {
+		return nil
+	}
+
+	certificate, err := loadDTLSCertificate(config.DTLSCertificate)
+	if err != nil {
+		return err
+	}
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		return err
+	}
+	for _, fingerprint := range fingerprints {
+		fmt.Printf("DTLS fingerprint: %s %s (expires %s)\n", fingerprint.Algorithm, fingerprint.Value, certificate.Expires().Format(time.DateOnly))
+	}
+
+	configuration.Certificates = []webrtc.Certificate{*certificate}
+	return nil
+}
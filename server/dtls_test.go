@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// answerTo joins an audio peer to room and returns the answer.
+func answerTo(t *testing.T, serverURL, room string) string {
+	t.Helper()
+
+	_, offer := createOffer(t, addAudio)
+	res, answer := postOffer(t, serverURL+"/whip?room="+room, offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	return answer
+}
+
+func TestDTLSSetup(t *testing.T) {
+	for _, test := range []struct {
+		setup string
+		want  string
+	}{
+		{"", "a=setup:active"},
+		{dtlsSetupActive, "a=setup:active"},
+		{dtlsSetupPassive, "a=setup:passive"},
+	} {
+		server := startServer(t, "-dtls-setup", test.setup)
+		if answer := answerTo(t, server.URL, "setup"); !strings.Contains(answer, "\r\n"+test.want+"\r\n") {
+			t.Errorf("-dtls-setup %q answered without %s:\n%s", test.setup, test.want, answer)
+		}
+	}
+
+	config.DTLSSetup = dtlsSetupActPass
+	if err := configureDTLSSetup(&webrtc.SettingEngine{}); err == nil {
+		t.Error("answering as actpass was accepted")
+	}
+}
+
+// answerFingerprint returns the value of the answer's a=fingerprint line.
+func answerFingerprint(t *testing.T, answer string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(answer, "\r\n") {
+		if value, ok := strings.CutPrefix(line, "a=fingerprint:"); ok {
+			return value
+		}
+	}
+	t.Fatalf("answer has no fingerprint:\n%s", answer)
+	return ""
+}
+
+func TestDTLSCertificate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dtls.pem")
+
+	// The first start generates the certificate, the next loads it.
+	server := startServer(t, "-dtls-certificate", path)
+	generated := answerFingerprint(t, answerTo(t, server.URL, "pinned"))
+	server = startServer(t, "-dtls-certificate", path)
+	loaded := answerFingerprint(t, answerTo(t, server.URL, "pinned"))
+	if loaded != generated {
+		t.Errorf("fingerprint changed from %s to %s across starts", generated, loaded)
+	}
+
+	certificate, err := loadDTLSCertificate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fingerprints[0].Algorithm + " " + fingerprints[0].Value; !strings.EqualFold(loaded, want) {
+		t.Errorf("answer fingerprint %s, want the certificate's %s", loaded, want)
+	}
+
+	// Without a certificate each start generates its own.
+	server = startServer(t)
+	if fingerprint := answerFingerprint(t, answerTo(t, server.URL, "unpinned")); fingerprint == loaded {
+		t.Error("server without -dtls-certificate answered with the pinned fingerprint")
+	}
+}
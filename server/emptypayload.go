@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Values of -empty-payloads.
+const (
+	emptyPayloadsRelay = "relay"
+	emptyPayloadsDrop  = "drop"
+	emptyPayloadsAuto  = "auto"
+)
+
+func validateEmptyPayloads(mode string) error {
+	switch mode {
+	case emptyPayloadsRelay, emptyPayloadsDrop, emptyPayloadsAuto:
+		return nil
+	default:
+		return fmt.Errorf("unknown empty-payloads %q: want relay, drop or auto", mode)
+	}
+}
+
+// relaysEmptyPayloads reports whether the source track's packets without
+// payload are relayed. With DTX, an Opus source sends such packets, or tiny
+// ones, while silent, and receivers rely on them to tell silence from loss,
+// so -empty-payloads auto relays them from sources that negotiated usedtx=1
+// and drops them from others, where they are typically padding.
+func relaysEmptyPayloads(track *webrtc.TrackRemote) bool {
+	switch config.EmptyPayloads {
+	case emptyPayloadsRelay:
+		return true
+	case emptyPayloadsDrop:
+		return false
+	default:
+		return negotiatesDTX(track.Codec())
+	}
+}
+
+// negotiatesDTX reports whether the codec's fmtp enables discontinuous
+// transmission.
+func negotiatesDTX(codec webrtc.RTPCodecParameters) bool {
+	for _, parameter := range strings.Split(codec.SDPFmtpLine, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+		if strings.EqualFold(key, "usedtx") && value == "1" {
+			return true
+		}
+	}
+	return false
+}
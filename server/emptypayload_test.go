@@ -0,0 +1,100 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// joinSendingEmpty joins a peer to room sending Opus negotiated with fmtp
+// until the test ends, every other packet without payload.
+func joinSendingEmpty(t *testing.T, serverURL, room, fmtp string) {
+	t.Helper()
+
+	codec := audioCodecs[0]
+	codec.SDPFmtpLine = fmtp
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(codec.RTPCodecCapability, "audio", "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerConnection, offer := createOfferWith(t, webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)), func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTrack(track)
+		return err
+	})
+	answerOffer(t, serverURL, room, peerConnection, offer)
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		pkt := &rtp.Packet{Header: rtp.Header{Version: 2}}
+		for {
+			select {
+			case <-ticker.C:
+				pkt.SequenceNumber++
+				pkt.Timestamp += 960
+				pkt.Payload = nil
+				if pkt.SequenceNumber%2 == 0 {
+					pkt.Payload = []byte{1}
+				}
+				_ = track.WriteRTP(pkt)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func TestEmptyPayloads(t *testing.T) {
+	tests := []struct {
+		mode    string
+		fmtp    string
+		relayed bool
+	}{
+		{emptyPayloadsRelay, "minptime=10;useinbandfec=1", true},
+		{emptyPayloadsDrop, "minptime=10;useinbandfec=1;usedtx=1", false},
+		{emptyPayloadsAuto, "minptime=10;useinbandfec=1", false},
+		{emptyPayloadsAuto, "minptime=10;useinbandfec=1;usedtx=1", true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode+" "+test.fmtp, func(t *testing.T) {
+			server := startServer(t, "-empty-payloads", test.mode)
+			_, packets := joinWithCodec(t, server.URL, "dtx", audioCodecs[0])
+			dropped := relayStats.PacketsEmptyDropped.Load()
+			joinSendingEmpty(t, server.URL, "dtx", test.fmtp)
+
+			empty, full := 0, 0
+			deadline := time.After(relayTimeout)
+			for full < 25 {
+				select {
+				case pkt := <-packets:
+					if len(pkt.Payload) == 0 {
+						empty++
+					} else {
+						full++
+					}
+				case <-deadline:
+					t.Fatalf("subscriber heard %d packets with payload, want 25", full)
+				}
+			}
+
+			if relayed := empty > 0; relayed != test.relayed {
+				t.Errorf("%d empty packets relayed along %d with payload, want relayed %t", empty, full, test.relayed)
+			}
+			counted := relayStats.PacketsEmptyDropped.Load() - dropped
+			if test.relayed && counted != 0 {
+				t.Errorf("%d empty packets counted as dropped while relaying them", counted)
+			}
+			if !test.relayed && counted < 20 {
+				t.Errorf("%d empty packets counted as dropped, want at least 20", counted)
+			}
+		})
+	}
+}
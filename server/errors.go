@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorBody is the JSON error response.
+type errorBody struct {
+	Error string `json:"error"`
+	// Reason is a machine-readable cause, set by writeOverloaded.
+	Reason string `json:"reason,omitempty"`
+}
+
+// writeError writes an error response in the format req accepts: JSON
+// {"error": message} when it prefers application/json, plain text like
+// http.Error otherwise.
+func writeError(res http.ResponseWriter, req *http.Request, message string, code int) {
+	if !prefersJSON(req) {
+		http.Error(res, message, code)
+		return
+	}
+
+	res.Header().Del("Content-Length")
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(code)
+	encoder := json.NewEncoder(res)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(errorBody{Error: message}); err != nil {
+		fmt.Printf("Error writing error response: %s\n", err.Error())
+	}
+}
+
+// prefersJSON reports whether the Accept header ranks JSON above plain text.
+// Ties, including a missing header or */*, keep the plain-text default.
+func prefersJSON(req *http.Request) bool {
+	var jsonQuality, textQuality float64
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaRange, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case mediaRange == "application/json" || strings.HasSuffix(mediaRange, "+json"):
+			jsonQuality = max(jsonQuality, quality)
+		case mediaRange == "text/plain" || mediaRange == "text/*" || mediaRange == "*/*":
+			textQuality = max(textQuality, quality)
+		}
+	}
+	return jsonQuality > textQuality
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"application/json", "application/json", `{"error":"room <a> & co. not found"}` + "\n"},
+		{"text/plain;q=0.5, application/problem+json", "application/json", `{"error":"room <a> & co. not found"}` + "\n"},
+		{"text/plain", "text/plain; charset=utf-8", "room <a> & co. not found\n"},
+		{"application/json;q=0.5, text/plain", "text/plain; charset=utf-8", "room <a> & co. not found\n"},
+		{"*/*", "text/plain; charset=utf-8", "room <a> & co. not found\n"},
+		{"", "text/plain; charset=utf-8", "room <a> & co. not found\n"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/whip", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		recorder := httptest.NewRecorder()
+		writeError(recorder, req, "room <a> & co. not found", http.StatusNotFound)
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("Accept %q: status %d, want 404", test.accept, recorder.Code)
+		}
+		if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("Accept %q: Content-Type %q, want %q", test.accept, contentType, test.contentType)
+		}
+		if body := recorder.Body.String(); body != test.body {
+			t.Errorf("Accept %q: body %q, want %q", test.accept, body, test.body)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// roomEventLogSize caps the events kept per room; older ones are dropped.
+const roomEventLogSize = 100
+
+// RoomEvent types.
+const (
+	eventJoin      = "join"
+	eventLeave     = "leave"
+	eventPair      = "pair"
+	eventPublisher = "publisher"
+	eventKick      = "kick"
+	eventDrain     = "drain"
+	eventInactive  = "inactive"
+	eventExpired   = "expired"
+	eventBye       = "bye"
+	eventSpeaker   = "speaker"
+	eventError     = "error"
+)
+
+// RoomEvent is an entry of a room's event log.
+type RoomEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	PeerID  string    `json:"peerId,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// roomEventLog is a ring buffer of a room's latest events, guarded by the
+// room's mutex.
+type roomEventLog struct {
+	events []RoomEvent
+	next   int
+}
+
+func (l *roomEventLog) add(eventType, peerID, message string) {
+	event := RoomEvent{Time: time.Now(), Type: eventType, PeerID: peerID, Message: message}
+	if len(l.events) < roomEventLogSize {
+		l.events = append(l.events, event)
+		return
+	}
+	l.events[l.next] = event
+	l.next = (l.next + 1) % roomEventLogSize
+}
+
+// list returns the events oldest first.
+func (l *roomEventLog) list() []RoomEvent {
+	return append(append([]RoomEvent{}, l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// logEvent adds an event to the room's log.
+func (r *Room) logEvent(eventType, peerID, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events.add(eventType, peerID, message)
+}
+
+// roomEvents is the response of GET /rooms/<id>/events.
+type roomEvents struct {
+	Room   string      `json:"room"`
+	Events []RoomEvent `json:"events"`
+}
+
+func roomEventsHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodGet)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	room.mutex.Lock()
+	events := roomEvents{Room: room.ID, Events: room.events.list()}
+	room.mutex.Unlock()
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(events); err != nil {
+		fmt.Printf("Error writing room events: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRoomEventLogBounded(t *testing.T) {
+	var log roomEventLog
+	for i := range roomEventLogSize + 10 {
+		log.add(eventJoin, fmt.Sprint(i), "")
+	}
+
+	events := log.list()
+	if len(events) != roomEventLogSize {
+		t.Fatalf("log holds %d events, want %d", len(events), roomEventLogSize)
+	}
+	if first, last := events[0].PeerID, events[len(events)-1].PeerID; first != "10" || last != fmt.Sprint(roomEventLogSize+9) {
+		t.Errorf("log runs from %s to %s, want the latest %d oldest first", first, last, roomEventLogSize)
+	}
+}
+
+func TestRoomEvents(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	query := url.Values{"room": {"logged"}, "topology": {"pairs"}}
+	alice := server.JoinQuery(t, query)
+	bob := server.JoinQuery(t, query)
+	assertRelayed(t, alice, bob, relayTimeout)
+	if _, err := server.TryJoin(t, query); err == nil {
+		t.Fatal("third peer joined a pairs room")
+	}
+	bob.Close()
+	eventually(t, "bob leaving", func() bool { return roomManager.findPeer(bob.ID) == nil })
+
+	if status := statusWithToken(t, http.MethodGet, server.URL+"/rooms/logged/events", ""); status != http.StatusUnauthorized {
+		t.Errorf("events GET without the admin token answered %d, want 401", status)
+	}
+	if status, _ := request(t, http.MethodGet, server.URL+"/rooms/missing/events", nil); status != http.StatusNotFound {
+		t.Errorf("events GET of a missing room answered %d, want 404", status)
+	}
+
+	status, body := request(t, http.MethodGet, server.URL+"/rooms/logged/events", nil)
+	if status != http.StatusOK {
+		t.Fatalf("events GET answered %d: %s", status, body)
+	}
+	var log roomEvents
+	if err := json.Unmarshal([]byte(body), &log); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, event := range log.Events {
+		got = append(got, event.Type)
+		if event.Type != eventError && event.PeerID != alice.ID && event.PeerID != bob.ID {
+			t.Errorf("%s event of peer %q, neither alice nor bob", event.Type, event.PeerID)
+		}
+	}
+	want := []string{eventJoin, eventJoin, eventPair, eventError, eventLeave}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("room logged %v, want %v", got, want)
+	}
+}
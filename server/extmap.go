@@ -0,0 +1,42 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/pion/sdp/v3"
+)
+
+// hoistExtmapAllowMixed moves an offer's media-level a=extmap-allow-mixed
+// to the session level, returning whether it changed the offer. RFC 8285
+// allows the attribute at either level, but pion only answers it at the
+// session level and otherwise drops it, which some clients reject. It is
+// hoisted only when every active media section carries it, as at the
+// session level it covers them all.
+func hoistExtmapAllowMixed(offer *sdp.SessionDescription) bool {
+	if hasAttribute(offer.Attributes, sdp.AttrKeyExtMapAllowMixed) {
+		return false
+	}
+
+	active := 0
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Port.Value == 0 {
+			continue
+		}
+		if !hasAttribute(media.Attributes, sdp.AttrKeyExtMapAllowMixed) {
+			return false
+		}
+		active++
+	}
+	if active == 0 {
+		return false
+	}
+
+	offer.Attributes = append(offer.Attributes, sdp.NewPropertyAttribute(sdp.AttrKeyExtMapAllowMixed))
+	return true
+}
+
+func hasAttribute(attributes []sdp.Attribute, key string) bool {
+	return slices.ContainsFunc(attributes, func(attribute sdp.Attribute) bool {
+		return attribute.Key == key
+	})
+}
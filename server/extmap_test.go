@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// mediaLevelAllowMixed moves the offer's session-level a=extmap-allow-mixed
+// into each media section.
+func mediaLevelAllowMixed(t *testing.T, offer string) string {
+	t.Helper()
+
+	const line = "a=" + sdp.AttrKeyExtMapAllowMixed
+	moved := withoutLine(offer, line)
+	if moved == offer {
+		t.Fatalf("offer has no %s to move", line)
+	}
+	return strings.ReplaceAll(moved, "\r\na=mid:", "\r\n"+line+"\r\na=mid:")
+}
+
+func TestExtmapAllowMixedHoisted(t *testing.T) {
+	server := startServer(t)
+	peerConnection, offer := createOffer(t, addAudio)
+	connected := make(chan struct{}, 1)
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	answer := answerOffer(t, server.URL, "mixed", peerConnection, mediaLevelAllowMixed(t, offer))
+	session := answer[:strings.Index(answer, "\r\nm=")]
+	if !strings.Contains(session, "\r\na="+sdp.AttrKeyExtMapAllowMixed+"\r\n") {
+		t.Errorf("answer to a media-level a=extmap-allow-mixed doesn't carry it:\n%s", answer)
+	}
+	select {
+	case <-connected:
+	case <-time.After(relayTimeout):
+		t.Fatal("handshake didn't complete")
+	}
+}
+
+func TestExtmapAllowMixedInSomeSections(t *testing.T) {
+	_, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		if err := addAudio(peerConnection); err != nil {
+			return err
+		}
+		return addAudio(peerConnection)
+	})
+	offer = mediaLevelAllowMixed(t, offer)
+	// Only the first section keeps it.
+	second := strings.LastIndex(offer, "\r\nm=")
+	offer = offer[:second] + withoutLine(offer[second:], "a="+sdp.AttrKeyExtMapAllowMixed)
+
+	var parsed sdp.SessionDescription
+	if err := parsed.Unmarshal([]byte(offer)); err != nil {
+		t.Fatal(err)
+	}
+	if hoistExtmapAllowMixed(&parsed) {
+		t.Error("a=extmap-allow-mixed of one section was hoisted over both")
+	}
+}
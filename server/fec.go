@@ -0,0 +1,47 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// fecAdaptation decides, from the worst loss a source's listeners report,
+// whether the source should protect its Opus stream with more in-band FEC:
+// raised once loss reaches -fec-loss-high, relaxed again once it falls to
+// -fec-loss-low, the gap keeping loss hovering at one threshold from
+// flapping. The decisions are only logged for now, to tune the thresholds
+// against real traffic before the server acts on them; until then sources
+// adapt to the relayed Receiver Reports alone.
+type fecAdaptation struct {
+	source *Peer
+	raised bool
+}
+
+// update feeds the worst fraction lost (out of 256) of a report interval,
+// logging when the decision changes.
+func (a *fecAdaptation) update(fractionLost uint8) {
+	loss := float64(fractionLost) * 100 / 256
+
+	switch {
+	case !a.raised && loss >= config.FECLossHigh:
+		a.raised = true
+		fmt.Printf("Peer %s: listeners report %.1f%% loss, would raise Opus FEC\n", a.source.ID, loss)
+	case a.raised && loss <= config.FECLossLow:
+		a.raised = false
+		fmt.Printf("Peer %s: listeners report %.1f%% loss, would relax Opus FEC\n", a.source.ID, loss)
+	}
+}
+
+// validateFECAdaptation checks the -fec-* flags.
+func validateFECAdaptation() error {
+	if !config.FECAdaptation {
+		return nil
+	}
+	if !config.RelayReceiverReports {
+		return errors.New("fec-adaptation needs -relay-receiver-reports")
+	}
+	if config.FECLossLow < 0 || config.FECLossHigh > 100 || config.FECLossLow >= config.FECLossHigh {
+		return errors.New("fec-loss-low and fec-loss-high must satisfy 0 <= low < high <= 100")
+	}
+	return nil
+}
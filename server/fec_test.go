@@ -0,0 +1,49 @@
+package server
+
+import "testing"
+
+func TestFECAdaptation(t *testing.T) {
+	config = Config{FECLossHigh: 10, FECLossLow: 2}
+	adaptation := &fecAdaptation{source: &Peer{ID: "source"}}
+
+	// Fractions lost are out of 256: 13 is 5.1%, 26 is 10.2% and 5 is 2%.
+	steps := []struct {
+		fractionLost uint8
+		raised       bool
+	}{
+		{0, false},
+		{13, false},
+		{26, true},
+		// Between the thresholds the decision holds.
+		{13, true},
+		{5, false},
+		{13, false},
+		{255, true},
+	}
+	for i, step := range steps {
+		adaptation.update(step.fractionLost)
+		if adaptation.raised != step.raised {
+			t.Errorf("step %d: FEC raised %t at %d/256 loss, want %t", i, adaptation.raised, step.fractionLost, step.raised)
+		}
+	}
+}
+
+func TestValidateFECAdaptation(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		valid bool
+	}{
+		{"disabled", Config{FECLossHigh: 1, FECLossLow: 5}, true},
+		{"valid", Config{FECAdaptation: true, RelayReceiverReports: true, FECLossHigh: 10, FECLossLow: 2}, true},
+		{"without receiver reports", Config{FECAdaptation: true, FECLossHigh: 10, FECLossLow: 2}, false},
+		{"low above high", Config{FECAdaptation: true, RelayReceiverReports: true, FECLossHigh: 2, FECLossLow: 10}, false},
+		{"high above 100", Config{FECAdaptation: true, RelayReceiverReports: true, FECLossHigh: 150, FECLossLow: 2}, false},
+	}
+	for _, test := range tests {
+		config = test.cfg
+		if err := validateFECAdaptation(); (err == nil) != test.valid {
+			t.Errorf("%s: validateFECAdaptation() = %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
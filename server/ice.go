@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// sdpFragContentType is the WHIP media type for trickle ICE and ICE restart
+// PATCH bodies (RFC 8840).
+const sdpFragContentType = "application/trickle-ice-sdpfrag"
+
+// sdpFragment is the subset of an SDP fragment WHIP uses: ICE credentials and
+// candidates for the bundled media section.
+type sdpFragment struct {
+	Ufrag string
+	Pwd   string
+	Mid   string
+	// Media is the m= line value of the bundled media section, e.g.
+	// "audio 9 UDP/TLS/RTP/SAVPF 111"; RFC 8840 fragments repeat it.
+	Media           string
+	Candidates      []string
+	EndOfCandidates bool
+}
+
+func parseSDPFragment(body string) sdpFragment {
+	var fragment sdpFragment
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, _ := strings.Cut(strings.TrimPrefix(line, "a="), ":")
+
+		switch {
+		case strings.HasPrefix(line, "m=") && fragment.Media == "":
+			fragment.Media = strings.TrimPrefix(line, "m=")
+		case !strings.HasPrefix(line, "a="):
+		case key == "ice-ufrag":
+			fragment.Ufrag = value
+		case key == "ice-pwd":
+			fragment.Pwd = value
+		case key == "mid" && fragment.Mid == "":
+			fragment.Mid = value
+		case key == "candidate":
+			fragment.Candidates = append(fragment.Candidates, "candidate:"+value)
+		case key == "end-of-candidates":
+			fragment.EndOfCandidates = true
+		}
+	}
+	return fragment
+}
+
+func (f sdpFragment) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "a=ice-ufrag:%s\r\na=ice-pwd:%s\r\n", f.Ufrag, f.Pwd)
+	media := f.Media
+	if media == "" {
+		media = "audio 9 UDP/TLS/RTP/SAVPF 0"
+	}
+	fmt.Fprintf(&b, "m=%s\r\na=mid:%s\r\n", media, f.Mid)
+	for _, candidate := range f.Candidates {
+		fmt.Fprintf(&b, "a=%s\r\n", candidate)
+	}
+	if f.EndOfCandidates {
+		b.WriteString("a=end-of-candidates\r\n")
+	}
+	return b.String()
+}
+
+// fragmentFromDescription extracts the ICE credentials and candidates of the
+// first media section, which carries the whole bundle.
+func fragmentFromDescription(description *sdp.SessionDescription) sdpFragment {
+	fragment := sdpFragment{}
+	if ufrag, ok := description.Attribute("ice-ufrag"); ok {
+		fragment.Ufrag = ufrag
+	}
+	if pwd, ok := description.Attribute("ice-pwd"); ok {
+		fragment.Pwd = pwd
+	}
+	if len(description.MediaDescriptions) == 0 {
+		return fragment
+	}
+
+	media := description.MediaDescriptions[0]
+	fragment.Media = fmt.Sprintf("%s 9 %s %s", media.MediaName.Media,
+		strings.Join(media.MediaName.Protos, "/"), strings.Join(media.MediaName.Formats, " "))
+	for _, attribute := range media.Attributes {
+		switch attribute.Key {
+		case "ice-ufrag":
+			fragment.Ufrag = attribute.Value
+		case "ice-pwd":
+			fragment.Pwd = attribute.Value
+		case "mid":
+			fragment.Mid = attribute.Value
+		case "candidate":
+			fragment.Candidates = append(fragment.Candidates, "candidate:"+attribute.Value)
+		case "end-of-candidates":
+			fragment.EndOfCandidates = true
+		}
+	}
+	return fragment
+}
+
+// handleICEFragment applies a WHIP sdpfrag PATCH. Candidates for the current
+// ICE session are trickled in and answered with 204; new credentials restart
+// ICE and are answered with the server's new credentials and candidates.
+func handleICEFragment(res http.ResponseWriter, req *http.Request, peer *Peer) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fragment := parseSDPFragment(string(body))
+
+	remote := peer.PeerConnection.RemoteDescription()
+	if remote == nil {
+		writeError(res, req, "session has no remote description", http.StatusConflict)
+		return
+	}
+	parsedRemote, err := remote.Unmarshal()
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if fragment.Ufrag == "" || fragment.Ufrag == fragmentFromDescription(parsedRemote).Ufrag {
+		// WHIP answers a resource supporting ICE restarts but not trickle
+		// ICE with 422.
+		if !config.TrickleICE && (len(fragment.Candidates) > 0 || fragment.EndOfCandidates) {
+			writeError(res, req, "trickle ICE is not supported", http.StatusUnprocessableEntity)
+			return
+		}
+		for _, candidate := range fragment.Candidates {
+			mid := fragment.Mid
+			if err = peer.PeerConnection.AddICECandidate(webrtc.ICECandidateInit{
+				Candidate: candidate, SDPMid: &mid,
+			}); err != nil {
+				writeError(res, req, "invalid candidate: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// WHIP answers a resource supporting trickle ICE but not ICE restarts
+	// with 422 too.
+	if customICECredentials() {
+		writeError(res, req, "ICE restarts are not supported with custom ICE credential lengths", http.StatusUnprocessableEntity)
+		return
+	}
+
+	fmt.Printf("ICE restart requested by peer %s\n", peer.ID)
+	replaceICECredentials(parsedRemote, fragment)
+	offer, err := parsedRemote.Marshal()
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logSDP("ICE restart offer", peer, string(offer))
+	if err = peer.PeerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer, SDP: string(offer),
+	}); err != nil {
+		writeError(res, req, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peer.PeerConnection)
+	answer, err := peer.PeerConnection.CreateAnswer(nil)
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = peer.PeerConnection.SetLocalDescription(answer); err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !awaitGathering(req.Context(), peer, gatherComplete) {
+		return
+	}
+
+	logSDP("ICE restart answer", peer, peer.PeerConnection.LocalDescription().SDP)
+	parsedLocal, err := peer.PeerConnection.LocalDescription().Unmarshal()
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", sdpFragContentType)
+	res.WriteHeader(http.StatusOK)
+	if _, err = fmt.Fprint(res, fragmentFromDescription(parsedLocal).String()); err != nil {
+		fmt.Printf("Error writing ICE restart answer: %s\n", err.Error())
+	}
+}
+
+// localAnswer returns the peer connection's answer as sent to the client.
+// With -trickle-ice it carries the session-level a=ice-options:trickle RFC
+// 8840 has answers announce trickle ICE support with, which pion leaves
+// out, a=rtcp-rsize only where offered, see answerRTCPRsize, and the b=AS
+// line of the peer's room, see withAnswerBitrate; pion rejects modified
+// local descriptions, so all are fixed up here.
+func localAnswer(peer *Peer) string {
+	peerConnection := peer.PeerConnection
+	answer := answerRTCPRsize(peerConnection.LocalDescription().SDP, peerConnection.RemoteDescription())
+	answer = withAnswerBitrate(answer, peer.rooms[0].Options.AnswerBitrate)
+	media := strings.Index(answer, "\r\nm=")
+	if !config.TrickleICE || media < 0 || strings.Contains(answer[:media], "a=ice-options:") {
+		return answer
+	}
+	return answer[:media] + "\r\na=ice-options:trickle" + answer[media:]
+}
+
+// replaceICECredentials rewrites a remote offer with the fragment's ICE
+// credentials and candidates, turning it into an ICE restart offer.
+func replaceICECredentials(description *sdp.SessionDescription, fragment sdpFragment) {
+	description.Attributes = withoutICEAttributes(description.Attributes)
+	description.Origin.SessionVersion++
+
+	for i, media := range description.MediaDescriptions {
+		media.Attributes = withoutICEAttributes(media.Attributes)
+		media.WithValueAttribute("ice-ufrag", fragment.Ufrag)
+		media.WithValueAttribute("ice-pwd", fragment.Pwd)
+
+		// Candidates belong to the fragment's media section, the first one
+		// if it names none.
+		mid, _ := media.Attribute("mid")
+		if fragment.Mid == "" && i != 0 || fragment.Mid != "" && mid != fragment.Mid {
+			continue
+		}
+		for _, candidate := range fragment.Candidates {
+			media.WithValueAttribute("candidate", strings.TrimPrefix(candidate, "candidate:"))
+		}
+		if fragment.EndOfCandidates {
+			media.WithPropertyAttribute("end-of-candidates")
+		}
+	}
+}
+
+func withoutICEAttributes(attributes []sdp.Attribute) []sdp.Attribute {
+	kept := attributes[:0]
+	for _, attribute := range attributes {
+		switch attribute.Key {
+		case "ice-ufrag", "ice-pwd", "candidate", "end-of-candidates":
+		default:
+			kept = append(kept, attribute)
+		}
+	}
+	return kept
+}
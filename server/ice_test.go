@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// parseDescription parses description apart from the one a connection
+// keeps, which Unmarshal would write to while the connection reads it.
+func parseDescription(t *testing.T, description *webrtc.SessionDescription) *sdp.SessionDescription {
+	t.Helper()
+
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.UnmarshalString(description.SDP); err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// patchFragment PATCHes fragment to the peer's resource and returns the
+// status and body of the response.
+func patchFragment(t *testing.T, peer *synthpeer.Peer, fragment sdpFragment) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPatch, peer.Location, strings.NewReader(fragment.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", sdpFragContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestICERestart(t *testing.T) {
+	server := startServer(t)
+	alice := server.Join(t, "restart")
+	bob := server.Join(t, "restart")
+	assertRelayed(t, alice, bob, relayTimeout)
+
+	offer, err := alice.PeerConnection.LocalDescription().Unmarshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := fragmentFromDescription(offer)
+
+	// The current credentials only trickle candidates.
+	if status, body := patchFragment(t, alice, sdpFragment{Ufrag: current.Ufrag, Pwd: current.Pwd, Mid: current.Mid}); status != http.StatusNoContent {
+		t.Fatalf("trickle PATCH answered %d: %s", status, body)
+	}
+
+	restart := sdpFragment{Ufrag: "restartufrag", Pwd: "restartpasswordrestartpassword", Mid: current.Mid}
+	status, body := patchFragment(t, alice, restart)
+	if status != http.StatusOK {
+		t.Fatalf("ICE restart answered %d: %s", status, body)
+	}
+	answer := parseSDPFragment(body)
+	if answer.Ufrag == "" || answer.Pwd == "" || !strings.HasPrefix(answer.Media, "audio ") {
+		t.Fatalf("ICE restart answer lacks credentials or media:\n%s", body)
+	}
+	remote := parseDescription(t, roomManager.findPeer(alice.ID).PeerConnection.RemoteDescription())
+	if ufrag := fragmentFromDescription(remote).Ufrag; ufrag != restart.Ufrag {
+		t.Errorf("server's remote ufrag is %q, want the restart's %q", ufrag, restart.Ufrag)
+	}
+}
+
+func TestSDPFragmentMedia(t *testing.T) {
+	server := startServer(t)
+	alice := server.Join(t, "fragment")
+
+	fragment := fragmentFromDescription(parseDescription(t, alice.PeerConnection.RemoteDescription()))
+	if !strings.HasPrefix(fragment.Media, "audio 9 UDP/TLS/RTP/SAVPF ") || strings.HasSuffix(fragment.Media, " 0") {
+		t.Fatalf("fragment media is %q, want the answer's audio section", fragment.Media)
+	}
+	if parsed := parseSDPFragment(fragment.String()); parsed.Media != fragment.Media || parsed.Mid != fragment.Mid {
+		t.Fatalf("fragment round-tripped to media %q mid %q, want %q %q",
+			parsed.Media, parsed.Mid, fragment.Media, fragment.Mid)
+	}
+}
+
+func TestAnswerICEOptionsTrickle(t *testing.T) {
+	tests := []struct {
+		args    []string
+		trickle bool
+	}{
+		{nil, true},
+		{[]string{"-trickle-ice=false"}, false},
+	}
+	for _, test := range tests {
+		server := startServer(t, test.args...)
+		answer := answerTo(t, server.URL, "trickle")
+
+		// The attribute is session-level, before the first media section.
+		session, _, _ := strings.Cut(answer, "\r\nm=")
+		if advertised := strings.Contains(session+"\r\n", "\r\na=ice-options:trickle\r\n"); advertised != test.trickle {
+			t.Errorf("%v: answer advertises trickle %t, want %t:\n%s", test.args, advertised, test.trickle, answer)
+		}
+		if count := strings.Count(answer, "a=ice-options:"); count > 1 {
+			t.Errorf("%v: answer has %d ice-options lines", test.args, count)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+)
+
+// ICE credential lengths, in characters. pion generates 16-character ufrags
+// and 32-character passwords, well above the RFC 8839 minimums, but some
+// carrier-grade NATs and SBCs in mobile networks insist on longer ones.
+const (
+	pionICEUfragLength = 16
+	pionICEPwdLength   = 32
+	minICEUfragLength  = 4
+	minICEPwdLength    = 22
+	maxICECharLength   = 256
+)
+
+// iceChars are the characters credentials are drawn from, the ice-char
+// alphabet less "+" and "/".
+const iceChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// The components of webrtcAPI, kept to build an API per connection when
+// -ice-ufrag-length or -ice-pwd-length asks for custom credentials: pion
+// only takes them from the SettingEngine.
+var (
+	webrtcMediaEngine   *webrtc.MediaEngine
+	webrtcInterceptors  *interceptor.Registry
+	webrtcSettingEngine webrtc.SettingEngine
+)
+
+// validateICECredentialLengths checks the configured lengths against RFC
+// 8839, zero keeping pion's.
+func validateICECredentialLengths() error {
+	if length := config.ICEUfragLength; length != 0 && (length < minICEUfragLength || length > maxICECharLength) {
+		return fmt.Errorf("ice-ufrag-length must be between %d and %d, got %d", minICEUfragLength, maxICECharLength, length)
+	}
+	if length := config.ICEPwdLength; length != 0 && (length < minICEPwdLength || length > maxICECharLength) {
+		return fmt.Errorf("ice-pwd-length must be between %d and %d, got %d", minICEPwdLength, maxICECharLength, length)
+	}
+	return nil
+}
+
+// customICECredentials reports whether -ice-ufrag-length or -ice-pwd-length
+// asks for credentials of other lengths than pion's.
+func customICECredentials() bool {
+	return config.ICEUfragLength != 0 || config.ICEPwdLength != 0
+}
+
+// newPeerConnection creates a peer connection with ICE credentials of the
+// configured lengths. pion restarts ICE with the SettingEngine's credentials,
+// which can't change once the connection has it, so handleICEFragment refuses
+// restarts of these connections rather than answer with the old ones.
+func newPeerConnection() (*webrtc.PeerConnection, error) {
+	if !customICECredentials() {
+		return webrtcAPI.NewPeerConnection(peerConfiguration())
+	}
+
+	ufragLength, pwdLength := config.ICEUfragLength, config.ICEPwdLength
+	if ufragLength == 0 {
+		ufragLength = pionICEUfragLength
+	}
+	if pwdLength == 0 {
+		pwdLength = pionICEPwdLength
+	}
+
+	settingEngine := webrtcSettingEngine
+	settingEngine.SetICECredentials(randomICEString(ufragLength), randomICEString(pwdLength))
+	api := webrtc.NewAPI(
+		webrtc.WithMediaEngine(webrtcMediaEngine),
+		webrtc.WithInterceptorRegistry(webrtcInterceptors),
+		webrtc.WithSettingEngine(settingEngine),
+	)
+	return api.NewPeerConnection(peerConfiguration())
+}
+
+// randomICEString returns length random characters of iceChars.
+func randomICEString(length int) string {
+	b := make([]byte, 0, length)
+	random := make([]byte, 1)
+	for len(b) < length {
+		if _, err := rand.Read(random); err != nil {
+			panic(err)
+		}
+		// Rejecting the tail of the byte range keeps the draw uniform.
+		if int(random[0]) < 256-256%len(iceChars) {
+			b = append(b, iceChars[int(random[0])%len(iceChars)])
+		}
+	}
+	return string(b)
+}
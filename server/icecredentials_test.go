@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestICECredentialLengths(t *testing.T) {
+	server := startServer(t, "-ice-ufrag-length", "40", "-ice-pwd-length", "64")
+	alice := server.Join(t, "long")
+	bob := server.Join(t, "long")
+	assertRelayed(t, alice, bob, relayTimeout)
+
+	fragment := fragmentFromDescription(parseDescription(t, alice.PeerConnection.RemoteDescription()))
+	if len(fragment.Ufrag) != 40 || len(fragment.Pwd) != 64 {
+		t.Fatalf("answer has a %d-character ufrag and a %d-character password, want 40 and 64",
+			len(fragment.Ufrag), len(fragment.Pwd))
+	}
+
+	// pion would restart ICE with the same credentials, so the restart is
+	// refused and the session carries on.
+	restart := sdpFragment{Ufrag: randomICEString(16), Pwd: randomICEString(32), Mid: fragment.Mid}
+	req, err := http.NewRequest(http.MethodPatch, alice.Location, strings.NewReader(restart.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", sdpFragContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("ICE restart answered %d, want 422", res.StatusCode)
+	}
+	assertRelayed(t, alice, bob, relayTimeout)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// configureICEFilters restricts candidate gathering to the interfaces and
+// addresses allowed by config. A deny entry always wins; a non-empty allow
+// list excludes everything it doesn't match.
+func configureICEFilters(settingEngine *webrtc.SettingEngine) error {
+	allowNetworks, err := parseCIDRs(config.ICEAllowCIDRs)
+	if err != nil {
+		return err
+	}
+	denyNetworks, err := parseCIDRs(config.ICEDenyCIDRs)
+	if err != nil {
+		return err
+	}
+
+	if len(config.ICEAllowInterfaces) > 0 || len(config.ICEDenyInterfaces) > 0 {
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			if slices.Contains(config.ICEDenyInterfaces, name) {
+				return false
+			}
+			return len(config.ICEAllowInterfaces) == 0 || slices.Contains(config.ICEAllowInterfaces, name)
+		})
+	}
+
+	if len(allowNetworks) > 0 || len(denyNetworks) > 0 {
+		settingEngine.SetIPFilter(func(ip net.IP) bool {
+			if containsIP(denyNetworks, ip) {
+				return false
+			}
+			return len(allowNetworks) == 0 || containsIP(allowNetworks, ip)
+		})
+	}
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICE filter CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// answerCandidateIPs joins a server started with args and returns the
+// addresses of the candidates in its answer.
+func answerCandidateIPs(t *testing.T, args ...string) []net.IP {
+	t.Helper()
+	server := startServer(t, args...)
+	_, offer := createOffer(t, addAudio)
+
+	res, answer := postOffer(t, server.URL+"/whip?room=filtered", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	var ips []net.IP
+	for _, line := range strings.Split(answer, "\r\n") {
+		if fields := strings.Fields(line); strings.HasPrefix(line, "a=candidate:") && len(fields) > 4 {
+			ips = append(ips, net.ParseIP(fields[4]))
+		}
+	}
+	return ips
+}
+
+func TestICEDenyCIDRs(t *testing.T) {
+	if len(answerCandidateIPs(t)) == 0 {
+		t.Skip("no host candidates to filter")
+	}
+	if ips := answerCandidateIPs(t, "-ice-deny-cidrs", "0.0.0.0/0,::/0"); len(ips) != 0 {
+		t.Errorf("answer offers %v with every address denied", ips)
+	}
+}
+
+func TestICEAllowCIDRs(t *testing.T) {
+	ips := answerCandidateIPs(t)
+	if len(ips) == 0 {
+		t.Skip("no host candidates to filter")
+	}
+	allowed := &net.IPNet{IP: ips[0], Mask: net.CIDRMask(len(ips[0])*8, len(ips[0])*8)}
+	filtered := answerCandidateIPs(t, "-ice-allow-cidrs", allowed.String())
+	if len(filtered) == 0 {
+		t.Fatalf("answer has no candidates in the allowed %s", allowed)
+	}
+	for _, ip := range filtered {
+		if !allowed.Contains(ip) {
+			t.Errorf("answer offers %s outside the allowed %s", ip, allowed)
+		}
+	}
+}
+
+func TestParseCIDRsRejectsInvalid(t *testing.T) {
+	if _, err := parseCIDRs([]string{"10.0.0.0/8", "10.0.0.0"}); err == nil {
+		t.Error("a CIDR without a prefix length was accepted")
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// iceConnectBuckets are the upper bounds, in seconds, of the time-to-connected
+// histogram.
+var iceConnectBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30}
+
+// iceMetrics counts how connections establish. Connections are labeled with
+// the candidate type of their selected pair, see pairCandidateType.
+var iceMetrics = struct {
+	connected map[string]*durationHistogram
+	failed    uint64
+	mutex     sync.Mutex
+}{connected: make(map[string]*durationHistogram)}
+
+// durationHistogram counts durations into buckets with the upper bounds it
+// was made for.
+type durationHistogram struct {
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newDurationHistogram(bounds []float64) *durationHistogram {
+	return &durationHistogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// observe counts a duration. The caller serializes calls.
+func (h *durationHistogram) observe(elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// recordICEConnected counts a connection that took elapsed to connect.
+func recordICEConnected(peerConnection *webrtc.PeerConnection, elapsed time.Duration) {
+	candidateType := pairCandidateType(peerConnection)
+
+	iceMetrics.mutex.Lock()
+	defer iceMetrics.mutex.Unlock()
+
+	histogram := iceMetrics.connected[candidateType]
+	if histogram == nil {
+		histogram = newDurationHistogram(iceConnectBuckets)
+		iceMetrics.connected[candidateType] = histogram
+	}
+	histogram.observe(elapsed)
+}
+
+// recordICEFailed counts a connection whose ICE checks failed.
+func recordICEFailed() {
+	iceMetrics.mutex.Lock()
+	defer iceMetrics.mutex.Unlock()
+
+	iceMetrics.failed++
+}
+
+// pairCandidateType returns the candidate type of the selected pair's less
+// direct end: relay when either end is a TURN relay, then srflx, prflx and
+// host. It is "unknown" when no pair is selected.
+func pairCandidateType(peerConnection *webrtc.PeerConnection) string {
+	pair, err := peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		return "unknown"
+	}
+
+	for _, candidateType := range []webrtc.ICECandidateType{
+		webrtc.ICECandidateTypeRelay, webrtc.ICECandidateTypeSrflx, webrtc.ICECandidateTypePrflx,
+	} {
+		if pair.Local.Typ == candidateType || pair.Remote.Typ == candidateType {
+			return candidateType.String()
+		}
+	}
+	return webrtc.ICECandidateTypeHost.String()
+}
+
+func writeICEMetrics(w io.Writer) {
+	iceMetrics.mutex.Lock()
+	defer iceMetrics.mutex.Unlock()
+
+	writeMetricHeader(w, "single_whip_ice_connected_total", "counter", "Connections whose ICE checks succeeded, by selected candidate type.")
+	for candidateType, histogram := range iceMetrics.connected {
+		writeSample(w, "single_whip_ice_connected_total", float64(histogram.count), "candidate_type", candidateType)
+	}
+	writeMetric(w, "single_whip_ice_failed_total", "counter",
+		"Connections whose ICE checks failed.", float64(iceMetrics.failed))
+
+	writeMetricHeader(w, "single_whip_ice_time_to_connected_seconds", "histogram",
+		"Time from receiving the offer to ICE connected, by selected candidate type.")
+	for candidateType, histogram := range iceMetrics.connected {
+		for i, bound := range iceConnectBuckets {
+			writeSample(w, "single_whip_ice_time_to_connected_seconds_bucket", float64(histogram.buckets[i]),
+				"candidate_type", candidateType, "le", fmt.Sprint(bound))
+		}
+		writeSample(w, "single_whip_ice_time_to_connected_seconds_bucket", float64(histogram.count),
+			"candidate_type", candidateType, "le", "+Inf")
+		writeSample(w, "single_whip_ice_time_to_connected_seconds_sum", histogram.sum, "candidate_type", candidateType)
+		writeSample(w, "single_whip_ice_time_to_connected_seconds_count", float64(histogram.count), "candidate_type", candidateType)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// defaultICEServer is used when no -ice-server or -ice-srv-domain is given.
+const defaultICEServer = "stun:stun.l.google.com:19302"
+
+// iceServersMutex guards peerConnectionConfiguration's ICE servers, and the
+// TURN credentials in config, which refreshICEServers and
+// watchTURNCredentials replace while the server runs.
+var iceServersMutex sync.RWMutex
+
+// peerConfiguration returns the configuration of new peer connections.
+func peerConfiguration() webrtc.Configuration {
+	iceServersMutex.RLock()
+	defer iceServersMutex.RUnlock()
+
+	return peerConnectionConfiguration
+}
+
+// configureICEServers sets the STUN and TURN servers of -ice-server and
+// those -ice-srv-domain's SRV records name, TURN ones with the
+// -turn-username and -turn-credential long-term credentials.
+//
+// With -no-stun there are none: the server gathers only host candidates,
+// without contacting anyone, which completes right away. Peers then connect
+// only if they can reach one of the server's interface addresses directly,
+// e.g. on the same LAN.
+func configureICEServers(configuration *webrtc.Configuration) error {
+	if config.NoSTUN {
+		if len(config.ICEServers) > 0 || config.ICESRVDomain != "" {
+			return errors.New("-no-stun is mutually exclusive with -ice-server and -ice-srv-domain")
+		}
+		configuration.ICEServers = nil
+		fmt.Println("No STUN or TURN servers, gathering host candidates only")
+		return nil
+	}
+
+	urls := config.ICEServers
+	if config.ICESRVDomain != "" {
+		discovered, err := discoverICEServers(config.ICESRVDomain)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ICE servers from SRV records of %s: %s\n", config.ICESRVDomain, strings.Join(discovered, ", "))
+		urls = append(slices.Clip(urls), discovered...)
+	} else if len(urls) == 0 {
+		urls = stringList{defaultICEServer}
+	}
+
+	servers, err := iceServersFor(urls)
+	if err != nil {
+		return err
+	}
+	configuration.ICEServers = servers
+	return nil
+}
+
+// iceServersFor groups STUN and TURN server URLs into ICE servers.
+func iceServersFor(urls []string) ([]webrtc.ICEServer, error) {
+	var stun, turn []string
+	for _, url := range urls {
+		scheme, _, _ := strings.Cut(url, ":")
+		switch scheme {
+		case "stun", "stuns":
+			stun = append(stun, url)
+		case "turn", "turns":
+			turn = append(turn, url)
+		default:
+			return nil, fmt.Errorf("invalid ICE server %q: want a stun:, stuns:, turn: or turns: URL", url)
+		}
+		if strings.ContainsAny(url, "<> \t\r\n") {
+			return nil, fmt.Errorf("invalid ICE server %q", url)
+		}
+	}
+	if len(turn) > 0 && (config.TURNUsername == "" || config.TURNCredential == "") {
+		return nil, errors.New("TURN servers need -turn-username and -turn-credential")
+	}
+	if strings.ContainsFunc(config.TURNUsername+config.TURNCredential, unicode.IsControl) {
+		return nil, errors.New("TURN credentials must not contain control characters")
+	}
+
+	var servers []webrtc.ICEServer
+	if len(stun) > 0 {
+		servers = append(servers, webrtc.ICEServer{URLs: stun})
+	}
+	if len(turn) > 0 {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           turn,
+			Username:       config.TURNUsername,
+			Credential:     config.TURNCredential,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	return servers, nil
+}
+
+// addICEServerLinks advertises the server's ICE servers to the client in
+// Link headers (RFC 9725 section 4.6), e.g.
+//
+//	Link: <turn:turn.example.net?transport=udp>; rel="ice-server"; username="user"; credential="secret"; credential-type="password"
+//
+// when -ice-server-links is set.
+func addICEServerLinks(res http.ResponseWriter) {
+	if !config.ICEServerLinks {
+		return
+	}
+
+	for _, server := range peerConfiguration().ICEServers {
+		for _, url := range server.URLs {
+			res.Header().Add("Link", iceServerLink(url, server))
+		}
+	}
+}
+
+func iceServerLink(url string, server webrtc.ICEServer) string {
+	link := "<" + url + `>; rel="ice-server"`
+	if server.Username == "" {
+		return link
+	}
+
+	credential, _ := server.Credential.(string)
+	return link + "; username=" + quoteLinkParameter(server.Username) +
+		"; credential=" + quoteLinkParameter(credential) +
+		`; credential-type="password"`
+}
+
+// quoteLinkParameter formats value as an RFC 8288 quoted-string, escaping
+// quotes and backslashes.
+func quoteLinkParameter(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// parseLink parses one RFC 8288 link-value into its target and parameters,
+// unquoting quoted-string values.
+func parseLink(t *testing.T, value string) (string, map[string]string) {
+	t.Helper()
+
+	rest, found := strings.CutPrefix(value, "<")
+	if !found {
+		t.Fatalf("link %q doesn't start with a target", value)
+	}
+	target, rest, found := strings.Cut(rest, ">")
+	if !found {
+		t.Fatalf("link %q has an unterminated target", value)
+	}
+
+	params := make(map[string]string)
+	for rest = strings.TrimLeft(rest, " "); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		if rest, found = strings.CutPrefix(rest, ";"); !found {
+			t.Fatalf("link %q has %q where a parameter should start", value, rest)
+		}
+		name, after, found := strings.Cut(strings.TrimLeft(rest, " "), "=")
+		if !found {
+			t.Fatalf("link %q has a parameter without a value", value)
+		}
+		if !strings.HasPrefix(after, `"`) {
+			token, _, _ := strings.Cut(after, ";")
+			params[name], rest = strings.TrimSpace(token), after[len(token):]
+			continue
+		}
+
+		var unquoted strings.Builder
+		i := 1
+		for ; i < len(after) && after[i] != '"'; i++ {
+			if after[i] == '\\' {
+				i++
+				if i == len(after) {
+					break
+				}
+			}
+			unquoted.WriteByte(after[i])
+		}
+		if i >= len(after) {
+			t.Fatalf("link %q has an unterminated quoted-string", value)
+		}
+		params[name], rest = unquoted.String(), after[i+1:]
+	}
+	return target, params
+}
+
+func TestICEServerLinks(t *testing.T) {
+	config = Config{ICEServerLinks: true}
+	peerConnectionConfiguration = webrtc.Configuration{ICEServers: []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.net"}},
+		{
+			URLs:           []string{"turn:turn.example.net?transport=udp"},
+			Username:       `us"er\name`,
+			Credential:     `p;a,s"s=`,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		},
+	}}
+	t.Cleanup(func() { peerConnectionConfiguration = webrtc.Configuration{} })
+
+	res := httptest.NewRecorder()
+	addICEServerLinks(res)
+	links := res.Header().Values("Link")
+	if len(links) != 2 {
+		t.Fatalf("%d Link headers, want 2: %q", len(links), links)
+	}
+
+	target, params := parseLink(t, links[0])
+	if target != "stun:stun.example.net" || params["rel"] != "ice-server" {
+		t.Errorf("STUN link parses to %s %v", target, params)
+	}
+	if _, ok := params["username"]; ok {
+		t.Errorf("STUN link carries credentials: %s", links[0])
+	}
+
+	target, params = parseLink(t, links[1])
+	want := map[string]string{
+		"rel":             "ice-server",
+		"username":        `us"er\name`,
+		"credential":      `p;a,s"s=`,
+		"credential-type": "password",
+	}
+	if target != "turn:turn.example.net?transport=udp" {
+		t.Errorf("TURN link target is %s", target)
+	}
+	for name, value := range want {
+		if params[name] != value {
+			t.Errorf("TURN link %s parses to %q, want %q", name, params[name], value)
+		}
+	}
+}
+
+func TestNoSTUN(t *testing.T) {
+	server := startServer(t)
+	if servers := peerConfiguration().ICEServers; len(servers) != 0 {
+		t.Fatalf("-no-stun configured ICE servers %v", servers)
+	}
+
+	answer := answerTo(t, server.URL, "lan")
+	if !strings.Contains(answer, " typ host") {
+		t.Errorf("answer has no host candidate:\n%s", answer)
+	}
+	if strings.Contains(answer, " typ srflx") || strings.Contains(answer, " typ relay") {
+		t.Errorf("answer has candidates from a STUN or TURN server:\n%s", answer)
+	}
+
+	config.ICEServers = stringList{defaultICEServer}
+	t.Cleanup(func() { config.ICEServers = nil })
+	if err := configureICEServers(&webrtc.Configuration{}); err == nil {
+		t.Error("-no-stun accepted alongside -ice-server")
+	}
+}
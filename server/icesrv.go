@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// srvLookupTimeout bounds each SRV lookup of discoverICEServers.
+const srvLookupTimeout = 5 * time.Second
+
+// srvResolver looks up SRV records; net.Resolver is one.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// iceServerResolver resolves the -ice-srv-domain records.
+var iceServerResolver srvResolver = net.DefaultResolver
+
+// iceSRVServices are the SRV services of STUN (RFC 5389) and TURN (RFC
+// 5928) servers, and the URLs their records become.
+var iceSRVServices = []struct {
+	service, proto string
+	scheme         string
+	transport      string
+}{
+	{"stun", "udp", "stun", ""},
+	{"stuns", "tcp", "stuns", ""},
+	{"turn", "udp", "turn", "udp"},
+	{"turn", "tcp", "turn", "tcp"},
+	{"turns", "tcp", "turns", "tcp"},
+}
+
+// discoverICEServers returns the STUN and TURN server URLs the SRV records
+// of domain name, in the resolver's priority and weight order. Services
+// without records are skipped, but finding none at all is an error.
+func discoverICEServers(domain string) ([]string, error) {
+	var urls []string
+	for _, service := range iceSRVServices {
+		ctx, cancel := context.WithTimeout(context.Background(), srvLookupTimeout)
+		_, records, err := iceServerResolver.LookupSRV(ctx, service.service, service.proto, domain)
+		cancel()
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("looking up _%s._%s.%s: %w", service.service, service.proto, domain, err)
+		}
+
+		for _, record := range records {
+			// A target of "." means the service isn't offered (RFC 2782).
+			target := strings.TrimSuffix(record.Target, ".")
+			if target == "" {
+				continue
+			}
+			url := fmt.Sprintf("%s:%s", service.scheme, net.JoinHostPort(target, fmt.Sprint(record.Port)))
+			if service.transport != "" {
+				url += "?transport=" + service.transport
+			}
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no STUN or TURN SRV records found for %s", domain)
+	}
+	return urls, nil
+}
+
+// refreshICEServers looks up the -ice-srv-domain records every
+// -ice-srv-refresh, replacing the ICE servers of new peer connections when
+// they changed; existing ones keep theirs. A failed lookup keeps the
+// current servers.
+func refreshICEServers() {
+	ticker := time.NewTicker(config.ICESRVRefresh)
+	defer ticker.Stop()
+
+	current := peerConfiguration().ICEServers
+	for range ticker.C {
+		discovered, err := discoverICEServers(config.ICESRVDomain)
+		if err != nil {
+			fmt.Printf("Error refreshing ICE servers, keeping the current ones: %s\n", err.Error())
+			continue
+		}
+		// Building the servers and storing them under one lock keeps
+		// watchTURNCredentials from rotating the credentials in between,
+		// which would store the old ones again.
+		iceServersMutex.Lock()
+		servers, err := iceServersFor(append(slices.Clip(config.ICEServers), discovered...))
+		changed := err == nil && !slices.EqualFunc(servers, current, func(a, b webrtc.ICEServer) bool {
+			return slices.Equal(a.URLs, b.URLs)
+		})
+		if changed {
+			peerConnectionConfiguration.ICEServers = servers
+		}
+		iceServersMutex.Unlock()
+		if err != nil {
+			fmt.Printf("Error refreshing ICE servers, keeping the current ones: %s\n", err.Error())
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		fmt.Printf("ICE servers from SRV records of %s changed: %s\n", config.ICESRVDomain, strings.Join(discovered, ", "))
+		current = servers
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// fakeSRVResolver answers SRV lookups from records keyed by "service.proto",
+// and as not found for the rest.
+type fakeSRVResolver map[string][]*net.SRV
+
+func (r fakeSRVResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r[service+"."+proto]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return "_" + service + "._" + proto + "." + name + ".", records, nil
+}
+
+func TestICESRVDiscovery(t *testing.T) {
+	iceServerResolver = fakeSRVResolver{
+		"stun.udp": {{Target: "stun.example.net.", Port: 3478}},
+		"turn.udp": {{Target: "turn1.example.net.", Port: 3478}, {Target: "turn2.example.net.", Port: 3479}},
+		// The TCP TURN service isn't offered.
+		"turn.tcp":  {{Target: ".", Port: 0}},
+		"turns.tcp": {{Target: "turn1.example.net.", Port: 5349}},
+	}
+	t.Cleanup(func() { iceServerResolver = net.DefaultResolver })
+	config = Config{ICESRVDomain: "example.net", TURNUsername: "user", TURNCredential: "secret"}
+
+	var configuration webrtc.Configuration
+	if err := configureICEServers(&configuration); err != nil {
+		t.Fatal(err)
+	}
+	want := []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.example.net:3478"}},
+		{
+			URLs: []string{
+				"turn:turn1.example.net:3478?transport=udp",
+				"turn:turn2.example.net:3479?transport=udp",
+				"turns:turn1.example.net:5349?transport=tcp",
+			},
+			Username:       "user",
+			Credential:     "secret",
+			CredentialType: webrtc.ICECredentialTypePassword,
+		},
+	}
+	if !slices.EqualFunc(configuration.ICEServers, want, func(a, b webrtc.ICEServer) bool {
+		return slices.Equal(a.URLs, b.URLs) && a.Username == b.Username && a.Credential == b.Credential
+	}) {
+		t.Errorf("ICE servers %+v, want %+v", configuration.ICEServers, want)
+	}
+
+	iceServerResolver = fakeSRVResolver{}
+	if _, err := discoverICEServers("example.net"); err == nil {
+		t.Error("a domain without SRV records gave ICE servers")
+	}
+}
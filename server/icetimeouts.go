@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// configureICETimeouts applies the -ice-* timeouts. The defaults are pion's:
+// a connection is disconnected after 5s without traffic and failed after
+// another 25s, with keepalives every 2s while media is idle. High-latency
+// or lossy links, e.g. satellite, may want longer disconnected and failed
+// timeouts so short outages don't drop peers.
+func configureICETimeouts(settingEngine *webrtc.SettingEngine) error {
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ice-gather-timeout", config.ICEGatherTimeout},
+		{"ice-disconnected-timeout", config.ICEDisconnectedTimeout},
+		{"ice-failed-timeout", config.ICEFailedTimeout},
+		{"ice-keepalive-interval", config.ICEKeepaliveInterval},
+	} {
+		if timeout.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", timeout.name, timeout.value)
+		}
+	}
+
+	settingEngine.SetICETimeouts(config.ICEDisconnectedTimeout, config.ICEFailedTimeout, config.ICEKeepaliveInterval)
+	settingEngine.SetSTUNGatherTimeout(config.ICEGatherTimeout)
+	return nil
+}
+
+// awaitGathering waits for gatherComplete at most -ice-gather-timeout, after
+// which the local description carries the candidates gathered so far and
+// watchGathering keeps an eye on the rest. It returns false when ctx ends
+// first.
+func awaitGathering(ctx context.Context, peer *Peer, gatherComplete <-chan struct{}) bool {
+	timeout := time.NewTimer(config.ICEGatherTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-gatherComplete:
+	case <-timeout.C:
+		fmt.Printf("ICE gathering still running after %s, answering with the candidates found\n", config.ICEGatherTimeout)
+		if config.ICEGatherWatchdog > 0 {
+			peer.goLoop(func() { watchGathering(peer, gatherComplete) })
+		}
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// watchGathering tears the peer down when its gathering hasn't completed
+// -ice-gather-watchdog after the answer went out and it hasn't connected.
+// Gathering that never completes, e.g. a TURN allocation whose server
+// stopped answering, would otherwise hold the peer's agent, sockets and
+// goroutines until the peer leaves, which a peer that never connects
+// doesn't. A peer connected over the candidates it has keeps going.
+func watchGathering(peer *Peer, gatherComplete <-chan struct{}) {
+	watchdog := time.NewTimer(config.ICEGatherWatchdog)
+	defer watchdog.Stop()
+
+	select {
+	case <-gatherComplete:
+		return
+	case <-peer.done:
+		return
+	case <-watchdog.C:
+	}
+
+	candidates := 0
+	if description := peer.PeerConnection.LocalDescription(); description != nil {
+		candidates = strings.Count(description.SDP, "a=candidate:")
+	}
+	iceState := peer.PeerConnection.ICEConnectionState()
+	stalled := fmt.Sprintf("ICE gathering of peer %s in room %s stalled for %s (gathering state %s, ICE state %s, %d candidates)",
+		peer.ID, peer.rooms[0].ID, config.ICEGatherTimeout+config.ICEGatherWatchdog,
+		peer.PeerConnection.ICEGatheringState(), iceState, candidates)
+	if iceState == webrtc.ICEConnectionStateConnected || iceState == webrtc.ICEConnectionStateCompleted {
+		fmt.Printf("%s, keeping it as it is connected\n", stalled)
+		return
+	}
+	fmt.Printf("%s, closing it\n", stalled)
+	teardownPeer(peer)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestICETimeoutsMustBePositive(t *testing.T) {
+	startServer(t, "-ice-failed-timeout", "1m", "-ice-keepalive-interval", "500ms")
+
+	config.ICEDisconnectedTimeout = 0
+	if err := configureICETimeouts(&webrtc.SettingEngine{}); err == nil {
+		t.Error("a zero -ice-disconnected-timeout was accepted")
+	}
+}
+
+func TestAwaitGathering(t *testing.T) {
+	startServer(t, "-ice-gather-timeout", "50ms", "-ice-gather-watchdog", "0")
+
+	gathering := make(chan struct{})
+	started := time.Now()
+	if !awaitGathering(context.Background(), nil, gathering) {
+		t.Fatal("gathering past -ice-gather-timeout ended the negotiation")
+	}
+	if waited := time.Since(started); waited > time.Second {
+		t.Errorf("waited %s for gathering, want about the 50ms timeout", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if awaitGathering(ctx, nil, gathering) {
+		t.Error("gathering outlasting the request continued the negotiation")
+	}
+
+	close(gathering)
+	if !awaitGathering(context.Background(), nil, gathering) {
+		t.Error("completed gathering ended the negotiation")
+	}
+}
+
+// startStallingServer runs the server in process with a TURN server that
+// never answers, so gathering stalls until the watchdog fires.
+func startStallingServer(t *testing.T) *testServer {
+	t.Helper()
+
+	turn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = turn.Close() })
+
+	return startServer(t, "-no-stun=false",
+		"-ice-server", fmt.Sprintf("turn:%s?transport=udp", turn.LocalAddr()),
+		"-turn-username", "whip", "-turn-credential", "secret",
+		"-ice-gather-timeout", "200ms", "-ice-gather-watchdog", "500ms")
+}
+
+func TestGatheringWatchdogClosesUnconnectedPeer(t *testing.T) {
+	server := startStallingServer(t)
+
+	// The offerer never applies the answer, so the peer never connects.
+	_, offer := createOffer(t, addAudio)
+	if res, body := postOffer(t, server.URL+"/whip?room=stalled", offer, nil); res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, body)
+	}
+	if roomManager.findRoom("stalled") == nil {
+		t.Fatal("peer didn't join")
+	}
+	eventually(t, "the stalled peer closing", func() bool {
+		return roomManager.findRoom("stalled") == nil
+	})
+}
+
+func TestGatheringWatchdogKeepsConnectedPeer(t *testing.T) {
+	server := startStallingServer(t)
+	alice := server.Join(t, "connected")
+	bob := server.Join(t, "connected")
+	assertRelayed(t, alice, bob, relayTimeout)
+
+	time.Sleep(time.Second)
+	peer := roomManager.findPeer(alice.ID)
+	if peer == nil {
+		t.Fatal("watchdog closed a connected peer")
+	}
+	if state := peer.PeerConnection.ICEGatheringState(); state == webrtc.ICEGatheringStateComplete {
+		t.Fatal("gathering completed, so the watchdog never fired")
+	}
+	assertRelayed(t, bob, alice, relayTimeout)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader lets a client retry a WHIP POST without joining the
+// room twice: a POST repeating the key of a live session gets that session's
+// response again instead of a new peer.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	errIdempotencyKeyInFlight = errors.New("a request with this idempotency key is in progress")
+	errIdempotencyKeyReused   = errors.New("idempotency key was used for another room")
+	errIdempotencyKeyForeign  = errors.New("idempotency key was used by another client")
+)
+
+var idempotencyKeys = &idempotencyKeyStore{
+	sessions: make(map[string]*idempotentSession),
+}
+
+// idempotentSession is the response of the POST that created a session,
+// replayed to retries. PeerID is empty while that POST is still running.
+type idempotentSession struct {
+	RoomID string
+	// Subject is the identity that created the session, the only one it
+	// is replayed to.
+	Subject         string
+	PeerID          string
+	Location        string
+	ResumptionToken string
+	Answer          string
+	Expires         time.Time
+}
+
+type idempotencyKeyStore struct {
+	sessions map[string]*idempotentSession
+	mutex    sync.Mutex
+}
+
+// claim reserves key for a new session of subject in roomID. If the key
+// already names a live session of subject in that room it returns the
+// session to replay instead.
+func (s *idempotencyKeyStore) claim(key, roomID, subject string, ttl time.Duration) (*idempotentSession, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for storedKey, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, storedKey)
+		}
+	}
+
+	if session, exists := s.sessions[key]; exists {
+		switch {
+		case session.Subject != subject:
+			return nil, errIdempotencyKeyForeign
+		case session.RoomID != roomID:
+			return nil, errIdempotencyKeyReused
+		case session.PeerID == "":
+			return nil, errIdempotencyKeyInFlight
+		case roomManager.findPeer(session.PeerID) != nil:
+			replay := *session
+			return &replay, nil
+		}
+		// The session has ended; the key may start a new one.
+	}
+
+	s.sessions[key] = &idempotentSession{RoomID: roomID, Subject: subject, Expires: now.Add(ttl)}
+	return nil, nil
+}
+
+// complete records the response of the POST that claimed key.
+func (s *idempotencyKeyStore) complete(key string, session idempotentSession) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if claimed, exists := s.sessions[key]; exists {
+		session.Expires = claimed.Expires
+		s.sessions[key] = &session
+	}
+}
+
+// replaceResumptionToken records the token a replay of key's session
+// handed out in place of the stored one.
+func (s *idempotencyKeyStore) replaceResumptionToken(key, token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if session, exists := s.sessions[key]; exists {
+		session.ResumptionToken = token
+	}
+}
+
+// release drops a claim whose POST failed, so a retry starts afresh.
+func (s *idempotencyKeyStore) release(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, key)
+}
+
+// writeIdempotentReplay writes the stored response of session again.
+func writeIdempotentReplay(res http.ResponseWriter, session *idempotentSession) {
+	fmt.Printf("Replaying session %s for idempotent retry in room: %s\n", session.PeerID, session.RoomID)
+
+	res.Header().Add("Location", session.Location)
+	if session.ResumptionToken != "" {
+		res.Header().Set(resumptionTokenHeader, session.ResumptionToken)
+	}
+	addICEServerLinks(res)
+	res.WriteHeader(http.StatusCreated)
+
+	if _, err := fmt.Fprint(res, session.Answer); err != nil {
+		fmt.Printf("Error writing answer: %s\n", err.Error())
+	}
+}
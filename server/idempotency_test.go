@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// roomPeers returns how many peers the room with ID id has, 0 if there is no
+// such room.
+func roomPeers(id string) int {
+	roomManager.mutex.RLock()
+	room := roomManager.rooms[id]
+	roomManager.mutex.RUnlock()
+	if room == nil {
+		return 0
+	}
+	return len(room.otherPeers(nil))
+}
+
+func TestIdempotentRetry(t *testing.T) {
+	server := startServer(t, "-idempotency-key-ttl", "2s")
+	_, offer := createOffer(t, addAudio)
+	post := func(room string) (*http.Response, string) {
+		return postOffer(t, server.URL+"/whip?room="+room, offer, http.Header{
+			idempotencyKeyHeader: {"retry-test"},
+		})
+	}
+
+	created, answer := post("retried")
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("first POST answered %d: %s", created.StatusCode, answer)
+	}
+	retried, replayed := post("retried")
+	if retried.StatusCode != http.StatusCreated {
+		t.Fatalf("retry answered %d: %s", retried.StatusCode, replayed)
+	}
+	if location := retried.Header.Get("Location"); location != created.Header.Get("Location") {
+		t.Errorf("retry has Location %s, want %s", location, created.Header.Get("Location"))
+	}
+	if replayed != answer {
+		t.Error("retry has another answer")
+	}
+	if peers := roomPeers("retried"); peers != 1 {
+		t.Fatalf("room has %d peers after the retry, want 1", peers)
+	}
+
+	// The key names a session in one room only.
+	if res, body := post("elsewhere"); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused for another room answered %d, want 422: %s", res.StatusCode, body)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	expired, _ := post("retried")
+	if expired.StatusCode != http.StatusCreated {
+		t.Fatalf("POST after the key expired answered %d", expired.StatusCode)
+	}
+	if expired.Header.Get("Location") == created.Header.Get("Location") {
+		t.Fatal("expired key still replayed its session")
+	}
+	if peers := roomPeers("retried"); peers != 2 {
+		t.Fatalf("room has %d peers after the key expired, want 2", peers)
+	}
+}
+
+func TestIdempotentRetryByAnotherIdentity(t *testing.T) {
+	server := startServer(t, "-jwt-secret", string(testJWTSecret))
+	_, offer := createOffer(t, addAudio)
+	post := func(subject string) (*http.Response, string) {
+		claims := validClaims()
+		claims["sub"] = subject
+		return postOffer(t, server.URL+"/whip?room=claimed", offer, http.Header{
+			"Authorization":      {"Bearer " + signJWT(t, map[string]any{"alg": "HS256"}, claims, hs256(testJWTSecret))},
+			idempotencyKeyHeader: {"claimed-key"},
+		})
+	}
+
+	if res, body := post("alice"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("first POST answered %d: %s", res.StatusCode, body)
+	}
+	// The key replays only to the client that created the session.
+	if res, body := post("bob"); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("retry by another client answered %d, want 422: %s", res.StatusCode, body)
+	}
+	if res, _ := post("alice"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("retry by the creator answered %d, want 201", res.StatusCode)
+	}
+	if peers := roomPeers("claimed"); peers != 1 {
+		t.Fatalf("room has %d peers after the retries, want 1", peers)
+	}
+}
+
+func TestIdempotentReplayIssuesFreshToken(t *testing.T) {
+	server := startServer(t)
+	_, offer := createOffer(t, addAudio)
+	keyed := http.Header{idempotencyKeyHeader: {"token-test"}}
+	// Broadcast rooms take the resumed joins as well.
+	whipURL := server.URL + "/whip?room=tokens&topology=broadcast"
+
+	created, body := postOffer(t, whipURL, offer, keyed)
+	if created.StatusCode != http.StatusCreated {
+		t.Fatalf("first POST answered %d: %s", created.StatusCode, body)
+	}
+	first := created.Header.Get(resumptionTokenHeader)
+	// The client redeems its token, then retries the original POST.
+	_, resumeOffer := createOffer(t, addAudio)
+	if res, body := postOffer(t, whipURL, resumeOffer, http.Header{resumptionTokenHeader: {first}}); res.StatusCode != http.StatusCreated {
+		t.Fatalf("resumed join answered %d: %s", res.StatusCode, body)
+	}
+	retried, body := postOffer(t, whipURL, offer, keyed)
+	if retried.StatusCode != http.StatusCreated {
+		t.Fatalf("retry answered %d: %s", retried.StatusCode, body)
+	}
+	replayed := retried.Header.Get(resumptionTokenHeader)
+	if replayed == "" || replayed == first {
+		t.Fatalf("replay handed out token %q, want a fresh one in place of the redeemed %q", replayed, first)
+	}
+
+	_, resumeOffer = createOffer(t, addAudio)
+	if res, body := postOffer(t, whipURL, resumeOffer, http.Header{resumptionTokenHeader: {replayed}}); res.StatusCode != http.StatusCreated {
+		t.Fatalf("join with the replayed token answered %d: %s", res.StatusCode, body)
+	}
+}
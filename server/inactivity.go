@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxReapInterval bounds how often reapInactiveRooms checks the rooms.
+const maxReapInterval = time.Minute
+
+// touch records that the room received media at now.
+func (r *Room) touch(now time.Time) {
+	r.lastActivity.Store(now.UnixNano())
+}
+
+// touchRooms touches each of the source's rooms it sends into at now, all
+// but the broadcast rooms it only listens in, whether or not anyone hears it.
+func (p *Peer) touchRooms(now time.Time) {
+	for _, room := range p.rooms {
+		if !room.isSubscriber(p) {
+			room.touch(now)
+		}
+	}
+}
+
+// LastActivity returns when the room last received media, or when it was
+// created if it never has.
+func (r *Room) LastActivity() time.Time {
+	return time.Unix(0, r.lastActivity.Load())
+}
+
+// reapInactiveRooms closes the rooms that received no media for
+// config.InactivityTimeout, removing their peers even if they are still
+// connected, e.g. muted or stuck, so silent sessions don't hold their slots
+// forever.
+func reapInactiveRooms() {
+	ticker := time.NewTicker(min(config.InactivityTimeout/4, maxReapInterval))
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		closeInactiveRooms(now)
+	}
+}
+
+// closeInactiveRooms closes the rooms that received no media for
+// config.InactivityTimeout by now.
+func closeInactiveRooms(now time.Time) {
+	roomManager.mutex.RLock()
+	var inactive []*Room
+	for _, room := range roomManager.rooms {
+		if now.Sub(room.LastActivity()) >= config.InactivityTimeout {
+			inactive = append(inactive, room)
+		}
+	}
+	roomManager.mutex.RUnlock()
+
+	for _, room := range inactive {
+		fmt.Printf("Room %s received nothing for %s, closing it\n", room.ID, config.InactivityTimeout)
+		room.logEvent(eventInactive, "", "no media for "+config.InactivityTimeout.String())
+		for _, peer := range room.otherPeers(nil) {
+			teardownPeer(peer)
+		}
+	}
+}
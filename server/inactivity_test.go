@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInactiveRoomClosed(t *testing.T) {
+	server := startServer(t, "-inactivity-timeout", "1s")
+	// The listener never writes to its track, so the room receives nothing.
+	joinListener(t, server.URL, "silent")
+
+	closeInactiveRooms(time.Now())
+	if roomManager.findRoom("silent") == nil {
+		t.Fatal("room was closed before -inactivity-timeout passed")
+	}
+
+	closeInactiveRooms(time.Now().Add(time.Second))
+	if roomManager.findRoom("silent") != nil {
+		t.Fatal("room receiving nothing wasn't closed")
+	}
+}
+
+func TestLoneSenderKeepsRoomOpen(t *testing.T) {
+	server := startServer(t, "-inactivity-timeout", "1s")
+	server.Join(t, "lonely")
+
+	// Past the timeout since the room was created, with nobody to relay
+	// the sender's packets to.
+	time.Sleep(1500 * time.Millisecond)
+	closeInactiveRooms(time.Now())
+	if roomManager.findRoom("lonely") == nil {
+		t.Fatal("room of a lone sender was closed as inactive")
+	}
+}
+
+func TestRoomRelayedIntoKeptOpen(t *testing.T) {
+	server := startServer(t, "-inactivity-timeout", "1s")
+	// The joiner of home and busy only listens in home and publishes in
+	// busy, the second room it joined, so its packets alone keep busy
+	// open.
+	broadcast := url.Values{"topology": {"broadcast"}}
+	broadcast.Set("room", "home")
+	server.JoinQuery(t, broadcast)
+	broadcast.Set("room", "home,busy")
+	joiner := server.JoinQuery(t, broadcast)
+	listener := server.Join(t, "busy")
+
+	assertRelayed(t, joiner, listener, relayTimeout)
+	// Past the timeout since the rooms were created, so only received
+	// media keeps them open.
+	time.Sleep(1500 * time.Millisecond)
+	closeInactiveRooms(time.Now())
+	for _, id := range []string{"home", "busy"} {
+		if roomManager.findRoom(id) == nil {
+			t.Errorf("room %s relaying media was closed as inactive", id)
+		}
+	}
+}
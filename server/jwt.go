@@ -0,0 +1,338 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway tolerates clock skew between the server and the issuer when
+	// checking exp and nbf.
+	jwtLeeway = 30 * time.Second
+	// jwksRefreshInterval bounds how long fetched keys are trusted, so
+	// rotated-out keys stop validating.
+	jwksRefreshInterval = 10 * time.Minute
+	// jwksMinRefetchInterval limits refetches triggered by unknown key IDs.
+	jwksMinRefetchInterval = 30 * time.Second
+)
+
+// jwtAuthenticator admits requests bearing a JWT signed with -jwt-secret
+// (HS256) or a key of -jwt-jwks-url (RS256, ES256), optionally checking the
+// issuer and audience.
+type jwtAuthenticator struct {
+	secret   []byte
+	jwks     *jwksCache
+	issuer   string
+	audience string
+}
+
+func newJWTAuthenticator() *jwtAuthenticator {
+	authenticator := &jwtAuthenticator{issuer: config.JWTIssuer, audience: config.JWTAudience}
+	if config.JWTSecret != "" {
+		authenticator.secret = []byte(config.JWTSecret)
+	}
+	if config.JWTJWKSURL != "" {
+		authenticator.jwks = &jwksCache{url: config.JWTJWKSURL, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return authenticator
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jwtClaims are the claims the server checks: the registered ones, plus room
+// and role, which limit the bearer to those rooms and to publish and/or
+// subscribe. Either may be a string or an array; absent, it doesn't limit.
+type jwtClaims struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  jwtStrings `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+	Room      jwtStrings `json:"room"`
+	Role      jwtStrings `json:"role"`
+}
+
+// jwtStrings is a claim holding a single string or an array of them.
+type jwtStrings []string
+
+func (a *jwtStrings) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = jwtStrings{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a *jwtAuthenticator) Authenticate(req *http.Request, roomID string) (Identity, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: missing bearer token", errUnauthenticated)
+	}
+
+	var claims jwtClaims
+	if err := a.verify(token, &claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: invalid token: %s", errUnauthenticated, err.Error())
+	}
+
+	if claims.Room != nil && !slices.Contains(claims.Room, roomID) {
+		return Identity{}, fmt.Errorf("%w: token is not valid for room %q", errForbidden, roomID)
+	}
+	for _, role := range claims.Role {
+		if role != rolePublish && role != roleSubscribe {
+			return Identity{}, fmt.Errorf("%w: invalid token: unknown role %q", errUnauthenticated, role)
+		}
+	}
+
+	identity := Identity{Subject: claims.Subject}
+	if claims.Role != nil {
+		identity.Roles = append([]string{}, claims.Role...)
+	}
+	return identity, nil
+}
+
+// verify checks the token's signature and registered claims and decodes its
+// payload into claims.
+func (a *jwtAuthenticator) verify(token string, claims *jwtClaims) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+	if err = a.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return err
+	}
+
+	if err = decodeJWTPart(parts[1], claims); err != nil {
+		return fmt.Errorf("claims: %w", err)
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return errors.New("missing exp claim")
+	}
+	if now.After(numericDate(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(numericDate(*claims.NotBefore)) {
+		return errors.New("token not yet valid")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if a.audience != "" && !slices.Contains(claims.Audience, a.audience) {
+		return errors.New("token not issued for this audience")
+	}
+	return nil
+}
+
+// verifySignature checks signature over signed with the key the header's
+// algorithm selects. Algorithms without a configured key, and "none", fail.
+func (a *jwtAuthenticator) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch header.Algorithm {
+	case "HS256":
+		if a.secret == nil {
+			return errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	case "RS256", "ES256":
+		if a.jwks == nil {
+			return fmt.Errorf("%s tokens are not accepted", header.Algorithm)
+		}
+		key, err := a.jwks.key(header.KeyID)
+		if err != nil {
+			return err
+		}
+
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if header.Algorithm == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if header.Algorithm == "ES256" && len(signature) == 64 &&
+				ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+				return nil
+			}
+		}
+		return errors.New("bad signature")
+	default:
+		return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+	}
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// numericDate converts a JWT NumericDate, seconds since the Unix epoch.
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// jwksCache holds the public keys of a JWKS endpoint by key ID.
+type jwksCache struct {
+	url     string
+	client  *http.Client
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	mutex   sync.Mutex
+}
+
+// jsonWebKey is the subset of RFC 7517 keys the server can verify with.
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// key returns the key with ID kid, refetching the set when it is stale or, at
+// most every jwksMinRefetchInterval, when kid is unknown. The fetch runs
+// outside the lock, so lookups of cached keys never wait on the endpoint,
+// and a stale key stays in use while the endpoint fails.
+func (c *jwksCache) key(kid string) (crypto.PublicKey, error) {
+	c.mutex.Lock()
+	key, known := c.keys[kid]
+	age := time.Since(c.fetched)
+	if known && age < jwksRefreshInterval {
+		c.mutex.Unlock()
+		return key, nil
+	}
+	if !known && age < jwksMinRefetchInterval {
+		c.mutex.Unlock()
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	// Lookups during the fetch use the cached set rather than fetching too.
+	c.fetched = time.Now()
+	c.mutex.Unlock()
+
+	keys, err := c.fetch()
+	if err != nil {
+		if known {
+			fmt.Printf("Error refreshing JWKS, keeping the cached keys: %s\n", err.Error())
+			return key, nil
+		}
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	c.mutex.Lock()
+	c.keys = keys
+	c.mutex.Unlock()
+	if key, known = keys[kid]; !known {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// fetch returns the keys the endpoint serves by key ID.
+func (c *jwksCache) fetch() (map[string]crypto.PublicKey, error) {
+	res, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			fmt.Printf("Error parsing JWKS key %q: %s\n", jwk.KeyID, err.Error())
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
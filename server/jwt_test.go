@@ -0,0 +1,322 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testJWTSecret = []byte("test secret")
+
+// signJWT returns a token of header and claims signed with sign.
+func signJWT(t *testing.T, header, claims map[string]any, sign func(signed string) []byte) string {
+	t.Helper()
+
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func hs256(secret []byte) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+// validClaims expire in an hour.
+func validClaims() map[string]any {
+	return map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestJWTVerifyHS256(t *testing.T) {
+	authenticator := &jwtAuthenticator{secret: testJWTSecret}
+	header := map[string]any{"alg": "HS256"}
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExp := validClaims()
+	delete(noExp, "exp")
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", signJWT(t, header, validClaims(), hs256(testJWTSecret)), true},
+		{"wrong secret", signJWT(t, header, validClaims(), hs256([]byte("other"))), false},
+		{"expired", signJWT(t, header, expired, hs256(testJWTSecret)), false},
+		{"no exp", signJWT(t, header, noExp, hs256(testJWTSecret)), false},
+		{"alg none", signJWT(t, map[string]any{"alg": "none"}, validClaims(), func(string) []byte { return nil }), false},
+		{"RS256 without JWKS", signJWT(t, map[string]any{"alg": "RS256"}, validClaims(), hs256(testJWTSecret)), false},
+		{"malformed", "not.a-token", false},
+	}
+	for _, test := range tests {
+		var claims jwtClaims
+		err := authenticator.verify(test.token, &claims)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: verified", test.name)
+		}
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	authenticator := &jwtAuthenticator{secret: testJWTSecret}
+	header := map[string]any{"alg": "HS256"}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		exp   time.Time
+		nbf   time.Time
+		valid bool
+	}{
+		{"expired within the leeway", now.Add(-jwtLeeway / 2), time.Time{}, true},
+		{"expired beyond the leeway", now.Add(-2 * jwtLeeway), time.Time{}, false},
+		{"not before within the leeway", now.Add(time.Hour), now.Add(jwtLeeway / 2), true},
+		{"not before beyond the leeway", now.Add(time.Hour), now.Add(2 * jwtLeeway), false},
+	}
+	for _, test := range tests {
+		claims := map[string]any{"exp": test.exp.Unix()}
+		if !test.nbf.IsZero() {
+			claims["nbf"] = test.nbf.Unix()
+		}
+		var decoded jwtClaims
+		err := authenticator.verify(signJWT(t, header, claims, hs256(testJWTSecret)), &decoded)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: error %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestJWTIssuerAndAudience(t *testing.T) {
+	authenticator := &jwtAuthenticator{secret: testJWTSecret, issuer: "https://issuer.example", audience: "single-whip"}
+	header := map[string]any{"alg": "HS256"}
+
+	tests := []struct {
+		name     string
+		issuer   any
+		audience any
+		valid    bool
+	}{
+		{"matching", "https://issuer.example", "single-whip", true},
+		{"audience in a list", "https://issuer.example", []string{"other", "single-whip"}, true},
+		{"other issuer", "https://other.example", "single-whip", false},
+		{"other audience", "https://issuer.example", "other", false},
+		{"no audience", "https://issuer.example", nil, false},
+	}
+	for _, test := range tests {
+		claims := validClaims()
+		claims["iss"] = test.issuer
+		if test.audience != nil {
+			claims["aud"] = test.audience
+		}
+		var decoded jwtClaims
+		err := authenticator.verify(signJWT(t, header, claims, hs256(testJWTSecret)), &decoded)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: error %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+// jwksServer serves keys as a JWKS and counts the fetches.
+func jwksServer(t *testing.T, keys ...jsonWebKey) (*jwksCache, *atomic.Int32) {
+	t.Helper()
+
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_ = json.NewEncoder(res).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	return &jwksCache{url: server.URL, client: server.Client()}, &fetches
+}
+
+func TestJWTVerifyRS256AndES256(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	jwks, _ := jwksServer(t,
+		jsonWebKey{KeyType: "RSA", KeyID: "rsa", N: base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+		jsonWebKey{KeyType: "EC", KeyID: "ec", Curve: "P-256", X: pad(ecKey.X), Y: pad(ecKey.Y)},
+	)
+	authenticator := &jwtAuthenticator{jwks: jwks}
+
+	rs256 := func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	}
+	es256 := func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]any
+		sign   func(string) []byte
+		valid  bool
+	}{
+		{"RS256", map[string]any{"alg": "RS256", "kid": "rsa"}, rs256, true},
+		{"ES256", map[string]any{"alg": "ES256", "kid": "ec"}, es256, true},
+		{"RS256 with the EC key", map[string]any{"alg": "RS256", "kid": "ec"}, rs256, false},
+		{"ES256 signed with RSA", map[string]any{"alg": "ES256", "kid": "ec"}, rs256, false},
+		{"HS256 without a secret", map[string]any{"alg": "HS256", "kid": "rsa"}, hs256(testJWTSecret), false},
+	}
+	for _, test := range tests {
+		var claims jwtClaims
+		err := authenticator.verify(signJWT(t, test.header, validClaims(), test.sign), &claims)
+		if test.valid != (err == nil) {
+			t.Errorf("%s: error %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestJWKSUnknownKeyRefetchThrottled(t *testing.T) {
+	jwks, fetches := jwksServer(t)
+
+	for range 3 {
+		if _, err := jwks.key("missing"); err == nil {
+			t.Fatal("found a key the set doesn't have")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("fetched the JWKS %d times for unknown keys, want once per %s", n, jwksMinRefetchInterval)
+	}
+
+	jwks.fetched = time.Now().Add(-jwksMinRefetchInterval)
+	_, _ = jwks.key("missing")
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("fetched the JWKS %d times once the interval passed, want 2", n)
+	}
+}
+
+func TestJWKSRefreshKeepsCachedKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32))) }
+	keys := []jsonWebKey{{KeyType: "EC", KeyID: "ec", Curve: "P-256", X: pad(ecKey.X), Y: pad(ecKey.Y)}}
+	// After the first fetch the endpoint hangs until released, then fails.
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+			http.Error(res, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(res).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(server.Close)
+	jwks := &jwksCache{url: server.URL, client: server.Client()}
+	if _, err := jwks.key("ec"); err != nil {
+		t.Fatal(err)
+	}
+
+	jwks.mutex.Lock()
+	jwks.fetched = time.Now().Add(-jwksRefreshInterval)
+	jwks.mutex.Unlock()
+	refreshed := make(chan error, 1)
+	go func() {
+		_, err := jwks.key("ec")
+		refreshed <- err
+	}()
+	eventually(t, "the refresh fetching", func() bool { return fetches.Load() == 2 })
+
+	looked := make(chan error, 1)
+	go func() {
+		_, err := jwks.key("ec")
+		looked <- err
+	}()
+	select {
+	case err := <-looked:
+		if err != nil {
+			t.Fatalf("lookup during the refresh: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup of a cached key waited for the refresh")
+	}
+
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Fatalf("failed refresh dropped the cached key: %s", err.Error())
+	}
+}
+
+func TestJWTAuthenticateRoomAndRole(t *testing.T) {
+	authenticator := &jwtAuthenticator{secret: testJWTSecret}
+	header := map[string]any{"alg": "HS256"}
+
+	tests := []struct {
+		name  string
+		room  any
+		role  any
+		want  error
+		roles []string
+	}{
+		{"unscoped", nil, nil, nil, nil},
+		{"room listed", []string{"a", "lobby"}, nil, nil, nil},
+		{"room as a string", "lobby", "publish", nil, []string{"publish"}},
+		{"other room", "a", nil, errForbidden, nil},
+		{"unknown role", nil, "admin", errUnauthenticated, nil},
+	}
+	for _, test := range tests {
+		claims := validClaims()
+		if test.room != nil {
+			claims["room"] = test.room
+		}
+		if test.role != nil {
+			claims["role"] = test.role
+		}
+		req := httptest.NewRequest(http.MethodPost, "/whip?room=lobby", nil)
+		req.Header.Set("Authorization", "Bearer "+signJWT(t, header, claims, hs256(testJWTSecret)))
+
+		identity, err := authenticator.Authenticate(req, "lobby")
+		if !errors.Is(err, test.want) || (test.want == nil) != (err == nil) {
+			t.Errorf("%s: error %v, want %v", test.name, err, test.want)
+			continue
+		}
+		if err == nil && (identity.Subject != "alice" || len(identity.Roles) != len(test.roles)) {
+			t.Errorf("%s: identity %+v", test.name, identity)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// audioSections returns the number of audio media sections of the offer,
+// rejected ones aside.
+func audioSections(offer *sdp.SessionDescription) int {
+	sections := 0
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Media == "audio" && media.MediaName.Port.Value != 0 {
+			sections++
+		}
+	}
+	return sections
+}
+
+// addRelayTracks adds a relay track answering each audio media section of
+// the offer, pion pairing them with the sections in order, and returns
+// their tracks and senders. Their codec is one of allowed, see relayCodec.
+func addRelayTracks(peerConnection *webrtc.PeerConnection, offer *sdp.SessionDescription, allowed []string) ([]*webrtc.TrackLocalStaticRTP, []*webrtc.RTPSender, error) {
+	codec := relayCodec(offer, allowed)
+	var tracks []*webrtc.TrackLocalStaticRTP
+	var senders []*webrtc.RTPSender
+	for i := range audioSections(offer) {
+		id := "audio"
+		if i > 0 {
+			id = fmt.Sprintf("audio%d", i)
+		}
+		track, sender, err := addAudioTrack(peerConnection, codec, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		tracks, senders = append(tracks, track), append(senders, sender)
+	}
+	return tracks, senders, nil
+}
+
+// newLane returns a lane of the peer: the relay state, a Peer of its own
+// sharing the peer's ID, connection and lifetime, of the relay track
+// answering one of the peer's further audio media sections. So it is
+// written, timed and reported on exactly like the peer's first relay
+// track, by its own writeLoop, sendRTCPReports and readReceiverReports.
+// Lanes aren't members of rooms; relayTarget picks them.
+func (p *Peer) newLane(track *webrtc.TrackLocalStaticRTP, sender *webrtc.RTPSender) *Peer {
+	return &Peer{
+		ID:             p.ID,
+		PeerConnection: p.PeerConnection,
+		rooms:          p.rooms,
+		AudioTrack:     track,
+		AudioSender:    sender,
+		paused:         make(map[string]bool),
+		dataChannels:   make(map[string]*webrtc.DataChannel),
+		outbound:       make(chan outboundPacket, config.RelayQueueSize),
+		streams:        make(map[relayStreamKey]*relayStream),
+		done:           p.done,
+		connected:      p.connected,
+	}
+}
+
+// lane returns the peer's index-th relay track, the peer itself for the
+// first one.
+func (p *Peer) lane(index int) *Peer {
+	if index == 0 {
+		return p
+	}
+	return p.lanes[index-1]
+}
+
+// trackLane returns the index of the audio media section, among the peer's
+// audio ones, the receiver receives on.
+func (p *Peer) trackLane(receiver *webrtc.RTPReceiver) int {
+	lane := 0
+	for _, transceiver := range p.PeerConnection.GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeAudio {
+			continue
+		}
+		if transceiver.Receiver() == receiver {
+			return lane
+		}
+		lane++
+	}
+	return 0
+}
+
+// laneIdle is how long a relay track carries no packet of the source stream
+// assigned to it before assignLane may hand it to another, e.g. when the
+// speaker of a half-duplex room changes.
+const laneIdle = time.Second
+
+// laneSource is the source stream a relay track of a destination carries:
+// the source and the lane relaysTrack puts the source's track in.
+type laneSource struct {
+	source *Peer
+	lane   int
+	// lastRelayed is when a packet of the stream was last relayed.
+	lastRelayed time.Time
+}
+
+// relayTarget returns the relay track of destination, the destination or
+// one of its lanes, that the peer's track is relayed into, nil when it
+// isn't or nothing of it was relayed yet. A source's k-th audio media
+// section goes to lane k, and the lane beyond the destination's last takes
+// the newest of the source's tracks beyond, e.g. with a single one the
+// newest of all, see startTrack; assignRelayTarget decides which relay
+// track carries each lane.
+func (p *Peer) relayTarget(track *webrtc.TrackRemote, destination *Peer) *Peer {
+	lane, ok := p.relaysTrack(track, 1+len(destination.lanes))
+	if !ok {
+		return nil
+	}
+
+	destination.mutex.Lock()
+	defer destination.mutex.Unlock()
+
+	index := destination.laneIndex(p, lane)
+	if index < 0 {
+		return nil
+	}
+	return destination.lane(index)
+}
+
+// assignRelayTarget returns the relay track of destination a packet of the
+// peer's track relayed at now goes into, like relayTarget, assigning the
+// track's lane a relay track of its own on first use: the relay track of
+// the same index when it's free, the first free one otherwise. Each relay
+// track carries a single source stream, as two interleaved on its SSRC
+// garble each other; streams beyond the destination's relay tracks aren't
+// relayed until one falls idle for laneIdle or its source leaves, see
+// releaseLanes.
+func (p *Peer) assignRelayTarget(track *webrtc.TrackRemote, destination *Peer, now time.Time) *Peer {
+	lane, ok := p.relaysTrack(track, 1+len(destination.lanes))
+	if !ok {
+		return nil
+	}
+
+	destination.mutex.Lock()
+	defer destination.mutex.Unlock()
+
+	if destination.laneSources == nil {
+		destination.laneSources = make([]laneSource, 1+len(destination.lanes))
+	}
+	index := destination.laneIndex(p, lane)
+	if index < 0 {
+		free := func(assigned laneSource) bool {
+			return assigned.source == nil || now.Sub(assigned.lastRelayed) > laneIdle
+		}
+		if free(destination.laneSources[lane]) {
+			index = lane
+		} else if index = slices.IndexFunc(destination.laneSources, free); index < 0 {
+			if !destination.laneFull {
+				destination.laneFull = true
+				fmt.Printf("Peer %s hears more streams than its %d relay tracks, not relaying peer %s's\n", destination.ID, len(destination.laneSources), p.ID)
+			}
+			return nil
+		}
+		destination.laneSources[index] = laneSource{source: p, lane: lane}
+	}
+	destination.laneSources[index].lastRelayed = now
+	return destination.lane(index)
+}
+
+// laneIndex returns the index of the relay track carrying the source's
+// lane, -1 for none. The caller holds p.mutex.
+func (p *Peer) laneIndex(source *Peer, lane int) int {
+	return slices.IndexFunc(p.laneSources, func(assigned laneSource) bool {
+		return assigned.source == source && assigned.lane == lane
+	})
+}
+
+// releaseLanes frees the relay tracks of the peer carrying the source's
+// streams.
+func (p *Peer) releaseLanes(source *Peer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, assigned := range p.laneSources {
+		if assigned.source == source {
+			p.laneSources[i] = laneSource{}
+			p.laneFull = false
+		}
+	}
+}
+
+// relayTracks returns the number of relay tracks of the peer, how many
+// source streams it can hear at once.
+func (p *Peer) relayTracks() int {
+	if p.AudioTrack == nil {
+		return 0
+	}
+	return 1 + len(p.lanes)
+}
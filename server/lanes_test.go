@@ -0,0 +1,215 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// sectionPeer is a test client offering several sendrecv audio sections,
+// which counts the tags heard on each section's relay track and sends tags
+// on the tracks it is told to.
+type sectionPeer struct {
+	peerConnection *webrtc.PeerConnection
+	tracks         []*webrtc.TrackLocalStaticSample
+	// heard counts the packets received per section and tag.
+	heard []map[byte]int
+	done  chan struct{}
+	mutex sync.Mutex
+}
+
+func joinSections(t *testing.T, serverURL string, query url.Values, sections int) *sectionPeer {
+	t.Helper()
+
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &sectionPeer{peerConnection: peerConnection, done: make(chan struct{})}
+	t.Cleanup(func() {
+		close(p.done)
+		_ = peerConnection.Close()
+	})
+
+	for i := range sections {
+		track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio"+string(rune('0'+i)), "sections")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = peerConnection.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+		p.tracks = append(p.tracks, track)
+		p.heard = append(p.heard, map[byte]int{})
+	}
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		section := 0
+		for i, transceiver := range peerConnection.GetTransceivers() {
+			if transceiver.Receiver() == receiver {
+				section = i
+			}
+		}
+		for {
+			pkt, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			if len(pkt.Payload) > 0 {
+				p.mutex.Lock()
+				p.heard[section][pkt.Payload[0]]++
+				p.mutex.Unlock()
+			}
+		}
+	})
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	res, err := http.Post(serverURL+"/whip?"+query.Encode(), "application/sdp", strings.NewReader(peerConnection.LocalDescription().SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// send starts sending tag on the section's track.
+func (p *sectionPeer) send(section int, tag byte) {
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = p.tracks[section].WriteSample(media.Sample{Data: []byte{tag}, Duration: 20 * time.Millisecond})
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+func (p *sectionPeer) heardOn(section int, tag byte) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.heard[section][tag]
+}
+
+func TestSecondTrackAfterConnecting(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"stage"}, "topology": {"broadcast"}}
+	publisher := joinSections(t, server.URL, query, 2)
+	single := joinSections(t, server.URL, query, 1)
+	double := joinSections(t, server.URL, query, 2)
+
+	publisher.send(0, 'A')
+	eventually(t, "the first track reaching both", func() bool {
+		return single.heardOn(0, 'A') > 0 && double.heardOn(0, 'A') > 0
+	})
+
+	// The second track starts once the publisher is connected.
+	publisher.send(1, 'B')
+	eventually(t, "the second track reaching both", func() bool {
+		return single.heardOn(0, 'B') > 0 && double.heardOn(1, 'B') > 0
+	})
+
+	// single's relay track carries the newest track only, while double
+	// hears each on its own.
+	heardA := single.heardOn(0, 'A')
+	time.Sleep(silenceWait)
+	if single.heardOn(0, 'A') != heardA {
+		t.Error("peer with one relay track still hears the first track after the second started")
+	}
+	if double.heardOn(0, 'B') > 0 || double.heardOn(1, 'A') > 0 {
+		t.Error("peer with two relay tracks hears the tracks mixed up")
+	}
+	if double.heardOn(0, 'A') <= 0 {
+		t.Error("peer with two relay tracks stopped hearing the first track")
+	}
+}
+
+func TestTwoAudioSectionsOffered(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"duet"}, "topology": {"broadcast"}}
+	publisher := joinSections(t, server.URL, query, 2)
+	subscriber := joinSections(t, server.URL, query, 2)
+
+	for _, peer := range []*sectionPeer{publisher, subscriber} {
+		answer := peer.peerConnection.RemoteDescription().SDP
+		if sections := strings.Count(answer, "\r\nm=audio "); sections != 2 {
+			t.Fatalf("answer has %d audio sections, want 2:\n%s", sections, answer)
+		}
+	}
+
+	publisher.send(0, 'A')
+	publisher.send(1, 'B')
+	eventually(t, "both sections reaching the subscriber", func() bool {
+		return subscriber.heardOn(0, 'A') > 0 && subscriber.heardOn(1, 'B') > 0
+	})
+	if subscriber.heardOn(0, 'B') > 0 || subscriber.heardOn(1, 'A') > 0 {
+		t.Error("subscriber hears the sections mixed up")
+	}
+}
+
+func TestMeshOfSeveralSections(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"circle"}, "topology": {"mesh"}}
+	peers := make([]*sectionPeer, 3)
+	for i := range peers {
+		peers[i] = joinSections(t, server.URL, query, 2)
+		peers[i].send(0, byte('A'+i))
+	}
+
+	// Each peer hears the other two, each on a relay track of its own.
+	for i, listener := range peers {
+		eventually(t, "both other peers being heard", func() bool {
+			heard := 0
+			for j := range peers {
+				if j != i && listener.heardOn(0, byte('A'+j))+listener.heardOn(1, byte('A'+j)) > 0 {
+					heard++
+				}
+			}
+			return heard == 2
+		})
+		for section := range 2 {
+			tags := 0
+			for j := range peers {
+				if listener.heardOn(section, byte('A'+j)) > 0 {
+					tags++
+				}
+			}
+			if tags > 1 {
+				t.Errorf("peer %d hears %d sources on relay track %d", i, tags, section)
+			}
+		}
+	}
+
+	// A fourth would leave the others a relay track short.
+	if _, err := server.TryJoin(t, query); err == nil {
+		t.Error("mesh admitted more sources than its peers have relay tracks")
+	}
+}
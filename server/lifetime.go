@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// startLifetimeTimer schedules the peer's teardown -max-peer-lifetime after it
+// joined, however active it is, so no session holds its resources forever
+// and clients reconnect periodically. Closing the peer connection is the
+// client's notice; close cancels the timer when the peer leaves first.
+func (p *Peer) startLifetimeTimer() {
+	if config.MaxPeerLifetime <= 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lifetimeTimer = time.AfterFunc(config.MaxPeerLifetime, func() {
+		message := "session reached the maximum lifetime of " + config.MaxPeerLifetime.String()
+		fmt.Printf("Peer %s: %s, removing\n", p.ID, message)
+		for _, room := range p.rooms {
+			room.logEvent(eventExpired, p.ID, message)
+		}
+		teardownPeer(p)
+	})
+}
+
+// stopLifetimeTimer cancels the peer's scheduled lifetime teardown.
+func (p *Peer) stopLifetimeTimer() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.lifetimeTimer != nil {
+		p.lifetimeTimer.Stop()
+		p.lifetimeTimer = nil
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestMaxPeerLifetime(t *testing.T) {
+	const lifetime = 2 * time.Second
+	server := startServer(t, "-max-peer-lifetime", lifetime.String())
+	joined := time.Now()
+	first := server.Join(t, "expiring")
+	second := server.Join(t, "expiring")
+	assertRelayed(t, first, second, relayTimeout)
+
+	// The second peer leaves before its lifetime, cancelling its timer;
+	// the first stays until its own runs out.
+	second.Close()
+	eventually(t, "the first peer being torn down", func() bool {
+		return roomManager.findPeer(first.ID) == nil
+	})
+	if elapsed := time.Since(joined); elapsed < lifetime {
+		t.Errorf("peer torn down %s after joining, before its lifetime of %s", elapsed, lifetime)
+	}
+	eventually(t, "the expired peer's connection closing", func() bool {
+		state := first.PeerConnection.ConnectionState()
+		return state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateDisconnected || state == webrtc.PeerConnectionStateFailed
+	})
+	if roomManager.findRoom("expiring") != nil {
+		t.Error("room left behind after its last peer expired")
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// listen opens the server's listener: the -unix-socket path when set, for
+// sidecar deployments behind a local proxy, otherwise the -listen address. A
+// socket file left behind by an earlier run that didn't shut down cleanly is
+// removed first; any other file at the path is an error.
+func listen() (net.Listener, error) {
+	if config.UnixSocket == "" {
+		return net.Listen("tcp", config.ListenAddress)
+	}
+
+	info, err := os.Lstat(config.UnixSocket)
+	switch {
+	case err == nil && info.Mode()&fs.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a socket", config.UnixSocket)
+	case err == nil:
+		if err = os.Remove(config.UnixSocket); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	return net.Listen("unix", config.UnixSocket)
+}
+
+// serve serves handler on listener until SIGINT or SIGTERM. Closing the
+// server closes the listener, which removes a Unix socket's file.
+func serve(listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		fmt.Printf("Received %s, shutting down\n", received)
+		_ = server.Close()
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, more than t.TempDir()
+	// may leave.
+	dir, err := os.MkdirTemp("", "single-whip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "whip.sock")
+
+	// A socket left behind by a run that didn't shut down cleanly.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	if _, err = os.Lstat(path); err != nil {
+		t.Fatalf("stale socket wasn't left behind: %s", err.Error())
+	}
+
+	startServer(t, "-unix-socket", path)
+	listener, err := listen()
+	if err != nil {
+		t.Fatalf("listening over the stale socket: %s", err.Error())
+	}
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	server := &http.Server{Handler: mux}
+	go func() { _ = server.Serve(listener) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	res, err := client.Get("http://single-whip/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("GET /version over the socket answered %d", res.StatusCode)
+	}
+
+	_ = server.Close()
+	if _, err = os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file remains after shutdown: %v", err)
+	}
+
+	// Files other than sockets are left alone.
+	if err = os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if listener, err = listen(); err == nil {
+		_ = listener.Close()
+		t.Error("listened over a regular file")
+	}
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// icePasswordAttribute starts the line of the ICE password, which logSDP
+// leaves out: anyone holding it can answer the session's connectivity
+// checks. The username fragment stays, as every check carries it in the
+// clear anyway and it ties the log to candidates and STUN traffic.
+const icePasswordAttribute = "a=ice-pwd:"
+
+// logSDP logs a negotiation's full offer or answer with -log-sdp, tagged with
+// the peer and its rooms, for diagnosing codec and ICE negotiation failures.
+// ICE passwords are redacted.
+func logSDP(kind string, peer *Peer, description string) {
+	if config.LogSDP {
+		fmt.Print(sdpLogEntry(kind, peer, description))
+	}
+}
+
+// sdpLogEntry returns the log entry of logSDP.
+func sdpLogEntry(kind string, peer *Peer, description string) string {
+	roomIDs := make([]string, len(peer.rooms))
+	for i, room := range peer.rooms {
+		roomIDs[i] = room.ID
+	}
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(description, "\r\n", "\n"), "\n"), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, icePasswordAttribute) {
+			lines[i] = icePasswordAttribute + "<redacted>"
+		}
+	}
+	return fmt.Sprintf("SDP %s of peer %s in room %s:\n%s\n", kind, peer.ID, strings.Join(roomIDs, ","), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/sdp/v3"
+)
+
+func TestLogSDP(t *testing.T) {
+	server := startServer(t, "-log-sdp")
+	peer := server.Join(t, "logged")
+	logged := roomManager.findPeer(peer.ID)
+
+	for kind, description := range map[string]string{
+		"offer":  peer.PeerConnection.LocalDescription().SDP,
+		"answer": peer.PeerConnection.RemoteDescription().SDP,
+	} {
+		entry := sdpLogEntry(kind, logged, description)
+		if !strings.HasPrefix(entry, "SDP "+kind+" of peer "+peer.ID+" in room logged:\n") {
+			t.Errorf("%s logged as %q", kind, entry)
+		}
+		if !strings.Contains(entry, "\nm=audio ") {
+			t.Errorf("logged %s has no media section", kind)
+		}
+		if !strings.Contains(entry, "\na=ice-pwd:<redacted>\n") {
+			t.Errorf("ICE password of the %s wasn't redacted", kind)
+		}
+
+		var parsed sdp.SessionDescription
+		if err := parsed.Unmarshal([]byte(description)); err != nil {
+			t.Fatal(err)
+		}
+		for _, media := range parsed.MediaDescriptions {
+			if password, ok := media.Attribute("ice-pwd"); ok && strings.Contains(entry, password) {
+				t.Errorf("logged %s holds the ICE password %s", kind, password)
+			}
+		}
+	}
+}
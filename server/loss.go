@@ -0,0 +1,14 @@
+package server
+
+import "math/rand/v2"
+
+// simulateLoss reports whether -simulate-loss drops the packet about to be
+// written, counting it. Dropping after the queue and before the relay clock
+// leaves a gap in the destination's sequence numbers, as real loss would.
+func simulateLoss() bool {
+	if config.SimulateLoss <= 0 || rand.Float64()*100 >= config.SimulateLoss {
+		return false
+	}
+	relayStats.PacketsLossSimulated.Add(1)
+	return true
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulateLossRate(t *testing.T) {
+	startServer(t, "-unsafe", "-simulate-loss", "25")
+
+	const packets = 20000
+	before := relayStats.PacketsLossSimulated.Load()
+	dropped := 0
+	for range packets {
+		if simulateLoss() {
+			dropped++
+		}
+	}
+	// 25% of 20000 with a standard deviation of about 61 packets.
+	if dropped < 4700 || dropped > 5300 {
+		t.Errorf("dropped %d of %d packets, want about 25%%", dropped, packets)
+	}
+	if counted := relayStats.PacketsLossSimulated.Load() - before; counted != uint64(dropped) {
+		t.Errorf("counted %d simulated losses, dropped %d", counted, dropped)
+	}
+}
+
+func TestSimulateLossRequiresUnsafe(t *testing.T) {
+	if _, err := Handler("-simulate-loss", "25"); err == nil {
+		t.Error("-simulate-loss was accepted without -unsafe")
+	}
+}
+
+func TestSimulateLossRelayed(t *testing.T) {
+	server := startServer(t, "-unsafe", "-simulate-loss", "50")
+	source := server.Join(t, "lossy")
+	listener := server.Join(t, "lossy")
+	assertRelayed(t, source, listener, relayTimeout)
+
+	// The source sends a packet every 20ms, 100 over two seconds.
+	heard := listener.Heard(source)
+	time.Sleep(2 * time.Second)
+	if heard = listener.Heard(source) - heard; heard < 25 || heard > 75 {
+		t.Errorf("listener heard %d of about 100 packets, want about half", heard)
+	}
+}
@@ -1,35 +1,450 @@
-package main
+// Package server is the single-whip server, run by the single-whip command,
+// see Main, and in process by tests, see Handler and package singlewhiptest.
+package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 )
 
 var (
-	peerConnectionConfiguration = webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
+	peerConnectionConfiguration = webrtc.Configuration{}
+	webrtcAPI                   *webrtc.API
+	config                      Config
+
+	// relayedHeaderExtensions are negotiated with every peer and carried
+	// through the relay, remapped to the IDs each side agreed on.
+	relayedHeaderExtensions = []string{sdp.AudioLevelURI}
+
+	// audioCodecs are pion's default audio codecs. No video codecs are
+	// registered, so the answer rejects offered video sections with port 0
+	// while audio negotiates as usual.
+	audioCodecs = []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2,
+				SDPFmtpLine: "minptime=10;useinbandfec=1",
 			},
+			PayloadType: 111,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeG722, ClockRate: 8000},
+			PayloadType:        rtp.PayloadTypeG722,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+			PayloadType:        rtp.PayloadTypePCMU,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+			PayloadType:        rtp.PayloadTypePCMA,
 		},
 	}
-	webrtcAPI *webrtc.API
+
+	// negotiationSlots bounds concurrent WHIP negotiations; nil means
+	// unlimited.
+	negotiationSlots chan struct{}
+
+	errTooManyNegotiations = errors.New("too many concurrent negotiations")
+	errMaxRooms            = errors.New("maximum number of rooms reached")
+	errRoomFull            = errors.New("room is full")
+	errUserRoomQuota       = errors.New("maximum number of rooms per user reached")
+	errRoomDraining        = errors.New("room is draining")
+	errRoomRemoved         = errors.New("room was removed")
 )
 
+// Config holds the server settings populated from command-line flags.
+type Config struct {
+	// MaxRooms caps how many rooms may exist at once; zero means unlimited.
+	MaxRooms int
+	// MaxRoomsPerUser caps how many rooms one authenticated identity may
+	// have created at once, see Room.Creator; zero means unlimited.
+	MaxRoomsPerUser int
+	// MaxNegotiations caps how many WHIP offers are negotiated at once;
+	// excess requests get 503. Zero means unlimited.
+	MaxNegotiations int
+	// ResumptionTokenTTL is how long a token issued on join stays valid for
+	// reconnecting to the same room; zero disables issuing tokens.
+	ResumptionTokenTTL time.Duration
+	// REMBBitrate is the default RoomOptions.REMBBitrate for new rooms.
+	REMBBitrate uint64
+	// AnswerBitrate is the default RoomOptions.AnswerBitrate for new rooms.
+	AnswerBitrate uint64
+	// WHIPPaths are the ingest paths served by whipHandler; each also serves
+	// its resources under "<path>/<peer ID>".
+	WHIPPaths stringList
+	// WHEPPaths are the egress paths, served like WHIPPaths; they differ
+	// only with EnforceDirections.
+	WHEPPaths stringList
+	// EnforceDirections rejects WHIP offers that don't send audio and WHEP
+	// offers that do, see checkDirection.
+	EnforceDirections bool
+	// StrictParams rejects WHIP POSTs with unknown query parameters, see
+	// checkQueryParameters.
+	StrictParams bool
+	// BasePath is the sub-path the server is mounted at, e.g. /media. It
+	// prefixes every route, and with it the resource URLs handed to clients.
+	BasePath string
+	// ListenAddress is the TCP address the server listens on; port 0 picks
+	// a free one, which the startup line reports.
+	ListenAddress string
+	// UnixSocket, when set, is the path of a Unix domain socket the server
+	// listens on instead of TCP, see listen.
+	UnixSocket string
+	// ICEServers are the STUN and TURN server URLs peers gather candidates
+	// with; TURN ones use TURNUsername and TURNCredential. See
+	// configureICEServers.
+	ICEServers     stringList
+	TURNUsername   string
+	TURNCredential string
+	// TURNCredentialFile, when set, holds the TURN credentials instead of
+	// TURNUsername and TURNCredential and is reloaded when it changes, see
+	// watchTURNCredentials.
+	TURNCredentialFile string
+	// ICESRVDomain adds the STUN and TURN servers its SRV records name to
+	// ICEServers, looked up again every ICESRVRefresh, see
+	// discoverICEServers.
+	ICESRVDomain  string
+	ICESRVRefresh time.Duration
+	// NoSTUN configures no ICE servers at all, for LAN-only deployments.
+	NoSTUN bool
+	// ICEServerLinks advertises ICEServers in Link headers of answers.
+	ICEServerLinks bool
+	// CORSOrigins are the origins browsers may call the server from, "*"
+	// for any; CORSMethods, when set, limits the methods advertised to
+	// them and CORSHeaders replaces the default allowed headers. See
+	// addCORSHeaders.
+	CORSOrigins stringList
+	CORSMethods stringList
+	CORSHeaders stringList
+	// CORSMaxAge is how long browsers may cache preflight responses; zero
+	// leaves it to them.
+	CORSMaxAge time.Duration
+	// InactivityTimeout closes rooms that received no media for this long,
+	// see reapInactiveRooms; zero never does.
+	InactivityTimeout time.Duration
+	// RetryAfter is the Retry-After of joins rejected for capacity, see
+	// writeOverloaded; zero omits the header.
+	RetryAfter time.Duration
+	// StartupBufferSize is how many relayed packets a peer's writeLoop
+	// holds until its connection is up; zero drops them instead.
+	StartupBufferSize int
+	// RelayQueueSize is how many relayed packets may wait for each
+	// destination before the oldest are dropped.
+	RelayQueueSize int
+	// ICEAllowInterfaces and ICEDenyInterfaces filter the network interfaces
+	// used for candidate gathering by name.
+	ICEAllowInterfaces stringList
+	ICEDenyInterfaces  stringList
+	// ICEAllowCIDRs and ICEDenyCIDRs filter gathered candidate addresses.
+	ICEAllowCIDRs stringList
+	ICEDenyCIDRs  stringList
+	// ICEGatherTimeout bounds candidate gathering for an answer, which then
+	// carries the candidates found so far; NegotiationTimeout still bounds
+	// the whole request.
+	ICEGatherTimeout time.Duration
+	// ICEGatherWatchdog closes peers whose gathering is still running this
+	// long after ICEGatherTimeout, see watchGathering.
+	ICEGatherWatchdog time.Duration
+	// LogSDP logs every negotiation's offer and answer in full, see logSDP.
+	LogSDP bool
+	// StreamCandidates answers WHIP POSTs accepting text/event-stream
+	// before gathering completes, see candidateStream.
+	StreamCandidates bool
+	// TrickleICE accepts candidates trickled in PATCH requests, advertised
+	// in answers, see localAnswer.
+	TrickleICE bool
+	// RTCPRsize accepts reduced-size RTCP in answers to offers proposing
+	// it, see answerRTCPRsize.
+	RTCPRsize bool
+	// ICEDisconnectedTimeout, ICEFailedTimeout and ICEKeepaliveInterval
+	// tune ICE connectivity checks, see configureICETimeouts.
+	ICEDisconnectedTimeout time.Duration
+	ICEFailedTimeout       time.Duration
+	ICEKeepaliveInterval   time.Duration
+	// RTCPMuxPolicy and BundlePolicy decide which offers are answered, see
+	// checkTransportPolicies.
+	RTCPMuxPolicy string
+	BundlePolicy  string
+	// ICEUfragLength and ICEPwdLength are the lengths of the ICE
+	// credentials in answers, see newPeerConnection; zero keeps pion's.
+	// Connections with custom lengths can't restart ICE.
+	ICEUfragLength int
+	ICEPwdLength   int
+	// QualityInterval is how often per-peer RTP quality is sampled for
+	// /stats/prometheus.
+	QualityInterval time.Duration
+	// QualityMaxSeries caps how many peers get per-peer quality series.
+	QualityMaxSeries int
+	// DSCP is the DiffServ class marked on outgoing packets; empty disables
+	// marking. See configureUDPMux.
+	DSCP string
+	// UDPMuxPort serves every connection's ICE traffic from one UDP port;
+	// zero gives each connection its own ephemeral ports.
+	UDPMuxPort int
+	// RecordDir receives a recording of every source's relayed audio; empty
+	// disables recording.
+	RecordDir string
+	// RecordFormat is the recording format, see validateRecordFormat.
+	RecordFormat string
+	// RecordOnJoin records new rooms from the start; otherwise recording
+	// starts through the admin API.
+	RecordOnJoin bool
+	// AccessLog logs every HTTP request.
+	AccessLog bool
+	// CNAME is the stream ID of every relay track; pion advertises it as the
+	// SDP cname and sendRTCPReports carries it in RTCP SDES.
+	CNAME string
+	// NegotiationTimeout bounds a whole WHIP POST, from reading the offer to
+	// finishing ICE gathering; zero disables the bound.
+	NegotiationTimeout time.Duration
+	// Topology is the default RoomOptions.Topology for new rooms.
+	Topology string
+	// IdempotencyKeyTTL is how long an Idempotency-Key keeps replaying the
+	// session it created; zero disables idempotent POSTs.
+	IdempotencyKeyTTL time.Duration
+	// MaxEgressBitrate caps the relayed audio sent to each peer in bits per
+	// second, dropping packets above it; zero disables the cap.
+	MaxEgressBitrate uint64
+	// AdminToken is the bearer token of the admin API; empty disables it.
+	AdminToken string
+	// DisconnectGrace is how long a disconnected peer may take to reconnect
+	// before it is removed; zero leaves removal to ICE failure.
+	DisconnectGrace time.Duration
+	// MaxPeerLifetime is how long a peer may stay, however active; zero
+	// doesn't limit it.
+	MaxPeerLifetime time.Duration
+	// DTLSSetup is the a=setup attribute of answers, see
+	// configureDTLSSetup.
+	DTLSSetup string
+	// DTLSCertificate is a PEM file holding the DTLS certificate and key,
+	// created on first start, see loadDTLSCertificate. Empty uses a new
+	// certificate per process.
+	DTLSCertificate string
+	// AuthTokens are static bearer tokens admitting WHIP POSTs to any room.
+	AuthTokens stringList
+	// JWTSecret and JWTJWKSURL admit WHIP POSTs bearing JWTs signed with the
+	// HS256 secret or a key of the JWKS; JWTIssuer and JWTAudience, when
+	// set, must match the iss and aud claims.
+	JWTSecret   string
+	JWTJWKSURL  string
+	JWTIssuer   string
+	JWTAudience string
+	// RED negotiates redundant audio with peers that offer it, see
+	// mimeTypeRED.
+	RED bool
+	// CodecPreference orders the codecs of answers, most preferred first,
+	// see preferCodecs.
+	CodecPreference stringList
+	// Transcode is the default RoomOptions.Transcode for new rooms.
+	Transcode bool
+	// RelayReceiverReports sends sources the worst loss and jitter their
+	// listeners report, see relayReceiverReports.
+	RelayReceiverReports bool
+	// MinimalInterceptors runs without the interceptors that fail to
+	// register instead of refusing to start, see registerInterceptors.
+	MinimalInterceptors bool
+	// RelayBye ends a source's relay when it sends an RTCP BYE, passing the
+	// BYE on to its listeners, see relayGoodbye.
+	RelayBye bool
+	// SilenceFill fills gaps of up to this many packets in the relayed
+	// stream with silence, see silenceFillers; zero disables it.
+	SilenceFill int
+	// EmptyPayloads relays or drops sources' packets without payload, or
+	// decides by whether they negotiated DTX, see relaysEmptyPayloads.
+	EmptyPayloads string
+	// FECAdaptation logs whether sources should raise or relax Opus FEC as
+	// listeners' loss crosses FECLossHigh and FECLossLow, in percent, see
+	// fecAdaptation.
+	FECAdaptation bool
+	FECLossHigh   float64
+	FECLossLow    float64
+	// SimulateLoss drops this percentage of relayed packets at random, to
+	// exercise NACK, FEC and jitter buffers without a lossy network. It
+	// needs Unsafe, which gates settings that degrade service on purpose.
+	SimulateLoss float64
+	Unsafe       bool
+}
+
+// stringList is a flag.Value collecting comma-separated values across
+// repeated flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
 type Room struct {
-	ID    string
-	PeerA *Peer
-	PeerB *Peer
-	mutex sync.Mutex
+	ID      string
+	Options RoomOptions
+	// Creator is the Identity.Subject of the client whose join created the
+	// room, empty for anonymous ones. Its rooms count toward
+	// config.MaxRoomsPerUser while they exist.
+	Creator string
+	// Peers are the room's members in join order.
+	Peers []*Peer
+	// Publisher is the only source relayed in a broadcast room: the first
+	// peer to join while the role is vacant.
+	Publisher *Peer
+	// Speaker is the only source relayed in a half-duplex room, nobody
+	// until granted the floor.
+	Speaker *Peer
+	// recording enables recording of the room's sources, see
+	// setRecording.
+	recording bool
+	// sinks are the room's file sinks, see fileSink.
+	sinks []*fileSink
+	// events is the room's recent history, see roomEventsHandler.
+	events roomEventLog
+	// draining rejects new peers and drainTimer removes the remaining ones,
+	// see Room.drain.
+	draining   bool
+	drainTimer *time.Timer
+	// lastActivity is when the room last relayed media, in Unix
+	// nanoseconds, see LastActivity.
+	lastActivity atomic.Int64
+	mutex        sync.Mutex
+}
+
+// Room topologies accepted by -topology and the topology query parameter, or
+// its alias mode.
+const (
+	// topologyPairs admits two peers who hear each other.
+	topologyPairs = "pairs"
+	// topologyMesh admits peers who all hear each other, each source on a
+	// relay track of its own at every other peer, so only as many as the
+	// peers have relay tracks for: two of the usual peers offering a single
+	// audio media section, more when all offer more, see meshFits.
+	topologyMesh = "mesh"
+	// topologyBroadcast relays the publisher to every other peer and
+	// nobody else's audio.
+	topologyBroadcast = "broadcast"
+)
+
+func validateTopology(topology string) error {
+	switch topology {
+	case topologyPairs, topologyMesh, topologyBroadcast:
+		return nil
+	default:
+		return fmt.Errorf("unknown topology %q: want pairs, mesh or broadcast", topology)
+	}
+}
+
+// RoomOptions are the per-room settings fixed when the room is created, taken
+// from the query parameters of the first join.
+type RoomOptions struct {
+	// REMBBitrate is the bitrate in bits per second publishers are asked to
+	// stay under via REMB; zero disables REMB.
+	REMBBitrate uint64
+	// AnswerBitrate is the b=AS bandwidth in kbps answers cap clients'
+	// sending at, see withAnswerBitrate; zero adds none.
+	AnswerBitrate uint64
+	// Topology decides who hears whom, see Room.destinations.
+	Topology string
+	// Transcode converts Opus to G.711 for peers that negotiated only PCMU
+	// or PCMA, see payloadAdapter. It decodes every Opus packet such peers
+	// hear, so it costs CPU per source.
+	Transcode bool
+	// AllowedSSRCs, when set, limits relaying to the sources' streams with
+	// these SSRCs; packets of other streams are ignored. Nil relays all.
+	AllowedSSRCs []uint32
+	// HalfDuplex relays only the room's Speaker, for push-to-talk or
+	// moderated rooms; the others are heard once granted the floor, see
+	// roomSpeakerHandler.
+	HalfDuplex bool
+	// Codecs, when set, are the only codecs the room negotiates, by
+	// lowercase encoding; offers listing none of them are rejected, see
+	// checkRoomCodecs. Nil allows every registered codec.
+	Codecs []string
 }
 
 type Peer struct {
+	ID             string
 	PeerConnection *webrtc.PeerConnection
-	AudioTrack     *webrtc.TrackLocalStaticRTP
+	// rooms are the rooms the peer joined, more than one for a join like
+	// ?room=a,b. The first one's options set up the peer's own relay
+	// track, e.g. its codec and answer; each room's apply to what is
+	// relayed through it, see relayDestinations. Fixed once the peer is
+	// created.
+	rooms       []*Room
+	AudioTrack  *webrtc.TrackLocalStaticRTP
+	AudioSender *webrtc.RTPSender
+	// recorders record the audio relayed from this peer's tracks.
+	recorders []*trackRecorder
+	// clock times the Sender Reports of the peer's relay track.
+	clock relayClock
+	// feedback is the peer's latest Receiver Report on its relay track.
+	feedback receiverFeedback
+	// disconnectTimer tears the peer down unless its connection recovers
+	// within config.DisconnectGrace.
+	disconnectTimer *time.Timer
+	// lifetimeTimer tears the peer down after config.MaxPeerLifetime.
+	lifetimeTimer *time.Timer
+	// dataChannels are the peer's open data channels by label.
+	dataChannels map[string]*webrtc.DataChannel
+	// bytesReceived counts RTP payload bytes received from this peer.
+	bytesReceived atomic.Uint64
+	// streams count what is relayed to this peer per source stream.
+	streams map[relayStreamKey]*relayStream
+	// outbound queues relayed packets for writeLoop so a slow write to this
+	// peer never stalls the sources reading into it.
+	outbound  chan outboundPacket
+	done      chan struct{}
+	closeOnce sync.Once
+	// loopsMutex orders startLoop against close, so no loop of the peer
+	// starts once it is closed.
+	loopsMutex sync.Mutex
+	// teardownOnce runs teardownPeer's work once.
+	teardownOnce sync.Once
+	// connected is closed once the peer first connects, see writeLoop.
+	connected     chan struct{}
+	connectedOnce sync.Once
+	// audioTracks are the peer's live audio tracks in arrival order, see
+	// startTrack.
+	audioTracks []sourceTrack
+	// lanes are the relay tracks answering the peer's audio media sections
+	// after the first, see newLane. Fixed once the peer is created.
+	lanes []*Peer
+	// laneSources are the source streams the peer's relay tracks carry,
+	// indexed like lane, see assignRelayTarget. laneFull is set once a
+	// stream found none free, to log it once.
+	laneSources []laneSource
+	laneFull    bool
+	// paused holds the source peer IDs this peer stopped receiving from.
+	// The empty key pauses every source.
+	paused map[string]bool
+	mutex  sync.Mutex
 }
 
 type RoomManager struct {
@@ -41,192 +456,1790 @@ var roomManager = &RoomManager{
 	rooms: make(map[string]*Room),
 }
 
-func main() {
-	mediaEngine := &webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+// peerLoops counts the running goroutines serving peers, see startLoop.
+// Once every peer is closed, and its connection with it, they all end.
+var peerLoops sync.WaitGroup
+
+// Main runs the single-whip command with the arguments in os.Args.
+func Main() {
+	configFile, showVersion, selfTest := registerFlags(flag.CommandLine)
+	flag.Usage = usage
+
+	command, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		usage()
+		os.Exit(2)
+	}
+	_ = flag.CommandLine.Parse(args)
+	switch {
+	case *showVersion:
+		command = commandVersion
+	case *selfTest:
+		command = commandSelfTest
+	}
+
+	if command == commandVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	if *configFile != "" {
+		if err = loadConfigFile(*configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid config file %s: %s\n", *configFile, err.Error())
+			os.Exit(2)
+		}
+	}
+	configure(command != commandValidate)
+
+	if command == commandValidate {
+		os.Exit(runValidate(flag.Args()))
+	}
+
+	handler := newHandler()
+
+	go collectPeerQuality()
+	if config.InactivityTimeout > 0 {
+		go reapInactiveRooms()
+	}
+
+	if command == commandSelfTest {
+		if err := runSelfTest(handler); err != nil {
+			fmt.Printf("FAIL: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("PASS")
+		return
+	}
+
+	listener, err := listen()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(versionString())
+	fmt.Printf("Server started on %s\n", listener.Addr())
+	if err = serve(listener, handler); err != nil {
+		panic(err)
+	}
+}
+
+// Handler configures the server from flags, as given to the command, and
+// returns its routes for serving in process, e.g. with httptest. The
+// server's state is global, so a process runs one at a time: Handler first
+// stops the peers of the previous one, and Stop those of the last. Unlike
+// the command it starts no background loops, such as the
+// -inactivity-timeout reaper.
+func Handler(flags ...string) (handler http.Handler, err error) {
+	Stop()
+	config = Config{}
+	peerConnectionConfiguration = webrtc.Configuration{}
+	negotiationSlots = nil
+	roomManager = &RoomManager{rooms: make(map[string]*Room)}
+
+	flagSet := flag.NewFlagSet("single-whip", flag.ContinueOnError)
+	registerFlags(flagSet)
+	if err = flagSet.Parse(flags); err != nil {
+		return nil, err
+	}
+	// configure panics on invalid settings, which the command reports as
+	// they are.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	configure(true)
+	return newHandler(), nil
+}
+
+// Stop tears down the peers of the server Handler configured last and waits
+// for their loops to end. Closing its HTTP server first ensures no join is
+// under way.
+func Stop() {
+	roomManager.mutex.RLock()
+	var peers []*Peer
+	for _, room := range roomManager.rooms {
+		peers = append(peers, room.otherPeers(nil)...)
+	}
+	roomManager.mutex.RUnlock()
+	for _, peer := range peers {
+		teardownPeer(peer)
+	}
+	peerLoops.Wait()
+}
+
+// newHandler returns the server's routes, logging requests with
+// -access-log.
+func newHandler() http.Handler {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	if config.AccessLog {
+		return accessLog(mux)
+	}
+	return mux
+}
+
+// registerFlags registers the server's flags on flags, setting config, and
+// returns those naming what to do rather than a setting.
+func registerFlags(flags *flag.FlagSet) (configFile *string, showVersion, selfTest *bool) {
+	flags.IntVar(&config.MaxRooms, "max-rooms", 0, "maximum number of concurrent rooms (0 = unlimited)")
+	flags.IntVar(&config.MaxRoomsPerUser, "max-rooms-per-user", 0, "maximum number of concurrent rooms each authenticated user may create, excess joins get 429 (0 = unlimited)")
+	flags.IntVar(&config.MaxNegotiations, "max-negotiations", 0, "maximum number of concurrent WHIP negotiations (0 = unlimited)")
+	flags.Var(&config.ICEServers, "ice-server", "STUN or TURN server URL, comma-separated or repeated (default "+defaultICEServer+")")
+	flags.StringVar(&config.ICESRVDomain, "ice-srv-domain", "", "add the STUN and TURN servers named by the _stun._udp, _stuns._tcp, _turn._udp, _turn._tcp and _turns._tcp SRV records of this domain")
+	flags.DurationVar(&config.ICESRVRefresh, "ice-srv-refresh", 5*time.Minute, "how often to look up the -ice-srv-domain SRV records again (0 = only at startup)")
+	flags.BoolVar(&config.NoSTUN, "no-stun", false, "use no STUN or TURN server, gathering only host candidates; peers must reach the server's addresses directly, e.g. on a LAN")
+	flags.StringVar(&config.TURNUsername, "turn-username", "", "username for the -ice-server TURN servers")
+	flags.StringVar(&config.TURNCredential, "turn-credential", "", "password for the -ice-server TURN servers")
+	flags.StringVar(&config.TURNCredentialFile, "turn-credential-file", "", "JSON file with the username and credential of the -ice-server TURN servers, applied to new connections whenever it changes")
+	flags.BoolVar(&config.ICEServerLinks, "ice-server-links", false, "advertise the ICE servers, with TURN credentials, in Link headers of answers")
+	flags.Var(&config.CORSOrigins, "cors-origin", "origins allowed to call the server from browsers, comma-separated or repeated (default *)")
+	flags.Var(&config.CORSMethods, "cors-method", "limit the methods advertised to browsers, comma-separated or repeated (default all each endpoint supports)")
+	flags.Var(&config.CORSHeaders, "cors-header", "request headers browsers may send, comma-separated or repeated (default any)")
+	flags.DurationVar(&config.CORSMaxAge, "cors-max-age", 0, "how long browsers may cache CORS preflight responses (0 = browser default)")
+	flags.DurationVar(&config.InactivityTimeout, "inactivity-timeout", time.Hour, "close rooms that received no media for this long, even with peers connected (0 = never)")
+	flags.DurationVar(&config.RetryAfter, "retry-after", 5*time.Second, "Retry-After of joins rejected because the server, or room, is at capacity or draining")
+	flags.DurationVar(&config.ResumptionTokenTTL, "resumption-token-ttl", 5*time.Minute, "validity of the resumption token issued on join (0 = disabled)")
+	flags.Uint64Var(&config.REMBBitrate, "remb-bitrate", 0, "default REMB target in bits per second sent to publishers (0 = disabled)")
+	flags.Uint64Var(&config.AnswerBitrate, "answer-bitrate", 0, "default b=AS bandwidth in kbps put in answers' audio sections (0 = none)")
+	flags.Var(&config.WHIPPaths, "whip-path", "WHIP ingest path, comma-separated or repeated (default /whip)")
+	flags.Var(&config.WHEPPaths, "whep-path", "WHEP egress path, comma-separated or repeated (default /whep)")
+	flags.BoolVar(&config.StrictParams, "strict-params", false, "reject WHIP POSTs with unknown query parameters with 400")
+	flags.BoolVar(&config.EnforceDirections, "enforce-directions", false, "reject WHIP offers that don't send audio and WHEP offers that do, with 422")
+	flags.StringVar(&config.BasePath, "base-path", "", "path prefix the server is mounted at, e.g. /media (default root)")
+	flags.StringVar(&config.ListenAddress, "listen", ":8080", "TCP address to listen on, port 0 for any free port")
+	flags.StringVar(&config.UnixSocket, "unix-socket", "", "listen on this Unix domain socket path instead of TCP")
+	flags.IntVar(&config.RelayQueueSize, "relay-queue-size", 50, "relayed packets buffered per destination before dropping the oldest")
+	flags.IntVar(&config.StartupBufferSize, "startup-buffer", 25, "relayed packets held per destination until it connects, then sent (0 = drop them)")
+	flags.Var(&config.ICEAllowInterfaces, "ice-allow-interfaces", "only gather ICE candidates on these interfaces, comma-separated")
+	flags.Var(&config.ICEDenyInterfaces, "ice-deny-interfaces", "never gather ICE candidates on these interfaces (e.g. docker0), comma-separated")
+	flags.Var(&config.ICEAllowCIDRs, "ice-allow-cidrs", "only gather ICE candidates with addresses in these CIDRs, comma-separated")
+	flags.Var(&config.ICEDenyCIDRs, "ice-deny-cidrs", "never gather ICE candidates with addresses in these CIDRs, comma-separated")
+	flags.DurationVar(&config.ICEGatherTimeout, "ice-gather-timeout", 5*time.Second, "time spent gathering ICE candidates before answering with those found")
+	flags.BoolVar(&config.LogSDP, "log-sdp", false, "log the full SDP offer and answer of every negotiation, ICE passwords redacted (verbose, includes client addresses)")
+	flags.BoolVar(&config.TrickleICE, "trickle-ice", true, "accept trickled ICE candidates in WHIP PATCH requests and advertise it with a=ice-options:trickle")
+	flags.BoolVar(&config.RTCPRsize, "rtcp-rsize", true, "accept reduced-size RTCP (a=rtcp-rsize) in answers when offered")
+	flags.BoolVar(&config.StreamCandidates, "stream-candidates", false, "answer WHIP POSTs that accept text/event-stream immediately and stream ICE candidates as they are gathered (non-standard)")
+	flags.DurationVar(&config.ICEGatherWatchdog, "ice-gather-watchdog", 30*time.Second, "close peers whose ICE gathering is still running this long after answering (0 = never)")
+	flags.DurationVar(&config.ICEDisconnectedTimeout, "ice-disconnected-timeout", 5*time.Second, "time without ICE traffic before a connection is disconnected")
+	flags.DurationVar(&config.ICEFailedTimeout, "ice-failed-timeout", 25*time.Second, "time disconnected before a connection fails")
+	flags.StringVar(&config.RTCPMuxPolicy, "rtcp-mux-policy", rtcpMuxNegotiate, "RTCP mux policy: negotiate, or require to reject offers without a=rtcp-mux")
+	flags.StringVar(&config.BundlePolicy, "bundle-policy", bundleBalanced, "bundle policy: balanced, max-compat, or max-bundle to reject offers not bundling every section")
+	flags.IntVar(&config.ICEUfragLength, "ice-ufrag-length", 0, "length of the ICE ufrag in answers, for middleboxes requiring longer credentials (0 = pion's 16)")
+	flags.IntVar(&config.ICEPwdLength, "ice-pwd-length", 0, "length of the ICE password in answers (0 = pion's 32)")
+	flags.DurationVar(&config.ICEKeepaliveInterval, "ice-keepalive-interval", 2*time.Second, "interval of ICE keepalives while no media flows")
+	flags.DurationVar(&config.QualityInterval, "quality-interval", 10*time.Second, "interval between per-peer RTP quality samples")
+	flags.IntVar(&config.QualityMaxSeries, "quality-max-series", 500, "maximum number of peers exported with per-peer quality metrics")
+	flags.StringVar(&config.DSCP, "dscp", "", "DiffServ class marked on outgoing WebRTC packets, e.g. EF (requires -udp-mux-port)")
+	flags.IntVar(&config.UDPMuxPort, "udp-mux-port", 0, "serve all ICE traffic from this single UDP port (0 = ephemeral ports per connection)")
+	flags.StringVar(&config.RecordDir, "record-dir", "", "directory to record each source's relayed audio into (empty = disabled)")
+	flags.StringVar(&config.RecordFormat, "record-format", recordFormatOgg, "recording format: ogg, rtpdump, or wav decoding Opus to 48kHz mono PCM; ogg and wav skip non-Opus sources")
+	flags.BoolVar(&config.RecordOnJoin, "record-on-join", true, "record rooms from creation when -record-dir is set (otherwise start via POST /rooms/<id>/record)")
+	flags.BoolVar(&config.AccessLog, "access-log", true, "log every HTTP request")
+	flags.StringVar(&config.CNAME, "cname", "tts-client", "CNAME of the relay tracks in SDP and RTCP")
+	flags.DurationVar(&config.NegotiationTimeout, "negotiation-timeout", 15*time.Second, "overall time budget for a WHIP negotiation (0 = unlimited)")
+	flags.StringVar(&config.Topology, "topology", topologyPairs, "default room topology: pairs, mesh or broadcast")
+	flags.BoolVar(&config.RED, "red", false, "relay Opus with RED redundancy to peers that offer it (about twice the audio bitrate)")
+	flags.Var(&config.CodecPreference, "codec-preference", "audio codecs in the order answers prefer them, comma-separated or repeated (default opus,G722,PCMU,PCMA)")
+	flags.DurationVar(&config.IdempotencyKeyTTL, "idempotency-key-ttl", 5*time.Minute, "how long a WHIP POST Idempotency-Key replays its session (0 = disabled)")
+	flags.Uint64Var(&config.MaxEgressBitrate, "max-egress-bitrate", 0, "cap on relayed audio sent to each peer in bits per second, excess packets are dropped (0 = unlimited)")
+	flags.StringVar(&config.AdminToken, "admin-token", "", "bearer token for the admin API (empty = admin API disabled)")
+	flags.DurationVar(&config.DisconnectGrace, "disconnect-grace", 10*time.Second, "time a disconnected peer may take to recover before removal (0 = wait for ICE failure)")
+	flags.DurationVar(&config.MaxPeerLifetime, "max-peer-lifetime", 0, "close peers this long after they joined, regardless of activity, e.g. 4h (0 = unlimited)")
+	flags.StringVar(&config.DTLSCertificate, "dtls-certificate", "", "PEM file with a persistent DTLS certificate and key, generated if missing, for a stable fingerprint")
+	flags.StringVar(&config.DTLSSetup, "dtls-setup", "", "DTLS setup attribute of the answer: active or passive (default active)")
+	flags.Var(&config.AuthTokens, "auth-token", "bearer token required for WHIP POSTs, comma-separated or repeated (empty = no authentication)")
+	flags.StringVar(&config.JWTSecret, "jwt-secret", "", "HS256 secret validating bearer JWTs on WHIP POSTs")
+	flags.StringVar(&config.JWTJWKSURL, "jwt-jwks-url", "", "JWKS URL of the keys validating RS256/ES256 bearer JWTs on WHIP POSTs")
+	flags.StringVar(&config.JWTIssuer, "jwt-issuer", "", "required iss claim of bearer JWTs")
+	flags.StringVar(&config.JWTAudience, "jwt-audience", "", "required aud claim of bearer JWTs")
+	flags.BoolVar(&config.Transcode, "transcode", false, "transcode Opus to PCMU/PCMA for G.711-only peers in new rooms (CPU-heavy)")
+	flags.BoolVar(&config.RelayReceiverReports, "relay-receiver-reports", true, "relay the worst loss and jitter subscribers report back to publishers")
+	flags.BoolVar(&config.MinimalInterceptors, "minimal-interceptors", false, "start without the RTP interceptors (NACK, reports, TWCC, ...) that fail to register instead of exiting")
+	flags.BoolVar(&config.RelayBye, "relay-bye", false, "end a publisher's relay when it sends an RTCP BYE and send BYE to its subscribers")
+	flags.StringVar(&config.EmptyPayloads, "empty-payloads", emptyPayloadsAuto, "relay or drop packets without payload, or auto to relay them only from sources that negotiated Opus DTX (relay, drop or auto)")
+	flags.IntVar(&config.SilenceFill, "silence-fill", 0, "fill gaps of up to this many lost packets in relayed audio with silence (0 = disabled)")
+	flags.BoolVar(&config.FECAdaptation, "fec-adaptation", false, "log when publishers should raise or relax Opus FEC for the loss subscribers report (decisions are only logged for now)")
+	flags.Float64Var(&config.FECLossHigh, "fec-loss-high", 10, "subscriber loss percentage at which -fec-adaptation raises FEC")
+	flags.Float64Var(&config.FECLossLow, "fec-loss-low", 2, "subscriber loss percentage at which -fec-adaptation relaxes FEC again")
+	flags.Float64Var(&config.SimulateLoss, "simulate-loss", 0, "percentage of relayed packets to drop at random, for testing (requires -unsafe)")
+	flags.BoolVar(&config.Unsafe, "unsafe", false, "allow testing settings that degrade service, never use in production")
+	configFile = flags.String("config", "", "JSON or YAML file of settings keyed by flag name; flags override it")
+	showVersion = flags.Bool("version", false, "same as the version command")
+	selfTest = flags.Bool("selftest", false, "same as the selftest command")
+	return configFile, showVersion, selfTest
+}
+
+// configure checks config once the flags are parsed, panicking on invalid
+// settings, and sets up the WebRTC API and the other state the handlers
+// share. Unless serving, as for the validate command, it only checks the
+// settings: it binds no UDP socket, creates no recording directory or DTLS
+// certificate and starts no refresh of TURN credentials or ICE servers.
+func configure(serving bool) {
+	if config.InactivityTimeout < 0 {
+		panic("inactivity-timeout must not be negative")
+	}
+	if config.RetryAfter < 0 {
+		panic("retry-after must not be negative")
+	}
+	if config.StartupBufferSize < 0 {
+		panic("startup-buffer must not be negative")
+	}
+	if config.RelayQueueSize < 1 {
+		panic("relay-queue-size must be at least 1")
+	}
+
+	if config.QualityInterval <= 0 {
+		panic("quality-interval must be positive")
+	}
+
+	if config.SimulateLoss < 0 || config.SimulateLoss > 100 {
+		panic("simulate-loss must be between 0 and 100")
+	}
+	if config.SimulateLoss > 0 {
+		if !config.Unsafe {
+			panic("simulate-loss degrades every relay and requires -unsafe")
+		}
+		fmt.Printf("WARNING: dropping %g%% of relayed packets (-simulate-loss)\n", config.SimulateLoss)
+	}
+
+	if config.LogSDP {
+		fmt.Println("WARNING: logging every SDP offer and answer in full (-log-sdp); logs grow quickly and hold client addresses")
+	}
+
+	if err := validateFECAdaptation(); err != nil {
+		panic(err)
+	}
+
+	if err := validateTopology(config.Topology); err != nil {
+		panic(err)
+	}
+
+	if err := validateEmptyPayloads(config.EmptyPayloads); err != nil {
+		panic(err)
+	}
+
+	if err := validateAnswerBitrate(config.AnswerBitrate); err != nil {
+		panic(err)
+	}
+
+	if config.RecordDir != "" {
+		if err := validateRecordFormat(config.RecordFormat); err != nil {
+			panic(err)
+		}
+		if serving {
+			if err := os.MkdirAll(config.RecordDir, 0o755); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	if config.BasePath != "" && !strings.HasPrefix(config.BasePath, "/") {
+		panic(fmt.Sprintf("base path %q must start with /", config.BasePath))
+	}
+	config.BasePath = strings.TrimSuffix(config.BasePath, "/")
+
+	if len(config.WHIPPaths) == 0 {
+		config.WHIPPaths = stringList{"/whip"}
+	}
+	if len(config.CORSOrigins) == 0 {
+		config.CORSOrigins = stringList{"*"}
+	}
+	for i, method := range config.CORSMethods {
+		config.CORSMethods[i] = strings.ToUpper(method)
+	}
+	if len(config.WHEPPaths) == 0 {
+		config.WHEPPaths = stringList{"/whep"}
+	}
+	if len(config.CodecPreference) == 0 {
+		config.CodecPreference = defaultCodecPreference
+	}
+	if err := checkCodecPreference(); err != nil {
+		panic(err)
+	}
+	mountPaths(config.WHIPPaths, "WHIP")
+	mountPaths(config.WHEPPaths, "WHEP")
+	for _, whepPath := range config.WHEPPaths {
+		if slices.Contains(config.WHIPPaths, whepPath) {
+			panic(fmt.Sprintf("path %q can't be both a WHIP and a WHEP path", whepPath))
+		}
+	}
+
+	var err error
+	if authenticator, err = newAuthenticator(); err != nil {
 		panic(err)
 	}
 
+	if config.MaxNegotiations > 0 {
+		negotiationSlots = make(chan struct{}, config.MaxNegotiations)
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, codec := range audioCodecs {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			panic(err)
+		}
+	}
+	if config.RED {
+		if err := mediaEngine.RegisterCodec(redCodec, webrtc.RTPCodecTypeAudio); err != nil {
+			panic(err)
+		}
+	}
+	for _, uri := range relayedHeaderExtensions {
+		if err := mediaEngine.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{URI: uri}, webrtc.RTPCodecTypeAudio,
+		); err != nil {
+			panic(err)
+		}
+	}
+
 	settingEngine := webrtc.SettingEngine{}
 
 	settingEngine.SetReceiveMTU(8192)
 
 	settingEngine.SetSRTPReplayProtectionWindow(1024)
 
+	if err := configureICEFilters(&settingEngine); err != nil {
+		panic(err)
+	}
+
+	var turnCredentialFile []byte
+	if config.TURNCredentialFile != "" {
+		var err error
+		if turnCredentialFile, err = loadTURNCredentials(); err != nil {
+			panic(err)
+		}
+	}
+	if err := configureICEServers(&peerConnectionConfiguration); err != nil {
+		panic(err)
+	}
+	if serving && config.TURNCredentialFile != "" {
+		go watchTURNCredentials(turnCredentialFile)
+	}
+	if serving && config.ICESRVDomain != "" && config.ICESRVRefresh > 0 {
+		go refreshICEServers()
+	}
+
+	if err := configureTransportPolicies(&peerConnectionConfiguration); err != nil {
+		panic(err)
+	}
+
+	if err := validateICECredentialLengths(); err != nil {
+		panic(err)
+	}
+
+	if err := configureICETimeouts(&settingEngine); err != nil {
+		panic(err)
+	}
+
+	if serving {
+		if err := configureUDPMux(&settingEngine); err != nil {
+			panic(err)
+		}
+	} else if _, err := checkUDPMux(); err != nil {
+		panic(err)
+	}
+
+	if err := configureDTLSSetup(&settingEngine); err != nil {
+		panic(err)
+	}
+
+	if serving {
+		if err := configureDTLSCertificate(&peerConnectionConfiguration); err != nil {
+			panic(err)
+		}
+	}
+
+	interceptorRegistry := &interceptor.Registry{}
+	if err := registerInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal: %s; -minimal-interceptors skips interceptors that fail\n", err.Error())
+		os.Exit(1)
+	}
+
+	webrtcMediaEngine, webrtcInterceptors, webrtcSettingEngine = mediaEngine, interceptorRegistry, settingEngine
 	webrtcAPI = webrtc.NewAPI(
 		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
 		webrtc.WithSettingEngine(settingEngine),
 	)
+}
 
-	http.HandleFunc("/whip", whipHandler)
+// registerRoutes registers the server's handlers on mux.
+func registerRoutes(mux *http.ServeMux) {
+	for _, whipPath := range config.WHIPPaths {
+		mux.HandleFunc(whipPath, whipHandler)
+		mux.HandleFunc(whipPath+"/", resourceHandler)
+		mux.HandleFunc(whipPath+"/validate", validateHandler)
+		mux.HandleFunc(whipPath+"/room/", whipHandler)
+		fmt.Printf("WHIP endpoint: %s\n", whipPath)
+	}
+	for _, whepPath := range config.WHEPPaths {
+		mux.HandleFunc(whepPath, whipHandler)
+		mux.HandleFunc(whepPath+"/", resourceHandler)
+		mux.HandleFunc(whepPath+"/validate", validateHandler)
+		mux.HandleFunc(whepPath+"/room/", whipHandler)
+		fmt.Printf("WHEP endpoint: %s\n", whepPath)
+	}
+	mux.HandleFunc(config.BasePath+"/version", versionHandler)
+	mux.HandleFunc(config.BasePath+"/stats", statsHandler)
+	mux.HandleFunc(config.BasePath+"/stats/prometheus", prometheusHandler)
+	mux.HandleFunc(config.BasePath+"/metrics", prometheusHandler)
+	mux.HandleFunc(config.BasePath+"/admin/stats/reset", statsResetHandler)
+	mux.HandleFunc(config.BasePath+"/admin/streams", streamsHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/record", roomRecordHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/events", roomEventsHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/info", roomInfoHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/drain", roomDrainHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/peers/{peerID}", roomPeerHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/peers/{peerID}/pli", roomPeerPLIHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/speaker", roomSpeakerHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/sinks", roomSinksHandler)
+	mux.HandleFunc(config.BasePath+"/rooms/{id}/sinks/{sinkID}", roomSinkHandler)
+}
 
-	fmt.Println("Server started on :8080")
-	panic(http.ListenAndServe(":8080", nil))
+// mountPaths checks the endpoint paths of a -whip-path or -whep-path flag and
+// prefixes them with the base path.
+func mountPaths(paths stringList, kind string) {
+	for i, endpointPath := range paths {
+		if !strings.HasPrefix(endpointPath, "/") {
+			panic(fmt.Sprintf("%s path %q must start with /", kind, endpointPath))
+		}
+		paths[i] = config.BasePath + strings.TrimSuffix(endpointPath, "/")
+	}
 }
 
 func whipHandler(res http.ResponseWriter, req *http.Request) {
-	res.Header().Add("Access-Control-Allow-Origin", "*")
-	res.Header().Add("Access-Control-Allow-Methods", "POST")
-	res.Header().Add("Access-Control-Allow-Headers", "*")
-	res.Header().Add("Access-Control-Allow-Headers", "Authorization")
+	addCORSHeaders(res, req, http.MethodPost)
 
 	if req.Method == http.MethodOptions {
 		return
 	}
 
-	roomID := req.URL.Query().Get("room")
-	if roomID == "" {
-		http.Error(res, "room parameter is required", http.StatusBadRequest)
+	if err := checkQueryParameters(req.URL.Query()); err != nil {
+		writeError(res, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !acquireNegotiationSlot() {
+		writeOverloaded(res, req, errTooManyNegotiations)
+		return
+	}
+	defer releaseNegotiationSlot()
+
+	basePath := whipBasePath(req.URL.Path)
+	roomID, err := roomIDFromRequest(req, basePath)
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roomIDs, err := splitRoomIDs(roomID)
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	fmt.Printf("Client connecting to room: %s\n", roomID)
 
-	offer, err := io.ReadAll(req.Body)
+	// A valid resumption token marks a reconnect to a room the client already
+	// joined, letting it skip full authentication.
+	var identity Identity
+	if token := req.Header.Get(resumptionTokenHeader); token != "" {
+		var valid bool
+		if identity, valid = resumptionTokens.redeem(token, roomID); !valid {
+			writeError(res, req, "invalid or expired resumption token", http.StatusUnauthorized)
+			return
+		}
+		fmt.Printf("Client resumed session in room: %s\n", roomID)
+	} else {
+		// Joining several rooms needs credentials valid for each.
+		for _, id := range roomIDs {
+			var ok bool
+			if identity, ok = authenticate(res, req, id); !ok {
+				return
+			}
+		}
+		if identity.Subject != "" {
+			fmt.Printf("Authenticated %s for room: %s\n", identity.Subject, roomID)
+		}
+	}
+
+	// A retried POST with the key of a live session gets that session back
+	// rather than a second peer. Only once authenticated, as the replay hands
+	// out the session's resource and resumption token, and only to the
+	// identity that created the session. The claim is released unless
+	// negotiation succeeds.
+	idempotencyKey := req.Header.Get(idempotencyKeyHeader)
+	if config.IdempotencyKeyTTL <= 0 {
+		idempotencyKey = ""
+	}
+	var completedSession *idempotentSession
+	if idempotencyKey != "" {
+		existing, claimErr := idempotencyKeys.claim(idempotencyKey, roomID, identity.Subject, config.IdempotencyKeyTTL)
+		switch {
+		case errors.Is(claimErr, errIdempotencyKeyInFlight):
+			writeError(res, req, claimErr.Error(), http.StatusConflict)
+			return
+		case claimErr != nil:
+			writeError(res, req, claimErr.Error(), http.StatusUnprocessableEntity)
+			return
+		case existing != nil:
+			// The stored token is single-use and may be redeemed already,
+			// so the replay hands out a fresh one in its place.
+			if existing.ResumptionToken != "" {
+				resumptionTokens.revoke(existing.ResumptionToken)
+				existing.ResumptionToken = resumptionTokens.issue(roomID, identity, config.ResumptionTokenTTL)
+				idempotencyKeys.replaceResumptionToken(idempotencyKey, existing.ResumptionToken)
+			}
+			writeIdempotentReplay(res, existing)
+			return
+		}
+
+		defer func() {
+			if completedSession == nil {
+				idempotencyKeys.release(idempotencyKey)
+				return
+			}
+			idempotencyKeys.complete(idempotencyKey, *completedSession)
+		}()
+	}
+
+	ctx := req.Context()
+	if config.NegotiationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.NegotiationTimeout)
+		defer cancel()
+
+		deadline, _ := ctx.Deadline()
+		_ = http.NewResponseController(res).SetReadDeadline(deadline)
+	}
+
+	readStarted := time.Now()
+	offer, err := readOffer(req)
 	if err != nil {
-		panic(err)
+		if ctx.Err() != nil {
+			writeError(res, req, "negotiation timed out", http.StatusGatewayTimeout)
+			return
+		}
+		fmt.Printf("Error reading offer: %s\n", err.Error())
+		writeError(res, req, err.Error(), offerErrorStatus(err))
+		return
+	}
+	recordNegotiationPhase(req, phaseBodyRead, readStarted)
+
+	parsedOffer := &sdp.SessionDescription{}
+	if err = parsedOffer.Unmarshal(offer); err != nil {
+		writeError(res, req, "invalid SDP offer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A video-only offer would get an answer rejecting every section,
+	// without ICE credentials to connect with.
+	if !offerHasMedia(parsedOffer, "audio") && !offerHasMedia(parsedOffer, "application") {
+		writeError(res, req, "offer has no audio or data channel section to answer", http.StatusUnprocessableEntity)
+		return
+	}
+	if err = checkTransportPolicies(parsedOffer); err != nil {
+		writeError(res, req, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if hoistExtmapAllowMixed(parsedOffer) {
+		if offer, err = parsedOffer.Marshal(); err != nil {
+			writeError(res, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err = checkDirection(parsedOffer, isWHEPPath(basePath)); err != nil {
+		writeError(res, req, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err = identity.authorizeOffer(parsedOffer); err != nil {
+		fmt.Printf("Rejected client for room %s: %s\n", roomID, err.Error())
+		writeError(res, req, err.Error(), http.StatusForbidden)
+		return
 	}
 
-	peerConnection, err := webrtcAPI.NewPeerConnection(peerConnectionConfiguration)
+	options, err := parseRoomOptions(req.URL.Query())
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		writeError(res, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	audioTrack, err := webrtc.NewTrackLocalStaticRTP(
-		webrtc.RTPCodecCapability{
-			MimeType: webrtc.MimeTypeOpus,
-		},
-		"audio",
-		"tts-client",
-	)
+	rooms, err := roomManager.getOrCreateRooms(roomIDs, options, identity.Subject)
+	if errors.Is(err, errUserRoomQuota) {
+		fmt.Printf("Rejected %s for room %s: %s\n", identity.Subject, roomID, err.Error())
+		writeError(res, req, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		writeOverloaded(res, req, err)
+		return
+	}
+	room := rooms[0]
+
+	if err = checkRoomCodecs(parsedOffer, rooms); err != nil {
+		roomManager.removeRoomsIfEmpty(rooms)
+		writeError(res, req, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	peerConnection, err := newPeerConnection()
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		roomManager.removeRoomsIfEmpty(rooms)
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = peerConnection.AddTrack(audioTrack)
+	// Offers negotiating only a data channel get no audio track.
+	var audioTrack *webrtc.TrackLocalStaticRTP
+	var audioSender *webrtc.RTPSender
+	relayTracks, relaySenders, err := addRelayTracks(peerConnection, parsedOffer, allowedCodecs(rooms))
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		_ = peerConnection.Close()
+		roomManager.removeRoomsIfEmpty(rooms)
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if len(relayTracks) > 0 {
+		audioTrack, audioSender = relayTracks[0], relaySenders[0]
+	}
 
 	peer := &Peer{
+		ID:             newPeerID(),
 		PeerConnection: peerConnection,
+		rooms:          rooms,
 		AudioTrack:     audioTrack,
+		AudioSender:    audioSender,
+		paused:         make(map[string]bool),
+		dataChannels:   make(map[string]*webrtc.DataChannel),
+		outbound:       make(chan outboundPacket, config.RelayQueueSize),
+		streams:        make(map[relayStreamKey]*relayStream),
+		done:           make(chan struct{}),
+		connected:      make(chan struct{}),
+	}
+	for i := 1; i < len(relayTracks); i++ {
+		peer.lanes = append(peer.lanes, peer.newLane(relayTracks[i], relaySenders[i]))
 	}
 
-	room := roomManager.getOrCreateRoom(roomID)
-	otherPeer := room.addPeer(peer)
-
-	if otherPeer != nil {
-		connectPeers(peer, otherPeer)
-		connectPeers(otherPeer, peer)
+	if err = peer.joinRooms(); err != nil {
+		_ = peerConnection.Close()
+		writeOverloaded(res, req, err)
+		return
 	}
+	peer.startLifetimeTimer()
+	peer.goLoop(peer.writeLoop)
+	if audioSender != nil {
+		peer.goLoop(func() { sendRTCPReports(peer) })
+		peer.goLoop(func() { readReceiverReports(peer) })
+	}
+	for _, lane := range peer.lanes {
+		peer.goLoop(lane.writeLoop)
+		peer.goLoop(func() { sendRTCPReports(lane) })
+		peer.goLoop(func() { readReceiverReports(lane) })
+	}
+	// Joining may have replaced a room removed since the lookup.
+	room = peer.rooms[0]
+	connectPeers(room, peer)
+	connectDataChannels(room, peer)
 
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		fmt.Printf("Connection state: %s (Room: %s)\n", state.String(), roomID)
 
-		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
-			room.removePeer(peer)
+		switch state {
+		case webrtc.PeerConnectionStateDisconnected:
+			peer.startDisconnectTimer()
+		case webrtc.PeerConnectionStateConnected:
+			peer.markConnected()
+			peer.stopDisconnectTimer()
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			peer.stopDisconnectTimer()
+			teardownPeer(peer)
 		}
 	})
 
-	writeAnswer(res, peerConnection, offer, "/whip")
-}
-
-func (rm *RoomManager) getOrCreateRoom(roomID string) *Room {
-	rm.mutex.Lock()
-	defer rm.mutex.Unlock()
+	var token string
+	if config.ResumptionTokenTTL > 0 {
+		token = resumptionTokens.issue(roomID, identity, config.ResumptionTokenTTL)
+		res.Header().Set(resumptionTokenHeader, token)
+	}
 
-	room, exists := rm.rooms[roomID]
-	if !exists {
-		room = &Room{
-			ID: roomID,
+	location := basePath + "/" + peer.ID
+	if err = writeAnswer(ctx, res, req, peer, offer, location); err != nil {
+		// The peer is already wired into the room; don't leave it there
+		// half-connected.
+		teardownPeer(peer)
+		if token != "" {
+			resumptionTokens.revoke(token)
 		}
-		rm.rooms[roomID] = room
-		fmt.Printf("Created room: %s\n", roomID)
+		return
+	}
+
+	completedSession = &idempotentSession{
+		RoomID:          roomID,
+		Subject:         identity.Subject,
+		PeerID:          peer.ID,
+		Location:        location,
+		ResumptionToken: token,
+		Answer:          localAnswer(peer),
 	}
-	return room
 }
 
-func (r *Room) addPeer(peer *Peer) *Peer {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// setLocalDescription applies the answer of writeAnswer. Tests replace it to
+// fail or stall negotiations after the peer joined its rooms.
+var setLocalDescription = (*webrtc.PeerConnection).SetLocalDescription
 
-	if r.PeerA == nil {
-		r.PeerA = peer
-		return nil
-	} else if r.PeerB == nil {
-		r.PeerB = peer
-		return r.PeerA
+// whipBasePath returns the configured WHIP or WHEP path the request was routed
+// through, the longest one prefixing requestPath.
+func whipBasePath(requestPath string) string {
+	basePath := ""
+	for _, whipPath := range slices.Concat(config.WHIPPaths, config.WHEPPaths) {
+		if (requestPath == whipPath || strings.HasPrefix(requestPath, whipPath+"/")) && len(whipPath) > len(basePath) {
+			basePath = whipPath
+		}
 	}
-
-	return nil
+	return basePath
 }
 
-func (r *Room) removePeer(peer *Peer) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+// roomIDFromRequest reads the room from either the room query parameter or a
+// "<base>/room/<id>" path.
+func roomIDFromRequest(req *http.Request, basePath string) (string, error) {
+	queryRoom := req.URL.Query().Get("room")
+	pathRoom := ""
+	if rest, ok := strings.CutPrefix(req.URL.Path, basePath+"/room/"); ok {
+		pathRoom = strings.TrimSuffix(rest, "/")
+		if strings.Contains(pathRoom, "/") {
+			return "", fmt.Errorf("invalid room path %q", req.URL.Path)
+		}
+	}
 
-	if r.PeerA == peer {
-		r.PeerA = nil
-		fmt.Printf("Peer left room %s\n", r.ID)
-	} else if r.PeerB == peer {
-		r.PeerB = nil
-		fmt.Printf("Peer left room %s\n", r.ID)
+	switch {
+	case queryRoom != "" && pathRoom != "" && queryRoom != pathRoom:
+		return "", fmt.Errorf("room %q in path conflicts with room %q in query", pathRoom, queryRoom)
+	case pathRoom != "":
+		return pathRoom, nil
+	case queryRoom != "":
+		return queryRoom, nil
+	default:
+		return "", errors.New("room is required, as ?room=<id> or in the path as /room/<id>")
 	}
 }
 
-func connectPeers(source *Peer, destination *Peer) {
-	source.PeerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		for {
-			pkt, _, err := track.ReadRTP()
-			if err != nil {
-				break
-			}
-
-			if err = destination.AudioTrack.WriteRTP(pkt); err != nil {
-				break
+// teardownPeer removes the peer from its rooms and releases its connection and
+// relay. A WHIP DELETE, the connection failing, the admin API and the
+// peer's timers may all tear the peer down at once; the first call does the
+// work and later ones wait for it to finish, so none returns while the peer
+// is still in a room.
+func teardownPeer(peer *Peer) {
+	peer.teardownOnce.Do(func() {
+		destinations := peer.destinations()
+		for _, room := range peer.rooms {
+			room.removePeer(peer)
+			roomManager.removeRoomIfEmpty(room)
+		}
+		peer.close()
+		_ = peer.PeerConnection.Close()
+		for _, destination := range destinations {
+			destination.releaseLanes(peer)
+			destination.forgetStreams(peer)
+			for _, lane := range destination.lanes {
+				lane.forgetStreams(peer)
 			}
 		}
 	})
 }
 
-func writeAnswer(res http.ResponseWriter, peerConnection *webrtc.PeerConnection, offer []byte, path string) {
+// startDisconnectTimer schedules the peer's teardown after
+// config.DisconnectGrace, so a blip that recovers keeps the session while a
+// connection that stays down frees its slot.
+func (p *Peer) startDisconnectTimer() {
+	if config.DisconnectGrace <= 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.disconnectTimer != nil {
+		return
+	}
+	p.disconnectTimer = time.AfterFunc(config.DisconnectGrace, func() {
+		fmt.Printf("Peer %s did not reconnect within %s, removing\n", p.ID, config.DisconnectGrace)
+		teardownPeer(p)
+	})
+}
+
+// stopDisconnectTimer cancels a pending disconnect teardown.
+func (p *Peer) stopDisconnectTimer() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.disconnectTimer != nil {
+		p.disconnectTimer.Stop()
+		p.disconnectTimer = nil
+	}
+}
+
+func addAudioTrack(peerConnection *webrtc.PeerConnection, codec webrtc.RTPCodecCapability, id string) (*webrtc.TrackLocalStaticRTP, *webrtc.RTPSender, error) {
+	audioTrack, err := webrtc.NewTrackLocalStaticRTP(
+		codec,
+		id,
+		config.CNAME,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	audioSender, err := peerConnection.AddTrack(audioTrack)
+	if err != nil {
+		return nil, nil, err
+	}
+	return audioTrack, audioSender, nil
+}
+
+// offerHasMedia reports whether the offer has a media section of mediaType,
+// e.g. "audio" or "application".
+func offerHasMedia(offer *sdp.SessionDescription, mediaType string) bool {
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Media == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func acquireNegotiationSlot() bool {
+	if negotiationSlots == nil {
+		return true
+	}
+
+	select {
+	case negotiationSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func releaseNegotiationSlot() {
+	if negotiationSlots != nil {
+		<-negotiationSlots
+	}
+}
+
+// forwardingRequest is the JSON body of a PATCH on a WHIP resource. It pauses
+// or resumes forwarding of Source to the resource's peer; an empty Source
+// applies to every source in the room.
+type forwardingRequest struct {
+	Source string `json:"source"`
+	Paused bool   `json:"paused"`
+}
+
+func resourceHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPatch, http.MethodDelete)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+
+	peerID := path.Base(req.URL.Path)
+	peer := roomManager.findPeer(peerID)
+	if peer == nil {
+		writeError(res, req, "resource not found", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPatch:
+		if mediaType(req) == sdpFragContentType {
+			handleICEFragment(res, req, peer)
+			return
+		}
+
+		var forwarding forwardingRequest
+		if err := json.NewDecoder(req.Body).Decode(&forwarding); err != nil {
+			writeError(res, req, "invalid forwarding request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		peer.setPaused(forwarding.Source, forwarding.Paused)
+		fmt.Printf("Peer %s paused=%t for source %q\n", peer.ID, forwarding.Paused, forwarding.Source)
+		res.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		// WHIP clients end their session by deleting its resource.
+		fmt.Printf("Peer %s ended its session\n", peer.ID)
+		teardownPeer(peer)
+		res.WriteHeader(http.StatusOK)
+	default:
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// mediaType returns the request's Content-Type without parameters.
+func mediaType(req *http.Request) string {
+	contentType, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+func newPeerID() string {
+	return randomHex(8)
+}
+
+// randomHex returns n random bytes hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseRoomOptions reads the room settings from a join's query parameters,
+// falling back to the server defaults.
+func parseRoomOptions(query url.Values) (RoomOptions, error) {
+	options := RoomOptions{
+		REMBBitrate:   config.REMBBitrate,
+		AnswerBitrate: config.AnswerBitrate,
+		Topology:      config.Topology,
+		Transcode:     config.Transcode,
+	}
+
+	topology := query.Get("topology")
+	if topology == "" {
+		topology = query.Get("mode")
+	}
+	if topology != "" {
+		if err := validateTopology(topology); err != nil {
+			return options, err
+		}
+		options.Topology = topology
+	}
+
+	if remb := query.Get("remb"); remb != "" {
+		bitrate, err := strconv.ParseUint(remb, 10, 64)
+		if err != nil {
+			return options, fmt.Errorf("invalid remb parameter: %w", err)
+		}
+		options.REMBBitrate = bitrate
+	}
+
+	if bandwidth := query.Get("bandwidth"); bandwidth != "" {
+		kbps, err := strconv.ParseUint(bandwidth, 10, 64)
+		if err == nil {
+			err = validateAnswerBitrate(kbps)
+		}
+		if err != nil {
+			return options, fmt.Errorf("invalid bandwidth parameter: %w", err)
+		}
+		options.AnswerBitrate = kbps
+	}
+
+	if transcode := query.Get("transcode"); transcode != "" {
+		enabled, err := strconv.ParseBool(transcode)
+		if err != nil {
+			return options, fmt.Errorf("invalid transcode parameter: %w", err)
+		}
+		options.Transcode = enabled
+	}
+
+	switch duplex := query.Get("duplex"); duplex {
+	case "", duplexFull:
+	case duplexHalf:
+		options.HalfDuplex = true
+	default:
+		return options, fmt.Errorf("invalid duplex parameter %q: want full or half", duplex)
+	}
+
+	if codecs := query.Get("codecs"); codecs != "" {
+		var err error
+		if options.Codecs, err = parseRoomCodecs(codecs); err != nil {
+			return options, err
+		}
+	}
+
+	if ssrcs := query.Get("ssrc"); ssrcs != "" {
+		for _, value := range strings.Split(ssrcs, ",") {
+			ssrc, err := strconv.ParseUint(strings.TrimSpace(value), 0, 32)
+			if err != nil {
+				return options, fmt.Errorf("invalid ssrc parameter: %w", err)
+			}
+			options.AllowedSSRCs = append(options.AllowedSSRCs, uint32(ssrc))
+		}
+	}
+	return options, nil
+}
+
+// getOrCreateRoom returns the room with the given ID, creating it for
+// creator with options unless that would exceed config.MaxRooms or
+// config.MaxRoomsPerUser, or another instance hosts it, see RoomRegistry.
+// Options are ignored for rooms that already exist.
+func (rm *RoomManager) getOrCreateRoom(roomID string, options RoomOptions, creator string) (*Room, error) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	room, exists := rm.rooms[roomID]
+	if !exists {
+		if config.MaxRooms > 0 && len(rm.rooms) >= config.MaxRooms {
+			return nil, errMaxRooms
+		}
+		if creator != "" && config.MaxRoomsPerUser > 0 && rm.roomsCreatedBy(creator) >= config.MaxRoomsPerUser {
+			return nil, fmt.Errorf("%w (%d)", errUserRoomQuota, config.MaxRoomsPerUser)
+		}
+		host, err := roomRegistry.Claim(roomID)
+		if err != nil {
+			return nil, fmt.Errorf("claiming room %s: %w", roomID, err)
+		}
+		if host != "" {
+			return nil, fmt.Errorf("%w: %s", errRoomHostedElsewhere, host)
+		}
+
+		room = &Room{
+			ID:        roomID,
+			Options:   options,
+			Creator:   creator,
+			recording: config.RecordDir != "" && config.RecordOnJoin,
+		}
+		room.touch(time.Now())
+		rm.rooms[roomID] = room
+		fmt.Printf("Created room: %s\n", roomID)
+	}
+	return room, nil
+}
+
+// removeRoomIfEmpty deletes the room once its last peer has left so it no
+// longer counts toward config.MaxRooms.
+func (rm *RoomManager) removeRoomIfEmpty(room *Room) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if len(room.otherPeers(nil)) > 0 || rm.rooms[room.ID] != room {
+		return
+	}
+
+	delete(rm.rooms, room.ID)
+	room.closeSinks()
+	if err := roomRegistry.Release(room.ID); err != nil {
+		fmt.Printf("Error releasing room %s: %s\n", room.ID, err.Error())
+	}
+	fmt.Printf("Removed room: %s\n", room.ID)
+}
+
+// roomsCreatedBy counts the rooms creator created. The caller holds rm.mutex.
+func (rm *RoomManager) roomsCreatedBy(creator string) int {
+	count := 0
+	for _, room := range rm.rooms {
+		if room.Creator == creator {
+			count++
+		}
+	}
+	return count
+}
+
+func (rm *RoomManager) findRoom(roomID string) *Room {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	return rm.rooms[roomID]
+}
+
+func (rm *RoomManager) findPeer(peerID string) *Peer {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	for _, room := range rm.rooms {
+		for _, peer := range room.otherPeers(nil) {
+			if peer.ID == peerID {
+				return peer
+			}
+		}
+	}
+	return nil
+}
+
+// addPeer admits the peer unless the room is draining or its topology is
+// full. It fails with errRoomRemoved if the room emptied and was removed
+// since the peer looked it up, see removeRoomIfEmpty.
+func (r *Room) addPeer(peer *Peer) error {
+	roomManager.mutex.RLock()
+	defer roomManager.mutex.RUnlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if roomManager.rooms[r.ID] != r {
+		return errRoomRemoved
+	}
+	if r.draining {
+		r.events.add(eventError, peer.ID, "rejected, room is draining")
+		return errRoomDraining
+	}
+	if r.Options.Topology == topologyPairs && len(r.Peers) >= 2 || r.Options.Topology == topologyMesh && !r.meshFits(peer) {
+		r.events.add(eventError, peer.ID, "rejected, room is full")
+		return errRoomFull
+	}
+
+	r.Peers = append(r.Peers, peer)
+	r.events.add(eventJoin, peer.ID, "")
+	if r.Options.Topology == topologyPairs && len(r.Peers) == 2 {
+		r.events.add(eventPair, peer.ID, "paired with "+r.Peers[0].ID)
+	}
+	if r.Options.Topology == topologyBroadcast && r.Publisher == nil {
+		r.Publisher = peer
+		r.events.add(eventPublisher, peer.ID, "")
+		fmt.Printf("Peer %s is publishing to room %s\n", peer.ID, r.ID)
+	}
+	return nil
+}
+
+// meshFits reports whether every peer of the mesh room would still have a
+// relay track for each other peer sending audio with the peer admitted, see
+// assignRelayTarget. Half-duplex rooms relay one speaker at a time, which
+// any peer has the relay track for. The caller holds r.mutex.
+func (r *Room) meshFits(peer *Peer) bool {
+	if r.Options.HalfDuplex {
+		return true
+	}
+	peers := append(slices.Clone(r.Peers), peer)
+	sources := 0
+	for _, member := range peers {
+		if member.AudioTrack != nil {
+			sources++
+		}
+	}
+	for _, member := range peers {
+		if member.AudioTrack != nil && member.relayTracks() < sources-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// removePeer removes the peer from the room; removing a peer that isn't a
+// member does nothing.
+func (r *Room) removePeer(peer *Peer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := slices.Index(r.Peers, peer)
+	if i < 0 {
+		return
+	}
+	r.Peers = slices.Delete(r.Peers, i, i+1)
+	r.events.add(eventLeave, peer.ID, "")
+	fmt.Printf("Peer left room %s\n", r.ID)
+
+	if r.Publisher == peer {
+		r.Publisher = nil
+	}
+	if r.Speaker == peer {
+		r.Speaker = nil
+	}
+	peer.closeRecorders()
+}
+
+// otherPeers returns the peers in the room other than exclude.
+func (r *Room) otherPeers(exclude *Peer) []*Peer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peers := make([]*Peer, 0, len(r.Peers))
+	for _, peer := range r.Peers {
+		if peer != exclude {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// destinations returns the peers that receive what source sends, following
+// the room's topology, nobody but in half-duplex rooms for its speaker.
+func (r *Room) destinations(source *Peer) []*Peer {
+	if !r.isSpeaker(source) {
+		return nil
+	}
+	if r.Options.Topology == topologyBroadcast {
+		r.mutex.Lock()
+		isPublisher := r.Publisher == source
+		r.mutex.Unlock()
+
+		if !isPublisher {
+			return nil
+		}
+	}
+	return r.otherPeers(source)
+}
+
+// allowsSSRC reports whether the room relays the stream with ssrc, see
+// RoomOptions.AllowedSSRCs.
+func (r *Room) allowsSSRC(ssrc uint32) bool {
+	return r.Options.AllowedSSRCs == nil || slices.Contains(r.Options.AllowedSSRCs, ssrc)
+}
+
+// allowsSSRC reports whether any of the peer's rooms relays its stream with
+// ssrc.
+func (p *Peer) allowsSSRC(ssrc uint32) bool {
+	return slices.ContainsFunc(p.rooms, func(room *Room) bool { return room.allowsSSRC(ssrc) })
+}
+
+// isSubscriber reports whether peer only listens: it is in a broadcast room
+// and not its publisher. Subscribers stay subscribers when the publisher
+// leaves.
+func (r *Room) isSubscriber(peer *Peer) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.Options.Topology == topologyBroadcast && r.Publisher != peer
+}
+
+func (p *Peer) setPaused(sourceID string, paused bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if paused {
+		p.paused[sourceID] = true
+	} else if sourceID == "" {
+		clear(p.paused)
+	} else {
+		delete(p.paused, sourceID)
+	}
+}
+
+func (p *Peer) isPaused(sourceID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.paused[""] || p.paused[sourceID]
+}
+
+// maxReadErrors is how many consecutive ReadRTP errors a relay survives,
+// e.g. malformed packets or interceptor hiccups, before giving up on the
+// track. Errors meaning the track has ended stop it right away.
+const maxReadErrors = 10
+
+// trackEnded reports whether a ReadRTP error means the track is gone rather
+// than a single bad read.
+func trackEnded(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe)
+}
+
+// rtpReader is the part of a *webrtc.TrackRemote the relay reads from.
+type rtpReader interface {
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+}
+
+// readRelayedRTP returns the next packet of the source's track, retrying
+// up to maxReadErrors consecutive failed reads. It returns the error that
+// ended the track, or the last of too many failed reads.
+func readRelayedRTP(track rtpReader, source *Peer) (*rtp.Packet, error) {
+	for readErrors := 1; ; readErrors++ {
+		pkt, _, err := track.ReadRTP()
+		if err == nil || trackEnded(err) || readErrors >= maxReadErrors {
+			return pkt, err
+		}
+		fmt.Printf("Error reading from peer %s: %s\n", source.ID, err.Error())
+	}
+}
+
+// connectPeers fans the source's incoming audio out to whichever peers share
+// its rooms at the time each packet arrives. room is the source's first room.
+func connectPeers(room *Room, source *Peer) {
+	source.PeerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if !source.startLoop() {
+			return
+		}
+		defer peerLoops.Done()
+
+		if track.Kind() != webrtc.RTPCodecTypeAudio {
+			fmt.Printf("Ignoring %s track from peer %s in audio-only mode\n", track.Kind().String(), source.ID)
+			drainTrack(track)
+			return
+		}
+		if source.listensOnly() {
+			fmt.Printf("Ignoring audio from peer %s, a subscriber of broadcast room %s\n", source.ID, room.ID)
+			drainTrack(track)
+			return
+		}
+
+		relays := relayStats.ActiveRelays.Add(1)
+		fmt.Printf("Relay of peer %s started, %d relays and %d goroutines running\n", source.ID, relays, runtime.NumGoroutine())
+		defer func() {
+			relays := relayStats.ActiveRelays.Add(-1)
+			fmt.Printf("Relay of peer %s stopped, %d relays and %d goroutines running\n", source.ID, relays, runtime.NumGoroutine())
+		}()
+
+		clockRate := trackClockRate(track)
+		if negotiated := track.Codec().ClockRate; negotiated != clockRate {
+			fmt.Printf("Peer %s negotiated %s at %d Hz, timing it at its RTP clock rate of %d Hz\n", source.ID, track.Codec().MimeType, negotiated, clockRate)
+		}
+		source.goLoop(func() { relaySenderReports(source, receiver, clockRate) })
+
+		sourceParameters := receiver.GetParameters()
+		sourceExtensions := headerExtensionIDs(sourceParameters.HeaderExtensions)
+		adapter := newPayloadAdapter(source, sourceParameters.Codecs)
+
+		done := make(chan struct{})
+		defer close(done)
+		if source.rembBitrate() > 0 {
+			source.goLoop(func() { sendREMB(source, track, done) })
+		}
+		if config.RelayReceiverReports {
+			source.goLoop(func() { relayReceiverReports(source, track, done) })
+		}
+
+		recorders := startRecordings(source, track.Codec().MimeType)
+
+		source.startTrack(track, source.trackLane(receiver))
+		defer source.endTrack(track)
+
+		relayEmpty := relaysEmptyPayloads(track)
+		relayIdle := false
+		ignoredSSRCs := make(map[uint32]bool)
+		for {
+			pkt, err := readRelayedRTP(track, source)
+			if err != nil {
+				if !trackEnded(err) {
+					fmt.Printf("Error reading from peer %s, stopping its relay: %s\n", source.ID, err.Error())
+					room.logEvent(eventError, source.ID, "relay stopped: "+err.Error())
+				}
+				break
+			}
+			source.bytesReceived.Add(uint64(len(pkt.Payload)))
+
+			if len(pkt.Payload) == 0 && !relayEmpty {
+				relayStats.PacketsEmptyDropped.Add(1)
+				continue
+			}
+
+			if !source.allowsSSRC(pkt.SSRC) {
+				if !ignoredSSRCs[pkt.SSRC] {
+					ignoredSSRCs[pkt.SSRC] = true
+					fmt.Printf("Ignoring SSRC %d from peer %s, not allowed in room %s\n", pkt.SSRC, source.ID, source.roomList())
+				}
+				continue
+			}
+			// Received media counts as activity even with nobody to relay
+			// to, so a lone sender keeps its room open.
+			now := time.Now()
+			source.touchRooms(now)
+
+			for _, recorder := range recorders {
+				if !recorder.room.allowsSSRC(pkt.SSRC) {
+					continue
+				}
+				if err = recordRTP(recorder, pkt, adapter.codec(pkt) == mimeTypeRED); err != nil {
+					fmt.Printf("Error recording peer %s: %s\n", source.ID, err.Error())
+				}
+			}
+
+			// The connection stays up while nobody listens, e.g. after the
+			// other peer of a pair left, and relaying resumes with the
+			// next packet once someone joins.
+			destinations, sinks := source.relayDestinations(pkt.SSRC), source.relaySinks(pkt.SSRC)
+			if idle := len(destinations) == 0 && len(sinks) == 0; idle != relayIdle {
+				relayIdle = idle
+				if idle {
+					fmt.Printf("Peer %s has nobody to relay to in room %s, pausing its relay\n", source.ID, room.ID)
+				} else {
+					fmt.Printf("Resuming relay of peer %s in room %s\n", source.ID, room.ID)
+				}
+			}
+			if relayIdle {
+				continue
+			}
+			adapter.reset(pkt)
+
+			for _, relay := range destinations {
+				destination := relay.peer
+				if destination.AudioTrack == nil || destination.isPaused(source.ID) {
+					continue
+				}
+				if destination = source.assignRelayTarget(track, destination, now); destination == nil {
+					continue
+				}
+
+				destinationParameters := destination.AudioSender.GetParameters()
+				relayed := remapHeaderExtensions(pkt, sourceExtensions,
+					headerExtensionIDs(destinationParameters.HeaderExtensions))
+
+				if !adapter.adapt(relayed, relay.room, destination, destinationParameters.Codecs) {
+					continue
+				}
+				destination.enqueue(outboundPacket{pkt: relayed, stream: destination.relayStream(source, pkt.SSRC)})
+			}
+			if _, ok := source.relaysTrack(track, 1); !ok {
+				continue
+			}
+			for _, sink := range sinks {
+				sink.write(pkt, adapter.codec(pkt) == mimeTypeRED, clockRate)
+			}
+		}
+	})
+}
+
+// sourceTrack is an audio track a peer sends, on its lane-th audio media
+// section.
+type sourceTrack struct {
+	track *webrtc.TrackRemote
+	lane  int
+}
+
+// startTrack records track, received on the peer's lane-th audio media
+// section. Each section is relayed into the matching relay track of
+// destinations that have one, see relayTarget, but a destination's relay
+// track only carries one of them at a time: when a peer sends more audio
+// tracks than a destination has relay tracks, e.g. starting a second
+// microphone on a further section it offered, its last relay track carries
+// the newest, and the others keep being read, recorded and discarded.
+// endTrack hands the relay back to the newest remaining one. File sinks,
+// with a single track each, record the newest of all.
+//
+// The server never renegotiates to add relay tracks: WHIP gives it no way
+// to send a subscriber an offer, nor a client a way to send a new one on
+// its resource, so each peer's relay tracks are those its own offer asked
+// for, see newLane, and a peer wanting to hear more sources at once offers
+// more audio sections up front.
+func (p *Peer) startTrack(track *webrtc.TrackRemote, lane int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.audioTracks) > 0 {
+		fmt.Printf("Peer %s sent another audio track %s on audio section %d, relayed instead of %s to destinations with fewer relay tracks\n", p.ID, track.ID(), lane, p.audioTracks[len(p.audioTracks)-1].track.ID())
+	}
+	p.audioTracks = append(p.audioTracks, sourceTrack{track: track, lane: lane})
+}
+
+func (p *Peer) endTrack(track *webrtc.TrackRemote) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	index := slices.IndexFunc(p.audioTracks, func(audioTrack sourceTrack) bool {
+		return audioTrack.track == track
+	})
+	if index < 0 {
+		return
+	}
+	p.audioTracks = slices.Delete(p.audioTracks, index, index+1)
+	if index == len(p.audioTracks) && index > 0 {
+		fmt.Printf("Peer %s audio track %s ended, relaying %s again\n", p.ID, track.ID(), p.audioTracks[index-1].track.ID())
+	}
+}
+
+// relaysTrack returns which of lanes relay tracks a destination relays the
+// peer's track into, false for none: the track's own lane, or the last
+// lane for tracks beyond it, and only the newest of the tracks sharing one.
+func (p *Peer) relaysTrack(track *webrtc.TrackRemote, lanes int) (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	index := slices.IndexFunc(p.audioTracks, func(audioTrack sourceTrack) bool {
+		return audioTrack.track == track
+	})
+	if index < 0 {
+		return 0, false
+	}
+	lane := min(p.audioTracks[index].lane, lanes-1)
+	for _, newer := range p.audioTracks[index+1:] {
+		if min(newer.lane, lanes-1) == lane {
+			return 0, false
+		}
+	}
+	return lane, true
+}
+
+// enqueue queues pkt for writeLoop, dropping the oldest queued packets when
+// the queue is full to bound relay latency.
+func (p *Peer) enqueue(pkt outboundPacket) {
+	for {
+		select {
+		case p.outbound <- pkt:
+			return
+		default:
+		}
+
+		select {
+		case <-p.outbound:
+			relayStats.PacketsDropped.Add(1)
+		default:
+		}
+	}
+}
+
+// writeLoop writes queued packets to the peer's track until the peer closes.
+//
+// Until the peer first connects, pion discards what is written to its track,
+// so the packets relayed in the meantime, e.g. to a subscriber joining a
+// running stream, are held, up to config.StartupBufferSize and dropping the
+// oldest beyond, and written once it connects.
+func (p *Peer) writeLoop() {
+	egress := newTokenBucket(config.MaxEgressBitrate)
+	var clockRate uint32
+	var codecs []webrtc.RTPCodecParameters
+	send := func(pkt *rtp.Packet, stream *relayStream) {
+		if err := p.AudioTrack.WriteRTP(pkt); err != nil {
+			relayStats.WriteErrors.Add(1)
+			fmt.Printf("Error relaying to peer %s: %s\n", p.ID, err.Error())
+			return
+		}
+		relayStats.PacketsRelayed.Add(1)
+		stream.packets.Add(1)
+		stream.bytes.Add(uint64(len(pkt.Payload)))
+
+		p.mutex.Lock()
+		p.clock.relayed(pkt, time.Now())
+		p.mutex.Unlock()
+	}
+	write := func(out outboundPacket) {
+		pkt := out.pkt
+		if egress != nil {
+			now := time.Now()
+			allowed := egress.allow(pkt.MarshalSize(), now)
+			egress.logDrops(p.ID, now)
+			if !allowed {
+				relayStats.PacketsRateLimited.Add(1)
+				return
+			}
+		}
+		if simulateLoss() {
+			return
+		}
+		if clockRate == 0 {
+			clockRate, _ = relayClockRate(p)
+			codecs = p.AudioSender.GetParameters().Codecs
+		}
+
+		p.mutex.Lock()
+		p.clock.rewrite(pkt, clockRate, time.Now())
+		fillers := p.silenceFillers(pkt, clockRate, codecs)
+		p.mutex.Unlock()
+
+		for _, filler := range fillers {
+			relayStats.PacketsSilenceInserted.Add(1)
+			send(filler, out.stream)
+		}
+		send(pkt, out.stream)
+	}
+
+	var pending []outboundPacket
+	connected := p.connected
+	if config.StartupBufferSize <= 0 {
+		connected = nil
+	}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-connected:
+			connected = nil
+			for _, pkt := range pending {
+				write(pkt)
+			}
+			pending = nil
+		case pkt := <-p.outbound:
+			if connected == nil {
+				write(pkt)
+				continue
+			}
+			if len(pending) >= config.StartupBufferSize {
+				pending = pending[1:]
+				relayStats.PacketsDropped.Add(1)
+			}
+			pending = append(pending, pkt)
+		}
+	}
+}
+
+// markConnected tells writeLoop the peer's connection is up. It is safe to
+// call more than once.
+func (p *Peer) markConnected() {
+	p.connectedOnce.Do(func() {
+		close(p.connected)
+	})
+}
+
+// close stops the peer's writeLoop. It is safe to call more than once.
+func (p *Peer) close() {
+	p.closeOnce.Do(func() {
+		p.loopsMutex.Lock()
+		close(p.done)
+		p.loopsMutex.Unlock()
+		p.stopLifetimeTimer()
+	})
+}
+
+// startLoop counts a goroutine serving the peer in peerLoops, which the
+// goroutine calls Done on when it ends. It reports false, for the goroutine
+// to return at once, if the peer is already closed.
+func (p *Peer) startLoop() bool {
+	p.loopsMutex.Lock()
+	defer p.loopsMutex.Unlock()
+
+	select {
+	case <-p.done:
+		return false
+	default:
+		peerLoops.Add(1)
+		return true
+	}
+}
+
+// goLoop runs loop in a goroutine counted by startLoop.
+func (p *Peer) goLoop(loop func()) {
+	if !p.startLoop() {
+		return
+	}
+	go func() {
+		defer peerLoops.Done()
+		loop()
+	}()
+}
+
+// drainTrack reads and discards everything on a track the server doesn't relay
+// until the track ends, so its buffers never back up.
+func drainTrack(track *webrtc.TrackRemote) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := track.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// headerExtensionIDs maps each relayed header extension URI to the ID
+// negotiated for it.
+func headerExtensionIDs(extensions []webrtc.RTPHeaderExtensionParameter) map[string]uint8 {
+	ids := make(map[string]uint8)
+	for _, extension := range extensions {
+		for _, uri := range relayedHeaderExtensions {
+			if extension.URI == uri {
+				ids[uri] = uint8(extension.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// remapHeaderExtensions returns a copy of pkt carrying only the relayed header
+// extensions, renumbered from the source's negotiated IDs to the
+// destination's. Extensions the destination didn't negotiate are dropped.
+func remapHeaderExtensions(pkt *rtp.Packet, sourceIDs, destinationIDs map[string]uint8) *rtp.Packet {
+	relayed := &rtp.Packet{Header: pkt.Header.Clone(), Payload: pkt.Payload}
+	relayed.Header.Extension = false
+	relayed.Header.ExtensionProfile = 0
+	relayed.Header.Extensions = nil
+
+	for uri, sourceID := range sourceIDs {
+		destinationID, ok := destinationIDs[uri]
+		if !ok {
+			continue
+		}
+		// IDs above 14 only fit RFC 8285 two-byte headers, which pion
+		// doesn't pick itself for short extensions.
+		if destinationID > 14 && !relayed.Header.Extension {
+			relayed.Header.Extension = true
+			relayed.Header.ExtensionProfile = rtp.ExtensionProfileTwoByte
+		}
+
+		if payload := pkt.Header.GetExtension(sourceID); payload != nil {
+			if err := relayed.Header.SetExtension(destinationID, payload); err != nil {
+				fmt.Printf("Error relaying header extension %s: %s\n", uri, err.Error())
+			}
+		}
+	}
+	return relayed
+}
+
+// writeAnswer negotiates the offer and writes the answer to res. On failure it
+// writes the error response itself and returns the error; if ctx ends first
+// the response is 504.
+func writeAnswer(ctx context.Context, res http.ResponseWriter, req *http.Request, peer *Peer, offer []byte, location string) error {
+	peerConnection := peer.PeerConnection
+	// State changes may be reported on several goroutines, so connected,
+	// which records the first connection only, is atomic.
+	started := time.Now()
+	var connected atomic.Bool
+	logSDP("offer", peer, string(offer))
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
 		fmt.Printf("ICE state: %s\n", connectionState.String())
 
-		if connectionState == webrtc.ICEConnectionStateFailed {
+		switch connectionState {
+		case webrtc.ICEConnectionStateConnected:
+			if connected.CompareAndSwap(false, true) {
+				recordICEConnected(peerConnection, time.Since(started))
+			}
+		case webrtc.ICEConnectionStateFailed:
+			recordICEFailed()
 			_ = peerConnection.Close()
 		}
 	})
 
+	phaseStarted := time.Now()
 	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer, SDP: string(offer),
 	}); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	recordNegotiationPhase(req, phaseSetRemoteDescription, phaseStarted)
+	if err := preferCodecs(peerConnection, allowedCodecs(peer.rooms)); err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	if err := checkDeadline(ctx, res, req); err != nil {
+		return err
+	}
+
+	var stream *candidateStream
+	if wantsCandidateStream(req) {
+		stream = newCandidateStream(peerConnection)
 	}
 
 	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	phaseStarted = time.Now()
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return err
 	}
+	recordNegotiationPhase(req, phaseCreateAnswer, phaseStarted)
 
-	if err = peerConnection.SetLocalDescription(answer); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
+	phaseStarted = time.Now()
+	if err = setLocalDescription(peerConnection, answer); err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return err
 	}
-	<-gatherComplete
+	if err := checkDeadline(ctx, res, req); err != nil {
+		return err
+	}
+
+	if stream != nil {
+		stream.serve(ctx, res, peer, gatherComplete, location)
+		logSDP("answer", peer, localAnswer(peer))
+		return nil
+	}
+	if !awaitGathering(ctx, peer, gatherComplete) {
+		return checkDeadline(ctx, res, req)
+	}
+	recordNegotiationPhase(req, phaseICEGathering, phaseStarted)
+	logSDP("answer", peer, localAnswer(peer))
 
-	res.Header().Add("Location", path)
+	res.Header().Add("Location", location)
+	addICEServerLinks(res)
 	res.WriteHeader(http.StatusCreated)
 
-	_, err = fmt.Fprint(res, peerConnection.LocalDescription().SDP)
+	_, err = fmt.Fprint(res, localAnswer(peer))
 	if err != nil {
 		fmt.Printf("Error writing answer: %s\n", err.Error())
 	}
+	return nil
+}
+
+// checkDeadline writes a 504 and returns the context's error once the
+// negotiation budget is spent.
+func checkDeadline(ctx context.Context, res http.ResponseWriter, req *http.Request) error {
+	if err := ctx.Err(); err != nil {
+		writeError(res, req, "negotiation timed out", http.StatusGatewayTimeout)
+		return err
+	}
+	return nil
 }
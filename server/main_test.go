@@ -0,0 +1,1146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// relayTimeout bounds how long the relay tests wait for a packet.
+const relayTimeout = 10 * time.Second
+
+// silenceWait is how long the relay tests listen for packets that mustn't
+// arrive.
+const silenceWait = time.Second
+
+// startServer runs the server in process with the given flags, for tests
+// reaching into it, and tears its peers down, waiting for their loops to
+// end, when the test ends. The server state is global, so these tests don't
+// run in parallel.
+func startServer(t *testing.T, args ...string) *testServer {
+	t.Helper()
+
+	handler, err := Handler(append([]string{"-no-stun"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		// No join is under way once the server is closed.
+		server.Close()
+		Stop()
+	})
+	return &testServer{URL: server.URL, client: synthpeer.NewClient(server.URL + "/whip")}
+}
+
+// testServer joins test peers to a server started by startServer. It is
+// singlewhiptest.Server for this package's tests, which can't import
+// singlewhiptest as it imports this package.
+type testServer struct {
+	URL    string
+	client *synthpeer.Client
+}
+
+// Join joins a test peer to room, see JoinQuery.
+func (s *testServer) Join(t *testing.T, room string) *synthpeer.Peer {
+	t.Helper()
+	return s.JoinQuery(t, url.Values{"room": {room}})
+}
+
+// JoinQuery joins a test peer with query, failing the test unless the
+// server answers with 201, and has it leave when the test ends.
+func (s *testServer) JoinQuery(t *testing.T, query url.Values) *synthpeer.Peer {
+	t.Helper()
+
+	peer, err := s.TryJoin(t, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer
+}
+
+// TryJoin is JoinQuery returning a *synthpeer.StatusError when the server
+// answers other than 201.
+func (s *testServer) TryJoin(t *testing.T, query url.Values) (*synthpeer.Peer, error) {
+	t.Helper()
+
+	peer, err := s.client.Join(query)
+	var status *synthpeer.StatusError
+	if errors.As(err, &status) {
+		return nil, err
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(peer.Close)
+	return peer, nil
+}
+
+// assertRelayed fails the test unless destination hears source within
+// timeout.
+func assertRelayed(t *testing.T, source, destination *synthpeer.Peer, timeout time.Duration) {
+	t.Helper()
+
+	if err := synthpeer.AwaitRelayed(source, destination, timeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertNotRelayed fails the test if destination hears source within wait.
+func assertNotRelayed(t *testing.T, source, destination *synthpeer.Peer, wait time.Duration) {
+	t.Helper()
+
+	if err := synthpeer.AwaitNotRelayed(source, destination, wait); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// adminToken is the -admin-token of the servers tests use the admin API of.
+const adminToken = "secret"
+
+// request sends a request to the server, with the admin token, and returns
+// the response's status and body.
+func request(t *testing.T, method, url string, body io.Reader) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(data)
+}
+
+// eventually fails the test unless condition holds within relayTimeout.
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(relayTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen within %s", what, relayTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// createOffer returns a new peer connection, set up by setup, e.g. with the
+// transceivers to offer, and its offer once ICE gathering completed. The
+// connection closes when the test ends.
+func createOffer(t *testing.T, setup func(*webrtc.PeerConnection) error) (*webrtc.PeerConnection, string) {
+	t.Helper()
+	return createOfferWith(t, webrtc.NewAPI(), setup)
+}
+
+// createOfferWith is createOffer with a connection of api.
+func createOfferWith(t *testing.T, api *webrtc.API, setup func(*webrtc.PeerConnection) error) (*webrtc.PeerConnection, string) {
+	t.Helper()
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = peerConnection.Close() })
+	if err = setup(peerConnection); err != nil {
+		t.Fatal(err)
+	}
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return peerConnection, peerConnection.LocalDescription().SDP
+}
+
+// addAudio sets a peer connection up to offer sendrecv audio.
+func addAudio(peerConnection *webrtc.PeerConnection) error {
+	_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio)
+	return err
+}
+
+// postOffer POSTs the offer to url with header and returns the response and
+// its body.
+func postOffer(t *testing.T, url, offer string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(offer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/sdp")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+// setForwarding pauses or resumes forwarding of source to peer.
+func setForwarding(t *testing.T, peer, source *synthpeer.Peer, paused bool) {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"source": %q, "paused": %t}`, source.ID, paused)
+	if status, _ := request(t, http.MethodPatch, peer.Location, strings.NewReader(body)); status != http.StatusNoContent {
+		t.Fatalf("forwarding PATCH answered %d, want 204", status)
+	}
+}
+
+func TestPauseAndResumeForwarding(t *testing.T) {
+	server := startServer(t)
+	alice := server.Join(t, "paused")
+	bob := server.Join(t, "paused")
+	assertRelayed(t, alice, bob, relayTimeout)
+	assertRelayed(t, bob, alice, relayTimeout)
+
+	setForwarding(t, bob, alice, true)
+	// Packets already queued may still arrive.
+	time.Sleep(200 * time.Millisecond)
+	heard, heardByAlice := bob.Heard(alice), alice.Heard(bob)
+	time.Sleep(silenceWait)
+	if bob.Heard(alice) != heard {
+		t.Fatalf("paused peer heard %d packets", bob.Heard(alice)-heard)
+	}
+	if alice.Heard(bob) == heardByAlice {
+		t.Fatal("pausing one direction stopped the other")
+	}
+
+	// A second of paused packets, 50 of them, would arrive at once if they
+	// had been held rather than dropped.
+	setForwarding(t, bob, alice, false)
+	time.Sleep(200 * time.Millisecond)
+	if caughtUp := bob.Heard(alice) - heard; caughtUp > 25 {
+		t.Fatalf("resumed peer heard %d packets within 200ms; paused packets were buffered", caughtUp)
+	}
+	eventually(t, "the resumed peer hearing its source", func() bool {
+		return bob.Heard(alice) > heard
+	})
+}
+
+func TestMaxRooms(t *testing.T) {
+	server := startServer(t, "-max-rooms", "1")
+	server.Join(t, "first")
+
+	_, offer := createOffer(t, addAudio)
+	res, body := postOffer(t, server.URL+"/whip?room=second", offer, nil)
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("join of a new room answered %d, want 503: %s", res.StatusCode, body)
+	}
+
+	// Rooms already open still admit peers.
+	server.Join(t, "first")
+}
+
+func TestEmptyRoomIsDropped(t *testing.T) {
+	server := startServer(t, "-max-rooms", "1")
+	alice := server.Join(t, "first")
+	bob := server.Join(t, "first")
+	assertRelayed(t, alice, bob, relayTimeout)
+
+	alice.Close()
+	bob.Close()
+	eventually(t, "the emptied room being dropped", func() bool {
+		roomManager.mutex.RLock()
+		defer roomManager.mutex.RUnlock()
+		return len(roomManager.rooms) == 0
+	})
+	server.Join(t, "second")
+}
+
+func TestJoinRecreatesRemovedRoom(t *testing.T) {
+	startServer(t)
+	stale, err := roomManager.getOrCreateRoom("emptied", RoomOptions{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The room empties, as when its last peer leaves, before the joiner
+	// that looked it up gets to join it.
+	roomManager.removeRoomIfEmpty(stale)
+
+	peer := &Peer{ID: newPeerID(), rooms: []*Room{stale}}
+	if err := peer.joinRooms(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		peer.rooms[0].removePeer(peer)
+		roomManager.removeRoomIfEmpty(peer.rooms[0])
+	})
+	if peer.rooms[0] == stale {
+		t.Fatal("peer joined the removed room")
+	}
+	if roomManager.findPeer(peer.ID) == nil {
+		t.Fatal("the joined peer can't be found")
+	}
+}
+
+// extensionPeer is a test client negotiating the audio level header
+// extension, sending on its track and collecting what it receives.
+type extensionPeer struct {
+	peerConnection *webrtc.PeerConnection
+	track          *webrtc.TrackLocalStaticRTP
+	sender         *webrtc.RTPSender
+	packets        chan *rtp.Packet
+	// audioLevelID is the ID the peer receives the audio level with.
+	audioLevelID chan int
+}
+
+// joinWithAudioLevel joins room with a peer negotiating the audio level
+// header extension after registering the extensions of before, which shift
+// the ID it offers for it.
+func joinWithAudioLevel(t *testing.T, serverURL, room string, before ...string) *extensionPeer {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	opus := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}
+	if err := mediaEngine.RegisterCodec(opus, webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	for _, uri := range append(before, sdp.AudioLevelURI) {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, webrtc.RTPCodecTypeAudio); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := &extensionPeer{packets: make(chan *rtp.Packet, 100), audioLevelID: make(chan int, 1)}
+	var err error
+	p.track, err = webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "extensions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offer string
+	p.peerConnection, offer = createOfferWith(t, webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)), func(peerConnection *webrtc.PeerConnection) error {
+		p.sender, err = peerConnection.AddTrack(p.track)
+		peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			for _, extension := range receiver.GetParameters().HeaderExtensions {
+				if extension.URI == sdp.AudioLevelURI {
+					p.audioLevelID <- extension.ID
+				}
+			}
+			for {
+				pkt, _, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				select {
+				case p.packets <- pkt:
+				default:
+				}
+			}
+		})
+		return err
+	})
+
+	res, answer := postOffer(t, serverURL+"/whip?room="+room, offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err = p.peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAudioLevelRelayed(t *testing.T) {
+	server := startServer(t)
+	publisher := joinWithAudioLevel(t, server.URL, "levels")
+	// The subscriber receives the audio level under another ID.
+	subscriber := joinWithAudioLevel(t, server.URL, "levels", sdp.ABSSendTimeURI)
+
+	var sentID uint8
+	for _, extension := range publisher.sender.GetParameters().HeaderExtensions {
+		if extension.URI == sdp.AudioLevelURI {
+			sentID = uint8(extension.ID)
+		}
+	}
+	if sentID == 0 {
+		t.Fatal("publisher didn't negotiate the audio level")
+	}
+
+	// Audio level 30 dBov with voice activity.
+	const level = 0x80 | 30
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for sequence := uint16(0); ; sequence++ {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			pkt := &rtp.Packet{
+				Header:  rtp.Header{Version: 2, SequenceNumber: sequence, Timestamp: uint32(sequence) * 960},
+				Payload: []byte{0xf8},
+			}
+			_ = pkt.Header.SetExtension(sentID, []byte{level})
+			_ = publisher.track.WriteRTP(pkt)
+		}
+	}()
+
+	var receivedID int
+	select {
+	case receivedID = <-subscriber.audioLevelID:
+	case <-time.After(relayTimeout):
+		t.Fatal("subscriber got no track")
+	}
+	if receivedID == int(sentID) {
+		t.Fatalf("both peers negotiated ID %d, the test needs them to differ", sentID)
+	}
+	select {
+	case pkt := <-subscriber.packets:
+		if value := pkt.Header.GetExtension(uint8(receivedID)); len(value) != 1 || value[0] != level {
+			t.Fatalf("relayed audio level under ID %d is %v, want [%d]", receivedID, value, level)
+		}
+	case <-time.After(relayTimeout):
+		t.Fatal("subscriber heard nothing")
+	}
+}
+
+func TestMaxNegotiations(t *testing.T) {
+	server := startServer(t, "-max-negotiations", "1")
+
+	// A POST whose offer never finishes arriving holds the only slot.
+	body, writer := io.Pipe()
+	defer writer.Close()
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		res, err := http.Post(server.URL+"/whip?room=held", "application/sdp", body)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	eventually(t, "the first negotiation taking the slot", func() bool {
+		return len(negotiationSlots) == 1
+	})
+
+	_, offer := createOffer(t, addAudio)
+	if res, body := postOffer(t, server.URL+"/whip?room=second", offer, nil); res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("negotiation beyond the limit answered %d, want 503: %s", res.StatusCode, body)
+	}
+
+	writer.Close()
+	<-held
+	server.Join(t, "second")
+}
+
+// addVideo sets a peer connection up to offer recvonly video. pion fails to
+// apply answers rejecting video it offered to send, as it binds its
+// placeholder video track regardless.
+func addVideo(peerConnection *webrtc.PeerConnection) error {
+	_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	return err
+}
+
+func TestVideoOnlyOffer(t *testing.T) {
+	server := startServer(t)
+	_, offer := createOffer(t, addVideo)
+
+	res, body := postOffer(t, server.URL+"/whip?room=video", offer, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("video-only offer answered %d: %s", res.StatusCode, body)
+	}
+	roomManager.mutex.RLock()
+	defer roomManager.mutex.RUnlock()
+	if roomManager.rooms["video"] != nil {
+		t.Error("rejected offer left its room behind")
+	}
+}
+
+// joinListener joins room with a peer that only receives audio, returning
+// the payloads it hears.
+func joinListener(t *testing.T, serverURL, room string) <-chan []byte {
+	t.Helper()
+
+	payloads := make(chan []byte, 100)
+	peerConnection, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			for {
+				pkt, _, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				select {
+				case payloads <- pkt.Payload:
+				default:
+				}
+			}
+		})
+		return addAudio(peerConnection)
+	})
+	answerOffer(t, serverURL, room, peerConnection, offer)
+	return payloads
+}
+
+// answerOffer joins room with the offer and applies the answer, which it
+// returns.
+func answerOffer(t *testing.T, serverURL, room string, peerConnection *webrtc.PeerConnection, offer string) string {
+	t.Helper()
+
+	res, answer := postOffer(t, serverURL+"/whip?room="+room, offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	return answer
+}
+
+func TestAudioAndVideoOffer(t *testing.T) {
+	server := startServer(t)
+	payloads := joinListener(t, server.URL, "camera")
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "camera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerConnection, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		if _, err := peerConnection.AddTrack(track); err != nil {
+			return err
+		}
+		return addVideo(peerConnection)
+	})
+	answer := answerOffer(t, server.URL, "camera", peerConnection, offer)
+	if !strings.Contains(answer, "\r\nm=video 0 ") {
+		t.Fatalf("answer doesn't reject the video section:\n%s", answer)
+	}
+	if !strings.Contains(answer, "\r\nm=audio 9 ") {
+		t.Fatalf("answer doesn't accept the audio section:\n%s", answer)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: []byte{'V'}, Duration: 20 * time.Millisecond})
+			case <-done:
+				return
+			}
+		}
+	}()
+	deadline := time.After(relayTimeout)
+	for {
+		select {
+		case payload := <-payloads:
+			if len(payload) > 0 && payload[0] == 'V' {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the audio+video peer's audio was not relayed")
+		}
+	}
+}
+
+// joinAt POSTs an audio offer to url, failing the test unless the server
+// answers with 201, and returns the resource URL of its Location.
+func joinAt(t *testing.T, url string) *url.URL {
+	t.Helper()
+
+	_, offer := createOffer(t, addAudio)
+	res, body := postOffer(t, url, offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST %s answered %d: %s", url, res.StatusCode, body)
+	}
+	location, err := res.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func TestWHIPPaths(t *testing.T) {
+	server := startServer(t, "-whip-path", "/whip,/ingest/v1/")
+
+	for _, endpoint := range []string{"/whip", "/ingest/v1"} {
+		location := joinAt(t, server.URL+endpoint+"?room="+url.QueryEscape(endpoint))
+		if !strings.HasPrefix(location.Path, endpoint+"/") {
+			t.Errorf("POST %s answered with Location %s", endpoint, location.Path)
+		}
+		if status, body := request(t, http.MethodPatch, location.String(), strings.NewReader(`{"paused": true}`)); status != http.StatusNoContent {
+			t.Errorf("PATCH %s answered %d: %s", location.Path, status, body)
+		}
+	}
+}
+
+func TestWHIPPathReplacesDefault(t *testing.T) {
+	server := startServer(t, "-whip-path", "/ingest")
+
+	if status, _ := request(t, http.MethodPost, server.URL+"/whip?room=paths", nil); status != http.StatusNotFound {
+		t.Errorf("POST to the unconfigured /whip answered %d, want 404", status)
+	}
+}
+
+func TestSlowSubscriberDropsOldest(t *testing.T) {
+	server := startServer(t, "-relay-queue-size", "5")
+	publisher := server.Join(t, "slow")
+	slow := server.Join(t, "slow")
+	assertRelayed(t, publisher, slow, relayTimeout)
+
+	// With its writeLoop stopped the subscriber's queue fills up like that
+	// of a writer stuck on the network.
+	stuck := roomManager.findPeer(slow.ID)
+	stuck.close()
+	eventually(t, "the stuck subscriber's queue filling", func() bool {
+		return len(stuck.outbound) == cap(stuck.outbound)
+	})
+	first := (<-stuck.outbound).pkt.SequenceNumber
+	dropped := relayStats.PacketsDropped.Load()
+
+	eventually(t, "packets for the stuck subscriber being dropped", func() bool {
+		return relayStats.PacketsDropped.Load() >= dropped+20
+	})
+	for range cap(stuck.outbound) {
+		if queued := (<-stuck.outbound).pkt.SequenceNumber; queued-first < 20 {
+			t.Fatalf("queue still holds packet %d, %d after packet %d; newer packets were dropped instead of the oldest", queued, queued-first, first)
+		}
+	}
+}
+
+func TestNegotiationTimeoutReadingOffer(t *testing.T) {
+	server := startServer(t, "-negotiation-timeout", "200ms")
+
+	// An offer that stops arriving halfway runs out the budget.
+	_, offer := createOffer(t, addAudio)
+	body, writer := io.Pipe()
+	defer writer.Close()
+	go func() {
+		_, _ = writer.Write([]byte(offer[:len(offer)/2]))
+	}()
+
+	start := time.Now()
+	res, err := http.Post(server.URL+"/whip?room=stalled", "application/sdp", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("stalled negotiation answered %d, want 504", res.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > relayTimeout {
+		t.Errorf("stalled negotiation took %s to time out", elapsed)
+	}
+}
+
+func assertRoomFull(t *testing.T, server *testServer, query url.Values) {
+	t.Helper()
+
+	_, err := server.TryJoin(t, query)
+	var status *synthpeer.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("join of a full room: got %v, want 503", err)
+	}
+}
+
+func TestNegotiationTimeoutStalledStep(t *testing.T) {
+	server := startServer(t, "-negotiation-timeout", "200ms")
+	// Applying the answer takes longer than the whole budget.
+	setLocalDescription = func(peerConnection *webrtc.PeerConnection, answer webrtc.SessionDescription) error {
+		time.Sleep(400 * time.Millisecond)
+		return peerConnection.SetLocalDescription(answer)
+	}
+	t.Cleanup(func() { setLocalDescription = (*webrtc.PeerConnection).SetLocalDescription })
+
+	_, err := server.TryJoin(t, url.Values{"room": {"stalled"}})
+	var status *synthpeer.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("join: got %v, want 504", err)
+	}
+	eventually(t, "the timed out peer leaving its room", func() bool {
+		return roomManager.findRoom("stalled") == nil
+	})
+}
+
+func TestPairsTopology(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"pair"}, "topology": {"pairs"}}
+	alice := server.JoinQuery(t, query)
+	bob := server.JoinQuery(t, query)
+
+	assertRelayed(t, alice, bob, relayTimeout)
+	assertRelayed(t, bob, alice, relayTimeout)
+	assertRoomFull(t, server, query)
+}
+
+func TestMeshTopology(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"mesh"}, "topology": {"mesh"}}
+	alice := server.JoinQuery(t, query)
+	bob := server.JoinQuery(t, query)
+
+	assertRelayed(t, alice, bob, relayTimeout)
+	assertRelayed(t, bob, alice, relayTimeout)
+	// The peers have a single relay track each, so a third source would
+	// share it with another.
+	assertRoomFull(t, server, query)
+}
+
+func TestBroadcastTopology(t *testing.T) {
+	server := startServer(t)
+	query := url.Values{"room": {"show"}, "mode": {"broadcast"}}
+	publisher := server.JoinQuery(t, query)
+	subscribers := []*synthpeer.Peer{server.JoinQuery(t, query), server.JoinQuery(t, query), server.JoinQuery(t, query)}
+
+	for _, subscriber := range subscribers {
+		assertRelayed(t, publisher, subscriber, relayTimeout)
+	}
+	// Subscribers send too, but nobody hears them.
+	time.Sleep(silenceWait)
+	for _, subscriber := range subscribers {
+		if heard := publisher.Heard(subscriber); heard > 0 {
+			t.Errorf("publisher heard %d packets from subscriber %s", heard, subscriber.ID)
+		}
+		for _, other := range subscribers {
+			if other == subscriber {
+				continue
+			}
+			if heard := other.Heard(subscriber); heard > 0 {
+				t.Errorf("subscriber %s heard %d packets from subscriber %s", other.ID, heard, subscriber.ID)
+			}
+		}
+	}
+}
+
+func TestFailedNegotiationLeavesRoom(t *testing.T) {
+	server := startServer(t)
+	setLocalDescription = func(*webrtc.PeerConnection, webrtc.SessionDescription) error {
+		return errors.New("injected failure")
+	}
+	t.Cleanup(func() { setLocalDescription = (*webrtc.PeerConnection).SetLocalDescription })
+
+	_, err := server.TryJoin(t, url.Values{"room": {"failing"}})
+	var status *synthpeer.StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("join: got %v, want 500", err)
+	}
+	roomManager.mutex.RLock()
+	defer roomManager.mutex.RUnlock()
+	if room := roomManager.rooms["failing"]; room != nil {
+		t.Fatalf("room kept %d peers after their negotiation failed", len(room.otherPeers(nil)))
+	}
+}
+
+func TestRoomInPath(t *testing.T) {
+	server := startServer(t)
+
+	location := joinAt(t, server.URL+"/whip/room/pathroom")
+	if !strings.HasPrefix(location.Path, "/whip/") || strings.Contains(location.Path, "/room/") {
+		t.Errorf("join by path answered with Location %s", location.Path)
+	}
+	joinAt(t, server.URL+"/whip/room/pathroom?room=pathroom")
+	roomManager.mutex.RLock()
+	room := roomManager.rooms["pathroom"]
+	roomManager.mutex.RUnlock()
+	if room == nil || len(room.otherPeers(nil)) != 2 {
+		t.Fatal("joins by path and by matching query didn't share the room")
+	}
+
+	_, offer := createOffer(t, addAudio)
+	for _, path := range []string{"/whip/room/pathroom?room=other", "/whip/room/a/b", "/whip"} {
+		if res, body := postOffer(t, server.URL+path, offer, nil); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("POST %s answered %d, want 400: %s", path, res.StatusCode, body)
+		}
+	}
+}
+
+func TestMaxEgressBitrate(t *testing.T) {
+	// The test peers' packets are 13 bytes, 50 a second: 5.2 kbps, of which
+	// 800 bps, about 8 packets a second, get through once the burst
+	// allowance of 1500 bytes is spent.
+	server := startServer(t, "-max-egress-bitrate", "800")
+	query := url.Values{"room": {"capped"}, "topology": {"broadcast"}}
+	publisher := server.JoinQuery(t, query)
+	subscriber := server.JoinQuery(t, query)
+	assertRelayed(t, publisher, subscriber, relayTimeout)
+	time.Sleep(3 * time.Second)
+
+	heard, limited := subscriber.Heard(publisher), relayStats.PacketsRateLimited.Load()
+	time.Sleep(2 * time.Second)
+	heard, limited = subscriber.Heard(publisher)-heard, relayStats.PacketsRateLimited.Load()-limited
+	if heard == 0 || heard > 25 {
+		t.Errorf("subscriber heard %d packets in 2s, want the cap's 15 or so", heard)
+	}
+	if limited < 50 {
+		t.Errorf("%d packets counted as rate limited in 2s, want at least 50", limited)
+	}
+}
+
+func TestDisconnectGrace(t *testing.T) {
+	server := startServer(t, "-disconnect-grace", "300ms")
+	blipping := server.Join(t, "blip")
+	listener := server.Join(t, "blip")
+	assertRelayed(t, blipping, listener, relayTimeout)
+	peer := roomManager.findPeer(blipping.ID)
+
+	// Disconnected, then connected again within the grace.
+	peer.startDisconnectTimer()
+	time.Sleep(100 * time.Millisecond)
+	peer.stopDisconnectTimer()
+	time.Sleep(500 * time.Millisecond)
+	if roomManager.findPeer(blipping.ID) == nil {
+		t.Fatal("peer that recovered within the grace was removed")
+	}
+	assertRelayed(t, blipping, listener, relayTimeout)
+
+	// Disconnected for longer than the grace.
+	peer.startDisconnectTimer()
+	eventually(t, "the disconnected peer's removal", func() bool {
+		return roomManager.findPeer(blipping.ID) == nil
+	})
+	if state := peer.PeerConnection.ConnectionState(); state != webrtc.PeerConnectionStateClosed {
+		t.Errorf("removed peer's connection is %s, want closed", state)
+	}
+	if roomManager.findPeer(listener.ID) == nil {
+		t.Error("the other peer was removed too")
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	server := startServer(t, "-base-path", "/media/")
+
+	location := joinAt(t, server.URL+"/media/whip?room=prefixed")
+	if !strings.HasPrefix(location.Path, "/media/whip/") {
+		t.Fatalf("Location %s lacks the base path", location.Path)
+	}
+
+	// Trickle ICE and DELETE reach the resource under the prefix only.
+	unprefixed := server.URL + strings.TrimPrefix(location.Path, "/media")
+	if status, _ := request(t, http.MethodDelete, unprefixed, nil); status != http.StatusNotFound {
+		t.Errorf("DELETE without the base path answered %d, want 404", status)
+	}
+	req, err := http.NewRequest(http.MethodPatch, location.String(), strings.NewReader("a=mid:0\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", sdpFragContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("trickle PATCH answered %d, want 204", res.StatusCode)
+	}
+	if status, body := request(t, http.MethodDelete, location.String(), nil); status != http.StatusOK {
+		t.Errorf("DELETE answered %d: %s", status, body)
+	}
+
+	if status, _ := request(t, http.MethodGet, server.URL+"/media/stats", nil); status != http.StatusOK {
+		t.Errorf("GET /media/stats answered %d, want 200", status)
+	}
+	if status, _ := request(t, http.MethodPost, server.URL+"/whip?room=prefixed", nil); status != http.StatusNotFound {
+		t.Errorf("POST to /whip without the base path answered %d, want 404", status)
+	}
+}
+
+// scriptedReader returns its reads in order, then io.EOF.
+type scriptedReader []error
+
+func (r *scriptedReader) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	if len(*r) == 0 {
+		return nil, nil, io.EOF
+	}
+	err := (*r)[0]
+	*r = (*r)[1:]
+	if err != nil {
+		return nil, nil, err
+	}
+	return &rtp.Packet{Payload: []byte{'R'}}, nil, nil
+}
+
+func TestReadRelayedRTP(t *testing.T) {
+	source := &Peer{ID: "source"}
+	transient := errors.New("transient")
+
+	// Transient errors are retried and their count resets on a good read.
+	reads := scriptedReader{transient, transient, nil}
+	for range maxReadErrors - 1 {
+		reads = append(reads, transient)
+	}
+	reads = append(reads, nil)
+	for i := range 2 {
+		if pkt, err := readRelayedRTP(&reads, source); err != nil || pkt == nil {
+			t.Fatalf("read %d after transient errors failed: %v", i, err)
+		}
+	}
+	if _, err := readRelayedRTP(&reads, source); !errors.Is(err, io.EOF) {
+		t.Errorf("read of the ended track returned %v, want EOF", err)
+	}
+
+	// Too many in a row end the relay.
+	reads = scriptedReader{}
+	for range maxReadErrors {
+		reads = append(reads, transient)
+	}
+	reads = append(reads, nil)
+	if _, err := readRelayedRTP(&reads, source); !errors.Is(err, transient) {
+		t.Errorf("%d failed reads returned %v, want the last error", maxReadErrors, err)
+	}
+}
+
+func TestLeaveAndRejoin(t *testing.T) {
+	server := startServer(t)
+	staying := server.Join(t, "rejoin")
+	leaving := server.Join(t, "rejoin")
+	assertRelayed(t, leaving, staying, relayTimeout)
+
+	leaving.Close()
+	eventually(t, "the leaving peer's removal", func() bool {
+		return roomManager.findPeer(leaving.ID) == nil
+	})
+	// Alone in the room, the staying peer's relay pauses.
+	relayed := relayStats.PacketsRelayed.Load()
+	time.Sleep(silenceWait)
+	if more := relayStats.PacketsRelayed.Load() - relayed; more > 0 {
+		t.Errorf("relayed %d packets with nobody to hear them", more)
+	}
+
+	rejoined := server.Join(t, "rejoin")
+	assertRelayed(t, staying, rejoined, relayTimeout)
+	assertRelayed(t, rejoined, staying, relayTimeout)
+}
+
+// sendTagged writes a packet with payload tag to track every 20ms until the
+// test ends.
+func sendTagged(t *testing.T, track *webrtc.TrackLocalStaticSample, tag byte) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: []byte{tag}, Duration: 20 * time.Millisecond})
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// awaitPayload reports whether payloads delivers one starting with tag
+// within wait.
+func awaitPayload(payloads <-chan []byte, tag byte, wait time.Duration) bool {
+	deadline := time.After(wait)
+	for {
+		select {
+		case payload := <-payloads:
+			if len(payload) > 0 && payload[0] == tag {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestSSRCAllowlist(t *testing.T) {
+	server := startServer(t)
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "allowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTrack(track)
+		return err
+	})
+	ssrc := allowed.GetSenders()[0].GetParameters().Encodings[0].SSRC
+
+	// A source whose SSRC the room doesn't list isn't relayed.
+	unlisted := joinListener(t, server.URL, fmt.Sprintf("unlisted&ssrc=%d", ssrc+1))
+	server.Join(t, "unlisted")
+	select {
+	case <-unlisted:
+		t.Error("listener hears a source whose SSRC the room doesn't allow")
+	case <-time.After(silenceWait):
+	}
+
+	listener := joinListener(t, server.URL, fmt.Sprintf("filtered&ssrc=%d", ssrc))
+	answerOffer(t, server.URL, "filtered", allowed, offer)
+	sendTagged(t, track, 'S')
+	if !awaitPayload(listener, 'S', relayTimeout) {
+		t.Error("the allowed SSRC was not relayed")
+	}
+
+	_, offer = createOffer(t, addAudio)
+	if res, _ := postOffer(t, server.URL+"/whip?room=invalid&ssrc=audio", offer, nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid ssrc parameter answered %d, want 400", res.StatusCode)
+	}
+}
+
+func TestStartupBufferHoldsPacketsUntilConnected(t *testing.T) {
+	server := startServer(t, "-startup-buffer", "25")
+
+	// The subscriber's answer is only applied later, so the server holds
+	// what it relays until then.
+	arrivals := make(chan time.Time, 200)
+	subscriber, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		return err
+	})
+	subscriber.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			if _, _, err := track.ReadRTP(); err != nil {
+				return
+			}
+			select {
+			case arrivals <- time.Now():
+			default:
+			}
+		}
+	})
+	res, answer := postOffer(t, server.URL+"/whip?room=late", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+
+	dropped := relayStats.PacketsDropped.Load()
+	publisher := server.Join(t, "late")
+	eventually(t, "the publisher's media reaching the server", func() bool {
+		peer := roomManager.findPeer(publisher.ID)
+		return peer != nil && peer.bytesReceived.Load() > 0
+	})
+	// 50 packets, half of them beyond the buffer.
+	time.Sleep(time.Second)
+	if overflowed := relayStats.PacketsDropped.Load() - dropped; overflowed < 15 {
+		t.Errorf("dropped %d packets beyond the startup buffer, want about 25", overflowed)
+	}
+
+	if err := subscriber.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	var first time.Time
+	select {
+	case first = <-arrivals:
+	case <-time.After(relayTimeout):
+		t.Fatal("subscriber heard nothing after connecting")
+	}
+	// The held packets arrive at once, where live ones come every 20ms.
+	time.Sleep(200 * time.Millisecond)
+	burst := 1
+	for len(arrivals) > 0 {
+		if (<-arrivals).Sub(first) < 100*time.Millisecond {
+			burst++
+		}
+	}
+	if burst < 20 {
+		t.Errorf("subscriber heard %d packets in its first 100ms, want the 25 held", burst)
+	}
+}
+
+func TestMaxRoomsPerUser(t *testing.T) {
+	server := startServer(t, "-auth-token", "first,second", "-max-rooms-per-user", "1")
+	_, offer := createOffer(t, addAudio)
+	join := func(token, room string) int {
+		res, _ := postOffer(t, server.URL+"/whip?room="+room, offer, http.Header{"Authorization": {"Bearer " + token}})
+		return res.StatusCode
+	}
+
+	if status := join("first", "a"); status != http.StatusCreated {
+		t.Fatalf("first user's first room answered %d", status)
+	}
+	if status := join("first", "b"); status != http.StatusTooManyRequests {
+		t.Fatalf("first user's second room answered %d, want 429", status)
+	}
+	if status := join("second", "b"); status != http.StatusCreated {
+		t.Fatalf("second user's first room answered %d", status)
+	}
+	// Joining a room another user created counts toward neither quota.
+	if status := join("first", "b"); status != http.StatusCreated {
+		t.Fatalf("first user joining the second's room answered %d", status)
+	}
+}
+
+func TestDeleteRacesConnectionTeardown(t *testing.T) {
+	server := startServer(t)
+	for round := range 10 {
+		room := fmt.Sprintf("race%d", round)
+		leaving := server.Join(t, room)
+		staying := server.Join(t, room)
+		assertRelayed(t, leaving, staying, relayTimeout)
+
+		// Closing the connection tears the peer down from its state change
+		// while the DELETEs do from the handler.
+		var wg sync.WaitGroup
+		statuses := make(chan int, 3)
+		for range 3 {
+			wg.Go(func() {
+				req, err := http.NewRequest(http.MethodDelete, leaving.Location, nil)
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				_ = res.Body.Close()
+				statuses <- res.StatusCode
+			})
+		}
+		wg.Go(func() { _ = leaving.PeerConnection.Close() })
+		wg.Wait()
+		close(statuses)
+
+		for status := range statuses {
+			if status != http.StatusOK && status != http.StatusNotFound {
+				t.Fatalf("DELETE answered %d, want 200 or 404", status)
+			}
+		}
+		staying.Close()
+		eventually(t, "room "+room+" emptying", func() bool {
+			return roomManager.findRoom(room) == nil
+		})
+	}
+	eventually(t, "every relay stopping", func() bool {
+		return relayStats.ActiveRelays.Load() == 0
+	})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// peerQuality is the latest inbound RTP quality sample of one peer.
+type peerQuality struct {
+	RoomID       string
+	PeerID       string
+	Jitter       float64
+	PacketsLost  int64
+	FractionLost float64
+
+	packetsReceived uint64
+}
+
+// peerQualityStore holds the samples taken by collectPeerQuality, replaced
+// wholesale on every tick so departed peers drop out of the export.
+var peerQualityStore = struct {
+	samples []peerQuality
+	mutex   sync.RWMutex
+}{}
+
+// collectPeerQuality samples every peer's inbound RTP stats each
+// config.QualityInterval, exporting at most config.QualityMaxSeries peers.
+func collectPeerQuality() {
+	previous := make(map[string]peerQuality)
+
+	ticker := time.NewTicker(config.QualityInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var samples []peerQuality
+		truncated := false
+
+		roomManager.mutex.RLock()
+		for _, room := range roomManager.rooms {
+			for _, peer := range room.otherPeers(nil) {
+				if len(samples) >= config.QualityMaxSeries {
+					truncated = true
+					break
+				}
+				samples = append(samples, samplePeerQuality(room, peer, previous[peer.ID]))
+			}
+		}
+		roomManager.mutex.RUnlock()
+
+		if truncated {
+			fmt.Printf("Peer quality export truncated to %d peers\n", config.QualityMaxSeries)
+		}
+
+		clear(previous)
+		for _, sample := range samples {
+			previous[sample.PeerID] = sample
+		}
+
+		peerQualityStore.mutex.Lock()
+		peerQualityStore.samples = samples
+		peerQualityStore.mutex.Unlock()
+	}
+}
+
+// samplePeerQuality reads the peer's inbound audio stats. Fraction lost covers
+// the interval since the previous sample.
+func samplePeerQuality(room *Room, peer *Peer, previous peerQuality) peerQuality {
+	sample := peerQuality{RoomID: room.ID, PeerID: peer.ID}
+
+	for _, stats := range peer.PeerConnection.GetStats() {
+		inbound, ok := stats.(webrtc.InboundRTPStreamStats)
+		if !ok || inbound.Kind != webrtc.RTPCodecTypeAudio.String() {
+			continue
+		}
+		sample.Jitter = inbound.Jitter
+		sample.PacketsLost = int64(inbound.PacketsLost)
+		sample.packetsReceived = uint64(inbound.PacketsReceived)
+	}
+
+	lost := sample.PacketsLost - previous.PacketsLost
+	received := int64(sample.packetsReceived) - int64(previous.packetsReceived)
+	if lost > 0 && lost+received > 0 {
+		sample.FractionLost = float64(lost) / float64(lost+received)
+	}
+	return sample
+}
+
+func prometheusHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(res, "single_whip_packets_relayed_total", "counter",
+		"Packets written to destination tracks.", float64(relayStats.PacketsRelayed.Load()))
+	writeMetric(res, "single_whip_packets_dropped_total", "counter",
+		"Packets dropped because a destination queue was full.", float64(relayStats.PacketsDropped.Load()))
+	writeMetric(res, "single_whip_packets_rate_limited_total", "counter",
+		"Packets dropped by the per-peer egress bitrate cap.", float64(relayStats.PacketsRateLimited.Load()))
+	writeMetric(res, "single_whip_write_errors_total", "counter",
+		"Failed writes to destination tracks.", float64(relayStats.WriteErrors.Load()))
+	writeMetric(res, "single_whip_packets_empty_dropped_total", "counter",
+		"Packets without payload dropped by -empty-payloads.", float64(relayStats.PacketsEmptyDropped.Load()))
+	writeMetric(res, "single_whip_active_relays", "gauge",
+		"Running relay loops, one per source track.", float64(relayStats.ActiveRelays.Load()))
+	writeMetric(res, "single_whip_goroutines", "gauge",
+		"Goroutines of the server process.", float64(runtime.NumGoroutine()))
+	if config.SimulateLoss > 0 {
+		writeMetric(res, "single_whip_packets_loss_simulated_total", "counter",
+			"Packets dropped by -simulate-loss.", float64(relayStats.PacketsLossSimulated.Load()))
+	}
+	if config.SilenceFill > 0 {
+		writeMetric(res, "single_whip_packets_silence_inserted_total", "counter",
+			"Silence packets inserted into gaps by -silence-fill.", float64(relayStats.PacketsSilenceInserted.Load()))
+	}
+	writeICEMetrics(res)
+	writeNegotiationMetrics(res)
+
+	peerQualityStore.mutex.RLock()
+	samples := peerQualityStore.samples
+	peerQualityStore.mutex.RUnlock()
+
+	writeMetricHeader(res, "single_whip_peer_inbound_jitter_seconds", "gauge", "Inbound RTP jitter per peer.")
+	for _, sample := range samples {
+		writeSample(res, "single_whip_peer_inbound_jitter_seconds", sample.Jitter, "room", sample.RoomID, "peer", sample.PeerID)
+	}
+	writeMetricHeader(res, "single_whip_peer_inbound_packets_lost", "gauge", "Cumulative inbound RTP packets lost per peer.")
+	for _, sample := range samples {
+		writeSample(res, "single_whip_peer_inbound_packets_lost", float64(sample.PacketsLost), "room", sample.RoomID, "peer", sample.PeerID)
+	}
+	writeMetricHeader(res, "single_whip_peer_inbound_fraction_lost", "gauge", "Fraction of inbound RTP packets lost per peer over the last interval.")
+	for _, sample := range samples {
+		writeSample(res, "single_whip_peer_inbound_fraction_lost", sample.FractionLost, "room", sample.RoomID, "peer", sample.PeerID)
+	}
+}
+
+func writeMetric(w io.Writer, name, metricType, help string, value float64) {
+	writeMetricHeader(w, name, metricType, help)
+	writeSample(w, name, value)
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeSample writes one sample line; labels are name/value pairs.
+func writeSample(w io.Writer, name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %g\n", name, value)
+		return
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabelValue(labels[i+1])))
+	}
+	fmt.Fprintf(w, "%s{%s} %g\n", name, strings.Join(pairs, ","), value)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metric returns the value of the /metrics sample written as sample, the
+// metric name followed by its labels if it has any. It reports false when
+// no such sample is exported.
+func metric(t *testing.T, server *testServer, sample string) (float64, bool) {
+	t.Helper()
+
+	status, body := request(t, http.MethodGet, server.URL+"/metrics", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /metrics answered %d", status)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), sample+" ")
+		if !found {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("sample %s has value %q", sample, value)
+		}
+		return parsed, true
+	}
+	return 0, false
+}
+
+func TestPrometheusRelayCounters(t *testing.T) {
+	server := startServer(t)
+	before, ok := metric(t, server, "single_whip_packets_relayed_total")
+	if !ok {
+		t.Fatal("single_whip_packets_relayed_total is not exported")
+	}
+
+	first := server.Join(t, "counted")
+	second := server.Join(t, "counted")
+	assertRelayed(t, first, second, relayTimeout)
+	if relayed, _ := metric(t, server, "single_whip_packets_relayed_total"); relayed <= before {
+		t.Errorf("single_whip_packets_relayed_total stayed at %g after relaying", relayed)
+	}
+}
+
+func TestPrometheusLabelsEscaped(t *testing.T) {
+	var out strings.Builder
+	writeSample(&out, "sample", 1, "room", "a\"b\\c\nd")
+	if want := "sample{room=\"a\\\"b\\\\c\\nd\"} 1\n"; out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestICEMetrics(t *testing.T) {
+	server := startServer(t)
+	before, _ := metric(t, server, `single_whip_ice_connected_total{candidate_type="host"}`)
+
+	first := server.Join(t, "connecting")
+	second := server.Join(t, "connecting")
+	assertRelayed(t, first, second, relayTimeout)
+
+	if connected, _ := metric(t, server, `single_whip_ice_connected_total{candidate_type="host"}`); connected < before+2 {
+		t.Errorf("single_whip_ice_connected_total counted %g host connections, want 2 more than %g", connected, before)
+	}
+	count, _ := metric(t, server, `single_whip_ice_time_to_connected_seconds_count{candidate_type="host"}`)
+	if all, _ := metric(t, server, `single_whip_ice_time_to_connected_seconds_bucket{candidate_type="host",le="+Inf"}`); all != count || count < 2 {
+		t.Errorf("time to connected histogram has %g samples in its +Inf bucket and a count of %g", all, count)
+	}
+	if _, ok := metric(t, server, "single_whip_ice_failed_total"); !ok {
+		t.Error("single_whip_ice_failed_total is not exported")
+	}
+	if status, _ := request(t, http.MethodGet, server.URL+"/metrics", nil); status != http.StatusOK {
+		t.Errorf("GET /metrics answered %d", status)
+	}
+}
+
+func TestGoroutineMetrics(t *testing.T) {
+	server := startServer(t)
+	if goroutines, ok := metric(t, server, "single_whip_goroutines"); !ok || goroutines <= 0 {
+		t.Fatalf("single_whip_goroutines is %g, exported %t", goroutines, ok)
+	}
+	if relays, _ := metric(t, server, "single_whip_active_relays"); relays != 0 {
+		t.Fatalf("%g relays running before anyone joined", relays)
+	}
+
+	first := server.Join(t, "gauge")
+	second := server.Join(t, "gauge")
+	assertRelayed(t, first, second, relayTimeout)
+	assertRelayed(t, second, first, relayTimeout)
+	if relays, _ := metric(t, server, "single_whip_active_relays"); relays != 2 {
+		t.Errorf("%g relays running for two peers, want 2", relays)
+	}
+
+	first.Close()
+	second.Close()
+	eventually(t, "the relay gauge returning to zero", func() bool {
+		relays, _ := metric(t, server, "single_whip_active_relays")
+		return relays == 0
+	})
+}
+
+// assertCumulativeHistogram checks that every series of the /metrics
+// histogram name counts cumulatively: its buckets never decrease and the
+// +Inf one equals its count. It returns the number of series.
+func assertCumulativeHistogram(t *testing.T, server *testServer, name string) int {
+	t.Helper()
+
+	_, body := request(t, http.MethodGet, server.URL+"/metrics", nil)
+	previous := make(map[string]float64)
+	infinite := make(map[string]float64)
+	counts := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		sample, value, _ := strings.Cut(line, " ")
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if labels, found := strings.CutPrefix(sample, name+"_count"); found {
+			counts[labels] = parsed
+			continue
+		}
+		labels, found := strings.CutPrefix(sample, name+"_bucket")
+		if !found {
+			continue
+		}
+		// The le label comes last, so the series is what precedes it.
+		series, le, _ := strings.Cut(labels, `le="`)
+		series = strings.TrimSuffix(series, ",") + "}"
+		if parsed < previous[series] {
+			t.Errorf("%s falls to %g, below the previous bucket's %g", line, parsed, previous[series])
+		}
+		previous[series] = parsed
+		if le == `+Inf"}` {
+			infinite[series] = parsed
+		}
+	}
+
+	for series, count := range counts {
+		if infinite[series] != count {
+			t.Errorf("%s%s +Inf bucket holds %g of %g observations", name, series, infinite[series], count)
+		}
+	}
+	return len(counts)
+}
+
+func TestHistogramsCumulative(t *testing.T) {
+	server := startServer(t)
+	first := server.Join(t, "histograms")
+	second := server.Join(t, "histograms")
+	assertRelayed(t, first, second, relayTimeout)
+
+	if series := assertCumulativeHistogram(t, server, "single_whip_negotiation_phase_seconds"); series == 0 {
+		t.Error("no negotiation phase timed after two negotiations")
+	}
+	if series := assertCumulativeHistogram(t, server, "single_whip_ice_time_to_connected_seconds"); series == 0 {
+		t.Error("no connection timed after two peers connected")
+	}
+	if count, ok := metric(t, server, `single_whip_negotiation_phase_seconds_count{endpoint="whip",phase="set_remote_description"}`); count < 2 {
+		t.Errorf("%g offers applied after two negotiations, exported %t", count, ok)
+	}
+}
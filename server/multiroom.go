@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// splitRoomIDs splits a join's room, e.g. ?room=a,b,c, into the rooms the
+// peer joins at once. The peer relays into, and hears, all of them.
+func splitRoomIDs(roomID string) ([]string, error) {
+	roomIDs := strings.Split(roomID, ",")
+	for i, id := range roomIDs {
+		if id == "" {
+			return nil, errors.New("empty room in room list")
+		}
+		if slices.Contains(roomIDs[:i], id) {
+			return nil, fmt.Errorf("room %q listed twice", id)
+		}
+	}
+	return roomIDs, nil
+}
+
+// getOrCreateRooms is getOrCreateRoom for each of roomIDs, removing the rooms
+// it created if any of them fails.
+func (rm *RoomManager) getOrCreateRooms(roomIDs []string, options RoomOptions, creator string) ([]*Room, error) {
+	rooms := make([]*Room, 0, len(roomIDs))
+	for _, roomID := range roomIDs {
+		room, err := rm.getOrCreateRoom(roomID, options, creator)
+		if err != nil {
+			rm.removeRoomsIfEmpty(rooms)
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (rm *RoomManager) removeRoomsIfEmpty(rooms []*Room) {
+	for _, room := range rooms {
+		rm.removeRoomIfEmpty(room)
+	}
+}
+
+// joinRooms adds the peer to each of its rooms, or to none if any of them
+// rejects it. A room removed since the peer looked it up is looked up
+// again, so the peer joins the room now under its ID rather than one nobody
+// else can find.
+func (p *Peer) joinRooms() error {
+	for i := 0; i < len(p.rooms); i++ {
+		room := p.rooms[i]
+		err := room.addPeer(p)
+		if errors.Is(err, errRoomRemoved) {
+			var current *Room
+			if current, err = roomManager.getOrCreateRoom(room.ID, room.Options, room.Creator); err == nil {
+				p.rooms[i] = current
+				i--
+				continue
+			}
+		}
+		if err != nil {
+			for _, joined := range p.rooms[:i] {
+				joined.removePeer(p)
+			}
+			roomManager.removeRoomsIfEmpty(p.rooms)
+			if len(p.rooms) == 1 {
+				return err
+			}
+			return fmt.Errorf("room %s: %w", room.ID, err)
+		}
+	}
+	return nil
+}
+
+// destinations returns the peers that receive what the peer sends across
+// all its rooms, each once even when it shares several rooms with the peer.
+func (p *Peer) destinations() []*Peer {
+	if len(p.rooms) == 1 {
+		return p.rooms[0].destinations(p)
+	}
+
+	var destinations []*Peer
+	for _, room := range p.rooms {
+		for _, destination := range room.destinations(p) {
+			if !slices.Contains(destinations, destination) {
+				destinations = append(destinations, destination)
+			}
+		}
+	}
+	return destinations
+}
+
+// relayDestination is a peer hearing a source and the room it hears it
+// through, whose options, e.g. transcoding, apply to the relay.
+type relayDestination struct {
+	peer *Peer
+	room *Room
+}
+
+// relayDestinations returns the peers that hear the peer's stream with ssrc
+// across its rooms, each with the first room they share with the peer that
+// relays it to them. Rooms whose AllowedSSRCs exclude the stream relay it
+// to nobody.
+func (p *Peer) relayDestinations(ssrc uint32) []relayDestination {
+	var destinations []relayDestination
+	for _, room := range p.rooms {
+		if !room.allowsSSRC(ssrc) {
+			continue
+		}
+		for _, peer := range room.destinations(p) {
+			if !slices.ContainsFunc(destinations, func(destination relayDestination) bool { return destination.peer == peer }) {
+				destinations = append(destinations, relayDestination{peer: peer, room: room})
+			}
+		}
+	}
+	return destinations
+}
+
+// relaySinks returns the sinks that hear the peer's stream with ssrc across
+// its rooms, those of rooms relaying it.
+func (p *Peer) relaySinks(ssrc uint32) []*fileSink {
+	var sinks []*fileSink
+	for _, room := range p.rooms {
+		if room.allowsSSRC(ssrc) {
+			sinks = append(sinks, room.sinksFor(p)...)
+		}
+	}
+	return sinks
+}
+
+// roomList returns the IDs of the peer's rooms as joined, e.g. a,b.
+func (p *Peer) roomList() string {
+	ids := make([]string, len(p.rooms))
+	for i, room := range p.rooms {
+		ids[i] = room.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+// listensOnly reports whether the peer is a subscriber in every room it
+// joined, so nothing it sends is relayed.
+func (p *Peer) listensOnly() bool {
+	for _, room := range p.rooms {
+		if !room.isSubscriber(p) {
+			return false
+		}
+	}
+	return true
+}
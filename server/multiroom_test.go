@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublisherInTwoRoomsFollowsEachRoomsSSRCs(t *testing.T) {
+	server := startServer(t)
+	open := server.Join(t, "open")
+	// No peer sends SSRC 1, so the room relays nobody.
+	closed := server.JoinQuery(t, url.Values{"room": {"closed"}, "ssrc": {"1"}})
+	publisher := server.Join(t, "open,closed")
+
+	assertRelayed(t, publisher, open, relayTimeout)
+	assertNotRelayed(t, publisher, closed, silenceWait)
+}
+
+func TestPublisherInTwoRoomsRecordedInEach(t *testing.T) {
+	dir := t.TempDir()
+	server := startServer(t, "-record-dir", dir)
+	first := server.Join(t, "first")
+	second := server.Join(t, "second")
+	publisher := server.Join(t, "first,second")
+
+	assertRelayed(t, publisher, first, relayTimeout)
+	assertRelayed(t, publisher, second, relayTimeout)
+	for _, room := range []string{"first", "second"} {
+		deadline := time.Now().Add(relayTimeout)
+		for {
+			files, err := filepath.Glob(filepath.Join(dir, room+"-"+publisher.ID+"-*.ogg"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("room %s has %d recordings of the publisher, want 1", room, len(files))
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The phases of a negotiation timed by the negotiation histogram.
+const (
+	// phaseBodyRead reads the offer from the request body, see readOffer.
+	phaseBodyRead = "body_read"
+	// phaseSetRemoteDescription applies the offer.
+	phaseSetRemoteDescription = "set_remote_description"
+	// phaseCreateAnswer creates the answer.
+	phaseCreateAnswer = "create_answer"
+	// phaseICEGathering runs from SetLocalDescription to gathering complete,
+	// or to -ice-gather-timeout for answers sent before. Answers streamed as
+	// candidates arrive, see candidateStream, don't wait for it and aren't
+	// counted.
+	phaseICEGathering = "ice_gathering"
+)
+
+// negotiationPhases are the phases in the order negotiations go through
+// them, which is the order they are exported in.
+var negotiationPhases = []string{phaseBodyRead, phaseSetRemoteDescription, phaseCreateAnswer, phaseICEGathering}
+
+// negotiationBuckets are the upper bounds, in seconds, of the negotiation
+// phase histogram. Phases other than gathering take milliseconds, so the
+// buckets start well below the ICE ones.
+var negotiationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// negotiationKey labels a phase histogram with the endpoint, whip or whep,
+// the negotiation came through.
+type negotiationKey struct {
+	endpoint string
+	phase    string
+}
+
+// negotiationMetrics times the phases of WHIP and WHEP negotiations.
+var negotiationMetrics = struct {
+	phases map[negotiationKey]*durationHistogram
+	mutex  sync.Mutex
+}{phases: make(map[negotiationKey]*durationHistogram)}
+
+// negotiationEndpoint returns the endpoint label of a WHIP POST: whep for
+// the -whep-path ones, whip for the rest.
+func negotiationEndpoint(req *http.Request) string {
+	if isWHEPPath(whipBasePath(req.URL.Path)) {
+		return "whep"
+	}
+	return "whip"
+}
+
+// recordNegotiationPhase counts a phase of a negotiation through the
+// request's endpoint that started at started.
+func recordNegotiationPhase(req *http.Request, phase string, started time.Time) {
+	key := negotiationKey{endpoint: negotiationEndpoint(req), phase: phase}
+	elapsed := time.Since(started)
+
+	negotiationMetrics.mutex.Lock()
+	defer negotiationMetrics.mutex.Unlock()
+
+	histogram := negotiationMetrics.phases[key]
+	if histogram == nil {
+		histogram = newDurationHistogram(negotiationBuckets)
+		negotiationMetrics.phases[key] = histogram
+	}
+	histogram.observe(elapsed)
+}
+
+func writeNegotiationMetrics(w io.Writer) {
+	negotiationMetrics.mutex.Lock()
+	defer negotiationMetrics.mutex.Unlock()
+
+	keys := make([]negotiationKey, 0, len(negotiationMetrics.phases))
+	for key := range negotiationMetrics.phases {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b negotiationKey) int {
+		if a.endpoint != b.endpoint {
+			return strings.Compare(a.endpoint, b.endpoint)
+		}
+		return slices.Index(negotiationPhases, a.phase) - slices.Index(negotiationPhases, b.phase)
+	})
+
+	writeMetricHeader(w, "single_whip_negotiation_phase_seconds", "histogram",
+		"Time spent in each phase of WHIP and WHEP negotiations, by endpoint and phase.")
+	for _, key := range keys {
+		histogram := negotiationMetrics.phases[key]
+		for i, bound := range negotiationBuckets {
+			writeSample(w, "single_whip_negotiation_phase_seconds_bucket", float64(histogram.buckets[i]),
+				"endpoint", key.endpoint, "phase", key.phase, "le", fmt.Sprint(bound))
+		}
+		writeSample(w, "single_whip_negotiation_phase_seconds_bucket", float64(histogram.count),
+			"endpoint", key.endpoint, "phase", key.phase, "le", "+Inf")
+		writeSample(w, "single_whip_negotiation_phase_seconds_sum", histogram.sum, "endpoint", key.endpoint, "phase", key.phase)
+		writeSample(w, "single_whip_negotiation_phase_seconds_count", float64(histogram.count), "endpoint", key.endpoint, "phase", key.phase)
+	}
+}
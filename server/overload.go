@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Reasons of overload responses, see writeOverloaded.
+const (
+	overloadNegotiations = "too_many_negotiations"
+	overloadMaxRooms     = "max_rooms"
+	overloadRoomFull     = "room_full"
+	overloadRoomDraining = "room_draining"
+)
+
+// overloadReason returns the reason of a join rejected for capacity, or false
+// when err isn't one.
+func overloadReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errTooManyNegotiations):
+		return overloadNegotiations, true
+	case errors.Is(err, errMaxRooms):
+		return overloadMaxRooms, true
+	case errors.Is(err, errRoomFull):
+		return overloadRoomFull, true
+	case errors.Is(err, errRoomDraining):
+		return overloadRoomDraining, true
+	default:
+		return "", false
+	}
+}
+
+// writeOverloaded answers a join the server has no capacity for with 503, a
+// Retry-After of config.RetryAfter and a JSON body naming the reason, e.g.
+// {"error": "room is full", "reason": "room_full"}, whatever the request
+// accepts, so clients can back off the same way in every case. Other errors
+// get a plain 503.
+func writeOverloaded(res http.ResponseWriter, req *http.Request, err error) {
+	reason, ok := overloadReason(err)
+	if !ok {
+		writeError(res, req, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if config.RetryAfter > 0 {
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(config.RetryAfter.Seconds()))))
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusServiceUnavailable)
+	encoder := json.NewEncoder(res)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(errorBody{Error: err.Error(), Reason: reason}); err != nil {
+		fmt.Printf("Error writing error response: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestOverloadResponses(t *testing.T) {
+	server := startServer(t, "-max-rooms", "1", "-max-negotiations", "1", "-retry-after", "2s", "-admin-token", adminToken)
+	server.Join(t, "open")
+	_, offer := createOffer(t, addAudio)
+
+	tests := []struct {
+		name   string
+		room   string
+		setup  func() func()
+		reason string
+	}{
+		{"max rooms", "other", nil, overloadMaxRooms},
+		{"room full", "open", func() func() {
+			server.Join(t, "open")
+			return nil
+		}, overloadRoomFull},
+		{"room draining", "open", func() func() {
+			if status, body := request(t, http.MethodPost, server.URL+"/rooms/open/drain", nil); status != http.StatusOK {
+				t.Fatalf("drain answered %d: %s", status, body)
+			}
+			return nil
+		}, overloadRoomDraining},
+		{"negotiation slots taken", "open", func() func() {
+			negotiationSlots <- struct{}{}
+			return func() { <-negotiationSlots }
+		}, overloadNegotiations},
+	}
+	for _, test := range tests {
+		if test.setup != nil {
+			if cleanup := test.setup(); cleanup != nil {
+				defer cleanup()
+			}
+		}
+
+		// The JSON body doesn't depend on what the client accepts.
+		res, body := postOffer(t, server.URL+"/whip?room="+test.room, offer, http.Header{"Accept": {"text/plain"}})
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: status %d, want 503", test.name, res.StatusCode)
+		}
+		if retryAfter := res.Header.Get("Retry-After"); retryAfter != "2" {
+			t.Errorf("%s: Retry-After %q, want 2", test.name, retryAfter)
+		}
+		if contentType := res.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: Content-Type %q, want application/json", test.name, contentType)
+		}
+		var overloaded errorBody
+		if err := json.Unmarshal([]byte(body), &overloaded); err != nil {
+			t.Errorf("%s: body %q isn't JSON: %s", test.name, body, err.Error())
+			continue
+		}
+		if overloaded.Reason != test.reason || overloaded.Error == "" {
+			t.Errorf("%s: body %+v, want reason %s and an error", test.name, overloaded, test.reason)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// whipQueryParameters are the query parameters WHIP POSTs understand: the
+// room, see roomIDFromRequest, and its options, see parseRoomOptions.
+var whipQueryParameters = []string{"room", "topology", "mode", "remb", "bandwidth", "transcode", "ssrc", "duplex", "codecs"}
+
+// checkQueryParameters rejects, with -strict-params, query parameters no
+// WHIP POST understands, such as typos like ?rooom=, which otherwise go
+// unnoticed as the server falls back to defaults.
+func checkQueryParameters(query url.Values) error {
+	if !config.StrictParams {
+		return nil
+	}
+
+	var unknown []string
+	for key := range query {
+		if !slices.Contains(whipQueryParameters, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("unknown query parameters: %s (known: %s)", strings.Join(unknown, ", "), strings.Join(whipQueryParameters, ", "))
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStrictParams(t *testing.T) {
+	server := startServer(t, "-strict-params")
+	_, offer := createOffer(t, addAudio)
+
+	res, body := postOffer(t, server.URL+"/whip?room=typo&rooom=typo&codec=opus", offer, nil)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown parameters answered %d, want 400", res.StatusCode)
+	}
+	if !strings.Contains(body, "codec, rooom") {
+		t.Errorf("error %q doesn't list the unknown parameters", body)
+	}
+	if roomManager.findRoom("typo") != nil {
+		t.Error("rejected POST created its room")
+	}
+
+	joinAt(t, server.URL+"/whip?room=typo&topology=broadcast")
+}
+
+func TestLenientParams(t *testing.T) {
+	server := startServer(t)
+
+	joinAt(t, server.URL+"/whip?room=typo&rooom=typo")
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// roomPeerPLIHandler serves POST /rooms/<id>/peers/<peerID>/pli, sending the
+// peer a Picture Loss Indication for each video track it publishes, so it
+// sends a keyframe, e.g. when a subscriber's video is corrupted. The server
+// registers no video codecs and rejects offered video, so for now every peer
+// gets 404 for having no video; the endpoint is there for when it relays
+// video.
+func roomPeerPLIHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	peerID := req.PathValue("peerID")
+	var peer *Peer
+	for _, member := range room.otherPeers(nil) {
+		if member.ID == peerID {
+			peer = member
+		}
+	}
+	if peer == nil {
+		writeError(res, req, "peer not found", http.StatusNotFound)
+		return
+	}
+
+	var packets []rtcp.Packet
+	for _, transceiver := range peer.PeerConnection.GetTransceivers() {
+		if transceiver.Kind() != webrtc.RTPCodecTypeVideo || transceiver.Receiver() == nil {
+			continue
+		}
+		for _, track := range transceiver.Receiver().Tracks() {
+			packets = append(packets, &rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())})
+		}
+	}
+	if len(packets) == 0 {
+		writeError(res, req, "peer has no video", http.StatusNotFound)
+		return
+	}
+
+	if err := peer.PeerConnection.WriteRTCP(packets); err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Sent PLI to peer %s in room %s\n", peer.ID, room.ID)
+	res.WriteHeader(http.StatusNoContent)
+}
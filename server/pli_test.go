@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPeerPLI(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	peer := server.Join(t, "keyframes")
+	pli := server.URL + "/rooms/keyframes/peers/" + peer.ID + "/pli"
+
+	if status := statusWithToken(t, http.MethodPost, pli, ""); status != http.StatusUnauthorized {
+		t.Errorf("PLI without the admin token answered %d, want 401", status)
+	}
+	if status, _ := request(t, http.MethodGet, pli, nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET answered %d, want 405", status)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unknown room", server.URL + "/rooms/missing/peers/" + peer.ID + "/pli", "room not found"},
+		{"unknown peer", server.URL + "/rooms/keyframes/peers/missing/pli", "peer not found"},
+		// The server negotiates audio only, so no peer has video to send a
+		// keyframe of.
+		{"audio-only peer", pli, "peer has no video"},
+	}
+	for _, test := range tests {
+		status, body := request(t, http.MethodPost, test.url, nil)
+		if status != http.StatusNotFound || !strings.Contains(body, test.want) {
+			t.Errorf("%s: PLI answered %d: %s, want 404: %s", test.name, status, body, test.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// egressBurst is how much traffic at the -max-egress-bitrate rate the
+	// token bucket lets through at once, absorbing packetization jitter.
+	egressBurst = 250 * time.Millisecond
+	// minEgressBurstBytes keeps very low caps from dropping every packet.
+	minEgressBurstBytes = 1500
+	// egressDropLogInterval is how often a rate-limited peer's drops are
+	// logged.
+	egressDropLogInterval = 10 * time.Second
+)
+
+// tokenBucket caps a peer's egress in bytes per second. It is used only by
+// the peer's writeLoop, so it needs no locking.
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+
+	dropped    uint64
+	lastReport time.Time
+}
+
+// newTokenBucket returns a full bucket for bitrate bits per second, or nil
+// when bitrate is zero.
+func newTokenBucket(bitrate uint64) *tokenBucket {
+	if bitrate == 0 {
+		return nil
+	}
+
+	rate := float64(bitrate) / 8
+	capacity := max(rate*egressBurst.Seconds(), minEgressBurstBytes)
+	now := time.Now()
+	return &tokenBucket{rate: rate, capacity: capacity, tokens: capacity, last: now, lastReport: now}
+}
+
+// allow takes size bytes from the bucket, reporting false when the packet
+// would exceed the cap and must be dropped.
+func (b *tokenBucket) allow(size int, now time.Time) bool {
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < float64(size) {
+		b.dropped++
+		return false
+	}
+	b.tokens -= float64(size)
+	return true
+}
+
+// logDrops periodically logs how many packets to peerID the cap dropped.
+func (b *tokenBucket) logDrops(peerID string, now time.Time) {
+	elapsed := now.Sub(b.lastReport)
+	if elapsed < egressDropLogInterval {
+		return
+	}
+
+	if b.dropped > 0 {
+		fmt.Printf("Egress cap dropped %d packets to peer %s in %s (%.1f/s)\n",
+			b.dropped, peerID, elapsed.Round(time.Second), float64(b.dropped)/elapsed.Seconds())
+	}
+	b.dropped = 0
+	b.lastReport = now
+}
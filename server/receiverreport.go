@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// receiverFeedbackTTL is how long a destination's latest Receiver Report
+// counts toward the reports relayed to its sources.
+const receiverFeedbackTTL = 3 * reportInterval
+
+// receiverFeedback is the latest Receiver Report a peer sent about its relay
+// track.
+type receiverFeedback struct {
+	fractionLost uint8
+	// jitter is in seconds, as the track's clock rate depends on its codec.
+	jitter float64
+	at     time.Time
+}
+
+// readReceiverReports reads the RTCP peers send about their relay track until
+// the sender stops, keeping the latest Receiver Report for
+// relayReceiverReports. Reading also feeds the sender's interceptors, e.g.
+// answering NACKs.
+func readReceiverReports(peer *Peer) {
+	for {
+		packets, _, err := peer.AudioSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		ssrc, ok := senderSSRC(peer.AudioSender)
+		if !ok {
+			continue
+		}
+		clockRate, _ := relayClockRate(peer)
+
+		for _, packet := range packets {
+			rr, ok := packet.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+			for _, report := range rr.Reports {
+				if report.SSRC != ssrc {
+					continue
+				}
+
+				feedback := receiverFeedback{fractionLost: report.FractionLost, at: time.Now()}
+				if clockRate > 0 {
+					feedback.jitter = float64(report.Jitter) / float64(clockRate)
+				}
+				peer.mutex.Lock()
+				peer.feedback = feedback
+				peer.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// relayReceiverReports periodically sends the source a Receiver Report for
+// track with the worst loss and jitter its destinations report, until done is
+// closed, so it can adapt, e.g. raise Opus FEC, to what listeners hear rather
+// than only to its uplink, which pion's own Receiver Reports keep covering.
+// In mesh rooms a destination's report covers everything relayed to it and
+// counts toward each of those sources. With -fec-adaptation the worst loss
+// also feeds the source's fecAdaptation.
+func relayReceiverReports(source *Peer, track *webrtc.TrackRemote, done <-chan struct{}) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	var adaptation *fecAdaptation
+	if config.FECAdaptation && isOpusFamily(strings.ToLower(track.Codec().MimeType)) {
+		adaptation = &fecAdaptation{source: source}
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			var worst receiverFeedback
+			for _, destination := range source.destinations() {
+				if destination = source.relayTarget(track, destination); destination == nil {
+					continue
+				}
+				destination.mutex.Lock()
+				feedback := destination.feedback
+				destination.mutex.Unlock()
+
+				if now.Sub(feedback.at) > receiverFeedbackTTL {
+					continue
+				}
+				worst.fractionLost = max(worst.fractionLost, feedback.fractionLost)
+				worst.jitter = max(worst.jitter, feedback.jitter)
+				worst.at = now
+			}
+			if worst.at.IsZero() {
+				continue
+			}
+			if adaptation != nil {
+				adaptation.update(worst.fractionLost)
+			}
+
+			var reporter uint32
+			if source.AudioSender != nil {
+				reporter, _ = senderSSRC(source.AudioSender)
+			}
+			if err := source.PeerConnection.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverReport{
+				SSRC: reporter,
+				Reports: []rtcp.ReceptionReport{{
+					SSRC:         uint32(track.SSRC()),
+					FractionLost: worst.fractionLost,
+					Jitter:       uint32(worst.jitter * float64(trackClockRate(track))),
+				}},
+			}}); err != nil {
+				fmt.Printf("Error relaying receiver reports to peer %s: %s\n", source.ID, err.Error())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestReceiverReportRelayed(t *testing.T) {
+	server := startServer(t)
+	publisherID, publisher := joinSendingCodec(t, server.URL, "reports", audioCodecs[0])
+
+	// The subscriber runs no interceptors, so the only reports it sends
+	// are the test's.
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(audioCodecs[0], webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(&interceptor.Registry{}))
+	subscriber, offer := createOfferWith(t, api, func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		return err
+	})
+	ssrcs := make(chan uint32, 1)
+	subscriber.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		ssrcs <- uint32(track.SSRC())
+		drainTrack(track)
+	})
+	answerOffer(t, server.URL, "reports", subscriber, offer)
+
+	var relaySSRC uint32
+	select {
+	case relaySSRC = <-ssrcs:
+	case <-time.After(relayTimeout):
+		t.Fatal("subscriber heard nothing")
+	}
+	publisherSSRC := uint32(publisher.GetSenders()[0].GetParameters().Encodings[0].SSRC)
+
+	// The subscriber reports losing a quarter of its relay track.
+	const fractionLost = 64
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = subscriber.WriteRTCP([]rtcp.Packet{&rtcp.ReceiverReport{
+					SSRC:    1,
+					Reports: []rtcp.ReceptionReport{{SSRC: relaySSRC, FractionLost: fractionLost, Jitter: 480}},
+				}})
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var subscriberPeer *Peer
+	for _, peer := range roomManager.findRoom("reports").otherPeers(nil) {
+		if peer.ID != publisherID {
+			subscriberPeer = peer
+		}
+	}
+	eventually(t, "the subscriber's report being read", func() bool {
+		subscriberPeer.mutex.Lock()
+		defer subscriberPeer.mutex.Unlock()
+		return subscriberPeer.feedback.fractionLost == fractionLost
+	})
+	subscriberPeer.mutex.Lock()
+	jitter := subscriberPeer.feedback.jitter
+	subscriberPeer.mutex.Unlock()
+	if jitter != 0.01 {
+		t.Errorf("read jitter %gs, want 480 ticks at 48kHz, 0.01s", jitter)
+	}
+
+	// The publisher hears it within a report interval.
+	reports := make(chan rtcp.ReceptionReport, 10)
+	go func() {
+		for {
+			received, _, err := publisher.GetSenders()[0].ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range received {
+				if rr, ok := packet.(*rtcp.ReceiverReport); ok {
+					for _, report := range rr.Reports {
+						select {
+						case reports <- report:
+						default:
+						}
+					}
+				}
+			}
+		}
+	}()
+	deadline := time.After(2 * reportInterval)
+	for {
+		select {
+		case report := <-reports:
+			if report.SSRC == publisherSSRC && report.FractionLost == fractionLost {
+				if report.Jitter != 480 {
+					t.Errorf("relayed jitter %d, want 480", report.Jitter)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("publisher got no report of its subscriber's loss")
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"github.com/pion/webrtc/v4/pkg/media/rtpdump"
+)
+
+// Recording formats accepted by -record-format.
+const (
+	recordFormatOgg     = "ogg"
+	recordFormatRTPDump = "rtpdump"
+	recordFormatWAV     = "wav"
+)
+
+// RelayRecorder receives every packet a source relays, in arrival order.
+type RelayRecorder interface {
+	WriteRTP(pkt *rtp.Packet) error
+	// Close finalizes the recording.
+	Close() error
+}
+
+// validateRecordFormat reports whether format can be recorded by this build.
+func validateRecordFormat(format string) error {
+	switch format {
+	case recordFormatOgg, recordFormatRTPDump, recordFormatWAV:
+		return nil
+	default:
+		return fmt.Errorf("unknown record format %q", format)
+	}
+}
+
+// recordRTP writes pkt to recorder, unwrapping RED so recordings always
+// hold plain Opus.
+func recordRTP(recorder RelayRecorder, pkt *rtp.Packet, red bool) error {
+	if !red {
+		return recorder.WriteRTP(pkt)
+	}
+
+	primary, err := redPrimary(pkt.Payload)
+	if err != nil {
+		return err
+	}
+	return recorder.WriteRTP(&rtp.Packet{Header: pkt.Header, Payload: primary})
+}
+
+// trackRecorder records one source track while its room is recording. It
+// follows the room as recording is toggled: each start opens a new file and
+// each stop finalizes it. Audio starts recording with the next packet; there
+// is no keyframe to wait for.
+type trackRecorder struct {
+	room   *Room
+	source *Peer
+	active RelayRecorder
+	path   string
+	mutex  sync.Mutex
+}
+
+// recordsCodec reports whether config.RecordFormat can hold a track of
+// mimeType: rtpdump keeps any RTP, while Ogg and WAV recordings hold Opus,
+// which RED's primary encoding is too.
+func recordsCodec(mimeType string) bool {
+	return config.RecordFormat == recordFormatRTPDump || isOpusFamily(strings.ToLower(mimeType))
+}
+
+// startRecordings returns a recorder for a new track of source, of
+// mimeType, in each of its rooms, see startRecording, none when recording
+// is not configured or the format can't hold the track's codec.
+func startRecordings(source *Peer, mimeType string) []*trackRecorder {
+	if config.RecordDir == "" {
+		return nil
+	}
+	if !recordsCodec(mimeType) {
+		fmt.Printf("Not recording %s from peer %s, %s recordings hold Opus only\n", mimeType, source.ID, config.RecordFormat)
+		return nil
+	}
+
+	recorders := make([]*trackRecorder, 0, len(source.rooms))
+	for _, room := range source.rooms {
+		recorders = append(recorders, startRecording(room, source))
+	}
+	return recorders
+}
+
+// startRecording returns the recorder for a new track of source in room,
+// already recording if the room is, or nil when recording is not
+// configured.
+func startRecording(room *Room, source *Peer) *trackRecorder {
+	if config.RecordDir == "" {
+		return nil
+	}
+
+	recorder := &trackRecorder{room: room, source: source}
+	source.mutex.Lock()
+	source.recorders = append(source.recorders, recorder)
+	source.mutex.Unlock()
+
+	if room.isRecording() {
+		if _, err := recorder.start(); err != nil {
+			fmt.Printf("Error starting recording for peer %s: %s\n", source.ID, err.Error())
+		}
+	}
+	return recorder
+}
+
+// start opens a new recording unless one is active and returns its path.
+func (r *trackRecorder) start() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.active != nil {
+		return r.path, nil
+	}
+
+	active, path, err := newRelayRecorder(r.room, r.source)
+	if err != nil {
+		return "", err
+	}
+	r.active, r.path = active, path
+	fmt.Printf("Recording peer %s to %s\n", r.source.ID, path)
+	return path, nil
+}
+
+// stop finalizes the active recording, if any, and returns its path.
+func (r *trackRecorder) stop() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.active == nil {
+		return "", nil
+	}
+
+	err := r.active.Close()
+	r.active = nil
+	return r.path, err
+}
+
+func (r *trackRecorder) WriteRTP(pkt *rtp.Packet) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.active == nil {
+		return nil
+	}
+	return r.active.WriteRTP(pkt)
+}
+
+func (r *trackRecorder) Close() error {
+	_, err := r.stop()
+	return err
+}
+
+// closeRecorders finalizes the peer's recordings.
+func (p *Peer) closeRecorders() {
+	p.mutex.Lock()
+	recorders := p.recorders
+	p.recorders = nil
+	p.mutex.Unlock()
+
+	for _, recorder := range recorders {
+		if err := recorder.Close(); err != nil {
+			fmt.Printf("Error finalizing recording for peer %s: %s\n", p.ID, err.Error())
+		}
+	}
+}
+
+// isRecording reports whether the room's sources are being recorded.
+func (r *Room) isRecording() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.recording
+}
+
+// setRecording starts or stops recording every source in the room and
+// returns the paths of the files being written or just finalized.
+func (r *Room) setRecording(enabled bool) ([]string, error) {
+	r.mutex.Lock()
+	r.recording = enabled
+	peers := slices.Clone(r.Peers)
+	r.mutex.Unlock()
+
+	paths := []string{}
+	var firstErr error
+	for _, peer := range peers {
+		peer.mutex.Lock()
+		recorders := slices.Clone(peer.recorders)
+		peer.mutex.Unlock()
+
+		for _, recorder := range recorders {
+			if recorder.room != r {
+				continue
+			}
+			var path string
+			var err error
+			if enabled {
+				path, err = recorder.start()
+			} else {
+				path, err = recorder.stop()
+			}
+			if err != nil {
+				fmt.Printf("Error toggling recording for peer %s: %s\n", peer.ID, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, firstErr
+}
+
+// newRelayRecorder creates a recorder writing the source's relayed audio to a
+// new file in config.RecordDir and returns it with the file's path.
+func newRelayRecorder(room *Room, source *Peer) (RelayRecorder, string, error) {
+	name := fmt.Sprintf("%s-%s-%s.%s", room.ID, source.ID, time.Now().UTC().Format("20060102T150405.000Z"), config.RecordFormat)
+	path := filepath.Join(config.RecordDir, filepath.Base(name))
+
+	recorder, err := newRecorder(path)
+	return recorder, path, err
+}
+
+// newRecorder creates a recorder writing to path in config.RecordFormat.
+func newRecorder(path string) (RelayRecorder, error) {
+	switch config.RecordFormat {
+	case recordFormatOgg:
+		return oggwriter.New(path, 48000, 2)
+	case recordFormatRTPDump:
+		return newRTPDumpRecorder(path)
+	case recordFormatWAV:
+		return newWAVRecorder(path)
+	default:
+		return nil, validateRecordFormat(config.RecordFormat)
+	}
+}
+
+// rtpDumpRecorder writes raw RTP in the rtpdump format read by rtpplay and
+// Wireshark.
+type rtpDumpRecorder struct {
+	file   *os.File
+	writer *rtpdump.Writer
+	start  time.Time
+}
+
+func newRTPDumpRecorder(path string) (*rtpDumpRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	writer, err := rtpdump.NewWriter(file, rtpdump.Header{Start: start, Source: net.IPv4zero})
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &rtpDumpRecorder{file: file, writer: writer, start: start}, nil
+}
+
+func (r *rtpDumpRecorder) WriteRTP(pkt *rtp.Packet) error {
+	payload, err := pkt.Marshal()
+	if err != nil {
+		return err
+	}
+	return r.writer.WritePacket(rtpdump.Packet{Offset: time.Since(r.start), Payload: payload})
+}
+
+func (r *rtpDumpRecorder) Close() error {
+	return r.file.Close()
+}
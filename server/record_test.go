@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestRecordDir(t *testing.T) {
+	for _, format := range []string{recordFormatOgg, recordFormatRTPDump, recordFormatWAV} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			server := startServer(t, "-record-dir", dir, "-record-format", format)
+			alice := server.Join(t, "recorded")
+			bob := server.Join(t, "recorded")
+			assertRelayed(t, alice, bob, relayTimeout)
+			assertRelayed(t, bob, alice, relayTimeout)
+			alice.Close()
+			bob.Close()
+
+			eventually(t, "both recordings being written", func() bool {
+				entries, err := os.ReadDir(dir)
+				if err != nil || len(entries) != 2 {
+					return false
+				}
+				for _, entry := range entries {
+					info, err := entry.Info()
+					if err != nil || info.Size() == 0 || !strings.HasPrefix(entry.Name(), "recorded-") || filepath.Ext(entry.Name()) != "."+format {
+						return false
+					}
+				}
+				return true
+			})
+		})
+	}
+}
+
+// setRoomRecording POSTs enabled to the room's record endpoint and returns
+// the files reported.
+func setRoomRecording(t *testing.T, serverURL, room string, enabled bool) []string {
+	t.Helper()
+
+	body := `{"enabled": false}`
+	if enabled {
+		body = `{"enabled": true}`
+	}
+	status, response := request(t, http.MethodPost, serverURL+"/rooms/"+room+"/record", strings.NewReader(body))
+	if status != http.StatusOK {
+		t.Fatalf("record POST answered %d: %s", status, response)
+	}
+	var recording recordingStatus
+	if err := json.Unmarshal([]byte(response), &recording); err != nil {
+		t.Fatal(err)
+	}
+	if recording.Enabled != enabled {
+		t.Errorf("record POST answered enabled=%t, want %t", recording.Enabled, enabled)
+	}
+	return recording.Files
+}
+
+// waitForMedia waits until the server received media from the peer with ID
+// id, by when its track's recorders exist.
+func waitForMedia(t *testing.T, id string) {
+	t.Helper()
+
+	eventually(t, "media from peer "+id, func() bool {
+		peer := roomManager.findPeer(id)
+		return peer != nil && peer.bytesReceived.Load() > 0
+	})
+}
+
+// joinSendingCodec joins a peer sending in codec to room and returns its ID
+// and connection. The peer negotiates Opus after codec, for the relay it
+// receives.
+func joinSendingCodec(t *testing.T, serverURL, room string, codec webrtc.RTPCodecParameters) (string, *webrtc.PeerConnection) {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, negotiated := range []webrtc.RTPCodecParameters{codec, audioCodecs[0]} {
+		if err := mediaEngine.RegisterCodec(negotiated, webrtc.RTPCodecTypeAudio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec.RTPCodecCapability, "audio", "codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerConnection, offer := createOfferWith(t, webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)), func(peerConnection *webrtc.PeerConnection) error {
+		_, err := peerConnection.AddTrack(track)
+		return err
+	})
+	res, answer := postOffer(t, serverURL+"/whip?room="+room, offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: make([]byte, 160), Duration: 20 * time.Millisecond})
+			case <-done:
+				return
+			}
+		}
+	}()
+	return path.Base(res.Header.Get("Location")), peerConnection
+}
+
+func TestRecordStartStop(t *testing.T) {
+	dir := t.TempDir()
+	server := startServer(t, "-admin-token", adminToken, "-record-dir", dir, "-record-on-join=false")
+	first := server.Join(t, "taped")
+	second := server.Join(t, "taped")
+	waitForMedia(t, first.ID)
+	waitForMedia(t, second.ID)
+
+	if status := statusWithToken(t, http.MethodPost, server.URL+"/rooms/taped/record", ""); status != http.StatusUnauthorized {
+		t.Errorf("record POST without the admin token answered %d, want 401", status)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("recorded %d files before recording started", len(entries))
+	}
+
+	started := setRoomRecording(t, server.URL, "taped", true)
+	if len(started) != 2 {
+		t.Fatalf("recording started %d files, want one per source: %v", len(started), started)
+	}
+	time.Sleep(silenceWait)
+	stopped := setRoomRecording(t, server.URL, "taped", false)
+	if strings.Join(stopped, ",") != strings.Join(started, ",") {
+		t.Errorf("stopping finalized %v, want %v", stopped, started)
+	}
+
+	for _, file := range stopped {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), "OggS") || len(data) < 1000 {
+			t.Errorf("%s holds %d bytes, not a second of Ogg audio", file, len(data))
+		}
+		size := len(data)
+		time.Sleep(100 * time.Millisecond)
+		if info, err := os.Stat(file); err != nil || info.Size() != int64(size) {
+			t.Errorf("%s still grows after recording stopped", file)
+		}
+	}
+}
+
+func TestRecordingNonOpusSource(t *testing.T) {
+	for _, test := range []struct {
+		format string
+		files  int
+	}{
+		{recordFormatOgg, 1},
+		{recordFormatWAV, 1},
+		{recordFormatRTPDump, 2},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			server := startServer(t, "-admin-token", adminToken, "-record-dir", t.TempDir(), "-record-format", test.format, "-record-on-join=false")
+			opus := server.Join(t, "mixed")
+			pcmu, _ := joinSendingCodec(t, server.URL, "mixed", audioCodecs[2])
+			waitForMedia(t, opus.ID)
+			waitForMedia(t, pcmu)
+
+			files := setRoomRecording(t, server.URL, "mixed", true)
+			if len(files) != test.files {
+				t.Fatalf("recording started %v, want %d files", files, test.files)
+			}
+			if test.files == 1 && !strings.Contains(files[0], opus.ID) {
+				t.Errorf("recording %s isn't the Opus source's", files[0])
+			}
+			setRoomRecording(t, server.URL, "mixed", false)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// mimeTypeRED is RFC 2198 redundant audio. With -red, peers that offer it
+// receive their relay track as RED, which carries each Opus frame again in
+// the following packet so a single lost packet costs no audio. The price is
+// bandwidth: with the usual redundancy distance of one, relayed audio takes
+// roughly twice the bitrate towards those peers.
+const mimeTypeRED = "audio/red"
+
+// redCodec is registered alongside the default codecs when -red is set. The
+// fmtp line names the Opus payload type of the redundant blocks.
+var redCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		MimeType:    mimeTypeRED,
+		ClockRate:   48000,
+		Channels:    2,
+		SDPFmtpLine: "111/111",
+	},
+	PayloadType: 63,
+}
+
+var errInvalidRED = errors.New("invalid RED payload")
+
+// redHeaderSize and redPrimaryHeaderSize are the RFC 2198 block header sizes
+// of a redundant block and of the final (primary) block.
+const (
+	redHeaderSize        = 4
+	redPrimaryHeaderSize = 1
+)
+
+// relayCodec picks the codec of a peer's relay track from what the offer
+// lists: RED when enabled and offered, else the first of Opus, PCMU and PCMA
+// in -codec-preference order, else Opus, skipping codecs not among allowed,
+// see allowedCodecs. G.711-only peers hear Opus sources only in transcoding
+// rooms.
+func relayCodec(offer *sdp.SessionDescription, allowed []string) webrtc.RTPCodecCapability {
+	if config.RED && codecAllowed(allowed, "red") && offerHasCodec(offer, "audio", "red") {
+		return redCodec.RTPCodecCapability
+	}
+
+	candidates := []webrtc.RTPCodecCapability{
+		{MimeType: webrtc.MimeTypeOpus},
+		{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+		{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+	}
+	slices.SortStableFunc(candidates, func(a, b webrtc.RTPCodecCapability) int {
+		return codecRank(a.MimeType) - codecRank(b.MimeType)
+	})
+	for _, candidate := range candidates {
+		_, encoding, _ := strings.Cut(candidate.MimeType, "/")
+		if codecAllowed(allowed, encoding) && offerHasCodec(offer, "audio", encoding) {
+			return candidate
+		}
+	}
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}
+}
+
+// staticPayloadTypes are the RFC 3551 payload types an offer may list
+// without an rtpmap.
+var staticPayloadTypes = map[string]string{"pcmu": "0", "pcma": "8", "g722": "9"}
+
+// offerHasCodec reports whether a mediaType section of the offer lists
+// encoding in an rtpmap, e.g. "red" in "63 red/48000/2", or by its static
+// payload type.
+func offerHasCodec(offer *sdp.SessionDescription, mediaType, encoding string) bool {
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Media == mediaType && mediaHasCodec(media, encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaHasCodec reports whether the media section lists encoding, see
+// offerHasCodec.
+func mediaHasCodec(media *sdp.MediaDescription, encoding string) bool {
+	staticType, hasStaticType := staticPayloadTypes[strings.ToLower(encoding)]
+	if hasStaticType && slices.Contains(media.MediaName.Formats, staticType) {
+		return true
+	}
+	for _, attribute := range media.Attributes {
+		if attribute.Key != "rtpmap" {
+			continue
+		}
+		_, codec, ok := strings.Cut(attribute.Value, " ")
+		if ok && strings.EqualFold(strings.SplitN(codec, "/", 2)[0], encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadTypes returns the negotiated payload types by lowercase MIME type.
+func payloadTypes(codecs []webrtc.RTPCodecParameters) map[string]webrtc.PayloadType {
+	types := make(map[string]webrtc.PayloadType, len(codecs))
+	for _, codec := range codecs {
+		types[strings.ToLower(codec.MimeType)] = codec.PayloadType
+	}
+	return types
+}
+
+// convertRED adapts a relayed payload between a source and a destination
+// that may each use RED or plain Opus. RED stays RED with the inner block
+// payload types rewritten to the destination's Opus payload type; RED to
+// Opus keeps only the primary block; Opus to RED wraps the frame as a lone
+// primary block.
+func convertRED(payload []byte, sourceRED, destinationRED bool, destinationOpus webrtc.PayloadType) ([]byte, error) {
+	switch {
+	case sourceRED && destinationRED:
+		return rewriteREDPayloadTypes(payload, uint8(destinationOpus))
+	case sourceRED:
+		return redPrimary(payload)
+	case destinationRED:
+		return append([]byte{uint8(destinationOpus) & 0x7f}, payload...), nil
+	default:
+		return payload, nil
+	}
+}
+
+// redHeaderLength returns the length of the block headers at the start of
+// payload and the total length of its redundant blocks.
+func redHeaderLength(payload []byte) (headers, redundant int, err error) {
+	for {
+		if headers >= len(payload) {
+			return 0, 0, errInvalidRED
+		}
+		if payload[headers]&0x80 == 0 {
+			headers += redPrimaryHeaderSize
+			break
+		}
+		if headers+redHeaderSize > len(payload) {
+			return 0, 0, errInvalidRED
+		}
+		redundant += int(payload[headers+2]&0x03)<<8 | int(payload[headers+3])
+		headers += redHeaderSize
+	}
+	if headers+redundant > len(payload) {
+		return 0, 0, errInvalidRED
+	}
+	return headers, redundant, nil
+}
+
+// redPrimary returns the primary block of a RED payload.
+func redPrimary(payload []byte) ([]byte, error) {
+	headers, redundant, err := redHeaderLength(payload)
+	if err != nil {
+		return nil, err
+	}
+	return payload[headers+redundant:], nil
+}
+
+// rewriteREDPayloadTypes returns a copy of a RED payload with every block
+// header naming payloadType.
+func rewriteREDPayloadTypes(payload []byte, payloadType uint8) ([]byte, error) {
+	headers, _, err := redHeaderLength(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := append([]byte(nil), payload...)
+	for i := 0; i < headers; {
+		rewritten[i] = rewritten[i]&0x80 | payloadType&0x7f
+		if rewritten[i]&0x80 == 0 {
+			break
+		}
+		i += redHeaderSize
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// redOffer returns an audio offer listing RED ahead of Opus.
+func redOffer(t *testing.T) string {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	for _, codec := range []webrtc.RTPCodecParameters{redCodec, {
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}} {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, offer := createOfferWith(t, webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)), addAudio)
+	return offer
+}
+
+func TestREDNegotiated(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		server := startServer(t, "-red="+strconv.FormatBool(enabled))
+		res, answer := postOffer(t, server.URL+"/whip?room=red", redOffer(t), nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+		}
+		if hasRED := strings.Contains(answer, " red/48000/2"); hasRED != enabled {
+			t.Errorf("with -red=%t the answer negotiates RED: %t\n%s", enabled, hasRED, answer)
+		}
+	}
+}
+
+func TestConvertRED(t *testing.T) {
+	// A 2-byte redundant block "ol" and the primary block "new", both
+	// with payload type 100.
+	red := []byte{0x80 | 100, 0, 0, 2, 100, 'o', 'l', 'n', 'e', 'w'}
+	tests := []struct {
+		name                      string
+		payload                   []byte
+		sourceRED, destinationRED bool
+		want                      []byte
+	}{
+		{"opus to opus", []byte("frame"), false, false, []byte("frame")},
+		{"red to opus", red, true, false, []byte("new")},
+		{"opus to red", []byte("frame"), false, true, append([]byte{111}, "frame"...)},
+		{"red to red", red, true, true, []byte{0x80 | 111, 0, 0, 2, 111, 'o', 'l', 'n', 'e', 'w'}},
+	}
+	for _, test := range tests {
+		got, err := convertRED(test.payload, test.sourceRED, test.destinationRED, 111)
+		if err != nil || !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+
+	for _, invalid := range [][]byte{{}, {0x80 | 100, 0}, {0x80 | 100, 0, 0, 9, 100, 'x'}} {
+		if _, err := redPrimary(invalid); err == nil {
+			t.Errorf("invalid RED payload %v accepted", invalid)
+		}
+	}
+}
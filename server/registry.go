@@ -0,0 +1,53 @@
+package server
+
+import "errors"
+
+// errRoomHostedElsewhere means the room lives on another instance of a
+// cluster, which this instance can't forward to yet.
+var errRoomHostedElsewhere = errors.New("room is hosted by another instance")
+
+// RoomRegistry records which server instance hosts each room, so that the
+// instances of a cluster agree on where a room's peers meet. RoomManager
+// claims a room when creating it and releases it once the room is removed.
+//
+// Only the single-node localRoomRegistry exists so far, and a room another
+// instance hosts is rejected with errRoomHostedElsewhere. The intended
+// design for the rest:
+//
+//   - A shared implementation, e.g. on Redis, claims with SET room:<id>
+//     <instance> NX and a TTL the host refreshes while the room lives, so a
+//     crashed instance's rooms become claimable again, and releases with a
+//     compare-and-delete script so an instance never drops another's claim.
+//   - An instance receiving a WHIP POST for a room hosted elsewhere still
+//     terminates the client's WebRTC session itself, as clients can't be
+//     handed to another instance mid-negotiation, and joins the room on the
+//     host over a forwarding link: one session per instance and room
+//     between the two, carrying the instance's local peers' audio to the
+//     host as one stream per peer, told apart by SSRC, and the audio the
+//     host relays to each of them back.
+//   - Peer joins and leaves cross the link on a data channel, so the host
+//     pairs, or meshes, remote peers exactly like its own; to the room they
+//     are peers whose tracks come through the link.
+type RoomRegistry interface {
+	// Claim makes this instance the host of roomID unless another instance
+	// already is, returning that instance's address, or the empty string
+	// when this instance hosts the room.
+	Claim(roomID string) (host string, err error)
+	// Release gives up this instance's claim on roomID.
+	Release(roomID string) error
+}
+
+// roomRegistry is the registry RoomManager claims rooms in.
+var roomRegistry RoomRegistry = localRoomRegistry{}
+
+// localRoomRegistry is the RoomRegistry of a server running alone, which
+// hosts every room itself.
+type localRoomRegistry struct{}
+
+func (localRoomRegistry) Claim(string) (string, error) {
+	return "", nil
+}
+
+func (localRoomRegistry) Release(string) error {
+	return nil
+}
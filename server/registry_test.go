@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRoomRegistry is an in-memory RoomRegistry in which remote rooms are
+// hosted by other instances.
+type fakeRoomRegistry struct {
+	remote   map[string]string
+	claimed  []string
+	released []string
+	mutex    sync.Mutex
+}
+
+func (r *fakeRoomRegistry) Claim(roomID string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if host, ok := r.remote[roomID]; ok {
+		return host, nil
+	}
+	r.claimed = append(r.claimed, roomID)
+	return "", nil
+}
+
+func (r *fakeRoomRegistry) Release(roomID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.released = append(r.released, roomID)
+	return nil
+}
+
+func (r *fakeRoomRegistry) calls() ([]string, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return slices.Clone(r.claimed), slices.Clone(r.released)
+}
+
+func TestRoomRegistry(t *testing.T) {
+	registry := &fakeRoomRegistry{remote: map[string]string{"elsewhere": "10.0.0.2:8080"}}
+	roomRegistry = registry
+	t.Cleanup(func() { roomRegistry = localRoomRegistry{} })
+	server := startServer(t)
+
+	first := server.Join(t, "local")
+	second := server.Join(t, "local")
+	if claimed, _ := registry.calls(); !slices.Equal(claimed, []string{"local"}) {
+		t.Errorf("two joins claimed %v, want the room once", claimed)
+	}
+
+	_, offer := createOffer(t, addAudio)
+	res, body := postOffer(t, server.URL+"/whip?room=elsewhere", offer, nil)
+	if res.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "10.0.0.2:8080") {
+		t.Errorf("join of a room hosted elsewhere answered %d: %s", res.StatusCode, body)
+	}
+	if roomManager.findRoom("elsewhere") != nil {
+		t.Error("room hosted elsewhere created locally")
+	}
+
+	first.Close()
+	second.Close()
+	eventually(t, "the room being released", func() bool {
+		_, released := registry.calls()
+		return slices.Equal(released, []string{"local"})
+	})
+}
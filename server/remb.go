@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const rembInterval = time.Second
+
+// rembBitrate returns the REMB target of the peer's stream, the lowest of
+// its rooms', as every room hears the same stream; zero when none sets one.
+func (p *Peer) rembBitrate() uint64 {
+	var bitrate uint64
+	for _, room := range p.rooms {
+		if target := room.Options.REMBBitrate; target > 0 && (bitrate == 0 || target < bitrate) {
+			bitrate = target
+		}
+	}
+	return bitrate
+}
+
+// sendREMB periodically asks the publisher of track to keep its bitrate under
+// the REMB target of its rooms until done is closed.
+//
+// The server runs no congestion controller of its own, so the target is a
+// fixed cap rather than an estimate. Publishers running transport-wide
+// congestion control combine it with their own estimate and send at the lower
+// of the two; browsers generally honor REMB for video only, so for audio it is
+// a hint for endpoints that implement it.
+func sendREMB(source *Peer, track *webrtc.TrackRemote, done <-chan struct{}) {
+	target := source.rembBitrate()
+	ticker := time.NewTicker(rembInterval)
+	defer ticker.Stop()
+
+	lastBytes := source.bytesReceived.Load()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		bytes := source.bytesReceived.Load()
+		bitrate := float64(bytes-lastBytes) * 8 / rembInterval.Seconds()
+		lastBytes = bytes
+		fmt.Printf("Peer %s inbound bitrate %.0f bps, REMB target %d bps (Room: %s)\n",
+			source.ID, bitrate, target, source.roomList())
+
+		if err := source.PeerConnection.WriteRTCP([]rtcp.Packet{
+			&rtcp.ReceiverEstimatedMaximumBitrate{
+				Bitrate: float32(target),
+				SSRCs:   []uint32{uint32(track.SSRC())},
+			},
+		}); err != nil {
+			fmt.Printf("Error sending REMB to peer %s: %s\n", source.ID, err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+func TestREMB(t *testing.T) {
+	server := startServer(t)
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "remb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sender *webrtc.RTPSender
+	peerConnection, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		sender, err = peerConnection.AddTrack(track)
+		return err
+	})
+	res, answer := postOffer(t, server.URL+"/whip?room=remb&remb=64000", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: []byte{0xf8}, Duration: 20 * time.Millisecond})
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	remb := make(chan float32, 1)
+	go func() {
+		for {
+			packets, _, err := sender.ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				if estimate, ok := packet.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+					select {
+					case remb <- estimate.Bitrate:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	select {
+	case bitrate := <-remb:
+		if bitrate != 64000 {
+			t.Errorf("REMB target %g, want 64000", bitrate)
+		}
+	case <-time.After(relayTimeout):
+		t.Fatal("publisher got no REMB")
+	}
+}
+
+func TestInvalidREMBParameter(t *testing.T) {
+	server := startServer(t)
+	_, offer := createOffer(t, addAudio)
+	if res, body := postOffer(t, server.URL+"/whip?room=remb&remb=fast", offer, nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid remb parameter answered %d, want 400: %s", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// resumptionTokenHeader carries the token issued on join and presented again
+// when the client reconnects to the same room.
+const resumptionTokenHeader = "Resumption-Token"
+
+var resumptionTokens = &resumptionTokenStore{
+	tokens: make(map[string]resumptionToken),
+}
+
+type resumptionToken struct {
+	RoomID string
+	// Identity is who joined with the token, restored on resumption.
+	Identity Identity
+	Expires  time.Time
+}
+
+// resumptionTokenStore holds the short-lived, single-use tokens that let a
+// peer rejoin its room without re-authenticating.
+type resumptionTokenStore struct {
+	tokens map[string]resumptionToken
+	mutex  sync.Mutex
+}
+
+func (s *resumptionTokenStore) issue(roomID string, identity Identity, ttl time.Duration) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for token, entry := range s.tokens {
+		if now.After(entry.Expires) {
+			delete(s.tokens, token)
+		}
+	}
+
+	token := randomHex(16)
+	s.tokens[token] = resumptionToken{RoomID: roomID, Identity: identity, Expires: now.Add(ttl)}
+	return token
+}
+
+// redeem consumes the token and reports whether it was valid for roomID,
+// returning the identity it was issued to.
+func (s *resumptionTokenStore) redeem(token, roomID string) (Identity, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exists := s.tokens[token]
+	if !exists {
+		return Identity{}, false
+	}
+	delete(s.tokens, token)
+
+	if entry.RoomID != roomID || !time.Now().Before(entry.Expires) {
+		return Identity{}, false
+	}
+	return entry.Identity, true
+}
+
+func (s *resumptionTokenStore) revoke(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.tokens, token)
+}
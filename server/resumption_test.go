@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResumptionToken(t *testing.T) {
+	server := startServer(t)
+	_, offer := createOffer(t, addAudio)
+	res, body := postOffer(t, server.URL+"/whip?room=resumed", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, body)
+	}
+	token := res.Header.Get(resumptionTokenHeader)
+	if token == "" {
+		t.Fatal("join issued no resumption token")
+	}
+
+	header := http.Header{resumptionTokenHeader: {token}}
+	if res, body = postOffer(t, server.URL+"/whip?room=elsewhere", offer, header); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token presented to another room answered %d, want 401: %s", res.StatusCode, body)
+	}
+	// Presenting the token to the wrong room consumed it.
+	if res, body = postOffer(t, server.URL+"/whip?room=resumed", offer, header); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("consumed token answered %d, want 401: %s", res.StatusCode, body)
+	}
+
+	_, offer = createOffer(t, addAudio)
+	res, _ = postOffer(t, server.URL+"/whip?room=reconnect", offer, nil)
+	header = http.Header{resumptionTokenHeader: {res.Header.Get(resumptionTokenHeader)}}
+	_, offer = createOffer(t, addAudio)
+	if res, body = postOffer(t, server.URL+"/whip?room=reconnect", offer, header); res.StatusCode != http.StatusCreated {
+		t.Fatalf("resumed join answered %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestResumptionTokenDisabled(t *testing.T) {
+	server := startServer(t, "-resumption-token-ttl", "0")
+	_, offer := createOffer(t, addAudio)
+	res, body := postOffer(t, server.URL+"/whip?room=plain", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, body)
+	}
+	if token := res.Header.Get(resumptionTokenHeader); token != "" {
+		t.Errorf("join issued resumption token %q with -resumption-token-ttl 0", token)
+	}
+}
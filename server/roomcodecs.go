@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// knownCodecs returns the registered audio codecs, named by encoding in
+// lowercase as in SDP rtpmap lines.
+func knownCodecs() []string {
+	known := []string{"red"}
+	for _, codec := range audioCodecs {
+		_, encoding, _ := strings.Cut(codec.MimeType, "/")
+		known = append(known, strings.ToLower(encoding))
+	}
+	return known
+}
+
+// parseRoomCodecs reads the codecs query parameter, a comma-separated list
+// of encodings like ?codecs=opus,pcmu, restricting a new room to them; see
+// RoomOptions.Codecs. RED carries Opus, so allowing it needs Opus too.
+func parseRoomCodecs(value string) ([]string, error) {
+	var codecs []string
+	for _, encoding := range strings.Split(value, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if !slices.Contains(knownCodecs(), encoding) {
+			return nil, fmt.Errorf("invalid codecs parameter: unknown codec %q", encoding)
+		}
+		if !slices.Contains(codecs, encoding) {
+			codecs = append(codecs, encoding)
+		}
+	}
+	if slices.Contains(codecs, "red") && !slices.Contains(codecs, "opus") {
+		return nil, errors.New("invalid codecs parameter: red needs opus")
+	}
+	return codecs, nil
+}
+
+// allowedCodecs returns the codecs every one of the rooms allows, nil when
+// none restricts them.
+func allowedCodecs(rooms []*Room) []string {
+	var allowed []string
+	restricted := false
+	for _, room := range rooms {
+		if room.Options.Codecs == nil {
+			continue
+		}
+		if !restricted {
+			allowed, restricted = slices.Clone(room.Options.Codecs), true
+			continue
+		}
+		allowed = slices.DeleteFunc(allowed, func(encoding string) bool {
+			return !slices.Contains(room.Options.Codecs, encoding)
+		})
+	}
+	if restricted && allowed == nil {
+		allowed = []string{}
+	}
+	return allowed
+}
+
+// codecAllowed reports whether the encoding, e.g. "opus", is among allowed;
+// nil allows every codec.
+func codecAllowed(allowed []string, encoding string) bool {
+	return allowed == nil || slices.Contains(allowed, strings.ToLower(encoding))
+}
+
+// checkRoomCodecs rejects offers with an audio media section listing none
+// of the codecs the rooms allow: answering it would negotiate either a codec
+// the rooms exclude or none at all.
+func checkRoomCodecs(offer *sdp.SessionDescription, rooms []*Room) error {
+	allowed := allowedCodecs(rooms)
+	if allowed == nil {
+		return nil
+	}
+	if len(allowed) == 0 {
+		return errors.New("the rooms joined allow no codec in common")
+	}
+
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Media != "audio" || media.MediaName.Port.Value == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(allowed, func(encoding string) bool { return mediaHasCodec(media, encoding) }) {
+			return fmt.Errorf("offer has no codec the room allows (%s)", strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
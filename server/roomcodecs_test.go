@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// pcmuOffer returns an offer negotiating PCMU only.
+func pcmuOffer(t *testing.T) string {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(audioCodecs[2], webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerConnection.Close()
+	if err = addAudio(peerConnection); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer.SDP
+}
+
+func TestOpusOnlyRoomRejectsPCMU(t *testing.T) {
+	server := startServer(t)
+	offer := pcmuOffer(t)
+
+	// Rejected by a room it would create, which isn't kept.
+	res, body := postOffer(t, server.URL+"/whip?room=fresh&codecs=opus", offer, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("PCMU offer creating an Opus-only room answered %d, want 422: %s", res.StatusCode, body)
+	}
+	if roomManager.findRoom("fresh") != nil {
+		t.Fatal("room created by the rejected offer kept")
+	}
+
+	// And by one already open.
+	server.JoinQuery(t, url.Values{"room": {"opus"}, "codecs": {"opus"}})
+	res, body = postOffer(t, server.URL+"/whip?room=opus", offer, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("PCMU offer to an Opus-only room answered %d, want 422: %s", res.StatusCode, body)
+	}
+	if peers := len(roomManager.findRoom("opus").otherPeers(nil)); peers != 1 {
+		t.Fatalf("room has %d peers, want 1", peers)
+	}
+	server.Join(t, "opus")
+}
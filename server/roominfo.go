@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// roomInfo is the response of GET /rooms/<id>/info: what each peer
+// negotiated, read from its transceivers.
+type roomInfo struct {
+	Room     string `json:"room"`
+	Topology string `json:"topology"`
+	// Creator is the authenticated user who created the room.
+	Creator string `json:"creator,omitempty"`
+	// Codecs are the only codecs the room allows, see RoomOptions.Codecs.
+	Codecs []string `json:"codecs,omitempty"`
+	// LastActivity is when the room last relayed media, see
+	// reapInactiveRooms.
+	LastActivity time.Time  `json:"lastActivity"`
+	Peers        []peerInfo `json:"peers"`
+}
+
+type peerInfo struct {
+	ID        string `json:"id"`
+	Publisher bool   `json:"publisher,omitempty"`
+	Speaker   bool   `json:"speaker,omitempty"`
+	// RelayCodec is the MIME type of the track the peer hears the room on.
+	RelayCodec   string            `json:"relayCodec,omitempty"`
+	Transceivers []transceiverInfo `json:"transceivers"`
+}
+
+// transceiverInfo describes one media section of a peer's session. Send and
+// receive codecs are those negotiated for each direction, nil for a
+// direction the section doesn't use.
+type transceiverInfo struct {
+	Mid           string        `json:"mid"`
+	Kind          string        `json:"kind"`
+	Direction     string        `json:"direction"`
+	SendCodecs    []codecReport `json:"sendCodecs,omitempty"`
+	ReceiveCodecs []codecReport `json:"receiveCodecs,omitempty"`
+}
+
+func roomInfoHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodGet)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	info := roomInfo{Room: room.ID, Topology: room.Options.Topology, Creator: room.Creator, Codecs: room.Options.Codecs, LastActivity: room.LastActivity(), Peers: []peerInfo{}}
+	for _, peer := range room.otherPeers(nil) {
+		info.Peers = append(info.Peers, describePeer(room, peer))
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(info); err != nil {
+		fmt.Printf("Error writing room info: %s\n", err.Error())
+	}
+}
+
+func describePeer(room *Room, peer *Peer) peerInfo {
+	room.mutex.Lock()
+	info := peerInfo{ID: peer.ID, Publisher: room.Publisher == peer, Speaker: room.Speaker == peer, Transceivers: []transceiverInfo{}}
+	room.mutex.Unlock()
+	if peer.AudioTrack != nil {
+		info.RelayCodec = peer.AudioTrack.Codec().MimeType
+	}
+
+	for _, transceiver := range peer.PeerConnection.GetTransceivers() {
+		description := transceiverInfo{
+			Mid:       transceiver.Mid(),
+			Kind:      transceiver.Kind().String(),
+			Direction: transceiver.Direction().String(),
+		}
+		if sender := transceiver.Sender(); sender != nil && sender.Track() != nil {
+			description.SendCodecs = codecReports(sender.GetParameters().Codecs)
+		}
+		if receiver := transceiver.Receiver(); receiver != nil && receiver.Track() != nil {
+			description.ReceiveCodecs = codecReports(receiver.GetParameters().Codecs)
+		}
+		info.Transceivers = append(info.Transceivers, description)
+	}
+	return info
+}
+
+func codecReports(codecs []webrtc.RTPCodecParameters) []codecReport {
+	reports := make([]codecReport, 0, len(codecs))
+	for _, codec := range codecs {
+		_, name, _ := strings.Cut(codec.MimeType, "/")
+		reports = append(reports, codecReport{
+			PayloadType: uint8(codec.PayloadType),
+			Name:        name,
+			ClockRate:   codec.ClockRate,
+			Channels:    codec.Channels,
+		})
+	}
+	return reports
+}
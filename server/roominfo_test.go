@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// jsonKeys returns the sorted keys of a JSON object.
+func jsonKeys(t *testing.T, value any) []string {
+	t.Helper()
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		t.Fatalf("%v is not a JSON object", value)
+	}
+	return slices.Sorted(maps.Keys(object))
+}
+
+func TestRoomInfo(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	first := server.Join(t, "described")
+	second := server.Join(t, "described")
+	assertRelayed(t, first, second, relayTimeout)
+	assertRelayed(t, second, first, relayTimeout)
+
+	url := server.URL + "/rooms/described/info"
+	if status := statusWithToken(t, http.MethodGet, url, ""); status != http.StatusUnauthorized {
+		t.Errorf("room info without the admin token answered %d, want 401", status)
+	}
+	status, body := request(t, http.MethodGet, url, nil)
+	if status != http.StatusOK {
+		t.Fatalf("room info answered %d: %s", status, body)
+	}
+
+	var info map[string]any
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jsonKeys(t, info), []string{"lastActivity", "peers", "room", "topology"}; !slices.Equal(got, want) {
+		t.Errorf("room info has keys %v, want %v", got, want)
+	}
+	if info["room"] != "described" || info["topology"] != topologyPairs {
+		t.Errorf("room info describes room %v with topology %v", info["room"], info["topology"])
+	}
+
+	peers, _ := info["peers"].([]any)
+	if len(peers) != 2 {
+		t.Fatalf("room info lists %d peers, want 2: %s", len(peers), body)
+	}
+	opus := map[string]any{"payloadType": 111.0, "name": "opus", "clockRate": 48000.0, "channels": 2.0}
+	for i, peer := range peers {
+		if got, want := jsonKeys(t, peer), []string{"id", "relayCodec", "transceivers"}; !slices.Equal(got, want) {
+			t.Errorf("peer %d has keys %v, want %v", i, got, want)
+		}
+		described := peer.(map[string]any)
+		if id := []string{first.ID, second.ID}[i]; described["id"] != id {
+			t.Errorf("peer %d is %v, want %s", i, described["id"], id)
+		}
+		if described["relayCodec"] != webrtc.MimeTypeOpus {
+			t.Errorf("peer %d hears the room in %v, want %s", i, described["relayCodec"], webrtc.MimeTypeOpus)
+		}
+
+		transceivers, _ := described["transceivers"].([]any)
+		if len(transceivers) != 1 {
+			t.Fatalf("peer %d has %d transceivers, want 1", i, len(transceivers))
+		}
+		transceiver := transceivers[0].(map[string]any)
+		if got, want := jsonKeys(t, transceiver), []string{"direction", "kind", "mid", "receiveCodecs", "sendCodecs"}; !slices.Equal(got, want) {
+			t.Errorf("peer %d's transceiver has keys %v, want %v", i, got, want)
+		}
+		if transceiver["mid"] != "0" || transceiver["kind"] != "audio" || transceiver["direction"] != "sendrecv" {
+			t.Errorf("peer %d's transceiver is %v", i, transceiver)
+		}
+		for _, direction := range []string{"sendCodecs", "receiveCodecs"} {
+			codecs, _ := transceiver[direction].([]any)
+			if len(codecs) == 0 || !reflect.DeepEqual(codecs[0], opus) {
+				t.Errorf("peer %d's %s are %v, want Opus/111 first", i, direction, codecs)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// rtcpRsizeLine is the media attribute negotiating reduced-size RTCP (RFC
+// 5506).
+const rtcpRsizeLine = "a=rtcp-rsize"
+
+// answerRTCPRsize returns the answer with a=rtcp-rsize only in the media
+// sections whose offer carried it, and, without -rtcp-rsize, in none. pion
+// puts the attribute in every section of its answers whether offered or not,
+// which RFC 5506 forbids, as an answerer may only accept what was offered.
+//
+// Interop: with reduced-size RTCP negotiated, receivers accept RTCP packets
+// sent alone rather than in compound packets led by a report. The periodic
+// reports of sendRTCPReports are compound either way, but the feedback the
+// server sends or relays, NACKs, PLIs, REMB, relayed Receiver Reports and
+// BYE, goes out alone like pion's interceptors send it, so peers that
+// didn't offer rtcp-rsize and insist on compound RTCP may drop it. Browsers
+// always offer it; -rtcp-rsize=false is for peers that choke on seeing it in
+// an answer.
+func answerRTCPRsize(answer string, offer *webrtc.SessionDescription) string {
+	if !strings.Contains(answer, rtcpRsizeLine) {
+		return answer
+	}
+
+	var offered []bool
+	if config.RTCPRsize && offer != nil {
+		var parsedOffer sdp.SessionDescription
+		if err := parsedOffer.Unmarshal([]byte(offer.SDP)); err == nil {
+			for _, media := range parsedOffer.MediaDescriptions {
+				_, ok := media.Attribute(sdp.AttrKeyRTCPRsize)
+				offered = append(offered, ok)
+			}
+		}
+	}
+
+	// Answer media sections follow the offer's, one for one.
+	sections := strings.Split(answer, "\r\nm=")
+	for i := 1; i < len(sections); i++ {
+		if i-1 < len(offered) && offered[i-1] {
+			continue
+		}
+		lines := strings.Split(sections[i], "\r\n")
+		kept := lines[:0]
+		for _, line := range lines {
+			if line != rtcpRsizeLine {
+				kept = append(kept, line)
+			}
+		}
+		sections[i] = strings.Join(kept, "\r\n")
+	}
+	return strings.Join(sections, "\r\nm=")
+}
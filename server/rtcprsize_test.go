@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAnswerRTCPRsize(t *testing.T) {
+	_, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		if err := addAudio(peerConnection); err != nil {
+			return err
+		}
+		return addAudio(peerConnection)
+	})
+	if strings.Count(offer, "\r\n"+rtcpRsizeLine+"\r\n") != 2 {
+		t.Fatalf("offer doesn't propose rtcp-rsize in both sections:\n%s", offer)
+	}
+	// Only the second section proposes it.
+	first := strings.Index(offer, "\r\n"+rtcpRsizeLine+"\r\n")
+	mixed := offer[:first] + offer[first+len("\r\n"+rtcpRsizeLine):]
+
+	tests := []struct {
+		name  string
+		args  []string
+		offer string
+		want  []bool
+	}{
+		{"offered", nil, offer, []bool{true, true}},
+		{"offered in one section", nil, mixed, []bool{false, true}},
+		{"not offered", nil, withoutLine(offer, rtcpRsizeLine), []bool{false, false}},
+		{"disabled", []string{"-rtcp-rsize=false"}, offer, []bool{false, false}},
+	}
+	for _, test := range tests {
+		server := startServer(t, test.args...)
+		res, answer := postOffer(t, server.URL+"/whip?room=rsize", test.offer, nil)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("%s: join answered %d: %s", test.name, res.StatusCode, answer)
+		}
+
+		sections := strings.Split(answer, "\r\nm=")[1:]
+		if len(sections) != len(test.want) {
+			t.Fatalf("%s: answer has %d media sections, want %d", test.name, len(sections), len(test.want))
+		}
+		for i, section := range sections {
+			if accepted := strings.Contains(section+"\r\n", "\r\n"+rtcpRsizeLine+"\r\n"); accepted != test.want[i] {
+				t.Errorf("%s: answer section %d accepts rtcp-rsize %t, want %t", test.name, i, accepted, test.want[i])
+			}
+		}
+	}
+}
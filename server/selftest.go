@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+)
+
+// selfTestTimeout bounds how long the self-test waits for each client to
+// hear the other.
+const selfTestTimeout = 15 * time.Second
+
+// runSelfTest serves handler on a loopback port, joins two synthetic clients
+// to a fresh pairs room, the peers singlewhiptest joins in tests, and waits
+// until each receives audio from the other.
+func runSelfTest(handler http.Handler) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	client := synthpeer.NewClient("http://" + listener.Addr().String() + config.WHIPPaths[0])
+	query := url.Values{"room": {"selftest-" + randomHex(4)}, "topology": {topologyPairs}}
+	names := []string{"first", "second"}
+	var peers []*synthpeer.Peer
+	for _, name := range names {
+		peer, err := client.Join(query)
+		if err != nil {
+			return fmt.Errorf("%s client: %w", name, err)
+		}
+		defer peer.Close()
+		peers = append(peers, peer)
+	}
+
+	for i, name := range names {
+		if err = synthpeer.AwaitRelayed(peers[1-i], peers[i], selfTestTimeout); err != nil {
+			return fmt.Errorf("%s client: %w", name, err)
+		}
+		fmt.Printf("Self-test: %s client received audio\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package server
+
+import "testing"
+
+func TestSelfTest(t *testing.T) {
+	startServer(t)
+
+	if err := runSelfTest(newHandler()); err != nil {
+		t.Fatal(err)
+	}
+}
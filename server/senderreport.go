@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to the
+// Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// interceptors are pion's default interceptors except the Sender Report
+// generator, by name. pion would time relay Sender Reports by packet
+// arrival; the server sends its own, derived from the source's reports, see
+// relayClock.
+var interceptors = []struct {
+	name     string
+	register func(*webrtc.MediaEngine, *interceptor.Registry) error
+}{
+	{"NACK", webrtc.ConfigureNack},
+	{"receiver report", func(_ *webrtc.MediaEngine, registry *interceptor.Registry) error {
+		receiverReports, err := report.NewReceiverInterceptor()
+		if err == nil {
+			registry.Add(receiverReports)
+		}
+		return err
+	}},
+	{"simulcast extension header", func(mediaEngine *webrtc.MediaEngine, _ *interceptor.Registry) error {
+		return webrtc.ConfigureSimulcastExtensionHeaders(mediaEngine)
+	}},
+	{"stats", func(_ *webrtc.MediaEngine, registry *interceptor.Registry) error {
+		return webrtc.ConfigureStatsInterceptor(registry)
+	}},
+	{"TWCC sender", webrtc.ConfigureTWCCSender},
+}
+
+// registerInterceptors registers the interceptors. One failing to register
+// is an error naming it, unless -minimal-interceptors, which runs without
+// it: peers then connect without e.g. retransmissions or congestion
+// feedback rather than not at all.
+func registerInterceptors(mediaEngine *webrtc.MediaEngine, registry *interceptor.Registry) error {
+	for _, setup := range interceptors {
+		err := setup.register(mediaEngine, registry)
+		if err == nil {
+			continue
+		}
+		if !config.MinimalInterceptors {
+			return fmt.Errorf("registering the %s interceptor: %w", setup.name, err)
+		}
+		fmt.Printf("WARNING: registering the %s interceptor failed, running without it: %s\n", setup.name, err.Error())
+	}
+	return nil
+}
+
+// relayClock keeps a peer's relay track one continuous stream and maps it
+// onto wall-clock time for its Sender Reports. Relayed RTP keeps the
+// source's sequence numbers and timestamps, shifted when the source changes
+// (see rewrite), so the mapping in the source's latest Sender Report, shifted
+// alike, holds for the relay track as well: it is extrapolated to the time
+// each report is sent, keeping the source's own capture timing (and so sync
+// with its other streams) instead of network jitter. Until the source
+// reports, the last relayed packet stands in, as in pion's generator. In mesh
+// rooms several sources share one track and the mapping follows whichever is
+// relayed.
+type relayClock struct {
+	packets uint32
+	octets  uint32
+
+	lastSeq  uint16
+	lastRTP  uint32
+	lastTime time.Time
+
+	// source is the SSRC currently relayed; seqOffset and rtpOffset shift
+	// its packets onto the track.
+	source    uint32
+	seqOffset uint16
+	rtpOffset uint32
+
+	sourceRTP  uint32
+	sourceTime time.Time
+}
+
+// rewrite shifts pkt onto the track's sequence numbers and timestamps. When
+// another source takes over, e.g. a new partner after the previous one left,
+// it continues where the last one stopped, its timestamps advanced by the
+// time in between, instead of jumping to its own numbering, which
+// receivers' SRTP replay protection and jitter buffers would reject.
+func (c *relayClock) rewrite(pkt *rtp.Packet, clockRate uint32, now time.Time) {
+	if pkt.SSRC != c.source {
+		c.source = pkt.SSRC
+		c.seqOffset, c.rtpOffset = 0, 0
+		if !c.lastTime.IsZero() {
+			c.seqOffset = c.lastSeq + 1 - pkt.SequenceNumber
+			c.rtpOffset = c.lastRTP + uint32(now.Sub(c.lastTime).Seconds()*float64(clockRate)) - pkt.Timestamp
+		}
+		c.sourceTime = time.Time{}
+	}
+	pkt.SequenceNumber += c.seqOffset
+	pkt.Timestamp += c.rtpOffset
+}
+
+// relayed records a packet written to the relay track. A reordered packet,
+// older than the last one, counts but leaves the position in the stream.
+func (c *relayClock) relayed(pkt *rtp.Packet, now time.Time) {
+	c.packets++
+	c.octets += uint32(len(pkt.Payload))
+	if !c.lastTime.IsZero() && int16(pkt.SequenceNumber-c.lastSeq) < 0 {
+		return
+	}
+	c.lastSeq = pkt.SequenceNumber
+	c.lastRTP = pkt.Timestamp
+	c.lastTime = now
+}
+
+// sourceReported records a Sender Report received at now, unless its source
+// isn't the one relayed.
+func (c *relayClock) sourceReported(sr *rtcp.SenderReport, now time.Time) {
+	if sr.SSRC != c.source {
+		return
+	}
+	c.sourceRTP = sr.RTPTime + c.rtpOffset
+	c.sourceTime = now
+}
+
+// report returns the Sender Report for ssrc at now, or false before anything
+// was relayed.
+func (c *relayClock) report(ssrc, clockRate uint32, now time.Time) (*rtcp.SenderReport, bool) {
+	if c.lastTime.IsZero() {
+		return nil, false
+	}
+
+	baseRTP, baseTime := c.lastRTP, c.lastTime
+	if !c.sourceTime.IsZero() {
+		baseRTP, baseTime = c.sourceRTP, c.sourceTime
+	}
+
+	return &rtcp.SenderReport{
+		SSRC:        ssrc,
+		NTPTime:     ntpTime(now),
+		RTPTime:     baseRTP + uint32(now.Sub(baseTime).Seconds()*float64(clockRate)),
+		PacketCount: c.packets,
+		OctetCount:  c.octets,
+	}, true
+}
+
+// relaySenderReports reads the source's RTCP for one track until the receiver
+// stops, passing its Sender Reports to the relay clocks of the destinations
+// whose tracks run at the source's clock rate; transcoded relays keep their
+// own timing. With -relay-bye, a BYE for the track ends it, see
+// relayGoodbye.
+func relaySenderReports(source *Peer, receiver *webrtc.RTPReceiver, clockRate uint32) {
+	for {
+		packets, _, err := receiver.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+		track := receiver.Track()
+		for _, packet := range packets {
+			if config.RelayBye && isGoodbye(packet, uint32(track.SSRC())) {
+				relayGoodbye(source, receiver)
+				return
+			}
+
+			sr, ok := packet.(*rtcp.SenderReport)
+			if !ok {
+				continue
+			}
+
+			for _, destination := range source.destinations() {
+				if destination.AudioTrack == nil {
+					continue
+				}
+				if destination = source.relayTarget(track, destination); destination == nil {
+					continue
+				}
+				if rate, ok := relayClockRate(destination); !ok || rate != clockRate {
+					continue
+				}
+				destination.mutex.Lock()
+				destination.clock.sourceReported(sr, now)
+				destination.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// relayClockRate returns the clock rate of the peer's relay track, see
+// codecClockRate; the track's own capability leaves it unset, so codecs
+// without a fixed rate take the negotiated one.
+func relayClockRate(peer *Peer) (uint32, bool) {
+	mimeType := peer.AudioTrack.Codec().MimeType
+	for _, codec := range peer.AudioSender.GetParameters().Codecs {
+		if strings.EqualFold(codec.MimeType, mimeType) {
+			return codecClockRate(mimeType, codec.ClockRate)
+		}
+	}
+	return codecClockRate(mimeType, 0)
+}
+
+// ntpTime converts t to the 64-bit NTP timestamp format.
+func ntpTime(t time.Time) uint64 {
+	nanoseconds := uint64(t.UnixNano())
+	seconds := nanoseconds/uint64(time.Second) + ntpEpochOffset
+	fraction := (nanoseconds % uint64(time.Second)) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// relayedPacket is an RTP packet a subscriber received and when.
+type relayedPacket struct {
+	ssrc      uint32
+	timestamp uint32
+	arrived   time.Time
+}
+
+func TestRelaySenderReports(t *testing.T) {
+	server := startServer(t, "-cname", "relay-test")
+
+	var mutex sync.Mutex
+	var last relayedPacket
+	reports := make(chan []rtcp.Packet, 10)
+	subscriber, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			go func() {
+				for {
+					packets, _, err := receiver.ReadRTCP()
+					if err != nil {
+						return
+					}
+					select {
+					case reports <- packets:
+					default:
+					}
+				}
+			}()
+			for {
+				pkt, _, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				mutex.Lock()
+				last = relayedPacket{ssrc: pkt.SSRC, timestamp: pkt.Timestamp, arrived: time.Now()}
+				mutex.Unlock()
+			}
+		})
+		_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		return err
+	})
+	res, answer := postOffer(t, server.URL+"/whip?room=reports", offer, nil)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err := subscriber.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	server.Join(t, "reports")
+
+	deadline := time.After(2*reportInterval + relayTimeout)
+	for {
+		var packets []rtcp.Packet
+		select {
+		case packets = <-reports:
+		case <-deadline:
+			t.Fatal("subscriber got no Sender Report")
+		}
+		arrived := time.Now()
+		mutex.Lock()
+		relayed := last
+		mutex.Unlock()
+
+		var sr *rtcp.SenderReport
+		var cname *rtcp.SourceDescriptionChunk
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.SenderReport:
+				sr = packet
+			case *rtcp.SourceDescription:
+				cname = &packet.Chunks[0]
+			}
+		}
+		// Reports of the relay track start once packets were relayed.
+		if sr == nil || relayed.arrived.IsZero() {
+			continue
+		}
+
+		if sr.SSRC != relayed.ssrc {
+			t.Errorf("Sender Report for SSRC %d, relayed packets have %d", sr.SSRC, relayed.ssrc)
+		}
+		// The report maps the time it was sent to where the relayed stream
+		// was by then, up to network jitter.
+		want := relayed.timestamp + uint32(arrived.Sub(relayed.arrived).Seconds()*48000)
+		if drift := int32(sr.RTPTime - want); drift < -4800 || drift > 4800 {
+			t.Errorf("Sender Report RTP time %d, %d ticks off the relayed packets' %d", sr.RTPTime, drift, want)
+		}
+		if cname == nil || cname.Source != relayed.ssrc || len(cname.Items) != 1 || cname.Items[0].Text != "relay-test" {
+			t.Errorf("Sender Report comes with SDES %+v, want CNAME relay-test for SSRC %d", cname, relayed.ssrc)
+		}
+		return
+	}
+}
+
+func TestRegisterInterceptors(t *testing.T) {
+	failing := errors.New("injected failure")
+	defaults := interceptors
+	interceptors = append(slices.Clip(defaults), struct {
+		name     string
+		register func(*webrtc.MediaEngine, *interceptor.Registry) error
+	}{"failing", func(*webrtc.MediaEngine, *interceptor.Registry) error { return failing }})
+	t.Cleanup(func() { interceptors = defaults })
+
+	config = Config{}
+	err := registerInterceptors(&webrtc.MediaEngine{}, &interceptor.Registry{})
+	if !errors.Is(err, failing) || !strings.Contains(err.Error(), "failing interceptor") {
+		t.Errorf("registering a failing interceptor returned %v, want an error naming it", err)
+	}
+
+	config.MinimalInterceptors = true
+	registry := &interceptor.Registry{}
+	if err = registerInterceptors(&webrtc.MediaEngine{}, registry); err != nil {
+		t.Errorf("-minimal-interceptors failed on a failing interceptor: %s", err.Error())
+	}
+	if _, err = registry.Build(""); err != nil {
+		t.Errorf("building the remaining interceptors: %s", err.Error())
+	}
+}
+
+// The server sends the relay track's Sender Reports itself, each with an
+// SDES, every reportInterval; pion's generator, which would add its own
+// every second, isn't registered.
+func TestNoDuplicateSenderReports(t *testing.T) {
+	server := startServer(t)
+
+	reports := make(chan []rtcp.Packet, 100)
+	subscriber, offer := createOffer(t, func(peerConnection *webrtc.PeerConnection) error {
+		peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+			go drainTrack(track)
+			for {
+				packets, _, err := receiver.ReadRTCP()
+				if err != nil {
+					return
+				}
+				reports <- packets
+			}
+		})
+		_, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		return err
+	})
+	answerOffer(t, server.URL, "reports", subscriber, offer)
+	server.Join(t, "reports")
+
+	// Once the first report arrived, the next is reportInterval away; pion's
+	// generator would send one within a second.
+	var first time.Time
+	window := time.After(reportInterval + relayTimeout)
+	for {
+		var packets []rtcp.Packet
+		select {
+		case packets = <-reports:
+		case <-window:
+			if first.IsZero() {
+				t.Fatal("subscriber got no Sender Report")
+			}
+			return
+		}
+
+		var sr *rtcp.SenderReport
+		described := false
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.SenderReport:
+				sr = packet
+			case *rtcp.SourceDescription:
+				described = true
+			}
+		}
+		switch {
+		case sr == nil:
+		case !described:
+			t.Fatalf("Sender Report for SSRC %d without the server's SDES, from pion's generator", sr.SSRC)
+		case !first.IsZero():
+			t.Fatalf("second Sender Report %s after the first, want %s", time.Since(first), reportInterval)
+		default:
+			first = time.Now()
+			window = time.After(1500 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// opusSilenceFrame is a 20ms Opus frame of silence.
+var opusSilenceFrame = []byte{0xf8, 0xff, 0xfe}
+
+// maxOpusDuration is the longest Opus packet, and the longest silence
+// packet inserted.
+const maxOpusDuration = 120 * time.Millisecond
+
+// Silent G.711 samples: the codes of linear zero.
+const (
+	pcmuSilence = 0xff
+	pcmaSilence = 0xd5
+)
+
+// silenceFillers returns, with -silence-fill, the silence packets filling
+// the gap in the peer's relay track sequence numbers before pkt, already
+// rewritten by the relay clock: packets lost on the way from the source, or
+// dropped by the server, would otherwise leave receivers concealing the
+// loss, which over several packets sounds worse than a short silence. The
+// fillers' timestamps are spread evenly over the gap. Gaps longer than
+// -silence-fill packets, or spacing fillers more than maxOpusDuration
+// apart, are left alone, as are gaps in timestamps only, which are Opus DTX
+// receivers already handle. A retransmission of a filled packet arriving
+// later reuses its sequence number and is discarded as a duplicate. The
+// caller holds the peer's mutex.
+func (p *Peer) silenceFillers(pkt *rtp.Packet, clockRate uint32, codecs []webrtc.RTPCodecParameters) []*rtp.Packet {
+	if config.SilenceFill <= 0 || p.clock.lastTime.IsZero() {
+		return nil
+	}
+	missing := pkt.SequenceNumber - p.clock.lastSeq - 1
+	if missing == 0 || int(missing) > config.SilenceFill {
+		return nil
+	}
+	step := (pkt.Timestamp - p.clock.lastRTP) / uint32(missing+1)
+	if step == 0 || step > clockRate*uint32(maxOpusDuration/time.Millisecond)/1000 {
+		return nil
+	}
+
+	payload, ok := silencePayload(strings.ToLower(p.AudioTrack.Codec().MimeType), step, codecs)
+	if !ok {
+		return nil
+	}
+
+	fillers := make([]*rtp.Packet, 0, missing)
+	for i := range uint32(missing) {
+		header := pkt.Header.Clone()
+		header.SequenceNumber = p.clock.lastSeq + 1 + uint16(i)
+		header.Timestamp = p.clock.lastRTP + (i+1)*step
+		header.Marker = false
+		header.Extension = false
+		header.ExtensionProfile = 0
+		header.Extensions = nil
+		fillers = append(fillers, &rtp.Packet{Header: header, Payload: payload})
+	}
+	return fillers
+}
+
+// silencePayload returns a packet of silence lasting samples in the relay
+// track's codec, false for codecs there's none for.
+func silencePayload(mimeType string, samples uint32, codecs []webrtc.RTPCodecParameters) ([]byte, bool) {
+	switch mimeType {
+	case mimeTypeOpus:
+		return opusSilenceFrame, true
+	case mimeTypeRED:
+		payload, err := convertRED(opusSilenceFrame, false, true, payloadTypes(codecs)[mimeTypeOpus])
+		return payload, err == nil
+	case mimeTypePCMU:
+		return bytes.Repeat([]byte{pcmuSilence}, int(samples)), true
+	case mimeTypePCMA:
+		return bytes.Repeat([]byte{pcmaSilence}, int(samples)), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// silencePeer returns a peer whose relay track in codec last relayed
+// sequence number 100 at timestamp 48000.
+func silencePeer(t *testing.T, codec webrtc.RTPCodecCapability) *Peer {
+	t.Helper()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(codec, "audio", "silence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &Peer{ID: "listener", AudioTrack: track}
+	peer.clock.relayed(&rtp.Packet{Header: rtp.Header{SequenceNumber: 100, Timestamp: 48000}}, time.Now())
+	return peer
+}
+
+func TestSilenceFill(t *testing.T) {
+	config = Config{SilenceFill: 3}
+	peer := silencePeer(t, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2})
+
+	// Two packets lost, 20ms of Opus each.
+	pkt := &rtp.Packet{Header: rtp.Header{SequenceNumber: 103, Timestamp: 48000 + 3*960, Marker: true}, Payload: []byte{1}}
+	fillers := peer.silenceFillers(pkt, 48000, nil)
+	if len(fillers) != 2 {
+		t.Fatalf("%d fillers for two lost packets, want 2", len(fillers))
+	}
+	for i, filler := range fillers {
+		if want := uint16(101 + i); filler.SequenceNumber != want {
+			t.Errorf("filler %d has sequence number %d, want %d", i, filler.SequenceNumber, want)
+		}
+		if want := uint32(48000 + (i+1)*960); filler.Timestamp != want {
+			t.Errorf("filler %d has timestamp %d, want %d", i, filler.Timestamp, want)
+		}
+		if filler.Marker {
+			t.Errorf("filler %d has the marker bit of the packet after the gap", i)
+		}
+		if !bytes.Equal(filler.Payload, opusSilenceFrame) {
+			t.Errorf("filler %d carries %x, want Opus silence", i, filler.Payload)
+		}
+	}
+
+	// No gap, a gap longer than -silence-fill, and packets further apart
+	// than any Opus frame are left alone.
+	for _, header := range []rtp.Header{
+		{SequenceNumber: 101, Timestamp: 48960},
+		{SequenceNumber: 105, Timestamp: 48000 + 5*960},
+		{SequenceNumber: 102, Timestamp: 48000 + 48000},
+	} {
+		if fillers := peer.silenceFillers(&rtp.Packet{Header: header}, 48000, nil); len(fillers) != 0 {
+			t.Errorf("%d fillers before sequence number %d at %d", len(fillers), header.SequenceNumber, header.Timestamp)
+		}
+	}
+
+	config.SilenceFill = 0
+	if fillers := peer.silenceFillers(pkt, 48000, nil); len(fillers) != 0 {
+		t.Errorf("%d fillers without -silence-fill", len(fillers))
+	}
+}
+
+func TestSilenceFillPCMU(t *testing.T) {
+	config = Config{SilenceFill: 3}
+	peer := silencePeer(t, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000})
+
+	pkt := &rtp.Packet{Header: rtp.Header{SequenceNumber: 102, Timestamp: 48000 + 2*160}}
+	fillers := peer.silenceFillers(pkt, 8000, nil)
+	if len(fillers) != 1 {
+		t.Fatalf("%d fillers for one lost packet, want 1", len(fillers))
+	}
+	if want := bytes.Repeat([]byte{pcmuSilence}, 160); !bytes.Equal(fillers[0].Payload, want) {
+		t.Errorf("filler carries %x, want 160 samples of PCMU silence", fillers[0].Payload)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// fileSink is a virtual subscriber of a room: it hears what a listener
+// joining the room would, one continuous stream as on a peer's relay track,
+// and writes it to a file in config.RecordDir in config.RecordFormat. Unlike
+// peers it holds no slot, doesn't keep the room open and is finalized when
+// the room closes. Only broadcast and half-duplex rooms take sinks, as only
+// they relay one source at a time, which the single stream can hold.
+type fileSink struct {
+	ID       string
+	Path     string
+	recorder RelayRecorder
+	clock    relayClock
+	mutex    sync.Mutex
+}
+
+// sinkInfo describes a sink in the admin API.
+type sinkInfo struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+// errSinkMixesSources rejects sinks of rooms relaying several sources at
+// once, see fileSink.
+var errSinkMixesSources = errors.New("file sinks need a broadcast or half-duplex room, which relays one source at a time")
+
+// addSink attaches a new file sink to the room.
+func (r *Room) addSink() (*fileSink, error) {
+	if r.Options.Topology != topologyBroadcast && !r.Options.HalfDuplex {
+		return nil, errSinkMixesSources
+	}
+
+	sink := &fileSink{ID: newPeerID()}
+	name := fmt.Sprintf("%s-sink-%s-%s.%s", r.ID, sink.ID, time.Now().UTC().Format("20060102T150405.000Z"), config.RecordFormat)
+	sink.Path = filepath.Join(config.RecordDir, filepath.Base(name))
+
+	var err error
+	if sink.recorder, err = newRecorder(sink.Path); err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	r.sinks = append(r.sinks, sink)
+	r.events.add(eventJoin, sink.ID, "file sink "+sink.Path)
+	r.mutex.Unlock()
+	fmt.Printf("Attached file sink %s to room %s, writing %s\n", sink.ID, r.ID, sink.Path)
+	return sink, nil
+}
+
+// removeSink detaches and finalizes the sink with ID id, returning it, or nil
+// if the room has none.
+func (r *Room) removeSink(id string) (*fileSink, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, sink := range r.sinks {
+		if sink.ID == id {
+			r.sinks = slices.Delete(r.sinks, i, i+1)
+			r.events.add(eventLeave, sink.ID, "file sink "+sink.Path)
+			fmt.Printf("Detached file sink %s from room %s\n", sink.ID, r.ID)
+			return sink, sink.close()
+		}
+	}
+	return nil, nil
+}
+
+// closeSinks finalizes all the room's sinks, once it closed.
+func (r *Room) closeSinks() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, sink := range r.sinks {
+		if err := sink.close(); err != nil {
+			fmt.Printf("Error finalizing file sink %s: %s\n", sink.ID, err.Error())
+		}
+	}
+	r.sinks = nil
+}
+
+// sinksFor returns the sinks that hear source: the publisher's in broadcast
+// rooms and the speaker's in half-duplex ones.
+func (r *Room) sinksFor(source *Peer) []*fileSink {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.Options.Topology == topologyBroadcast && r.Publisher != source {
+		return nil
+	}
+	if r.Options.HalfDuplex && r.Speaker != source {
+		return nil
+	}
+	return slices.Clone(r.sinks)
+}
+
+// sinks returns the sinks that hear the peer across its rooms.
+func (p *Peer) sinks() []*fileSink {
+	var sinks []*fileSink
+	for _, room := range p.rooms {
+		sinks = append(sinks, room.sinksFor(p)...)
+	}
+	return sinks
+}
+
+// write records pkt, from a source track at clockRate, shifted onto the
+// sink's stream like writeLoop does for a peer's relay track. Writes after
+// the sink closed are ignored.
+func (s *fileSink) write(pkt *rtp.Packet, red bool, clockRate uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.recorder == nil {
+		return
+	}
+
+	now := time.Now()
+	written := &rtp.Packet{Header: pkt.Header.Clone(), Payload: pkt.Payload}
+	s.clock.rewrite(written, clockRate, now)
+	if err := recordRTP(s.recorder, written, red); err != nil {
+		fmt.Printf("Error writing file sink %s: %s\n", s.ID, err.Error())
+		return
+	}
+	s.clock.relayed(written, now)
+}
+
+func (s *fileSink) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.recorder == nil {
+		return nil
+	}
+	err := s.recorder.Close()
+	s.recorder = nil
+	return err
+}
+
+// roomSinksHandler serves GET /rooms/<id>/sinks, listing the room's file
+// sinks, and POST, attaching a new one.
+func roomSinksHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodGet, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	var response any
+	if req.Method == http.MethodPost {
+		if config.RecordDir == "" {
+			writeError(res, req, "recording is not configured, set -record-dir", http.StatusConflict)
+			return
+		}
+		sink, err := room.addSink()
+		if errors.Is(err, errSinkMixesSources) {
+			writeError(res, req, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeError(res, req, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response = sinkInfo{ID: sink.ID, Path: sink.Path}
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusCreated)
+	} else {
+		room.mutex.Lock()
+		sinks := []sinkInfo{}
+		for _, sink := range room.sinks {
+			sinks = append(sinks, sinkInfo{ID: sink.ID, Path: sink.Path})
+		}
+		room.mutex.Unlock()
+		response = sinks
+		res.Header().Set("Content-Type", "application/json")
+	}
+
+	if err := json.NewEncoder(res).Encode(response); err != nil {
+		fmt.Printf("Error writing file sinks: %s\n", err.Error())
+	}
+}
+
+// roomSinkHandler serves DELETE /rooms/<id>/sinks/<sinkID>, detaching the
+// sink and finalizing its file.
+func roomSinkHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodDelete)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodDelete {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+
+	sink, err := room.removeSink(req.PathValue("sinkID"))
+	if sink == nil {
+		writeError(res, req, "sink not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(res, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(sinkInfo{ID: sink.ID, Path: sink.Path}); err != nil {
+		fmt.Printf("Error writing file sink: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/rtpdump"
+)
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	server := startServer(t, "-admin-token", adminToken, "-record-dir", dir, "-record-format", recordFormatRTPDump, "-record-on-join=false")
+	query := url.Values{"room": {"sunk"}, "topology": {topologyBroadcast}}
+	publisher := server.JoinQuery(t, query)
+	subscriber := server.JoinQuery(t, query)
+	assertRelayed(t, publisher, subscriber, relayTimeout)
+
+	sinksURL := server.URL + "/rooms/sunk/sinks"
+	if status := statusWithToken(t, http.MethodPost, sinksURL, ""); status != http.StatusUnauthorized {
+		t.Errorf("sink POST without the admin token answered %d, want 401", status)
+	}
+	status, body := request(t, http.MethodPost, sinksURL, nil)
+	if status != http.StatusCreated {
+		t.Fatalf("sink POST answered %d: %s", status, body)
+	}
+	var sink sinkInfo
+	if err := json.Unmarshal([]byte(body), &sink); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(sink.Path) != dir {
+		t.Errorf("sink writes %s, outside the record directory %s", sink.Path, dir)
+	}
+	if _, body = request(t, http.MethodGet, sinksURL, nil); !containsSink(t, body, sink) {
+		t.Errorf("sinks listed as %s, want %s among them", body, sink.ID)
+	}
+
+	time.Sleep(time.Second)
+	if status, body = request(t, http.MethodDelete, sinksURL+"/"+sink.ID, nil); status != http.StatusOK {
+		t.Fatalf("sink DELETE answered %d: %s", status, body)
+	}
+	if status, _ = request(t, http.MethodDelete, sinksURL+"/"+sink.ID, nil); status != http.StatusNotFound {
+		t.Errorf("second sink DELETE answered %d, want 404", status)
+	}
+
+	// The sink hears what the subscriber does, a second of the publisher,
+	// about 50 packets.
+	file, err := os.Open(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, _, err := rtpdump.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []*rtp.Packet
+	for {
+		dumped, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt := &rtp.Packet{}
+		if err = pkt.Unmarshal(dumped.Payload); err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, pkt)
+	}
+	if len(packets) < 30 {
+		t.Fatalf("sink wrote %d packets over a second, want about 50", len(packets))
+	}
+	for i, pkt := range packets[1:] {
+		if pkt.SSRC != packets[0].SSRC {
+			t.Fatalf("packet %d has SSRC %d, want the publisher's %d", i+1, pkt.SSRC, packets[0].SSRC)
+		}
+		if pkt.SequenceNumber != packets[i].SequenceNumber+1 {
+			t.Fatalf("packet %d has sequence number %d after %d", i+1, pkt.SequenceNumber, packets[i].SequenceNumber)
+		}
+	}
+}
+
+func containsSink(t *testing.T, body string, sink sinkInfo) bool {
+	t.Helper()
+
+	var sinks []sinkInfo
+	if err := json.Unmarshal([]byte(body), &sinks); err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range sinks {
+		if listed == sink {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFileSinkNeedsOneSource(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken, "-record-dir", t.TempDir(), "-record-on-join=false")
+	for _, query := range []url.Values{
+		{"room": {"paired"}, "topology": {topologyPairs}},
+		{"room": {"meshed"}, "topology": {topologyMesh}},
+		{"room": {"talking"}, "topology": {topologyMesh}, "duplex": {duplexHalf}},
+	} {
+		server.JoinQuery(t, query)
+		room := query.Get("room")
+		status, body := request(t, http.MethodPost, server.URL+"/rooms/"+room+"/sinks", nil)
+		want := http.StatusConflict
+		if query.Has("duplex") {
+			want = http.StatusCreated
+		}
+		if status != want {
+			t.Errorf("sink POST to %s room %s answered %d, want %d: %s", query.Get("topology"), room, status, want, body)
+		}
+	}
+	if sinks := len(roomManager.findRoom("paired").sinks); sinks != 0 {
+		t.Errorf("rejected sink POST attached %d sinks", sinks)
+	}
+}
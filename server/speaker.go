@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Values of the duplex query parameter.
+const (
+	duplexFull = "full"
+	duplexHalf = "half"
+)
+
+// speakerRequest is the JSON body of PUT /rooms/<id>/speaker.
+type speakerRequest struct {
+	PeerID string `json:"peerId"`
+}
+
+// speakerStatus reports a half-duplex room's speaker, empty when nobody has
+// the floor.
+type speakerStatus struct {
+	Speaker string `json:"speaker"`
+}
+
+// isSpeaker reports whether peer may be heard in the room: any peer unless
+// the room is half-duplex, where only its Speaker is.
+func (r *Room) isSpeaker(peer *Peer) bool {
+	if !r.Options.HalfDuplex {
+		return true
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.Speaker == peer
+}
+
+// setSpeaker hands the floor to the member with peerID, or to nobody for an
+// empty one, and returns the new speaker. It reports false, changing
+// nothing, when no member has peerID. The member is looked up under the
+// lock the assignment takes, so a peer removePeer took out meanwhile never
+// becomes a speaker nobody clears.
+func (r *Room) setSpeaker(peerID string) (*Peer, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var speaker *Peer
+	if peerID != "" {
+		i := slices.IndexFunc(r.Peers, func(peer *Peer) bool { return peer.ID == peerID })
+		if i < 0 {
+			return nil, false
+		}
+		speaker = r.Peers[i]
+	}
+	r.Speaker = speaker
+	return speaker, true
+}
+
+// roomSpeakerHandler serves the floor of a half-duplex room: GET
+// /rooms/<id>/speaker returns its speaker, PUT with a speakerRequest hands
+// the floor to the peer, and DELETE takes it back so nobody is heard.
+func roomSpeakerHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodGet, http.MethodPut, http.MethodDelete)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodPut && req.Method != http.MethodDelete {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	room := roomManager.findRoom(req.PathValue("id"))
+	if room == nil {
+		writeError(res, req, "room not found", http.StatusNotFound)
+		return
+	}
+	if !room.Options.HalfDuplex {
+		writeError(res, req, "room is not half-duplex, join it first with duplex=half", http.StatusConflict)
+		return
+	}
+
+	var speaker *Peer
+	switch req.Method {
+	case http.MethodPut:
+		var request speakerRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			writeError(res, req, "invalid speaker request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		found := false
+		if request.PeerID != "" {
+			speaker, found = room.setSpeaker(request.PeerID)
+		}
+		if !found {
+			writeError(res, req, "peer not found", http.StatusNotFound)
+			return
+		}
+		fmt.Printf("Peer %s has the floor in room %s\n", speaker.ID, room.ID)
+		room.logEvent(eventSpeaker, speaker.ID, "granted the floor")
+	case http.MethodDelete:
+		room.setSpeaker("")
+		fmt.Printf("Nobody has the floor in room %s\n", room.ID)
+		room.logEvent(eventSpeaker, "", "floor released")
+	default:
+		room.mutex.Lock()
+		speaker = room.Speaker
+		room.mutex.Unlock()
+	}
+
+	status := speakerStatus{}
+	if speaker != nil {
+		status.Speaker = speaker.ID
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(status); err != nil {
+		fmt.Printf("Error writing speaker: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+)
+
+// grantFloor hands the floor of the room to peer, returning the status.
+func grantFloor(t *testing.T, server *testServer, room string, peer *synthpeer.Peer) int {
+	t.Helper()
+
+	status, _ := request(t, http.MethodPut, server.URL+"/rooms/"+room+"/speaker",
+		strings.NewReader(fmt.Sprintf(`{"peerId": %q}`, peer.ID)))
+	return status
+}
+
+func TestHalfDuplexRelaysOnlyTheSpeaker(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	query := url.Values{"room": {"ptt"}, "topology": {"mesh"}, "duplex": {"half"}}
+	alice := server.JoinQuery(t, query)
+	bob := server.JoinQuery(t, query)
+	carol := server.JoinQuery(t, query)
+
+	// Nobody has the floor yet.
+	assertNotRelayed(t, alice, bob, silenceWait)
+
+	if status := grantFloor(t, server, "ptt", alice); status != http.StatusOK {
+		t.Fatalf("granting the floor answered %d", status)
+	}
+	assertRelayed(t, alice, bob, relayTimeout)
+	assertRelayed(t, alice, carol, relayTimeout)
+	assertNotRelayed(t, bob, carol, silenceWait)
+	assertNotRelayed(t, carol, alice, silenceWait)
+
+	if status := grantFloor(t, server, "ptt", bob); status != http.StatusOK {
+		t.Fatalf("handing the floor over answered %d", status)
+	}
+	assertRelayed(t, bob, alice, relayTimeout)
+	assertRelayed(t, bob, carol, relayTimeout)
+	// Packets of the former speaker already queued may still arrive.
+	heard := carol.Heard(alice)
+	time.Sleep(silenceWait)
+	if carol.Heard(alice) > heard+5 {
+		t.Fatal("the former speaker is still heard")
+	}
+
+	// A peer that left can't be granted the floor.
+	carol.Close()
+	if status := grantFloor(t, server, "ptt", carol); status != http.StatusNotFound {
+		t.Fatalf("granting the floor to a peer that left answered %d, want 404", status)
+	}
+	_, body := request(t, http.MethodGet, server.URL+"/rooms/ptt/speaker", nil)
+	if !strings.Contains(body, bob.ID) {
+		t.Fatalf("speaker is %s, want bob still", body)
+	}
+}
+
+func TestSetSpeakerOfRemovedPeer(t *testing.T) {
+	room := &Room{ID: "ptt", Options: RoomOptions{HalfDuplex: true}}
+	peer := &Peer{ID: "gone"}
+	room.Peers = []*Peer{peer}
+	room.removePeer(peer)
+
+	if speaker, found := room.setSpeaker("gone"); found || speaker != nil {
+		t.Fatal("removed peer granted the floor")
+	}
+	if room.isSpeaker(peer) {
+		t.Fatal("removed peer is the speaker")
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// relayStats aggregates relay counters across all rooms.
+var relayStats struct {
+	// PacketsRelayed counts packets written to destination tracks.
+	PacketsRelayed atomic.Uint64
+	// PacketsDropped counts packets discarded because a destination's
+	// queue was full.
+	PacketsDropped atomic.Uint64
+	// PacketsRateLimited counts packets discarded by -max-egress-bitrate.
+	PacketsRateLimited atomic.Uint64
+	// WriteErrors counts failed writes to destination tracks.
+	WriteErrors atomic.Uint64
+	// PacketsLossSimulated counts packets discarded by -simulate-loss.
+	PacketsLossSimulated atomic.Uint64
+	// PacketsSilenceInserted counts silence packets sent by -silence-fill.
+	PacketsSilenceInserted atomic.Uint64
+	// PacketsEmptyDropped counts packets without payload that
+	// -empty-payloads dropped instead of relaying.
+	PacketsEmptyDropped atomic.Uint64
+	// ActiveRelays counts running relay loops, one per source track, see
+	// connectPeers. It returns to zero once every peer left; otherwise
+	// relays leak.
+	ActiveRelays atomic.Int64
+}
+
+type statsSnapshot struct {
+	Rooms              int    `json:"rooms"`
+	Peers              int    `json:"peers"`
+	PacketsRelayed     uint64 `json:"packetsRelayed"`
+	PacketsDropped     uint64 `json:"packetsDropped"`
+	PacketsRateLimited uint64 `json:"packetsRateLimited"`
+	WriteErrors        uint64 `json:"writeErrors"`
+	ActiveRelays       int64  `json:"activeRelays"`
+	// PacketsEmptyDropped counts packets without payload dropped by
+	// -empty-payloads.
+	PacketsEmptyDropped uint64 `json:"packetsEmptyDropped"`
+	// PacketsLossSimulated is only reported while -simulate-loss is on.
+	PacketsLossSimulated uint64 `json:"packetsLossSimulated,omitempty"`
+	// PacketsSilenceInserted is only reported while -silence-fill is on.
+	PacketsSilenceInserted uint64 `json:"packetsSilenceInserted,omitempty"`
+}
+
+func statsHandler(res http.ResponseWriter, req *http.Request) {
+	snapshot := statsSnapshot{
+		PacketsRelayed:         relayStats.PacketsRelayed.Load(),
+		PacketsDropped:         relayStats.PacketsDropped.Load(),
+		PacketsRateLimited:     relayStats.PacketsRateLimited.Load(),
+		WriteErrors:            relayStats.WriteErrors.Load(),
+		ActiveRelays:           relayStats.ActiveRelays.Load(),
+		PacketsEmptyDropped:    relayStats.PacketsEmptyDropped.Load(),
+		PacketsLossSimulated:   relayStats.PacketsLossSimulated.Load(),
+		PacketsSilenceInserted: relayStats.PacketsSilenceInserted.Load(),
+	}
+
+	writeStats(res, snapshot)
+}
+
+// statsResetHandler serves POST /admin/stats/reset?confirm=true, which
+// zeroes the relay counters, e.g. between load test runs against a
+// persistent server, and responds with their totals up to the reset.
+// ActiveRelays is a gauge of running relays and is left alone, as are the
+// connections themselves.
+func statsResetHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+	if req.URL.Query().Get("confirm") != "true" {
+		writeError(res, req, "resetting the stats needs ?confirm=true", http.StatusBadRequest)
+		return
+	}
+
+	snapshot := statsSnapshot{
+		PacketsRelayed:         relayStats.PacketsRelayed.Swap(0),
+		PacketsDropped:         relayStats.PacketsDropped.Swap(0),
+		PacketsRateLimited:     relayStats.PacketsRateLimited.Swap(0),
+		WriteErrors:            relayStats.WriteErrors.Swap(0),
+		ActiveRelays:           relayStats.ActiveRelays.Load(),
+		PacketsEmptyDropped:    relayStats.PacketsEmptyDropped.Swap(0),
+		PacketsLossSimulated:   relayStats.PacketsLossSimulated.Swap(0),
+		PacketsSilenceInserted: relayStats.PacketsSilenceInserted.Swap(0),
+	}
+	fmt.Printf("Relay stats reset after %d packets relayed\n", snapshot.PacketsRelayed)
+	writeStats(res, snapshot)
+}
+
+// writeStats completes snapshot with the room and peer counts and writes it.
+func writeStats(res http.ResponseWriter, snapshot statsSnapshot) {
+	roomManager.mutex.RLock()
+	snapshot.Rooms = len(roomManager.rooms)
+	for _, room := range roomManager.rooms {
+		snapshot.Peers += len(room.otherPeers(nil))
+	}
+	roomManager.mutex.RUnlock()
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(snapshot); err != nil {
+		fmt.Printf("Error writing stats: %s\n", err.Error())
+	}
+}
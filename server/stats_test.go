@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestStatsReset(t *testing.T) {
+	server := startServer(t, "-admin-token", adminToken)
+	first := server.Join(t, "reset")
+	second := server.Join(t, "reset")
+	assertRelayed(t, first, second, relayTimeout)
+
+	if status := statusWithToken(t, http.MethodPost, server.URL+"/admin/stats/reset?confirm=true", ""); status != http.StatusUnauthorized {
+		t.Errorf("reset without the admin token answered %d, want 401", status)
+	}
+	if status, _ := request(t, http.MethodPost, server.URL+"/admin/stats/reset", nil); status != http.StatusBadRequest {
+		t.Errorf("reset without confirm=true answered %d, want 400", status)
+	}
+	if relayStats.PacketsRelayed.Load() == 0 {
+		t.Fatal("unconfirmed reset zeroed the counters")
+	}
+
+	// The relays keep counting, so start from totals they won't reach again
+	// during the test.
+	const total = 1_000_000
+	relayStats.PacketsRelayed.Store(total)
+	relayStats.PacketsDropped.Store(total)
+	relayStats.WriteErrors.Store(total)
+	status, body := request(t, http.MethodPost, server.URL+"/admin/stats/reset?confirm=true", nil)
+	if status != http.StatusOK {
+		t.Fatalf("reset answered %d: %s", status, body)
+	}
+	var before statsSnapshot
+	if err := json.Unmarshal([]byte(body), &before); err != nil {
+		t.Fatalf("decoding the reset response: %s", err.Error())
+	}
+	if before.PacketsRelayed < total || before.PacketsDropped != total || before.WriteErrors != total {
+		t.Errorf("reset reported totals %+v, want at least %d each", before, total)
+	}
+	if before.ActiveRelays != 2 {
+		t.Errorf("reset reported %d active relays, want 2", before.ActiveRelays)
+	}
+
+	_, body = request(t, http.MethodGet, server.URL+"/stats", nil)
+	var after statsSnapshot
+	if err := json.Unmarshal([]byte(body), &after); err != nil {
+		t.Fatalf("decoding the stats: %s", err.Error())
+	}
+	if after.PacketsRelayed >= total || after.PacketsDropped != 0 || after.WriteErrors != 0 {
+		t.Errorf("counters after the reset: %+v", after)
+	}
+	if after.ActiveRelays != 2 || after.Peers != 2 {
+		t.Errorf("reset touched the connections: %d relays for %d peers, want 2 and 2", after.ActiveRelays, after.Peers)
+	}
+
+	// The connections still relay.
+	assertRelayed(t, first, second, relayTimeout)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+)
+
+// relayStream counts what a destination was relayed from one stream of a
+// source, one SSRC.
+type relayStream struct {
+	source  *Peer
+	ssrc    uint32
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+// relayStreamKey identifies a destination's relayStream.
+type relayStreamKey struct {
+	source *Peer
+	ssrc   uint32
+}
+
+// outboundPacket is a packet queued for writeLoop and the stream it
+// counts towards.
+type outboundPacket struct {
+	pkt    *rtp.Packet
+	stream *relayStream
+}
+
+// relayStream returns the peer's counters for the source's stream with ssrc.
+// Those of a closed source aren't kept, so a packet the source's relay is
+// still fanning out after teardownPeer forgot its streams adds none back.
+func (p *Peer) relayStream(source *Peer, ssrc uint32) *relayStream {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := relayStreamKey{source: source, ssrc: ssrc}
+	if stream, ok := p.streams[key]; ok {
+		return stream
+	}
+	stream := &relayStream{source: source, ssrc: ssrc}
+	select {
+	case <-source.done:
+	default:
+		p.streams[key] = stream
+	}
+	return stream
+}
+
+// forgetStreams drops the peer's counters for the source's streams.
+func (p *Peer) forgetStreams(source *Peer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key := range p.streams {
+		if key.source == source {
+			delete(p.streams, key)
+		}
+	}
+}
+
+// streamInfo describes a relayed stream in the admin API. SSRC is the
+// source's; RelaySSRC the destination's relay track.
+type streamInfo struct {
+	Source    string `json:"source"`
+	Dest      string `json:"destination"`
+	SSRC      uint32 `json:"ssrc"`
+	RelaySSRC uint32 `json:"relaySsrc"`
+	Packets   uint64 `json:"packets"`
+	Bytes     uint64 `json:"bytes"`
+}
+
+// streamsHandler serves GET /admin/streams, listing the streams relayed
+// from each source to each destination since they were paired, with the
+// RTP packets and payload bytes written to the destination.
+func streamsHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodGet)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodGet {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdmin(res, req) {
+		return
+	}
+
+	var peers []*Peer
+	roomManager.mutex.RLock()
+	for _, room := range roomManager.rooms {
+		for _, peer := range room.otherPeers(nil) {
+			if !slices.Contains(peers, peer) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	roomManager.mutex.RUnlock()
+
+	streams := []streamInfo{}
+	for _, peer := range peers {
+		if peer.AudioSender == nil {
+			continue
+		}
+		for _, lane := range append([]*Peer{peer}, peer.lanes...) {
+			relaySSRC, _ := senderSSRC(lane.AudioSender)
+			lane.mutex.Lock()
+			for _, stream := range lane.streams {
+				streams = append(streams, streamInfo{
+					Source:    stream.source.ID,
+					Dest:      peer.ID,
+					SSRC:      stream.ssrc,
+					RelaySSRC: relaySSRC,
+					Packets:   stream.packets.Load(),
+					Bytes:     stream.bytes.Load(),
+				})
+			}
+			lane.mutex.Unlock()
+		}
+	}
+	slices.SortFunc(streams, func(a, b streamInfo) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Dest, b.Dest), cmp.Compare(a.SSRC, b.SSRC), cmp.Compare(a.RelaySSRC, b.RelaySSRC))
+	})
+
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(streams); err != nil {
+		fmt.Printf("Error writing streams: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/opus"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// g711ClockRate is the sample and clock rate of PCMU and PCMA.
+	g711ClockRate = 8000
+	// opusClockRate is the RTP clock rate of Opus whatever its sample rate.
+	opusClockRate = 48000
+	// maxOpusSamples is the longest Opus packet, 120ms, at g711ClockRate.
+	maxOpusSamples = 120 * g711ClockRate / 1000
+)
+
+var (
+	mimeTypeOpus = strings.ToLower(webrtc.MimeTypeOpus)
+	mimeTypePCMU = strings.ToLower(webrtc.MimeTypePCMU)
+	mimeTypePCMA = strings.ToLower(webrtc.MimeTypePCMA)
+)
+
+// payloadAdapter converts one source track's packets to each destination's
+// relay codec. Opus and RED convert into each other losslessly, see
+// convertRED. For destinations hearing the source through a room with
+// RoomOptions.Transcode, Opus is also decoded for PCMU and PCMA peers, once
+// per packet however many hear it, and PCMU and PCMA convert into each
+// other. There is no Opus encoder, so G.711 sources are never heard by Opus
+// peers.
+type payloadAdapter struct {
+	source *Peer
+	// codecs are the source's negotiated codecs, lowercase MIME type by
+	// payload type.
+	codecs map[uint8]string
+	// warned are the destinations already told they can't hear the source.
+	warned map[string]bool
+
+	decoder       *opus.Decoder
+	lastTimestamp uint32
+	timestamp     uint32
+	started       bool
+
+	// The packet being relayed, and its decoding once a destination needed
+	// it.
+	pkt          *rtp.Packet
+	sourceCodec  string
+	decoded      bool
+	pcm          []int16
+	pcmTimestamp uint32
+}
+
+func newPayloadAdapter(source *Peer, codecs []webrtc.RTPCodecParameters) *payloadAdapter {
+	adapter := &payloadAdapter{
+		source: source,
+		codecs: make(map[uint8]string, len(codecs)),
+		warned: map[string]bool{},
+	}
+	for _, codec := range codecs {
+		adapter.codecs[uint8(codec.PayloadType)] = strings.ToLower(codec.MimeType)
+	}
+	return adapter
+}
+
+// codec returns the lowercase MIME type of pkt, empty when unknown.
+func (a *payloadAdapter) codec(pkt *rtp.Packet) string {
+	return a.codecs[pkt.PayloadType]
+}
+
+// reset starts relaying pkt. Decoders must see every relayed packet, so
+// reset decodes Opus right away once any destination has needed it.
+func (a *payloadAdapter) reset(pkt *rtp.Packet) {
+	a.pkt = pkt
+	a.sourceCodec = a.codec(pkt)
+	a.decoded = false
+	if a.decoder != nil && isOpusFamily(a.sourceCodec) {
+		a.decode()
+	}
+}
+
+// adapt converts relayed, a copy of the current packet, to the relay codec
+// of the destination, hearing the source through room, reporting false when
+// the destination can't hear it.
+func (a *payloadAdapter) adapt(relayed *rtp.Packet, room *Room, destination *Peer, destinationCodecs []webrtc.RTPCodecParameters) bool {
+	destinationCodec := strings.ToLower(destination.AudioTrack.Codec().MimeType)
+
+	switch {
+	case isOpusFamily(a.sourceCodec) && isOpusFamily(destinationCodec):
+		sourceRED, destinationRED := a.sourceCodec == mimeTypeRED, destinationCodec == mimeTypeRED
+		if !sourceRED && !destinationRED {
+			return true
+		}
+		payload, err := convertRED(a.pkt.Payload, sourceRED, destinationRED, payloadTypes(destinationCodecs)[mimeTypeOpus])
+		if err != nil {
+			fmt.Printf("Error relaying RED from peer %s: %s\n", a.source.ID, err.Error())
+			return false
+		}
+		relayed.Payload = payload
+		return true
+	case a.sourceCodec == "" || a.sourceCodec == destinationCodec:
+		return true
+	case !isG711(destinationCodec):
+		a.unsupported(room, destination, destinationCodec, "Opus can't be encoded")
+		return false
+	case !room.Options.Transcode:
+		a.unsupported(room, destination, destinationCodec, "transcoding is disabled in the room")
+		return false
+	case isG711(a.sourceCodec):
+		relayed.Payload = encodeG711(destinationCodec, decodeG711(a.sourceCodec, a.pkt.Payload))
+		return true
+	case isOpusFamily(a.sourceCodec):
+		pcm, timestamp, ok := a.decode()
+		if !ok {
+			return false
+		}
+		relayed.Payload = encodeG711(destinationCodec, pcm)
+		relayed.Timestamp = timestamp
+		return true
+	default:
+		a.unsupported(room, destination, destinationCodec, "the codec can't be transcoded")
+		return false
+	}
+}
+
+// decode decodes the current Opus or RED packet to 8kHz mono PCM, returning
+// it with its timestamp on the 8kHz clock.
+func (a *payloadAdapter) decode() ([]int16, uint32, bool) {
+	if a.decoded {
+		return a.pcm, a.pcmTimestamp, a.pcm != nil
+	}
+	a.decoded = true
+	a.pcm = nil
+
+	if a.decoder == nil {
+		decoder, err := opus.NewDecoderWithOutput(g711ClockRate, 1)
+		if err != nil {
+			fmt.Printf("Error creating Opus decoder for peer %s: %s\n", a.source.ID, err.Error())
+			return nil, 0, false
+		}
+		a.decoder = &decoder
+	}
+
+	payload := a.pkt.Payload
+	if a.sourceCodec == mimeTypeRED {
+		primary, err := redPrimary(payload)
+		if err != nil {
+			fmt.Printf("Error transcoding RED from peer %s: %s\n", a.source.ID, err.Error())
+			return nil, 0, false
+		}
+		payload = primary
+	}
+
+	pcm := make([]int16, maxOpusSamples)
+	samples, err := a.decoder.DecodeToInt16(payload, pcm)
+	if err != nil {
+		fmt.Printf("Error decoding Opus from peer %s: %s\n", a.source.ID, err.Error())
+		return nil, 0, false
+	}
+
+	// The 8kHz timestamps advance by a sixth of the source's, following
+	// its gaps and reordering.
+	if a.started {
+		a.timestamp += uint32(int32(a.pkt.Timestamp-a.lastTimestamp) / (opusClockRate / g711ClockRate))
+	} else {
+		a.timestamp = a.pkt.Timestamp / (opusClockRate / g711ClockRate)
+		a.started = true
+	}
+	a.lastTimestamp = a.pkt.Timestamp
+
+	a.pcm, a.pcmTimestamp = pcm[:samples], a.timestamp
+	return a.pcm, a.pcmTimestamp, true
+}
+
+// unsupported logs, once per destination, that it can't hear the source,
+// also in the events of the room it hears the source through.
+func (a *payloadAdapter) unsupported(room *Room, destination *Peer, destinationCodec, reason string) {
+	if a.warned[destination.ID] {
+		return
+	}
+	a.warned[destination.ID] = true
+	message := fmt.Sprintf("not relaying %s from peer %s to %s: %s", a.sourceCodec, a.source.ID, destinationCodec, reason)
+	fmt.Printf("Peer %s: %s\n", destination.ID, message)
+	room.logEvent(eventError, destination.ID, message)
+}
+
+func isOpusFamily(mimeType string) bool {
+	return mimeType == mimeTypeOpus || mimeType == mimeTypeRED
+}
+
+func isG711(mimeType string) bool {
+	return mimeType == mimeTypePCMU || mimeType == mimeTypePCMA
+}
+
+// decodeG711 expands PCMU or PCMA samples to linear PCM.
+func decodeG711(mimeType string, payload []byte) []int16 {
+	pcm := make([]int16, len(payload))
+	for i, sample := range payload {
+		if mimeType == mimeTypePCMU {
+			pcm[i] = ulawToLinear(sample)
+		} else {
+			pcm[i] = alawToLinear(sample)
+		}
+	}
+	return pcm
+}
+
+// encodeG711 compands linear PCM to PCMU or PCMA.
+func encodeG711(mimeType string, pcm []int16) []byte {
+	payload := make([]byte, len(pcm))
+	for i, sample := range pcm {
+		if mimeType == mimeTypePCMU {
+			payload[i] = linearToULaw(sample)
+		} else {
+			payload[i] = linearToALaw(sample)
+		}
+	}
+	return payload
+}
+
+// Segment end points of the G.711 companding curves, in the 14-bit (μ-law)
+// and 13-bit (A-law) magnitudes they operate on.
+var (
+	ulawSegmentEnds = [8]int{0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff, 0x1fff}
+	alawSegmentEnds = [8]int{0x1f, 0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff}
+)
+
+const (
+	ulawBias = 0x84
+	ulawClip = 8159
+)
+
+func g711Segment(value int, ends *[8]int) int {
+	for segment, end := range ends {
+		if value <= end {
+			return segment
+		}
+	}
+	return len(ends)
+}
+
+func linearToULaw(sample int16) byte {
+	value, mask := int(sample)>>2, 0xff
+	if value < 0 {
+		value, mask = -value, 0x7f
+	}
+	value = min(value, ulawClip) + ulawBias>>2
+
+	segment := g711Segment(value, &ulawSegmentEnds)
+	if segment >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	return byte((segment<<4 | (value>>(segment+1))&0x0f) ^ mask)
+}
+
+func ulawToLinear(code byte) int16 {
+	code = ^code
+	value := (int(code&0x0f)<<3 + ulawBias) << ((code & 0x70) >> 4)
+	if code&0x80 != 0 {
+		return int16(ulawBias - value)
+	}
+	return int16(value - ulawBias)
+}
+
+func linearToALaw(sample int16) byte {
+	value, mask := int(sample)>>3, 0xd5
+	if value < 0 {
+		value, mask = -value-1, 0x55
+	}
+
+	segment := g711Segment(value, &alawSegmentEnds)
+	if segment >= 8 {
+		return byte(0x7f ^ mask)
+	}
+	code := segment << 4
+	if segment < 2 {
+		code |= (value >> 1) & 0x0f
+	} else {
+		code |= (value >> segment) & 0x0f
+	}
+	return byte(code ^ mask)
+}
+
+func alawToLinear(code byte) int16 {
+	code ^= 0x55
+	value := int(code&0x0f) << 4
+	switch segment := int(code&0x70) >> 4; segment {
+	case 0:
+		value += 8
+	case 1:
+		value += 0x108
+	default:
+		value = (value + 0x108) << (segment - 1)
+	}
+	if code&0x80 != 0 {
+		return int16(value)
+	}
+	return int16(-value)
+}
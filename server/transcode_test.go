@@ -0,0 +1,189 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Reference values of the ITU-T G.711 reference implementation.
+func TestULaw(t *testing.T) {
+	tests := []struct {
+		linear  int16
+		code    byte
+		decoded int16
+	}{
+		{0, 0xff, 0},
+		{1000, 0xce, 988},
+		{-1000, 0x4e, -988},
+		{8031, 0xa0, 7932},
+		{32767, 0x80, 32124},
+		{-32768, 0x00, -32124},
+	}
+	for _, test := range tests {
+		if code := linearToULaw(test.linear); code != test.code {
+			t.Errorf("linearToULaw(%d) = %#x, want %#x", test.linear, code, test.code)
+		}
+		if decoded := ulawToLinear(test.code); decoded != test.decoded {
+			t.Errorf("ulawToLinear(%#x) = %d, want %d", test.code, decoded, test.decoded)
+		}
+	}
+}
+
+func TestULawRoundTrip(t *testing.T) {
+	for code := range 256 {
+		// 0x7f is negative zero, encoded back as 0xff.
+		want := byte(code)
+		if want == 0x7f {
+			want = 0xff
+		}
+		if got := linearToULaw(ulawToLinear(byte(code))); got != want {
+			t.Errorf("μ-law %#x round-trips to %#x", code, got)
+		}
+	}
+}
+
+func TestALaw(t *testing.T) {
+	tests := []struct {
+		linear  int16
+		code    byte
+		decoded int16
+	}{
+		{0, 0xd5, 8},
+		{1000, 0xfa, 1008},
+		{-1000, 0x7a, -1008},
+		{32767, 0xaa, 32256},
+		{-32768, 0x2a, -32256},
+	}
+	for _, test := range tests {
+		if code := linearToALaw(test.linear); code != test.code {
+			t.Errorf("linearToALaw(%d) = %#x, want %#x", test.linear, code, test.code)
+		}
+		if decoded := alawToLinear(test.code); decoded != test.decoded {
+			t.Errorf("alawToLinear(%#x) = %d, want %d", test.code, decoded, test.decoded)
+		}
+	}
+}
+
+func TestALawRoundTrip(t *testing.T) {
+	for code := range 256 {
+		if got := linearToALaw(alawToLinear(byte(code))); got != byte(code) {
+			t.Errorf("A-law %#x round-trips to %#x", code, got)
+		}
+	}
+}
+
+// silkFrame is a 20ms SILK narrowband Opus packet, 160 samples at 8kHz.
+var silkFrame = []byte{0x08}
+
+func TestOpusTimestampsScaledTo8kHz(t *testing.T) {
+	adapter := newPayloadAdapter(&Peer{ID: "source"}, []webrtc.RTPCodecParameters{audioCodecs[0]})
+
+	// In order, after a lost packet, and reordered.
+	tests := []struct {
+		timestamp uint32
+		want      uint32
+	}{
+		{96000, 16000},
+		{96960, 16160},
+		{98880, 16480},
+		{97920, 16320},
+	}
+	for _, test := range tests {
+		adapter.reset(&rtp.Packet{Header: rtp.Header{PayloadType: 111, Timestamp: test.timestamp}, Payload: silkFrame})
+		pcm, timestamp, ok := adapter.decode()
+		if !ok {
+			t.Fatalf("decoding the packet at %d failed", test.timestamp)
+		}
+		if len(pcm) != 160 {
+			t.Errorf("decoded %d samples, want 160", len(pcm))
+		}
+		if timestamp != test.want {
+			t.Errorf("timestamp %d scaled to %d, want %d", test.timestamp, timestamp, test.want)
+		}
+	}
+}
+
+// joinWithCodec joins a peer negotiating only codec to room and returns its
+// connection and the packets it receives.
+func joinWithCodec(t *testing.T, serverURL, room string, codec webrtc.RTPCodecParameters) (*webrtc.PeerConnection, <-chan *rtp.Packet) {
+	t.Helper()
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+	peerConnection, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = peerConnection.Close() })
+	if _, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := make(chan *rtp.Packet, 100)
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		for {
+			pkt, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			select {
+			case packets <- pkt:
+			default:
+			}
+		}
+	})
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+
+	res, err := http.Post(serverURL+"/whip?"+url.Values{"room": {room}}.Encode(), "application/sdp", strings.NewReader(peerConnection.LocalDescription().SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("join answered %d: %s", res.StatusCode, answer)
+	}
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}); err != nil {
+		t.Fatal(err)
+	}
+	return peerConnection, packets
+}
+
+func TestPCMUSubscriberHearsOpusPublisher(t *testing.T) {
+	server := startServer(t, "-transcode")
+	_, packets := joinWithCodec(t, server.URL, "phone", audioCodecs[2])
+	server.Join(t, "phone")
+
+	select {
+	case pkt := <-packets:
+		if pkt.PayloadType != 0 {
+			t.Errorf("relayed payload type %d, want PCMU's 0", pkt.PayloadType)
+		}
+		if len(pkt.Payload) != 160 {
+			t.Errorf("relayed %d bytes, want 20ms of PCMU", len(pkt.Payload))
+		}
+	case <-time.After(relayTimeout):
+		t.Fatal("PCMU peer heard nothing from the Opus peer")
+	}
+}
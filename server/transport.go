@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// Transport policies accepted by -rtcp-mux-policy and -bundle-policy. pion
+// always answers with RTCP muxed onto RTP and every section bundled onto one
+// transport; the policies decide whether offers that don't ask for that are
+// still answered (negotiate, balanced) or rejected up front with 422, since
+// their answer would describe transports the client didn't plan for.
+const (
+	rtcpMuxNegotiate = "negotiate"
+	rtcpMuxRequire   = "require"
+
+	bundleBalanced  = "balanced"
+	bundleMaxCompat = "max-compat"
+	bundleMaxBundle = "max-bundle"
+)
+
+// configureTransportPolicies validates the policies and sets them on the
+// configuration of new peer connections.
+func configureTransportPolicies(configuration *webrtc.Configuration) error {
+	switch config.RTCPMuxPolicy {
+	case rtcpMuxNegotiate:
+		configuration.RTCPMuxPolicy = webrtc.RTCPMuxPolicyNegotiate
+	case rtcpMuxRequire:
+		configuration.RTCPMuxPolicy = webrtc.RTCPMuxPolicyRequire
+	default:
+		return fmt.Errorf("unknown RTCP mux policy %q: want %s or %s", config.RTCPMuxPolicy, rtcpMuxNegotiate, rtcpMuxRequire)
+	}
+
+	switch config.BundlePolicy {
+	case bundleBalanced:
+		configuration.BundlePolicy = webrtc.BundlePolicyBalanced
+	case bundleMaxCompat:
+		configuration.BundlePolicy = webrtc.BundlePolicyMaxCompat
+	case bundleMaxBundle:
+		configuration.BundlePolicy = webrtc.BundlePolicyMaxBundle
+	default:
+		return fmt.Errorf("unknown bundle policy %q: want %s, %s or %s", config.BundlePolicy, bundleBalanced, bundleMaxCompat, bundleMaxBundle)
+	}
+	return nil
+}
+
+// checkTransportPolicies reports why the offer can't be answered under the
+// configured policies: with require, every accepted section must offer
+// a=rtcp-mux; with max-bundle, all of them must share one BUNDLE group.
+func checkTransportPolicies(offer *sdp.SessionDescription) error {
+	var bundled []string
+	for _, attribute := range offer.Attributes {
+		if attribute.Key == "group" && strings.HasPrefix(attribute.Value, "BUNDLE ") {
+			bundled = strings.Fields(strings.TrimPrefix(attribute.Value, "BUNDLE "))
+			break
+		}
+	}
+
+	for _, media := range offer.MediaDescriptions {
+		if media.MediaName.Port.Value == 0 {
+			continue
+		}
+		mid, _ := media.Attribute("mid")
+
+		if config.RTCPMuxPolicy == rtcpMuxRequire {
+			if _, ok := media.Attribute("rtcp-mux"); !ok {
+				return fmt.Errorf("%s section %q must offer a=rtcp-mux", media.MediaName.Media, mid)
+			}
+		}
+		if config.BundlePolicy == bundleMaxBundle && !slices.Contains(bundled, mid) {
+			return fmt.Errorf("%s section %q must be in the offer's BUNDLE group", media.MediaName.Media, mid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// withoutLine returns the SDP without its lines equal to line.
+func withoutLine(description, line string) string {
+	return strings.ReplaceAll(description, "\r\n"+line+"\r\n", "\r\n")
+}
+
+func TestRTCPMuxPolicy(t *testing.T) {
+	_, offer := createOffer(t, addAudio)
+	unmuxed := withoutLine(offer, "a=rtcp-mux")
+	if unmuxed == offer {
+		t.Fatal("offer has no a=rtcp-mux line to remove")
+	}
+
+	server := startServer(t, "-rtcp-mux-policy", rtcpMuxRequire)
+	res, body := postOffer(t, server.URL+"/whip?room=muxed", unmuxed, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("non-muxed offer answered %d under require, want 422: %s", res.StatusCode, body)
+	}
+	if !strings.Contains(body, "a=rtcp-mux") {
+		t.Errorf("error %q doesn't name the missing a=rtcp-mux", body)
+	}
+	if answer := answerTo(t, server.URL, "muxed"); !strings.Contains(answer, "\r\na=rtcp-mux\r\n") {
+		t.Errorf("answer under require doesn't mux RTCP:\n%s", answer)
+	}
+
+	server = startServer(t)
+	if res, body = postOffer(t, server.URL+"/whip?room=muxed", unmuxed, nil); res.StatusCode != http.StatusCreated {
+		t.Errorf("non-muxed offer answered %d under negotiate, want 201: %s", res.StatusCode, body)
+	}
+}
+
+func TestBundlePolicy(t *testing.T) {
+	_, offer := createOffer(t, addAudio)
+	unbundled := withoutLine(offer, "a=group:BUNDLE 0")
+	if unbundled == offer {
+		t.Fatal("offer has no a=group:BUNDLE 0 line to remove")
+	}
+
+	server := startServer(t, "-bundle-policy", bundleMaxBundle)
+	if res, body := postOffer(t, server.URL+"/whip?room=bundled", unbundled, nil); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("unbundled offer answered %d under max-bundle, want 422: %s", res.StatusCode, body)
+	}
+	answerTo(t, server.URL, "bundled")
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// turnCredentialPollInterval is how often watchTURNCredentials checks the
+// -turn-credential-file for changes.
+const turnCredentialPollInterval = 10 * time.Second
+
+// turnCredentials is the JSON content of the -turn-credential-file, e.g.
+//
+//	{"username": "1700000000:whip", "credential": "secret"}
+type turnCredentials struct {
+	Username   string `json:"username"`
+	Credential string `json:"credential"`
+}
+
+// readTURNCredentials reads and checks the -turn-credential-file, returning
+// its raw content as well.
+func readTURNCredentials() (turnCredentials, []byte, error) {
+	var credentials turnCredentials
+	data, err := os.ReadFile(config.TURNCredentialFile)
+	if err != nil {
+		return credentials, nil, err
+	}
+	if err = json.Unmarshal(data, &credentials); err != nil {
+		return credentials, nil, fmt.Errorf("invalid TURN credential file %s: %w", config.TURNCredentialFile, err)
+	}
+	if credentials.Username == "" || credentials.Credential == "" {
+		return credentials, nil, fmt.Errorf("TURN credential file %s needs a username and a credential", config.TURNCredentialFile)
+	}
+	return credentials, data, nil
+}
+
+// loadTURNCredentials sets the TURN credentials from the
+// -turn-credential-file, for credentials an external process rotates, see
+// watchTURNCredentials. It returns the file's content.
+func loadTURNCredentials() ([]byte, error) {
+	if config.TURNUsername != "" || config.TURNCredential != "" {
+		return nil, errors.New("-turn-credential-file and -turn-username or -turn-credential are mutually exclusive")
+	}
+	credentials, data, err := readTURNCredentials()
+	if err != nil {
+		return nil, err
+	}
+	config.TURNUsername, config.TURNCredential = credentials.Username, credentials.Credential
+	return data, nil
+}
+
+// watchTURNCredentials checks the -turn-credential-file every
+// turnCredentialPollInterval, giving the TURN servers of new peer
+// connections its credentials once they change; existing connections keep
+// the ones they were created with. A file that fails to read or parse
+// keeps the current credentials, e.g. while it is being rewritten.
+func watchTURNCredentials(current []byte) {
+	ticker := time.NewTicker(turnCredentialPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		current = reloadTURNCredentials(current)
+	}
+}
+
+// reloadTURNCredentials applies the -turn-credential-file when its content
+// differs from current, returning the content applied.
+func reloadTURNCredentials(current []byte) []byte {
+	credentials, data, err := readTURNCredentials()
+	if err != nil {
+		fmt.Printf("Error reloading TURN credentials, keeping the current ones: %s\n", err.Error())
+		return current
+	}
+	if bytes.Equal(data, current) {
+		return current
+	}
+
+	iceServersMutex.Lock()
+	config.TURNUsername, config.TURNCredential = credentials.Username, credentials.Credential
+	servers := slices.Clone(peerConnectionConfiguration.ICEServers)
+	for i, server := range servers {
+		if server.CredentialType == webrtc.ICECredentialTypePassword && server.Username != "" {
+			servers[i].Username, servers[i].Credential = credentials.Username, credentials.Credential
+		}
+	}
+	peerConnectionConfiguration.ICEServers = servers
+	iceServersMutex.Unlock()
+
+	fmt.Printf("Reloaded TURN credentials from %s, username %s\n", config.TURNCredentialFile, credentials.Username)
+	return data
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// writeTURNCredentials writes a -turn-credential-file.
+func writeTURNCredentials(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// turnUsername returns the username of new peer connections' TURN server.
+func turnUsername(t *testing.T) string {
+	t.Helper()
+
+	for _, server := range peerConfiguration().ICEServers {
+		if server.Username != "" {
+			return server.Username
+		}
+	}
+	t.Fatal("no TURN server configured")
+	return ""
+}
+
+func TestTURNCredentialFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turn.json")
+	writeTURNCredentials(t, path, `{"username": "1:whip", "credential": "first"}`)
+
+	config = Config{ICEServers: stringList{"turn:turn.example.com:3478"}, TURNCredentialFile: path}
+	peerConnectionConfiguration = webrtc.Configuration{}
+	current, err := loadTURNCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = configureICEServers(&peerConnectionConfiguration); err != nil {
+		t.Fatal(err)
+	}
+	if username := turnUsername(t); username != "1:whip" {
+		t.Fatalf("username %q, want 1:whip", username)
+	}
+
+	writeTURNCredentials(t, path, `{"username": "2:whip", "credential": "second"}`)
+	current = reloadTURNCredentials(current)
+	if username := turnUsername(t); username != "2:whip" {
+		t.Fatalf("username %q after the reload, want 2:whip", username)
+	}
+
+	// A file caught mid-rewrite keeps the current credentials.
+	writeTURNCredentials(t, path, `{"username": "3:wh`)
+	reloadTURNCredentials(current)
+	if username := turnUsername(t); username != "2:whip" {
+		t.Fatalf("username %q after a broken file, want 2:whip", username)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// validationReport describes how the server would answer an offer.
+type validationReport struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+	// Offer and Answer list the media sections on each side; Answer is empty
+	// when negotiation failed.
+	Offer  []mediaReport `json:"offer,omitempty"`
+	Answer []mediaReport `json:"answer,omitempty"`
+}
+
+type mediaReport struct {
+	Mid       string        `json:"mid"`
+	Kind      string        `json:"kind"`
+	Direction string        `json:"direction"`
+	Rejected  bool          `json:"rejected,omitempty"`
+	Codecs    []codecReport `json:"codecs"`
+}
+
+type codecReport struct {
+	PayloadType uint8  `json:"payloadType"`
+	Name        string `json:"name"`
+	ClockRate   uint32 `json:"clockRate"`
+	Channels    uint16 `json:"channels,omitempty"`
+}
+
+// validateHandler runs an offer through negotiation on a throwaway peer
+// connection and reports the outcome without creating a relay session.
+func validateHandler(res http.ResponseWriter, req *http.Request) {
+	addCORSHeaders(res, req, http.MethodPost)
+
+	if req.Method == http.MethodOptions {
+		return
+	}
+	if req.Method != http.MethodPost {
+		writeError(res, req, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	offer, err := readOffer(req)
+	if err != nil {
+		writeError(res, req, err.Error(), offerErrorStatus(err))
+		return
+	}
+
+	report := validateOffer(string(offer))
+	res.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(res).Encode(report); err != nil {
+		fmt.Printf("Error writing validation report: %s\n", err.Error())
+	}
+}
+
+func validateOffer(offer string) validationReport {
+	report := validationReport{}
+
+	parsedOffer := &sdp.SessionDescription{}
+	if err := parsedOffer.Unmarshal([]byte(offer)); err != nil {
+		report.Errors = append(report.Errors, "parsing offer: "+err.Error())
+		return report
+	}
+	report.Offer = describeMedia(parsedOffer)
+
+	if err := checkTransportPolicies(parsedOffer); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	if hoistExtmapAllowMixed(parsedOffer) {
+		hoisted, err := parsedOffer.Marshal()
+		if err != nil {
+			report.Errors = append(report.Errors, "rewriting offer: "+err.Error())
+			return report
+		}
+		offer = string(hoisted)
+	}
+
+	peerConnection, err := newPeerConnection()
+	if err != nil {
+		report.Errors = append(report.Errors, "creating peer connection: "+err.Error())
+		return report
+	}
+	defer func() {
+		_ = peerConnection.Close()
+	}()
+
+	if _, _, err = addRelayTracks(peerConnection, parsedOffer, nil); err != nil {
+		report.Errors = append(report.Errors, "adding audio track: "+err.Error())
+		return report
+	}
+
+	if err = peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer, SDP: offer,
+	}); err != nil {
+		report.Errors = append(report.Errors, "setting remote description: "+err.Error())
+		return report
+	}
+	if err = preferCodecs(peerConnection, nil); err != nil {
+		report.Errors = append(report.Errors, "ordering codecs: "+err.Error())
+		return report
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		report.Errors = append(report.Errors, "creating answer: "+err.Error())
+		return report
+	}
+
+	parsedAnswer, err := answer.Unmarshal()
+	if err != nil {
+		report.Errors = append(report.Errors, "parsing answer: "+err.Error())
+		return report
+	}
+	report.Answer = describeMedia(parsedAnswer)
+
+	for _, media := range report.Answer {
+		if media.Kind == "audio" && !media.Rejected && len(media.Codecs) == 0 {
+			report.Errors = append(report.Errors, fmt.Sprintf("no common audio codec in media section %q", media.Mid))
+		}
+	}
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+func describeMedia(description *sdp.SessionDescription) []mediaReport {
+	reports := make([]mediaReport, 0, len(description.MediaDescriptions))
+	for _, media := range description.MediaDescriptions {
+		report := mediaReport{
+			Kind:      media.MediaName.Media,
+			Direction: "sendrecv",
+			Rejected:  media.MediaName.Port.Value == 0,
+			Codecs:    []codecReport{},
+		}
+
+		for _, attribute := range media.Attributes {
+			switch attribute.Key {
+			case "mid":
+				report.Mid = attribute.Value
+			case "sendrecv", "sendonly", "recvonly", "inactive":
+				report.Direction = attribute.Key
+			case "rtpmap":
+				if codec, ok := parseRTPMap(attribute.Value); ok {
+					report.Codecs = append(report.Codecs, codec)
+				}
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// parseRTPMap parses an rtpmap value such as "111 opus/48000/2".
+func parseRTPMap(value string) (codecReport, bool) {
+	payloadType, encoding, found := strings.Cut(value, " ")
+	if !found {
+		return codecReport{}, false
+	}
+
+	pt, err := strconv.ParseUint(payloadType, 10, 8)
+	if err != nil {
+		return codecReport{}, false
+	}
+	codec := codecReport{PayloadType: uint8(pt)}
+
+	parts := strings.Split(encoding, "/")
+	codec.Name = parts[0]
+	if len(parts) > 1 {
+		if clockRate, err := strconv.ParseUint(parts[1], 10, 32); err == nil {
+			codec.ClockRate = uint32(clockRate)
+		}
+	}
+	if len(parts) > 2 {
+		if channels, err := strconv.ParseUint(parts[2], 10, 16); err == nil {
+			codec.Channels = uint16(channels)
+		}
+	}
+	return codec, true
+}
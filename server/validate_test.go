@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// validate POSTs offer to the validate endpoint and decodes its report.
+func validate(t *testing.T, serverURL, offer string) validationReport {
+	t.Helper()
+
+	res, body := postOffer(t, serverURL+"/whip/validate", offer, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("validate answered %d: %s", res.StatusCode, body)
+	}
+	var report validationReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestValidateAudioOffer(t *testing.T) {
+	server := startServer(t)
+	_, offer := createOffer(t, addAudio)
+
+	report := validate(t, server.URL, offer)
+	if !report.Valid || len(report.Errors) != 0 {
+		t.Fatalf("audio offer reported invalid: %v", report.Errors)
+	}
+	if len(report.Answer) != 1 || report.Answer[0].Kind != "audio" || report.Answer[0].Rejected {
+		t.Fatalf("answer media %+v, want one accepted audio section", report.Answer)
+	}
+	if codecs := report.Answer[0].Codecs; len(codecs) == 0 || !strings.EqualFold(codecs[0].Name, "opus") || codecs[0].ClockRate != 48000 {
+		t.Errorf("answer codecs %+v, want Opus first", codecs)
+	}
+
+	roomManager.mutex.RLock()
+	defer roomManager.mutex.RUnlock()
+	if len(roomManager.rooms) != 0 {
+		t.Error("validating an offer created a room")
+	}
+}
+
+func TestValidateInvalidOffer(t *testing.T) {
+	server := startServer(t)
+
+	report := validate(t, server.URL, "not sdp")
+	if report.Valid || len(report.Errors) == 0 {
+		t.Fatalf("garbage offer reported valid: %+v", report)
+	}
+}
+
+func TestParseRTPMap(t *testing.T) {
+	codec, ok := parseRTPMap("111 opus/48000/2")
+	if want := (codecReport{PayloadType: 111, Name: "opus", ClockRate: 48000, Channels: 2}); !ok || codec != want {
+		t.Errorf("parsed %+v, %t, want %+v", codec, ok, want)
+	}
+	if _, ok = parseRTPMap("opus/48000"); ok {
+		t.Error("rtpmap without a payload type parsed")
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X github.com/aleksousa/single-whip/server.version=v1.2.0 -X github.com/aleksousa/single-whip/server.commit=$(git rev-parse --short HEAD) -X github.com/aleksousa/single-whip/server.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/single-whip
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+}
+
+func versionString() string {
+	return fmt.Sprintf("single-whip %s (commit %s, built %s)", version, commit, buildDate)
+}
+
+func versionHandler(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(versionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+	}); err != nil {
+		fmt.Printf("Error writing version: %s\n", err.Error())
+	}
+}
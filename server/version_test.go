@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	server := startServer(t)
+	version, commit, buildDate = "v1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	t.Cleanup(func() { version, commit, buildDate = "dev", "unknown", "unknown" })
+
+	status, body := request(t, http.MethodGet, server.URL+"/version", nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /version answered %d: %s", status, body)
+	}
+	var info versionInfo
+	if err := json.Unmarshal([]byte(body), &info); err != nil {
+		t.Fatalf("decoding the version: %s", err.Error())
+	}
+	if info != (versionInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-01-02T03:04:05Z"}) {
+		t.Errorf("GET /version reported %+v", info)
+	}
+	if want := "single-whip v1.2.3 (commit abc1234, built 2026-01-02T03:04:05Z)"; versionString() != want {
+		t.Errorf("version string %q, want %q", versionString(), want)
+	}
+}
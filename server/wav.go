@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pion/opus"
+	"github.com/pion/rtp"
+)
+
+const (
+	// wavHeaderSize is the size of the RIFF, fmt and data chunk headers.
+	wavHeaderSize = 44
+	// maxWAVGap bounds the silence written for a gap in a source's
+	// timestamps, one second, so a stream restarting at an unrelated
+	// timestamp doesn't write hours of it.
+	maxWAVGap = opusClockRate
+)
+
+// wavRecorder decodes a source's Opus to 48kHz 16-bit mono PCM in a WAV
+// file, for tools that can't read Ogg Opus. Timestamp gaps, from loss or
+// DTX, are filled with silence so the recording keeps real time, and late
+// packets are dropped. WAV sizes are 32-bit, so a recording holds about 12
+// hours.
+type wavRecorder struct {
+	file    *os.File
+	writer  *bufio.Writer
+	decoder opus.Decoder
+	pcm     []int16
+	// samples counts the samples written.
+	samples uint32
+	started bool
+	// next is the RTP timestamp of the sample after the last written.
+	next uint32
+}
+
+func newWAVRecorder(path string) (*wavRecorder, error) {
+	decoder, err := opus.NewDecoderWithOutput(opusClockRate, 1)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &wavRecorder{
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		decoder: decoder,
+		pcm:     make([]int16, maxOpusSamples*opusClockRate/g711ClockRate),
+	}
+	if err = r.writeHeader(r.writer); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *wavRecorder) WriteRTP(pkt *rtp.Packet) error {
+	if len(pkt.Payload) == 0 {
+		return nil
+	}
+	if r.started && int32(pkt.Timestamp-r.next) < 0 {
+		return nil
+	}
+
+	samples, err := r.decoder.DecodeToInt16(pkt.Payload, r.pcm)
+	if err != nil {
+		return err
+	}
+
+	if r.started {
+		if gap := pkt.Timestamp - r.next; gap > 0 {
+			if err = r.writeSamples(make([]int16, min(gap, maxWAVGap))); err != nil {
+				return err
+			}
+		}
+	}
+	r.started = true
+	r.next = pkt.Timestamp + uint32(samples)
+	return r.writeSamples(r.pcm[:samples])
+}
+
+func (r *wavRecorder) writeSamples(pcm []int16) error {
+	r.samples += uint32(len(pcm))
+	return binary.Write(r.writer, binary.LittleEndian, pcm)
+}
+
+// writeHeader writes the WAV header for the samples written so far.
+func (r *wavRecorder) writeHeader(w io.Writer) error {
+	dataSize := r.samples * 2
+	header := []any{
+		[]byte("RIFF"), uint32(wavHeaderSize - 8 + dataSize), []byte("WAVE"),
+		[]byte("fmt "), uint32(16),
+		uint16(1), // PCM
+		uint16(1), // mono
+		uint32(opusClockRate),
+		uint32(opusClockRate * 2), // byte rate
+		uint16(2),                 // block align
+		uint16(16),                // bits per sample
+		[]byte("data"), dataSize,
+	}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the final sizes into the header and closes the file.
+func (r *wavRecorder) Close() error {
+	err := r.writer.Flush()
+	if err == nil {
+		if _, err = r.file.Seek(0, io.SeekStart); err == nil {
+			err = r.writeHeader(r.file)
+		}
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestWAVRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.wav")
+	recorder, err := newWAVRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20ms packets: two in order, a lost one, a late duplicate, and one
+	// more.
+	for _, timestamp := range []uint32{0, 960, 2880, 960, 3840} {
+		if err = recorder.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: timestamp}, Payload: silkFrame}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const samples = 5 * 960
+	if len(data) != wavHeaderSize+samples*2 {
+		t.Fatalf("file has %d bytes, want %d", len(data), wavHeaderSize+samples*2)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Fatalf("not a WAV header: %q", data[:wavHeaderSize])
+	}
+	if size := binary.LittleEndian.Uint32(data[4:]); size != uint32(len(data)-8) {
+		t.Errorf("RIFF size %d, want %d", size, len(data)-8)
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:]); rate != 48000 {
+		t.Errorf("sample rate %d, want 48000", rate)
+	}
+	if size := binary.LittleEndian.Uint32(data[40:]); size != samples*2 {
+		t.Errorf("data size %d, want %d", size, samples*2)
+	}
+}
+
+func TestValidateRecordFormat(t *testing.T) {
+	for _, format := range []string{recordFormatOgg, recordFormatRTPDump, recordFormatWAV} {
+		if err := validateRecordFormat(format); err != nil {
+			t.Errorf("%s: %s", format, err.Error())
+		}
+	}
+	if err := validateRecordFormat("mp3"); err == nil {
+		t.Error("mp3 accepted")
+	}
+}
@@ -0,0 +1,28 @@
+package singlewhiptest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aleksousa/single-whip/singlewhiptest"
+)
+
+func TestRelay(t *testing.T) {
+	server := singlewhiptest.NewServer(t)
+	alice := server.Join(t, "room1")
+	bob := server.Join(t, "room1")
+
+	singlewhiptest.AssertRelayed(t, alice, bob, 10*time.Second)
+	singlewhiptest.AssertRelayed(t, bob, alice, 10*time.Second)
+}
+
+func TestRoomsAreIsolated(t *testing.T) {
+	server := singlewhiptest.NewServer(t)
+	alice := server.Join(t, "room1")
+	bob := server.Join(t, "room1")
+	carol := server.Join(t, "room2")
+
+	singlewhiptest.AssertRelayed(t, alice, bob, 10*time.Second)
+	singlewhiptest.AssertNotRelayed(t, alice, carol, time.Second)
+	singlewhiptest.AssertNotRelayed(t, carol, bob, time.Second)
+}
@@ -0,0 +1,149 @@
+// Package singlewhiptest runs single-whip servers in process for integration
+// tests and joins test peers to them, for this repository's tests and those
+// of programs built against the server.
+//
+// NewServer serves package server's Handler with httptest on a free
+// loopback port. The server's state is global, so a test binary runs one
+// server at a time, and tests using them must not run in parallel.
+//
+// Each test peer sends a stream of one-byte Opus packets carrying a tag of
+// its own, which lets the peers receiving them tell who they heard:
+//
+//	func TestRelay(t *testing.T) {
+//		server := singlewhiptest.NewServer(t)
+//		alice := server.Join(t, "room1")
+//		bob := server.Join(t, "room1")
+//
+//		singlewhiptest.AssertRelayed(t, alice, bob, 5*time.Second)
+//		singlewhiptest.AssertRelayed(t, bob, alice, 5*time.Second)
+//	}
+//
+// Extra server flags go to NewServer, extra WHIP query parameters to
+// JoinQuery:
+//
+//	server := singlewhiptest.NewServer(t, "-admin-token", "secret")
+//	peer := server.JoinQuery(t, url.Values{"room": {"room1"}, "topology": {"mesh"}})
+package singlewhiptest
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aleksousa/single-whip/internal/synthpeer"
+	"github.com/aleksousa/single-whip/server"
+)
+
+// Server is a single-whip server running for a test.
+type Server struct {
+	// URL is the server's base URL, e.g. http://127.0.0.1:34567.
+	URL string
+
+	client    *synthpeer.Client
+	http      *httptest.Server
+	closeOnce sync.Once
+}
+
+// NewServer starts a server with the given extra flags and stops it when the
+// test ends; Close stops it earlier. The server doesn't use STUN, so tests
+// don't depend on the network.
+func NewServer(t testing.TB, flags ...string) *Server {
+	t.Helper()
+
+	handler, err := server.Handler(append([]string{"-no-stun"}, flags...)...)
+	if err != nil {
+		t.Fatalf("singlewhiptest: starting the server: %s", err.Error())
+	}
+	httpServer := httptest.NewServer(handler)
+	s := Connect(httpServer.URL)
+	s.http = httpServer
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Connect returns a Server for joining test peers to a server already
+// running at url, e.g. one of the server's own tests started in process.
+// Its Close does nothing.
+func Connect(url string) *Server {
+	return &Server{URL: url, client: synthpeer.NewClient(url + "/whip")}
+}
+
+// Close stops the server, tearing down its peers. It may be called more
+// than once.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		if s.http == nil {
+			return
+		}
+		// No join is under way once the HTTP server is closed.
+		s.http.Close()
+		server.Stop()
+	})
+}
+
+// Join joins a test peer to room, see JoinQuery.
+func (s *Server) Join(t testing.TB, room string) *Peer {
+	t.Helper()
+	return s.JoinQuery(t, url.Values{"room": {room}})
+}
+
+// JoinQuery joins a test peer with a WHIP POST to /whip carrying query,
+// failing the test unless the server answers with 201. The peer sends its
+// tagged packets until it leaves, which it does with a DELETE of its
+// resource when the test ends or on Close.
+func (s *Server) JoinQuery(t testing.TB, query url.Values) *Peer {
+	t.Helper()
+
+	p, err := s.TryJoin(t, query)
+	if err != nil {
+		t.Fatalf("singlewhiptest: %s", err.Error())
+	}
+	return p
+}
+
+// StatusError is the error of TryJoin when the server rejects the offer.
+type StatusError = synthpeer.StatusError
+
+// TryJoin is JoinQuery returning a *StatusError when the server answers
+// other than 201, for tests of rejected joins. Other failures still fail the
+// test.
+func (s *Server) TryJoin(t testing.TB, query url.Values) (*Peer, error) {
+	t.Helper()
+
+	p, err := s.client.Join(query)
+	var status *StatusError
+	if errors.As(err, &status) {
+		return nil, err
+	}
+	if err != nil {
+		t.Fatalf("singlewhiptest: %s", err.Error())
+	}
+	t.Cleanup(p.Close)
+	return p, nil
+}
+
+// Peer is a test peer joined to a Server.
+type Peer = synthpeer.Peer
+
+// AssertRelayed fails the test unless destination receives a packet from
+// source within timeout.
+func AssertRelayed(t testing.TB, source, destination *Peer, timeout time.Duration) {
+	t.Helper()
+
+	if err := synthpeer.AwaitRelayed(source, destination, timeout); err != nil {
+		t.Fatalf("singlewhiptest: %s", err.Error())
+	}
+}
+
+// AssertNotRelayed fails the test if destination receives a packet from
+// source within wait.
+func AssertNotRelayed(t testing.TB, source, destination *Peer, wait time.Duration) {
+	t.Helper()
+
+	if err := synthpeer.AwaitNotRelayed(source, destination, wait); err != nil {
+		t.Fatalf("singlewhiptest: %s", err.Error())
+	}
+}